@@ -0,0 +1,65 @@
+package httpsig
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	sfv "github.com/dunglas/httpsfv"
+)
+
+// ErrSignatureAgentMismatch is returned when Signature-Agent does not point at the directory whose key verified the request
+var ErrSignatureAgentMismatch = errors.New("Signature-Agent does not match the verifying directory")
+
+// parseSignatureAgent returns the directory advertised by the Signature-Agent field, a structured field string
+func parseSignatureAgent(h http.Header) (string, error) {
+	values := h.Values("Signature-Agent")
+	if len(values) == 0 {
+		return "", fmt.Errorf("%w: no Signature-Agent", ErrSignatureAgentMismatch)
+	}
+	item, err := sfv.UnmarshalItem(values)
+	if err != nil {
+		return "", fmt.Errorf("parsing Signature-Agent: %w", err)
+	}
+	agent, ok := item.Value.(string)
+	if !ok {
+		return "", fmt.Errorf("Signature-Agent is not a string")
+	}
+	return agent, nil
+}
+
+// checkSignatureAgent verifies that the Signature-Agent of h resolves to the same directory as directoryBase
+func checkSignatureAgent(h http.Header, directoryBase string) error {
+	agent, err := parseSignatureAgent(h)
+	if err != nil {
+		return err
+	}
+	if !sameDirectory(agent, directoryBase) {
+		return fmt.Errorf("%w: %s", ErrSignatureAgentMismatch, agent)
+	}
+	return nil
+}
+
+// sameDirectory reports whether two directory bases resolve to the same directory URL, ignoring host case
+func sameDirectory(a, b string) bool {
+	ua, err := resolveDirectory(a)
+	if err != nil {
+		return false
+	}
+	ub, err := resolveDirectory(b)
+	if err != nil {
+		return false
+	}
+	return ua.Scheme == ub.Scheme && strings.EqualFold(ua.Host, ub.Host) && ua.Path == ub.Path && ua.RawQuery == ub.RawQuery
+}
+
+// resolveDirectory parses the directory URL of base
+func resolveDirectory(base string) (*url.URL, error) {
+	directory, err := directoryURL(base)
+	if err != nil {
+		return nil, err
+	}
+	return url.Parse(directory)
+}
@@ -0,0 +1,71 @@
+package httpsig
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireSignatureAgent(t *testing.T) {
+	_, staticKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	staticJWK, staticKeyID := publicJWK(t, staticKey)
+
+	m := &Middleware{
+		DirectoryBase:         "signer.example.com",
+		StaticKeys:            []json.RawMessage{staticJWK},
+		RequireSignatureAgent: true,
+		Fetcher:               &fakeFetcher{responses: []fakeResponse{{dir: directoryOf(ed25519JWK(testPrivateKey))}}},
+	}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		agent  string
+		static bool
+		want   int
+	}{
+		{name: "matching", agent: `"https://signer.example.com"`, want: http.StatusOK},
+		{name: "matching well-known URL", agent: `"https://Signer.Example.com/.well-known/http-message-signatures-directory"`, want: http.StatusOK},
+		{name: "other directory", agent: `"https://attacker.example"`, want: http.StatusUnauthorized},
+		{name: "other path on the same host", agent: `"https://signer.example.com/other.json"`, want: http.StatusUnauthorized},
+		{name: "missing", want: http.StatusUnauthorized},
+		{name: "not a string", agent: `signer`, want: http.StatusUnauthorized},
+		{name: "static key without agent", static: true, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+			if tt.agent != "" {
+				r.Header.Set("Signature-Agent", tt.agent)
+			}
+			if tt.static {
+				signRequest(t, r, staticKey, staticKeyID)
+			} else {
+				signRequest(t, r, testPrivateKey, testKeyID)
+			}
+
+			w := httptest.NewRecorder()
+			if err := m.ServeHTTP(w, r, okHandler{}); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireSignatureAgentWithoutDirectory(t *testing.T) {
+	m := &Middleware{StaticKeys: []json.RawMessage{ed25519JWK(testPrivateKey)}, RequireSignatureAgent: true}
+	if err := m.Provision(newTestContext(t)); err == nil {
+		t.Fatal("Provision succeeded without directory_base")
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package httpsig
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// reopenOnSIGHUP does nothing on platforms without SIGHUP
+func reopenOnSIGHUP(ctx context.Context, sink *JSONLAuditSink, logger *zap.Logger) {}
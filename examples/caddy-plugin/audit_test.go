@@ -0,0 +1,119 @@
+package httpsig
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// readAuditLog decodes every line of the audit log at path, failing on malformed lines
+func readAuditLog(t *testing.T, path string) []AuditRecord {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("malformed audit line %q: %v", scanner.Text(), err)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestJSONLAuditSinkConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewJSONLAuditSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Middleware{audit: sink}
+	m.validator.Store(newTestValidator(t))
+
+	const requests = 50
+	var wg sync.WaitGroup
+	for i := range requests {
+		r := newSignedRequest(t)
+		if i%2 == 1 {
+			r.Host = "example.org"
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := m.ServeHTTP(httptest.NewRecorder(), r, okHandler{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := m.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readAuditLog(t, path)
+	if len(records) != requests {
+		t.Fatalf("got %d audit records, want %d", len(records), requests)
+	}
+	counts := map[string]int{}
+	for _, rec := range records {
+		counts[rec.Result]++
+		if rec.KeyID != testKeyID || rec.Path != "/" || rec.Time.IsZero() {
+			t.Errorf("incomplete record %+v", rec)
+		}
+		if (rec.Result == AuditRejected) != (rec.Reason != "") {
+			t.Errorf("record %+v: reason must be set exactly for rejections", rec)
+		}
+		if rec.Result == AuditRejected && rec.Authority != "example.org" {
+			t.Errorf("rejected record authority = %q, want example.org", rec.Authority)
+		}
+	}
+	if counts[AuditAccepted] != requests/2 || counts[AuditRejected] != requests/2 {
+		t.Errorf("results = %v, want %d of each", counts, requests/2)
+	}
+}
+
+func TestJSONLAuditSinkReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+	sink, err := NewJSONLAuditSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	if err := sink.Record(newAuditRecord(r, ValidationResult{KeyID: testKeyID}, nil)); err != nil {
+		t.Fatal(err)
+	}
+	rotated := filepath.Join(dir, "audit.jsonl.1")
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Record(newAuditRecord(r, ValidationResult{KeyID: testKeyID}, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(readAuditLog(t, rotated)); n != 1 {
+		t.Errorf("rotated file has %d records, want 1", n)
+	}
+	if n := len(readAuditLog(t, path)); n != 1 {
+		t.Errorf("reopened file has %d records, want 1", n)
+	}
+}
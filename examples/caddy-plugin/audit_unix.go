@@ -0,0 +1,30 @@
+//go:build unix
+
+package httpsig
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// reopenOnSIGHUP reopens the audit log whenever the process receives SIGHUP, the usual log rotation signal,
+// until ctx is done
+func reopenOnSIGHUP(ctx context.Context, sink *JSONLAuditSink, logger *zap.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := sink.Reopen(); err != nil {
+				logger.Error("reopening audit log", zap.Error(err))
+			}
+		}
+	}
+}
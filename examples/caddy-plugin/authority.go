@@ -0,0 +1,70 @@
+package httpsig
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// ErrAuthorityMismatch is returned when a signature covers both host and @authority and the Host header
+// does not name the request authority
+var ErrAuthorityMismatch = errors.New("Host header does not match @authority")
+
+// AuthorityComponent selects which component signatures must cover to bind the request to the target host
+type AuthorityComponent string
+
+const (
+	// AuthorityComponentAuthority requires the derived @authority component, as RFC 9421 recommends
+	AuthorityComponentAuthority AuthorityComponent = "@authority"
+	// AuthorityComponentHost requires the host header field, for bots that sign it instead
+	AuthorityComponentHost AuthorityComponent = "host"
+	// AuthorityComponentBoth requires both
+	AuthorityComponentBoth AuthorityComponent = "both"
+)
+
+// ParseAuthorityComponent returns the authority component mode named s
+func ParseAuthorityComponent(s string) (AuthorityComponent, error) {
+	switch mode := AuthorityComponent(s); mode {
+	case AuthorityComponentAuthority, AuthorityComponentHost, AuthorityComponentBoth:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown authority component %q, must be @authority, host or both", s)
+}
+
+// fields returns the components the mode requires
+func (c AuthorityComponent) fields() []string {
+	switch c {
+	case AuthorityComponentHost:
+		return []string{"host"}
+	case AuthorityComponentBoth:
+		return []string{"@authority", "host"}
+	}
+	return []string{"@authority"}
+}
+
+// withHostField returns r with a Host header when a signature covers host.
+// Servers move the Host header to Request.Host, where the verifier does not look for header fields.
+func withHostField(r *http.Request, fields []string) *http.Request {
+	if !slices.Contains(fields, "host") || r.Header.Get("Host") != "" || r.Host == "" {
+		return r
+	}
+	candidate := *r
+	candidate.Header = r.Header.Clone()
+	candidate.Header.Set("Host", r.Host)
+	return &candidate
+}
+
+// checkHostMatch rejects signatures covering both host and @authority when the request carries a Host header
+// naming another authority. Both components verify on their own, so a request signed for one host
+// could otherwise be presented with its Host header pointing at another.
+func checkHostMatch(r *http.Request, input signatureInput) error {
+	if !input.covers("host") || !input.covers("@authority") {
+		return nil
+	}
+	if host := r.Header.Get("Host"); host != "" && !strings.EqualFold(host, r.Host) {
+		return fmt.Errorf("%w: %s is not %s", ErrAuthorityMismatch, host, r.Host)
+	}
+	return nil
+}
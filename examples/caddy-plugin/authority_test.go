@@ -0,0 +1,125 @@
+package httpsig
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/remitly-oss/httpsig-go"
+)
+
+// signHost signs r over fields, with its Host header set to host while signing as a client would send it
+func signHost(t *testing.T, r *http.Request, host string, fields ...string) {
+	t.Helper()
+	r.Header.Set("Host", host)
+	signRequestWith(t, r, httpsig.Algo_ED25519, testPrivateKey, testKeyID, fields...)
+	r.Header.Del("Host")
+}
+
+func TestAuthorityComponent(t *testing.T) {
+	tests := []struct {
+		name      string
+		component AuthorityComponent
+		fields    []string
+		wantErr   error
+	}{
+		{name: "default with @authority", fields: []string{"@authority"}},
+		{name: "default with host", fields: []string{"host"}, wantErr: ErrMissingComponent},
+		{name: "@authority", component: AuthorityComponentAuthority, fields: []string{"@authority"}},
+		{name: "@authority with host", component: AuthorityComponentAuthority, fields: []string{"host"}, wantErr: ErrMissingComponent},
+		{name: "host", component: AuthorityComponentHost, fields: []string{"host"}},
+		{name: "host with @authority", component: AuthorityComponentHost, fields: []string{"@authority"}, wantErr: ErrMissingComponent},
+		{name: "both", component: AuthorityComponentBoth, fields: []string{"@authority", "host"}},
+		{name: "both with @authority only", component: AuthorityComponentBoth, fields: []string{"@authority"}, wantErr: ErrMissingComponent},
+		{name: "both with host only", component: AuthorityComponentBoth, fields: []string{"host"}, wantErr: ErrMissingComponent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{AuthorityComponent: tt.component})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+			signHost(t, r, "example.com", tt.fields...)
+			if _, err := v.Validate(r); !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthorityComponentAddsToRequiredFields(t *testing.T) {
+	v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{
+		RequiredFields:     []string{"@method"},
+		AuthorityComponent: AuthorityComponentHost,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := v.Profile().RequiredFields, []string{"@method", "host"}; !slices.Equal(got, want) {
+		t.Errorf("required fields = %v, want %v", got, want)
+	}
+}
+
+func TestHostAuthorityMismatch(t *testing.T) {
+	v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{AuthorityComponent: AuthorityComponentBoth})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Signed for example.com with a Host header naming another site, which is presented alongside
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	signHost(t, r, "other.example", "@authority", "host")
+	r.Header.Set("Host", "other.example")
+	if _, err := v.Validate(r); !errors.Is(err, ErrAuthorityMismatch) {
+		t.Errorf("err = %v, want %v", err, ErrAuthorityMismatch)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	signHost(t, r, "Example.com", "@authority", "host")
+	r.Header.Set("Host", "Example.com")
+	if _, err := v.Validate(r); err != nil {
+		t.Errorf("matching Host header with other case: %v", err)
+	}
+}
+
+func TestExpectedAuthorities(t *testing.T) {
+	v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{
+		ExpectedAuthorities: []string{"www.example.com", "example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, public := range []string{"www.example.com", "example.com"} {
+		signed := httptest.NewRequest(http.MethodGet, "https://"+public+"/", nil)
+		signRequest(t, signed, testPrivateKey, testKeyID)
+		// An internal rewrite changed the host
+		r := httptest.NewRequest(http.MethodGet, "https://backend.internal/", nil)
+		r.Header = signed.Header
+		if _, err := v.Validate(r); err != nil {
+			t.Errorf("signed for %s: %v", public, err)
+		}
+	}
+	// Signatures over the request host are no longer accepted
+	r := httptest.NewRequest(http.MethodGet, "https://other.example/", nil)
+	signRequest(t, r, testPrivateKey, testKeyID)
+	if _, err := v.Validate(r); err == nil {
+		t.Error("signature over another authority accepted")
+	}
+
+	var m Middleware
+	if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\n\texpected_authority www.example.com example.com:8443\n}")); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"www.example.com", "example.com:8443"}; !slices.Equal(m.ExpectedAuthorities, want) {
+		t.Errorf("expected_authority = %v, want %v", m.ExpectedAuthorities, want)
+	}
+	bad := Middleware{StaticKeys: []json.RawMessage{ed25519JWK(testPrivateKey)}, ExpectedAuthorities: []string{"https://example.com/"}}
+	if err := bad.Provision(newTestContext(t)); err == nil {
+		t.Error("expected_authority with a scheme accepted")
+	}
+}
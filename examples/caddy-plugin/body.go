@@ -0,0 +1,102 @@
+package httpsig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// ErrNoUsableSignature is returned when no signature of a request could verify, judging from Signature-Input alone
+var ErrNoUsableSignature = errors.New("no signature uses a known key and covers the required components")
+
+// ErrBodyConsumed is returned when Content-Digest must be checked but an earlier handler already read the request body
+var ErrBodyConsumed = errors.New("request body was consumed before signature verification")
+
+// ErrBodyTooLarge is returned when Content-Digest must be checked on a body larger than the maximum body size
+var ErrBodyTooLarge = errors.New("request body exceeds the maximum size for Content-Digest verification")
+
+// bufferBody makes the body of r replayable when the verifier will digest it, which it does whenever
+// Content-Digest is present. Afterwards r.Body and r.GetBody yield the full body, however many times the
+// verifier reads it, so handlers after the middleware still see it. Bodies are held in memory, up to limit bytes
+// when limit is positive; a larger body is left readable in full for r and rejected with ErrBodyTooLarge.
+func bufferBody(r *http.Request, limit int64) error {
+	if r.Header.Get("Content-Digest") == "" || r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	if limit > 0 && r.ContentLength > limit {
+		return fmt.Errorf("%w: %d bytes", ErrBodyTooLarge, r.ContentLength)
+	}
+	src := io.Reader(r.Body)
+	if limit > 0 {
+		src = io.LimitReader(r.Body, limit+1)
+	}
+	body, err := io.ReadAll(src)
+	if err != nil {
+		r.Body.Close()
+		return fmt.Errorf("reading request body: %w", err)
+	}
+	if limit > 0 && int64(len(body)) > limit {
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		return fmt.Errorf("%w: more than %d bytes", ErrBodyTooLarge, limit)
+	}
+	r.Body.Close()
+	if len(body) == 0 && r.ContentLength > 0 {
+		return fmt.Errorf("%w: expected %d bytes", ErrBodyConsumed, r.ContentLength)
+	}
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	r.Body, _ = r.GetBody()
+	return nil
+}
+
+// withFreshBody returns a copy of r with a body that has not been read yet, when r has a replayable body
+func withFreshBody(r *http.Request) *http.Request {
+	if r.GetBody == nil || r.Body == nil || r.Body == http.NoBody {
+		return r
+	}
+	body, err := r.GetBody()
+	if err != nil {
+		return r
+	}
+	candidate := *r
+	candidate.Body = body
+	return &candidate
+}
+
+// expectsContinue reports whether the client waits for a 100 Continue response before sending the body.
+// The server sends it on the first read of r.Body, so nothing is sent until the body is buffered.
+func expectsContinue(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Expect"), "100-continue")
+}
+
+// precheck rejects requests none of whose signatures uses a known key and covers the required components.
+// It runs on Signature-Input alone, so requests that cannot verify are rejected before the body is requested
+// from clients waiting for 100 Continue, instead of after a large upload.
+func (v *SignatureValidator) precheck(r *http.Request) error {
+	if v.paramCase == ParameterCaseLenient {
+		if fixed, ok := withLowercaseParams(r); ok {
+			r = fixed
+		}
+	}
+	inputs, err := parseSignatureInputs(r.Header)
+	if err != nil {
+		return err
+	}
+	for _, label := range slices.Sorted(maps.Keys(inputs)) {
+		input := inputs[label]
+		// Keys of stores are only looked up when verifying
+		if _, ok := v.keys[input.KeyID]; (ok || len(v.fetcher.stores) > 0) && v.checkCoverage(input) == nil {
+			return nil
+		}
+	}
+	return ErrNoUsableSignature
+}
@@ -0,0 +1,227 @@
+package httpsig
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/remitly-oss/httpsig-go"
+)
+
+const testBody = `{"hello":"world"}`
+
+// newDigestRequest returns a POST request signed over its Content-Digest
+func newDigestRequest(t *testing.T) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "https://example.com/upload", strings.NewReader(testBody))
+	signRequestWith(t, r, httpsig.Algo_ED25519, testPrivateKey, testKeyID, "@authority", "content-digest")
+	return r
+}
+
+// bodyReader is a handler that reads the whole request body, recording what it read
+type bodyReader struct {
+	read string
+	next caddyhttp.Handler
+}
+
+func (br *bodyReader) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	br.read = string(body)
+	if br.next != nil {
+		return br.next.ServeHTTP(w, r)
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// middlewareHandler adapts the middleware to a caddyhttp.Handler calling next
+type middlewareHandler struct {
+	m    *Middleware
+	next caddyhttp.Handler
+}
+
+func (mh middlewareHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	return mh.m.ServeHTTP(w, r, mh.next)
+}
+
+func TestBodyOrdering(t *testing.T) {
+	m := &Middleware{}
+	m.validator.Store(newTestValidator(t))
+
+	t.Run("before a body-reading handler", func(t *testing.T) {
+		reader := &bodyReader{}
+		w := httptest.NewRecorder()
+		if err := (middlewareHandler{m, reader}).ServeHTTP(w, newDigestRequest(t)); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+		if reader.read != testBody {
+			t.Errorf("handler after the middleware read %q, want %q", reader.read, testBody)
+		}
+	})
+
+	t.Run("after a body-reading handler", func(t *testing.T) {
+		reader := &bodyReader{next: middlewareHandler{m, okHandler{}}}
+		w := httptest.NewRecorder()
+		if err := reader.ServeHTTP(w, newDigestRequest(t)); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+		}
+
+		r := newDigestRequest(t)
+		io.ReadAll(r.Body)
+		if _, err := m.validator.Load().Validate(r); !errors.Is(err, ErrBodyConsumed) {
+			t.Errorf("err = %v, want %v", err, ErrBodyConsumed)
+		}
+	})
+}
+
+func TestBodyAcrossRetries(t *testing.T) {
+	// Lenient authority verifies several candidates, each of which digests the body
+	v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{AuthorityNormalization: AuthorityLenient})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newDigestRequest(t)
+	r.Host = "EXAMPLE.com:443"
+	if _, err := v.Validate(r); err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != testBody {
+		t.Errorf("body after validation = %q, want %q", body, testBody)
+	}
+}
+
+// trackedBody records whether the client transport started sending the body
+type trackedBody struct {
+	r    io.Reader
+	sent atomic.Bool
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	b.sent.Store(true)
+	return b.r.Read(p)
+}
+
+func TestExpectContinue(t *testing.T) {
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received atomic.Value
+	srv := httptest.NewServer(newTestValidator(t).Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received.Store(string(body))
+	})))
+	defer srv.Close()
+	// Wait for 100 Continue for as long as the test runs, so the body is only sent when the server asks for it
+	client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: time.Minute}}
+
+	tests := []struct {
+		name     string
+		key      ed25519.PrivateKey
+		keyid    string
+		want     int
+		wantSent bool
+	}{
+		{name: "valid", key: testPrivateKey, keyid: testKeyID, want: http.StatusOK, wantSent: true},
+		{name: "unknown key", key: otherKey, keyid: "unknown", want: http.StatusUnauthorized, wantSent: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received.Store("")
+			r, err := http.NewRequest(http.MethodPut, srv.URL+"/upload", strings.NewReader(testBody))
+			if err != nil {
+				t.Fatal(err)
+			}
+			signRequestWith(t, r, httpsig.Algo_ED25519, tt.key, tt.keyid, "@authority", "content-digest")
+			body := &trackedBody{r: strings.NewReader(testBody)}
+			r.Body = io.NopCloser(body)
+			r.Header.Set("Expect", "100-continue")
+
+			resp, err := client.Do(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+			if got := body.sent.Load(); got != tt.wantSent {
+				t.Errorf("body sent = %v, want %v", got, tt.wantSent)
+			}
+			if tt.wantSent && received.Load() != testBody {
+				t.Errorf("handler received %q, want %q", received.Load(), testBody)
+			}
+		})
+	}
+}
+
+func TestMaxBodySize(t *testing.T) {
+	tests := []struct {
+		name          string
+		limit         int64
+		contentLength int64
+		wantErr       error
+	}{
+		{name: "within limit", limit: int64(len(testBody)), contentLength: int64(len(testBody))},
+		{name: "unlimited", contentLength: int64(len(testBody))},
+		{name: "declared too large", limit: 4, contentLength: int64(len(testBody)), wantErr: ErrBodyTooLarge},
+		{name: "streamed too large", limit: 4, contentLength: -1, wantErr: ErrBodyTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Middleware{StaticKeys: []json.RawMessage{ed25519JWK(testPrivateKey)}, MaxBodySize: tt.limit}
+			if err := m.Provision(newTestContext(t)); err != nil {
+				t.Fatal(err)
+			}
+			r := newDigestRequest(t)
+			r.ContentLength = tt.contentLength
+			if _, err := m.validate(r); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			// Rejected or not, the whole body remains for handlers after the middleware
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != testBody {
+				t.Errorf("body = %q, want %q", body, testBody)
+			}
+		})
+	}
+}
+
+func TestUnmarshalMaxBodySize(t *testing.T) {
+	var m Middleware
+	if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\nmax_body_size 1MiB\n}")); err != nil {
+		t.Fatal(err)
+	}
+	if m.MaxBodySize != 1<<20 {
+		t.Errorf("max_body_size = %d, want %d", m.MaxBodySize, 1<<20)
+	}
+	if err := (&Middleware{}).UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\nmax_body_size lots\n}")); err == nil {
+		t.Error("max_body_size lots accepted")
+	}
+}
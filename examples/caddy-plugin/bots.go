@@ -0,0 +1,84 @@
+package httpsig
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// ErrBotNotAllowed is returned when a verified bot requests a path or method its policy does not allow
+var ErrBotNotAllowed = errors.New("request not allowed for bot")
+
+// Bot names the signer of verified requests, by keyid or by the directory its key was loaded from,
+// and restricts what it may request
+type Bot struct {
+	// Name identifies the bot in the {http.httpsig.bot} placeholder and in logs
+	Name string `json:"name"`
+	// KeyIDs are the keyids, usually JWK thumbprints, that identify the bot
+	KeyIDs []string `json:"keyids,omitempty"`
+	// Directories are the directory bases, configured or discovered from Signature-Agent, whose keys identify the bot
+	Directories []string `json:"directories,omitempty"`
+	// Paths are the path patterns the bot may request, as in Caddy's path matcher. Empty allows every path.
+	Paths []string `json:"paths,omitempty"`
+	// Methods are the methods the bot may use. Empty allows every method.
+	Methods []string `json:"methods,omitempty"`
+	// Bypass exempts the bot from allowed_windows and sets {http.httpsig.bypass}, so that the handlers after
+	// the middleware, such as rate limiters, can let it through
+	Bypass bool `json:"bypass,omitempty"`
+}
+
+// validate checks that b identifies a bot and prepares its matchers
+func (b *Bot) validate() error {
+	if b.Name == "" {
+		return errors.New("bot without a name")
+	}
+	if len(b.KeyIDs) == 0 && len(b.Directories) == 0 {
+		return fmt.Errorf("bot %s: no keyids or directories", b.Name)
+	}
+	for _, base := range b.Directories {
+		if _, err := resolveDirectory(base); err != nil {
+			return fmt.Errorf("bot %s: directory %s: %w", b.Name, base, err)
+		}
+	}
+	for i, method := range b.Methods {
+		b.Methods[i] = strings.ToUpper(method)
+	}
+	return caddyhttp.MatchPath(b.Paths).Provision(caddy.Context{})
+}
+
+// identifies reports whether the key that verified result belongs to b
+func (b *Bot) identifies(result ValidationResult) bool {
+	if slices.Contains(b.KeyIDs, result.KeyID) {
+		return true
+	}
+	if result.Directory == "" {
+		return false
+	}
+	return slices.ContainsFunc(b.Directories, func(base string) bool { return sameDirectory(base, result.Directory) })
+}
+
+// allows checks r against the paths and methods of b
+func (b *Bot) allows(r *http.Request) error {
+	if len(b.Methods) > 0 && !slices.Contains(b.Methods, r.Method) {
+		return fmt.Errorf("%w %s: method %s", ErrBotNotAllowed, b.Name, r.Method)
+	}
+	if len(b.Paths) > 0 && !caddyhttp.MatchPath(b.Paths).Match(r) {
+		return fmt.Errorf("%w %s: path %s", ErrBotNotAllowed, b.Name, r.URL.Path)
+	}
+	return nil
+}
+
+// matchBot returns the first of bots identified by result, or nil when the signer is not a named bot
+func matchBot(bots []Bot, result ValidationResult) *Bot {
+	for i := range bots {
+		if bots[i].identifies(result) {
+			return &bots[i]
+		}
+	}
+	return nil
+}
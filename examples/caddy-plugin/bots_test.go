@@ -0,0 +1,112 @@
+package httpsig
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestBotPolicy(t *testing.T) {
+	monitorKey, monitorJWK, monitorKeyID := generateKey(t)
+	strangerKey, strangerJWK, strangerKeyID := generateKey(t)
+	// A window that is closed now, so that only bypassing bots are served
+	now := time.Now().UTC()
+	closed := now.Add(time.Hour).Format("15:04") + "-" + now.Add(2*time.Hour).Format("15:04")
+	m := &Middleware{
+		StaticKeys: []json.RawMessage{ed25519JWK(testPrivateKey), monitorJWK, strangerJWK},
+		Bots: []Bot{
+			{Name: "crawler", KeyIDs: []string{testKeyID}, Paths: []string{"/public/*"}, Methods: []string{"get"}},
+			{Name: "monitor", KeyIDs: []string{monitorKeyID}, Bypass: true},
+		},
+		AllowedWindows: &AllowedWindows{Ranges: []string{closed}},
+	}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		key        ed25519.PrivateKey
+		keyid      string
+		wantStatus int
+		wantBot    string
+		wantBypass string
+	}{
+		{name: "allowed path", method: http.MethodGet, path: "/public/page", key: testPrivateKey, keyid: testKeyID, wantStatus: http.StatusServiceUnavailable, wantBot: "crawler", wantBypass: "false"},
+		{name: "disallowed path", method: http.MethodGet, path: "/admin", key: testPrivateKey, keyid: testKeyID, wantStatus: http.StatusForbidden, wantBot: "crawler", wantBypass: "false"},
+		{name: "disallowed method", method: http.MethodPost, path: "/public/page", key: testPrivateKey, keyid: testKeyID, wantStatus: http.StatusForbidden, wantBot: "crawler", wantBypass: "false"},
+		{name: "bypass", method: http.MethodPost, path: "/admin", key: monitorKey, keyid: monitorKeyID, wantStatus: http.StatusOK, wantBot: "monitor", wantBypass: "true"},
+		{name: "unnamed signer", method: http.MethodGet, path: "/admin", key: strangerKey, keyid: strangerKeyID, wantStatus: http.StatusServiceUnavailable, wantBypass: "false"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, repl := withReplacer(httptest.NewRequest(tt.method, "https://example.com"+tt.path, nil))
+			signRequest(t, r, tt.key, tt.keyid)
+			w := httptest.NewRecorder()
+			if err := m.ServeHTTP(w, r, okHandler{}); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got, _ := repl.GetString("http.httpsig.bot"); got != tt.wantBot {
+				t.Errorf("bot = %q, want %q", got, tt.wantBot)
+			}
+			if got, _ := repl.GetString("http.httpsig.bypass"); got != tt.wantBypass {
+				t.Errorf("bypass = %q, want %q", got, tt.wantBypass)
+			}
+		})
+	}
+}
+
+func TestBotByDirectory(t *testing.T) {
+	bots := []Bot{{Name: "vendor", Directories: []string{"Vendor.example"}}}
+	if err := bots[0].validate(); err != nil {
+		t.Fatal(err)
+	}
+	if bot := matchBot(bots, ValidationResult{KeyID: testKeyID, Directory: "https://vendor.example"}); bot == nil || bot.Name != "vendor" {
+		t.Errorf("matchBot = %v, want vendor", bot)
+	}
+	if bot := matchBot(bots, ValidationResult{KeyID: testKeyID}); bot != nil {
+		t.Errorf("static key matched %s", bot.Name)
+	}
+}
+
+func TestUnmarshalBot(t *testing.T) {
+	var m Middleware
+	d := caddyfile.NewTestDispenser(`httpsig {
+		bot crawler {
+			keyids abc def
+			directories crawler.example
+			paths /public/* /robots.txt
+			methods GET HEAD
+			bypass
+		}
+	}`)
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Bots) != 1 {
+		t.Fatalf("bots = %+v", m.Bots)
+	}
+	bot := m.Bots[0]
+	if bot.Name != "crawler" || len(bot.KeyIDs) != 2 || len(bot.Directories) != 1 || len(bot.Paths) != 2 || len(bot.Methods) != 2 || !bot.Bypass {
+		t.Errorf("bot = %+v", bot)
+	}
+
+	for _, config := range []string{"bot", "bot crawler {\n\tkeyids\n}", "bot crawler {\n\tquota 10\n}"} {
+		if err := (&Middleware{}).UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\n" + config + "\n}")); err == nil {
+			t.Errorf("%q accepted", config)
+		}
+	}
+	if err := (&Bot{Name: "anonymous"}).validate(); err == nil {
+		t.Error("bot without keyids or directories accepted")
+	}
+}
@@ -0,0 +1,93 @@
+package httpsig
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// clockMeasureInterval is how often clock_reference is queried again, following the host clock drift
+const clockMeasureInterval = 15 * time.Minute
+
+// OffsetClock is a clock corrected by its measured offset from a reference, for hosts whose clock drifts.
+// Signature times are checked against the corrected time, so drift neither rejects fresh signatures
+// nor needs a wider skew to absorb it. It is safe for concurrent use.
+type OffsetClock struct {
+	base   func() time.Time
+	offset atomic.Int64
+}
+
+// NewOffsetClock returns a clock reading base, which defaults to time.Now, with no offset
+func NewOffsetClock(base func() time.Time) *OffsetClock {
+	if base == nil {
+		base = time.Now
+	}
+	return &OffsetClock{base: base}
+}
+
+// Now returns the corrected time. Pass it as ValidatorOptions.Now.
+func (c *OffsetClock) Now() time.Time {
+	return c.base().Add(c.Offset())
+}
+
+// Offset returns how far the reference is ahead of the base clock
+func (c *OffsetClock) Offset() time.Duration {
+	return time.Duration(c.offset.Load())
+}
+
+// SetOffset sets the correction applied to the base clock, as measured by another time source
+func (c *OffsetClock) SetOffset(offset time.Duration) {
+	c.offset.Store(int64(offset))
+}
+
+// Measure sets the offset from the Date header of a HEAD request to url, assuming the reference stamped it
+// halfway through the round trip. Date has a resolution of one second, which bounds the accuracy.
+func (c *OffsetClock) Measure(ctx context.Context, client *http.Client, url string) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	sent := c.base()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("querying clock reference %s: %w", url, err)
+	}
+	resp.Body.Close()
+	received := c.base()
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("clock reference %s sent no valid Date: %w", url, err)
+	}
+	// Date is truncated to the second, so on average the reference time was half a second later
+	reference := date.Add(500 * time.Millisecond)
+	offset := reference.Sub(sent.Add(received.Sub(sent) / 2))
+	c.SetOffset(offset)
+	return offset, nil
+}
+
+// measureClockLoop measures the clock offset from the reference until ctx is done, keeping the last offset on failures
+func (m *Middleware) measureClockLoop(ctx context.Context, clock *OffsetClock, client *http.Client) {
+	ticker := time.NewTicker(clockMeasureInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := clock.Measure(ctx, client, m.ClockReference); err != nil {
+			m.logger.Warn("measuring clock offset failed, keeping the previous offset",
+				zap.String("clock_reference", m.ClockReference),
+				zap.Duration("offset", clock.Offset()),
+				zap.Error(err))
+		}
+	}
+}
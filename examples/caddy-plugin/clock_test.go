@@ -0,0 +1,80 @@
+package httpsig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOffsetClockMeasure(t *testing.T) {
+	local := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	reference := local.Add(90 * time.Second)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", reference.Format(http.TimeFormat))
+	}))
+	defer srv.Close()
+
+	clock := NewOffsetClock(func() time.Time { return local })
+	offset, err := clock.Measure(context.Background(), srv.Client(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Date has a one second resolution, centered on the half second
+	if want := 90*time.Second + 500*time.Millisecond; offset != want || clock.Offset() != want {
+		t.Errorf("offset = %v, want %v", offset, want)
+	}
+	if got, want := clock.Now(), local.Add(offset); !got.Equal(want) {
+		t.Errorf("Now = %v, want %v", got, want)
+	}
+}
+
+func TestClockOffsetBoundaries(t *testing.T) {
+	// Test signatures are created now and expire five minutes later
+	tests := []struct {
+		name    string
+		drift   time.Duration
+		offset  time.Duration
+		wantErr error
+	}{
+		{name: "in sync"},
+		{name: "behind within skew", drift: -59 * time.Second},
+		{name: "behind beyond skew", drift: -2 * time.Minute, wantErr: ErrSignatureNotYetValid},
+		{name: "behind corrected", drift: -2 * time.Minute, offset: 2 * time.Minute},
+		{name: "ahead before expiry", drift: 4 * time.Minute},
+		{name: "ahead past expiry", drift: 6 * time.Minute, wantErr: ErrSignatureExpired},
+		{name: "ahead corrected", drift: 6 * time.Minute, offset: -6 * time.Minute},
+		{name: "overcorrected", drift: 6 * time.Minute, offset: -8 * time.Minute, wantErr: ErrSignatureNotYetValid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := NewOffsetClock(func() time.Time { return time.Now().Add(tt.drift) })
+			clock.SetOffset(tt.offset)
+			v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{Now: clock.Now})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := v.Validate(newSignedRequest(t)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClockReference(t *testing.T) {
+	// The host clock is ten minutes ahead, which would expire every signature
+	drifted := func() time.Time { return time.Now().Add(10 * time.Minute) }
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	m := &Middleware{StaticKeys: []json.RawMessage{ed25519JWK(testPrivateKey)}, Now: drifted, ClockReference: srv.URL}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.validator.Load().Validate(newSignedRequest(t)); err != nil {
+		t.Errorf("corrected clock: %v", err)
+	}
+}
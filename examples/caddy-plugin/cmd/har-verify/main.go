@@ -0,0 +1,71 @@
+// Command har-verify checks the web-bot-auth signatures of requests captured in a HAR file.
+//
+//	har-verify -directory example.com capture.har
+//	har-verify -key key.json capture.har
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	httpsig "github.com/cloudflareresearch/web-bot-auth/examples/caddy-plugin"
+)
+
+func main() {
+	directory := flag.String("directory", "", "directory_base to fetch keys from")
+	keyFile := flag.String("key", "", "file containing a public JWK to verify with")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s (-directory <base> | -key <jwk>) <file.har>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || (*directory == "") == (*keyFile == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var keys []json.RawMessage
+	if *keyFile != "" {
+		key, err := os.ReadFile(*keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		keys = append(keys, key)
+	} else {
+		dir, _, err := (&httpsig.HTTPDirectoryFetcher{}).Fetch(context.Background(), *directory)
+		if err != nil {
+			log.Fatal(err)
+		}
+		keys = dir.Keys
+	}
+
+	validator, err := httpsig.NewValidator(keys, httpsig.ValidatorOptions{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	results, err := httpsig.VerifyHAR(validator, f)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, res := range results {
+		switch {
+		case !res.Signed:
+			fmt.Printf("#%d %s %s: unsigned\n", res.Index, res.Method, res.URL)
+		case res.Err != nil:
+			fmt.Printf("#%d %s %s: invalid: %v\n", res.Index, res.Method, res.URL, res.Err)
+		default:
+			fmt.Printf("#%d %s %s: valid, keyid %s\n", res.Index, res.Method, res.URL, res.Result.KeyID)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package httpsig
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	sfv "github.com/dunglas/httpsfv"
+)
+
+// DebugHeader is the request header carrying the debug token. Requests with the configured token are answered
+// with a DebugReport instead of being passed on or rejected.
+const DebugHeader = "Web-Bot-Auth-Debug"
+
+// DebugSignature describes how one signature of a request was verified
+type DebugSignature struct {
+	Label string `json:"label"`
+	KeyID string `json:"keyid,omitempty"`
+	// KeyFound reports whether a trusted key has the keyid
+	KeyFound  bool   `json:"key_found"`
+	Algorithm string `json:"algorithm,omitempty"`
+	// Verified reports whether the signature verified over SignatureBase
+	Verified bool `json:"verified"`
+	// SignatureBase is the RFC 9421 signature base the verifier computed, to diff against the one signed. It is
+	// that the signature verified over, if any, else that of the first attempt.
+	SignatureBase string `json:"signature_base,omitempty"`
+	// RetriedBases are the other signature bases the verifier tried, for the expected authorities and the lenient
+	// modes, in order
+	RetriedBases []string `json:"retried_bases,omitempty"`
+	// Error explains why the signature did not verify over SignatureBase, or why no signature base was computed
+	Error string `json:"error,omitempty"`
+}
+
+// DebugReport describes the verification of a request, for bot developers to find canonicalization mismatches
+type DebugReport struct {
+	Verified bool `json:"verified"`
+	// Stage is the kind of error the request was rejected with, as returned by ErrorKind
+	Stage string `json:"stage,omitempty"`
+	Error string `json:"error,omitempty"`
+	// KeyID is the keyid of the accepted signature
+	KeyID      string           `json:"keyid,omitempty"`
+	Signatures []DebugSignature `json:"signatures"`
+}
+
+// debugRequested reports whether r carries the debug token
+func (m *Middleware) debugRequested(r *http.Request) bool {
+	token := r.Header.Get(DebugHeader)
+	return m.DebugToken != "" && token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(m.DebugToken)) == 1
+}
+
+// writeDebugReport answers r with the report of its verification by validator, which ended with result and err.
+// validator is nil when none could be found for r.
+func (m *Middleware) writeDebugReport(w http.ResponseWriter, r *http.Request, validator *SignatureValidator, result ValidationResult, err error) {
+	report := DebugReport{Verified: err == nil, Stage: ErrorKind(err), KeyID: result.KeyID}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	if validator != nil {
+		report.Signatures = validator.debugSignatures(r)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(report)
+}
+
+// debugSignatures describes each signature of r, by label, with the signature bases the verifier computed for it,
+// the retries of expected authorities and lenient modes included. Signatures are verified whatever their tag.
+func (v *SignatureValidator) debugSignatures(r *http.Request) []DebugSignature {
+	r, _ = v.normalize(r)
+	inputs, err := sfv.UnmarshalDictionary(r.Header.Values("Signature-Input"))
+	if err != nil {
+		return []DebugSignature{{Error: fmt.Sprintf("parsing Signature-Input: %v", err)}}
+	}
+	trace := &baseTrace{}
+	_, verr := v.verify(withBaseTrace(r, trace))
+
+	sigs := []DebugSignature{}
+	for _, label := range slices.Sorted(slices.Values(inputs.Names())) {
+		sig := DebugSignature{Label: label}
+		member, _ := inputs.Get(label)
+		input, ok := member.(sfv.InnerList)
+		if !ok {
+			sig.Error = "Signature-Input member is not an inner list"
+			sigs = append(sigs, sig)
+			continue
+		}
+		if keyid, ok := input.Params.Get("keyid"); ok {
+			sig.KeyID, _ = keyid.(string)
+		}
+		if sig.KeyID != "" {
+			if specer, err := v.fetcher.FetchByKeyID(r.Context(), r.Header, sig.KeyID); err == nil {
+				if ks, err := specer.KeySpec(); err == nil {
+					sig.KeyFound, sig.Algorithm = true, string(ks.Algo)
+				}
+			}
+		}
+		describeAttempts(&sig, trace, verr)
+		sigs = append(sigs, sig)
+	}
+	return sigs
+}
+
+// describeAttempts sets the signature bases of sig from the attempts of trace at verifying it. verr is the error
+// of the verification, reported when no attempt was made.
+func describeAttempts(sig *DebugSignature, trace *baseTrace, verr error) {
+	var attempts []baseAttempt
+	for _, attempt := range trace.attempts {
+		if attempt.label == sig.Label {
+			attempts = append(attempts, attempt)
+		}
+	}
+	if len(attempts) == 0 {
+		if verr != nil {
+			sig.Error = verr.Error()
+		}
+		return
+	}
+	chosen := slices.IndexFunc(attempts, func(attempt baseAttempt) bool { return attempt.err == nil })
+	sig.Verified = chosen >= 0
+	if chosen < 0 {
+		chosen = 0
+	}
+	sig.SignatureBase = string(attempts[chosen].base)
+	if err := attempts[chosen].err; err != nil {
+		sig.Error = err.Error()
+	}
+	for _, attempt := range attempts {
+		if base := string(attempt.base); base != "" && base != sig.SignatureBase && !slices.Contains(sig.RetriedBases, base) {
+			sig.RetriedBases = append(sig.RetriedBases, base)
+		}
+	}
+}
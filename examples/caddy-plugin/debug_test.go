@@ -0,0 +1,118 @@
+package httpsig
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/remitly-oss/httpsig-go"
+)
+
+func TestDebugReport(t *testing.T) {
+	m := &Middleware{DebugToken: "s3cret"}
+	m.validator.Store(newTestValidator(t))
+
+	// Signed for another host and path than the request is for
+	signed := httptest.NewRequest(http.MethodGet, "https://example.com/a", nil)
+	signRequestWith(t, signed, httpsig.Algo_ED25519, testPrivateKey, testKeyID, "@authority", "@path")
+	r := httptest.NewRequest(http.MethodGet, "https://other.example/a%20b", nil)
+	r.Header = signed.Header
+	r.Header.Set(DebugHeader, "s3cret")
+
+	w := httptest.NewRecorder()
+	if err := m.ServeHTTP(w, r, okHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("status = %d, Content-Type = %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var report DebugReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Verified || report.Stage != "invalid_signature" || len(report.Signatures) != 1 {
+		t.Fatalf("report = %+v", report)
+	}
+	sig := report.Signatures[0]
+	if sig.Label != "sig1" || sig.KeyID != testKeyID || !sig.KeyFound || sig.Algorithm != string(httpsig.Algo_ED25519) {
+		t.Errorf("signature = %+v", sig)
+	}
+	if want := "\"@authority\": other.example\n\"@path\": /a%20b\n\"@signature-params\": (\"@authority\" \"@path\");"; !strings.HasPrefix(sig.SignatureBase, want) {
+		t.Errorf("signature base = %q, want prefix %q", sig.SignatureBase, want)
+	}
+
+	// Other requests are not affected
+	for _, token := range []string{"", "wrong"} {
+		r.Header.Set(DebugHeader, token)
+		w := httptest.NewRecorder()
+		if err := m.ServeHTTP(w, r, okHandler{}); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: status = %d, want %d", token, w.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestDebugReportMatchesSignedBase(t *testing.T) {
+	v := newTestValidator(t)
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/search?q=a", nil)
+	signRequestWith(t, r, httpsig.Algo_ED25519, testPrivateKey, testKeyID, "@method", "@authority", "@target-uri", "@query")
+	if _, err := v.Validate(r); err != nil {
+		t.Fatal(err)
+	}
+	sigs := v.debugSignatures(r)
+	if len(sigs) != 1 || sigs[0].Error != "" {
+		t.Fatalf("signatures = %+v", sigs)
+	}
+	want := "\"@method\": GET\n\"@authority\": example.com\n\"@target-uri\": https://example.com/search?q=a\n\"@query\": ?q=a\n"
+	if !strings.HasPrefix(sigs[0].SignatureBase, want) {
+		t.Errorf("signature base = %q, want prefix %q", sigs[0].SignatureBase, want)
+	}
+}
+
+func TestDebugReportRetries(t *testing.T) {
+	v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{HeaderWhitespace: HeaderWhitespaceLenient})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	r.Header.Set("X-List", "a, b")
+	signRequestWith(t, r, httpsig.Algo_ED25519, testPrivateKey, testKeyID, "@authority", "x-list")
+	r.Header.Set("X-List", "a,   b")
+	if _, err := v.Validate(r); err != nil {
+		t.Fatal(err)
+	}
+
+	// The signature verified once whitespace was collapsed, over the base of the retry
+	sigs := v.debugSignatures(r)
+	if len(sigs) != 1 || !sigs[0].Verified || sigs[0].Error != "" {
+		t.Fatalf("signatures = %+v", sigs)
+	}
+	if want := "\"@authority\": example.com\n\"x-list\": a, b\n"; !strings.HasPrefix(sigs[0].SignatureBase, want) {
+		t.Errorf("signature base = %q, want prefix %q", sigs[0].SignatureBase, want)
+	}
+	if want := "\"@authority\": example.com\n\"x-list\": a,   b\n"; len(sigs[0].RetriedBases) != 1 || !strings.HasPrefix(sigs[0].RetriedBases[0], want) {
+		t.Errorf("retried bases = %q, want one with prefix %q", sigs[0].RetriedBases, want)
+	}
+
+	// Without a base that verifies, that of the first attempt is reported
+	r.Header.Set("X-List", "a,   c")
+	sigs = v.debugSignatures(r)
+	if len(sigs) != 1 || sigs[0].Verified || sigs[0].Error == "" || !strings.Contains(sigs[0].SignatureBase, "\"x-list\": a,   c\n") {
+		t.Errorf("signatures = %+v", sigs)
+	}
+}
+
+func TestDebugTokenCaddyfile(t *testing.T) {
+	var m Middleware
+	if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\n\tdebug_token s3cret\n}")); err != nil {
+		t.Fatal(err)
+	}
+	if m.DebugToken != "s3cret" {
+		t.Errorf("debug_token = %q", m.DebugToken)
+	}
+}
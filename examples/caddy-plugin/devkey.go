@@ -0,0 +1,99 @@
+package httpsig
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"go.uber.org/zap"
+)
+
+// loadDevKey trusts the development key of dev_key, generated at provision or kept in DevKeyFile, and logs its
+// private JWK and keyid so a bot under test can sign with it
+func (m *Middleware) loadDevKey() error {
+	private, err := devKey(m.DevKeyFile)
+	if err != nil {
+		return fmt.Errorf("dev_key: %w", err)
+	}
+	public, err := jwk.PublicKeyOf(private)
+	if err != nil {
+		return fmt.Errorf("dev_key: reading public key: %w", err)
+	}
+	publicJWK, err := json.Marshal(public)
+	if err != nil {
+		return fmt.Errorf("dev_key: encoding public key: %w", err)
+	}
+	ks, err := parseKeySpec(publicJWK)
+	if err != nil {
+		return fmt.Errorf("dev_key: %w", err)
+	}
+	privateJWK, err := json.Marshal(private)
+	if err != nil {
+		return fmt.Errorf("dev_key: encoding private key: %w", err)
+	}
+	m.staticKeys = append(m.staticKeys, ks)
+	// The keyid is the RFC 7638 thumbprint of the key
+	m.logger.Warn("INSECURE: trusting the development key of dev_key, whose private key is logged; sign test requests with it and never enable dev_key in production",
+		zap.String("keyid", ks.KeyID), zap.ByteString("jwk", privateJWK), zap.String("file", m.DevKeyFile))
+	return nil
+}
+
+// devKey returns the Ed25519 private key held in file, as a JWK or PEM. It is generated when file is empty, and
+// also written to file when file does not exist, so the key survives restarts.
+func devKey(file string) (jwk.Key, error) {
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err == nil {
+			return parseDevKey(data)
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating key: %w", err)
+	}
+	key, err := jwk.Import(private)
+	if err != nil {
+		return nil, fmt.Errorf("importing key: %w", err)
+	}
+	if file == "" {
+		return key, nil
+	}
+	data, err := json.Marshal(key)
+	if err != nil {
+		return nil, fmt.Errorf("encoding key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// parseDevKey parses the Ed25519 private key in data, a JWK or PEM
+func parseDevKey(data []byte) (jwk.Key, error) {
+	block, _ := pem.Decode(data)
+	key, err := jwk.ParseKey(data, jwk.WithPEM(block != nil))
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+	var private any
+	if err := jwk.Export(key, &private); err != nil {
+		return nil, fmt.Errorf("reading private key: %w", err)
+	}
+	if _, ok := private.(ed25519.PrivateKey); !ok {
+		return nil, fmt.Errorf("%T is not an Ed25519 private key", private)
+	}
+	return key, nil
+}
@@ -0,0 +1,68 @@
+package httpsig
+
+import (
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
+func TestDevKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dev", "key.jwk")
+	m := &Middleware{DevKey: true, DevKeyFile: file}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := parseDevKey(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var private ed25519.PrivateKey
+	if err := jwk.Export(key, &private); err != nil {
+		t.Fatal(err)
+	}
+	_, keyid := publicJWK(t, private)
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	signRequest(t, r, private, keyid)
+	if _, err := m.validate(r); err != nil {
+		t.Errorf("request signed with the dev key: %v", err)
+	}
+
+	// The key is read back from the file, so it survives restarts
+	again := &Middleware{DevKey: true, DevKeyFile: file}
+	if err := again.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := again.validate(r); err != nil {
+		t.Errorf("request signed with the kept dev key: %v", err)
+	}
+
+	// Without a file, each provision trusts a new key
+	fresh := &Middleware{DevKey: true}
+	if err := fresh.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fresh.validate(r); err == nil {
+		t.Error("a generated dev key verified the request signed with another")
+	}
+}
+
+func TestDevKeyFileInvalid(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "key.jwk")
+	if err := os.WriteFile(file, ed25519JWK(testPrivateKey), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m := &Middleware{DevKey: true, DevKeyFile: file}
+	if err := m.Provision(newTestContext(t)); err == nil {
+		t.Error("provisioned with a public dev key")
+	}
+}
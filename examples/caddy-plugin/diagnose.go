@@ -0,0 +1,138 @@
+package httpsig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/remitly-oss/httpsig-go"
+)
+
+// DiagnosisStage is one step of the chain from directory to verified signature
+type DiagnosisStage string
+
+const (
+	// StageFetch retrieves the directory
+	StageFetch DiagnosisStage = "fetch"
+	// StageKeys parses the directory keys
+	StageKeys DiagnosisStage = "keys"
+	// StageKeyMatch finds the directory key named by the request keyid
+	StageKeyMatch DiagnosisStage = "key_match"
+	// StageVerify verifies the request signature with that key
+	StageVerify DiagnosisStage = "verify"
+)
+
+// StageResult is the outcome of one diagnosis stage
+type StageResult struct {
+	Stage DiagnosisStage
+	OK    bool
+	// Reason explains a failure. It is empty for stages that passed.
+	Reason string
+}
+
+// DiagnosedKey describes one key found in the directory
+type DiagnosedKey struct {
+	KeyID     string
+	Algorithm httpsig.Algorithm
+	// Err is set when the key cannot be used, in which case KeyID and Algorithm are empty
+	Err error
+}
+
+// DiagnosisReport describes why a request was or was not accepted for a directory
+type DiagnosisReport struct {
+	DirectoryURL string
+	Keys         []DiagnosedKey
+	// KeyID is the keyid advertised by the request signature
+	KeyID string
+	// Stages are the stages that ran, in order. Diagnosis stops at the first failing stage.
+	Stages []StageResult
+	// Result is the accepted signature when every stage passed
+	Result ValidationResult
+}
+
+// OK reports whether the request was accepted
+func (r DiagnosisReport) OK() bool {
+	return len(r.Stages) > 0 && r.Stages[len(r.Stages)-1].Stage == StageVerify && r.Stages[len(r.Stages)-1].OK
+}
+
+func (r *DiagnosisReport) pass(stage DiagnosisStage) {
+	r.Stages = append(r.Stages, StageResult{Stage: stage, OK: true})
+}
+
+func (r *DiagnosisReport) fail(stage DiagnosisStage, format string, args ...any) {
+	r.Stages = append(r.Stages, StageResult{Stage: stage, Reason: fmt.Sprintf(format, args...)})
+}
+
+// Diagnose fetches the directory advertised by directoryBase and verifies req against it, reporting the outcome
+// of each stage. It answers "why isn't my bot accepted" during onboarding.
+// Signatures are verified with the default ValidatorOptions. Failures are part of the report, the error is
+// only set when req cannot be diagnosed at all.
+func Diagnose(ctx context.Context, directoryBase string, req *http.Request) (DiagnosisReport, error) {
+	return diagnose(ctx, &HTTPDirectoryFetcher{}, directoryBase, req)
+}
+
+func diagnose(ctx context.Context, fetcher DirectoryFetcher, directoryBase string, req *http.Request) (DiagnosisReport, error) {
+	if req == nil {
+		return DiagnosisReport{}, errors.New("no request to diagnose")
+	}
+	var report DiagnosisReport
+
+	dir, meta, err := fetcher.Fetch(ctx, directoryBase)
+	report.DirectoryURL = meta.URL
+	if err != nil {
+		report.fail(StageFetch, "%v", err)
+		return report, nil
+	}
+	report.pass(StageFetch)
+
+	var specs []keySpec
+	for i, keyData := range dir.Keys {
+		ks, err := parseKeySpec(keyData)
+		if err != nil {
+			report.Keys = append(report.Keys, DiagnosedKey{Err: fmt.Errorf("key %d: %w", i, err)})
+			continue
+		}
+		report.Keys = append(report.Keys, DiagnosedKey{KeyID: ks.KeyID, Algorithm: ks.Algo})
+		specs = append(specs, ks)
+	}
+	if len(specs) == 0 {
+		report.fail(StageKeys, "directory has no usable keys out of %d", len(dir.Keys))
+		return report, nil
+	}
+	report.pass(StageKeys)
+
+	inputs, err := parseSignatureInputs(req.Header)
+	if err != nil {
+		report.fail(StageKeyMatch, "%v", err)
+		return report, nil
+	}
+	if len(inputs) == 0 {
+		report.fail(StageKeyMatch, "request has no Signature-Input")
+		return report, nil
+	}
+	report.KeyID = firstKeyID(inputs)
+	if report.KeyID == "" {
+		report.fail(StageKeyMatch, "signature has no keyid")
+		return report, nil
+	}
+	if !slices.ContainsFunc(specs, func(ks keySpec) bool { return ks.KeyID == report.KeyID }) {
+		report.fail(StageKeyMatch, "keyid %q is not in the directory", report.KeyID)
+		return report, nil
+	}
+	report.pass(StageKeyMatch)
+
+	v, err := newValidator(specs, ValidatorOptions{})
+	if err != nil {
+		return report, err
+	}
+	result, err := v.Validate(req)
+	if err != nil {
+		report.fail(StageVerify, "%v", err)
+		return report, nil
+	}
+	report.Result = result
+	report.pass(StageVerify)
+	return report, nil
+}
@@ -0,0 +1,103 @@
+package httpsig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDiagnose(t *testing.T) {
+	valid := directoryOf(ed25519JWK(testPrivateKey))
+	tests := []struct {
+		name      string
+		response  fakeResponse
+		request   func(t *testing.T) *http.Request
+		wantStage DiagnosisStage // stage expected to fail, empty when all pass
+		wantKeys  int
+	}{
+		{
+			name:     "accepted",
+			response: fakeResponse{dir: valid},
+			request:  newSignedRequest,
+			wantKeys: 1,
+		},
+		{
+			name:      "fetch failure",
+			response:  fakeResponse{err: errors.New("connection refused")},
+			request:   newSignedRequest,
+			wantStage: StageFetch,
+		},
+		{
+			name:      "no usable keys",
+			response:  fakeResponse{dir: directoryOf(json.RawMessage(`{"kty":"OKP"}`))},
+			request:   newSignedRequest,
+			wantStage: StageKeys,
+			wantKeys:  1,
+		},
+		{
+			name:     "unsigned",
+			response: fakeResponse{dir: valid},
+			request: func(t *testing.T) *http.Request {
+				return httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+			},
+			wantStage: StageKeyMatch,
+			wantKeys:  1,
+		},
+		{
+			name:     "unknown keyid",
+			response: fakeResponse{dir: valid},
+			request: func(t *testing.T) *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+				signRequest(t, r, testPrivateKey, "another-bot")
+				return r
+			},
+			wantStage: StageKeyMatch,
+			wantKeys:  1,
+		},
+		{
+			name:     "signature mismatch",
+			response: fakeResponse{dir: valid},
+			request: func(t *testing.T) *http.Request {
+				r := newSignedRequest(t)
+				r.Host = "example.org"
+				return r
+			},
+			wantStage: StageVerify,
+			wantKeys:  1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := &fakeFetcher{responses: []fakeResponse{tt.response}}
+			report, err := diagnose(context.Background(), fetcher, "example.com", tt.request(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(report.Keys) != tt.wantKeys {
+				t.Errorf("reported %d keys, want %d", len(report.Keys), tt.wantKeys)
+			}
+			if report.OK() != (tt.wantStage == "") {
+				t.Errorf("OK() = %v, stages %+v", report.OK(), report.Stages)
+			}
+
+			last := report.Stages[len(report.Stages)-1]
+			if tt.wantStage == "" {
+				if report.Result.KeyID != testKeyID {
+					t.Errorf("Result.KeyID = %q, want %q", report.Result.KeyID, testKeyID)
+				}
+				return
+			}
+			if last.Stage != tt.wantStage || last.OK || last.Reason == "" {
+				t.Errorf("last stage = %+v, want a failure at %s with a reason", last, tt.wantStage)
+			}
+			for _, stage := range report.Stages[:len(report.Stages)-1] {
+				if !stage.OK {
+					t.Errorf("stage %s failed before %s", stage.Stage, tt.wantStage)
+				}
+			}
+		})
+	}
+}
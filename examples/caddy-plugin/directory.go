@@ -0,0 +1,482 @@
+package httpsig
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+)
+
+// wellKnownDirectory is the path of the key directory relative to directory_base
+const wellKnownDirectory = "/.well-known/http-message-signatures-directory"
+
+// DefaultDirectoryPaths are the locations tried, in order, when directory_base is a bare host
+var DefaultDirectoryPaths = []string{wellKnownDirectory}
+
+// directoryURL resolves directory_base to the URL of the key directory at its default location
+func directoryURL(base string) (string, error) {
+	urls, err := directoryURLs(base, nil)
+	if err != nil {
+		return "", err
+	}
+	return urls[0], nil
+}
+
+// directoryURLs resolves directory_base to the URLs the key directory may be served at, in priority order.
+// A bare host tries each of paths, DefaultDirectoryPaths when empty. A URL with a path beyond the host,
+// or ending in .json, is used verbatim, which accommodates hosts that cannot serve .well-known at their root.
+// A bare host uses https; plaintext http must be spelled out, and is only fetched with insecure_http.
+func directoryURLs(base string, paths []string) ([]string, error) {
+	if !strings.Contains(base, "://") {
+		base = "https://" + base
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, fmt.Errorf("parsing directory_base: %w", err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, fmt.Errorf("directory_base must use https, got %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("directory_base %q has no host", base)
+	}
+	if strings.HasSuffix(u.Path, ".json") || strings.Trim(u.Path, "/") != "" {
+		return []string{u.String()}, nil
+	}
+	if len(paths) == 0 {
+		paths = DefaultDirectoryPaths
+	}
+	urls := make([]string, 0, len(paths))
+	for _, path := range paths {
+		u.Path = path
+		urls = append(urls, u.String())
+	}
+	return urls, nil
+}
+
+type Directory struct {
+	Keys    []json.RawMessage `json:"keys"`
+	Purpose *string           `json:"purpose,omitempty"`
+}
+
+// ErrPurposeMismatch is returned for directories that do not declare one of the purposes in required_purpose
+var ErrPurposeMismatch = errors.New("directory purpose is not accepted")
+
+// checkPurpose rejects dir unless it declares one of purposes. Any directory is accepted when purposes is empty.
+func (dir Directory) checkPurpose(purposes []string) error {
+	if len(purposes) == 0 {
+		return nil
+	}
+	if dir.Purpose == nil {
+		return fmt.Errorf("%w: no purpose declared, want one of %v", ErrPurposeMismatch, purposes)
+	}
+	if !slices.Contains(purposes, *dir.Purpose) {
+		return fmt.Errorf("%w: %q, want one of %v", ErrPurposeMismatch, *dir.Purpose, purposes)
+	}
+	return nil
+}
+
+// FetchMeta describes how a directory was retrieved
+type FetchMeta struct {
+	// URL is the location the directory was fetched from
+	URL string
+	// FetchedAt is when the response was received
+	FetchedAt time.Time
+	// NotModified is true when the directory did not change since the previous fetch, as a 304 response tells or
+	// because the previous response is still fresh. The returned Directory is then the previous one when the fetcher
+	// kept it, and empty otherwise, so callers holding keys from the previous fetch need not parse it again.
+	NotModified bool
+}
+
+// DirectoryFetcher retrieves the key directory advertised by directory_base.
+// Production uses HTTPDirectoryFetcher; tests can substitute sequences of directories, errors and 304s.
+type DirectoryFetcher interface {
+	Fetch(ctx context.Context, base string) (Directory, FetchMeta, error)
+}
+
+// RateLimitedError is returned when the directory host asks clients to back off with 429 or 503
+type RateLimitedError struct {
+	URL    string
+	Status int
+	// RetryAfter is when the host accepts new requests. It is zero when Retry-After was absent or invalid.
+	RetryAfter time.Time
+}
+
+func (e *RateLimitedError) Error() string {
+	if e.RetryAfter.IsZero() {
+		return fmt.Sprintf("fetching directory %s: rate limited with status %d", e.URL, e.Status)
+	}
+	return fmt.Sprintf("fetching directory %s: rate limited with status %d until %s", e.URL, e.Status, e.RetryAfter.Format(time.RFC3339))
+}
+
+// parseRetryAfter parses a Retry-After value, either delay-seconds or an HTTP-date, relative to now
+func parseRetryAfter(value string, now time.Time) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return time.Time{}, false
+		}
+		return now.Add(time.Duration(seconds) * time.Second), true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// ParseTLSVersion returns the crypto/tls version for "1.2" or "1.3".
+// Older versions are not accepted for the key distribution channel.
+func ParseTLSVersion(s string) (uint16, error) {
+	switch s {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q, must be 1.2 or 1.3", s)
+}
+
+// ErrDirectoryTooLarge is returned for directories larger than the fetcher's MaxSize or with more than MaxKeys keys
+var ErrDirectoryTooLarge = errors.New("directory too large")
+
+const (
+	// DefaultMaxDirectorySize is the largest directory body read when max_directory_size is unset
+	DefaultMaxDirectorySize = 1 << 20
+	// DefaultMaxDirectoryKeys is the most keys a directory may publish when max_directory_keys is unset
+	DefaultMaxDirectoryKeys = 100
+)
+
+// DefaultDirectoryTimeout bounds each directory request when directory_timeout is unset
+const DefaultDirectoryTimeout = 10 * time.Second
+
+// directoryClientConfig configures the HTTP clients directories are fetched with
+type directoryClientConfig struct {
+	// minVersion is the lowest TLS version accepted
+	minVersion uint16
+	// timeout bounds each request, including reading the response body
+	timeout time.Duration
+	// proxy, when set, routes every request through it instead of the proxy from the environment
+	proxy *url.URL
+	// rootCAs, when set, are the roots directory hosts are verified against instead of the system ones
+	rootCAs *x509.CertPool
+}
+
+// newDirectoryClient returns an HTTP client for directory fetches that refuses TLS versions below config.minVersion
+func newDirectoryClient(config directoryClientConfig) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{MinVersion: config.minVersion, RootCAs: config.rootCAs}
+	if config.proxy != nil {
+		transport.Proxy = http.ProxyURL(config.proxy)
+	}
+	return &http.Client{Transport: transport, Timeout: config.timeout}
+}
+
+// loadRootCAs returns the system roots along with the PEM certificates in files, for directory hosts served by a
+// private CA or reached through a TLS-inspecting proxy
+func loadRootCAs(files []string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if err := appendCertFiles(pool, files); err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+// loadCertPool returns a pool of only the PEM certificates in files
+func loadCertPool(files []string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if err := appendCertFiles(pool, files); err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+// appendCertFiles adds the PEM certificates in files to pool
+func appendCertFiles(pool *x509.CertPool, files []string) error {
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("%s: no PEM certificates", file)
+		}
+	}
+	return nil
+}
+
+// HTTPDirectoryFetcher fetches directories over HTTPS, or plaintext HTTP with InsecureHTTP
+type HTTPDirectoryFetcher struct {
+	// Client performs the requests. Defaults to http.DefaultClient.
+	Client *http.Client
+	// Root, when set, rejects directories that are not signed by it
+	Root *DirectoryRoot
+	// Paths are the locations tried in order on a bare directory_base host. Defaults to DefaultDirectoryPaths.
+	Paths []string
+	// RequireSelfSigned rejects directories whose response is not signed by each of the keys they publish
+	RequireSelfSigned bool
+	// UserAgent, when set, is sent with each request so directory hosts can tell the verifier apart
+	UserAgent string
+	// MaxSize is the largest directory body read, in bytes. Defaults to DefaultMaxDirectorySize.
+	MaxSize int64
+	// MaxKeys is the most keys a directory may publish. Defaults to DefaultMaxDirectoryKeys.
+	MaxKeys int
+	// InsecureHTTP fetches directory_base URLs spelled with http://, whose keys anyone on the path can replace
+	InsecureHTTP bool
+
+	mu        sync.Mutex
+	responses map[string]cachedResponse
+	flights   singleflight.Group
+}
+
+// directoryFetchTimeout bounds a directory fetch, which callers share and may outlive the one that started it
+const directoryFetchTimeout = 30 * time.Second
+
+// maxDirectoryFreshness caps the Cache-Control max-age of directories, so that a directory host cannot keep
+// rotated keys from being picked up for longer
+const maxDirectoryFreshness = 24 * time.Hour
+
+// cachedResponse is the last directory served at a URL, with the validators and freshness of its response
+type cachedResponse struct {
+	dir          Directory
+	fetchedAt    time.Time
+	etag         string
+	lastModified string
+	freshUntil   time.Time
+}
+
+// freshness returns how long a directory response may be used without asking the host again, from its
+// Cache-Control max-age less its Age, and whether it may be stored at all
+func freshness(header http.Header) (time.Duration, bool) {
+	var maxAge time.Duration
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			switch strings.ToLower(name) {
+			case "no-store":
+				return 0, false
+			case "no-cache":
+				return 0, true
+			case "max-age":
+				if seconds, err := strconv.Atoi(strings.Trim(arg, `"`)); err == nil && seconds > 0 {
+					maxAge = time.Duration(seconds) * time.Second
+				}
+			}
+		}
+	}
+	if age, err := strconv.Atoi(header.Get("Age")); err == nil && age > 0 {
+		maxAge -= time.Duration(age) * time.Second
+	}
+	return min(max(maxAge, 0), maxDirectoryFreshness), true
+}
+
+// Fetch implements DirectoryFetcher.
+// Each of the directory URLs is tried in priority order, and the first that serves a directory is used.
+// A rate limited host is not asked again at the next location.
+func (f *HTTPDirectoryFetcher) Fetch(ctx context.Context, base string) (dir Directory, meta FetchMeta, err error) {
+	ctx, span := tracer(ctx).Start(ctx, "httpsig.directory.fetch", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() {
+		span.SetAttributes(attribute.String("httpsig.directory", base), attribute.String("url.full", meta.URL))
+		if u, perr := url.Parse(meta.URL); perr == nil {
+			span.SetAttributes(attribute.String("server.address", u.Hostname()))
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else {
+			span.SetAttributes(attribute.Bool("httpsig.directory.not_modified", meta.NotModified), attribute.Int("httpsig.directory.keys", len(dir.Keys)))
+		}
+		span.End()
+	}()
+
+	urls, err := directoryURLs(base, f.Paths)
+	if err != nil {
+		return Directory{}, FetchMeta{}, err
+	}
+	u, _ := url.Parse(urls[0])
+	if u.Scheme == "http" && !f.InsecureHTTP {
+		return Directory{}, FetchMeta{URL: urls[0]}, fmt.Errorf("directory_base %s uses plaintext http, which needs insecure_http", base)
+	}
+	// Bases spelled differently but naming the same directory share a flight
+	u.Scheme, u.Host = strings.ToLower(u.Scheme), strings.ToLower(u.Host)
+	key := u.String()
+
+	// Fetches share a flight per directory, so a burst of requests discovering a directory fetches it once. Other
+	// directories of the same host are fetched meanwhile, so that a slow one does not hold the others up.
+	ch := f.flights.DoChan(key, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), directoryFetchTimeout)
+		defer cancel()
+		dir, meta, err := f.fetchURLs(ctx, urls)
+		return flight{dir: dir, meta: meta}, err
+	})
+	select {
+	case <-ctx.Done():
+		return Directory{}, FetchMeta{URL: urls[0]}, ctx.Err()
+	case res := <-ch:
+		done := res.Val.(flight)
+		return done.dir, done.meta, res.Err
+	}
+}
+
+// flight is the outcome of a directory fetch shared by concurrent callers
+type flight struct {
+	dir  Directory
+	meta FetchMeta
+}
+
+// fetchURLs retrieves the directory from the first of urls serving one
+func (f *HTTPDirectoryFetcher) fetchURLs(ctx context.Context, urls []string) (Directory, FetchMeta, error) {
+	var errs []error
+	for _, directory := range urls {
+		dir, meta, err := f.fetch(ctx, directory)
+		var rle *RateLimitedError
+		if err == nil || errors.As(err, &rle) || ctx.Err() != nil {
+			return dir, meta, err
+		}
+		errs = append(errs, err)
+	}
+	return Directory{}, FetchMeta{URL: urls[0]}, errors.Join(errs...)
+}
+
+// fetch retrieves the directory at one URL. The previous response is used while it is fresh, then revalidated with
+// If-None-Match and If-Modified-Since.
+func (f *HTTPDirectoryFetcher) fetch(ctx context.Context, directory string) (Directory, FetchMeta, error) {
+	meta := FetchMeta{URL: directory}
+	f.mu.Lock()
+	cached, ok := f.responses[directory]
+	f.mu.Unlock()
+	if ok && time.Now().Before(cached.freshUntil) {
+		return cached.dir, FetchMeta{URL: directory, FetchedAt: cached.fetchedAt, NotModified: true}, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, directory, nil)
+	if err != nil {
+		return Directory{}, meta, err
+	}
+	if f.UserAgent != "" {
+		req.Header.Set("User-Agent", f.UserAgent)
+	}
+	if ok && cached.etag != "" {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+	if ok && cached.lastModified != "" {
+		req.Header.Set("If-Modified-Since", cached.lastModified)
+	}
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Directory{}, meta, fmt.Errorf("fetching directory %s: %w", directory, err)
+	}
+	defer resp.Body.Close()
+	meta.FetchedAt = time.Now()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		meta.NotModified = true
+		if !ok {
+			return Directory{}, meta, nil
+		}
+		fresh, _ := freshness(resp.Header)
+		cached.fetchedAt, cached.freshUntil = meta.FetchedAt, meta.FetchedAt.Add(fresh)
+		f.store(directory, cached)
+		return cached.dir, meta, nil
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		until, _ := parseRetryAfter(resp.Header.Get("Retry-After"), meta.FetchedAt)
+		return Directory{}, meta, &RateLimitedError{URL: directory, Status: resp.StatusCode, RetryAfter: until}
+	default:
+		return Directory{}, meta, fmt.Errorf("fetching directory %s: unexpected status %s", directory, resp.Status)
+	}
+
+	maxSize := f.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxDirectorySize
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(nil, resp.Body, maxSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return Directory{}, meta, fmt.Errorf("%w: %s exceeds %d bytes", ErrDirectoryTooLarge, directory, tooLarge.Limit)
+		}
+		return Directory{}, meta, fmt.Errorf("reading directory %s: %w", directory, err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	if f.Root != nil {
+		if err := f.Root.Verify(resp); err != nil {
+			return Directory{}, meta, fmt.Errorf("verifying directory %s: %w", directory, err)
+		}
+	}
+
+	var dir Directory
+	if err := json.NewDecoder(resp.Body).Decode(&dir); err != nil {
+		return Directory{}, meta, fmt.Errorf("decoding directory %s: %w", directory, err)
+	}
+	if dir.Keys == nil {
+		return Directory{}, meta, fmt.Errorf("decoding directory %s: no keys member", directory)
+	}
+	maxKeys := f.MaxKeys
+	if maxKeys <= 0 {
+		maxKeys = DefaultMaxDirectoryKeys
+	}
+	if len(dir.Keys) > maxKeys {
+		return Directory{}, meta, fmt.Errorf("%w: %s publishes %d keys, more than %d", ErrDirectoryTooLarge, directory, len(dir.Keys), maxKeys)
+	}
+	if f.RequireSelfSigned {
+		if err := verifySelfSigned(resp, dir, meta.FetchedAt); err != nil {
+			return Directory{}, meta, fmt.Errorf("verifying directory %s: %w", directory, err)
+		}
+	}
+	if fresh, storable := freshness(resp.Header); storable {
+		f.store(directory, cachedResponse{
+			dir:          dir,
+			fetchedAt:    meta.FetchedAt,
+			etag:         resp.Header.Get("ETag"),
+			lastModified: resp.Header.Get("Last-Modified"),
+			freshUntil:   meta.FetchedAt.Add(fresh),
+		})
+	} else {
+		f.store(directory, cachedResponse{})
+	}
+	return dir, meta, nil
+}
+
+// store records the response served at directory, or forgets it when resp is empty
+func (f *HTTPDirectoryFetcher) store(directory string, resp cachedResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if resp.dir.Keys == nil {
+		delete(f.responses, directory)
+		return
+	}
+	if f.responses == nil {
+		f.responses = map[string]cachedResponse{}
+	}
+	f.responses[directory] = resp
+}
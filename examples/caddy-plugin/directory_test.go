@@ -0,0 +1,986 @@
+package httpsig
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/remitly-oss/httpsig-go"
+)
+
+func TestDirectoryURL(t *testing.T) {
+	tests := []struct {
+		base    string
+		want    string
+		wantErr bool
+	}{
+		{base: "example.com", want: "https://example.com/.well-known/http-message-signatures-directory"},
+		{base: "example.com/", want: "https://example.com/.well-known/http-message-signatures-directory"},
+		{base: "https://example.com", want: "https://example.com/.well-known/http-message-signatures-directory"},
+		{base: "example.com:8443", want: "https://example.com:8443/.well-known/http-message-signatures-directory"},
+		{base: "example.com/~bot/directory", want: "https://example.com/~bot/directory"},
+		{base: "example.com/directory.json", want: "https://example.com/directory.json"},
+		{base: "https://cdn.example.net/bots/keys/", want: "https://cdn.example.net/bots/keys/"},
+		{base: "https://example.com/dir.json?v=2", want: "https://example.com/dir.json?v=2"},
+		{base: "http://localhost:8080", want: "http://localhost:8080/.well-known/http-message-signatures-directory"},
+		{base: "ftp://example.com", wantErr: true},
+		{base: "https://", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.base, func(t *testing.T) {
+			got, err := directoryURL(tt.base)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("directoryURL(%q) error = %v, wantErr %v", tt.base, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("directoryURL(%q) = %q, want %q", tt.base, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefreshRotation(t *testing.T) {
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherJWK, otherKeyID := publicJWK(t, otherKey)
+	fetchErr := errors.New("connection refused")
+
+	tests := []struct {
+		name      string
+		responses []fakeResponse
+		wantTest  bool // test key accepted after refresh
+		wantOther bool // other key accepted after refresh
+		wantErr   bool
+	}{
+		{
+			name:      "rotated",
+			responses: []fakeResponse{{dir: directoryOf(ed25519JWK(testPrivateKey))}, {dir: directoryOf(otherJWK)}},
+			wantOther: true,
+		},
+		{
+			name:      "key added",
+			responses: []fakeResponse{{dir: directoryOf(ed25519JWK(testPrivateKey))}, {dir: directoryOf(ed25519JWK(testPrivateKey), otherJWK)}},
+			wantTest:  true,
+			wantOther: true,
+		},
+		{
+			name:      "not modified",
+			responses: []fakeResponse{{dir: directoryOf(ed25519JWK(testPrivateKey))}, {notModified: true}},
+			wantTest:  true,
+		},
+		{
+			name:      "fetch failure keeps current keys",
+			responses: []fakeResponse{{dir: directoryOf(ed25519JWK(testPrivateKey))}, {err: fetchErr}},
+			wantTest:  true,
+			wantErr:   true,
+		},
+		{
+			name:      "invalid directory keeps current keys",
+			responses: []fakeResponse{{dir: directoryOf(ed25519JWK(testPrivateKey))}, {dir: directoryOf(json.RawMessage(`{"kty":"OKP"}`))}},
+			wantTest:  true,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := &fakeFetcher{responses: tt.responses}
+			m := &Middleware{DirectoryBase: "example.com", Fetcher: fetcher}
+			if err := m.Provision(newTestContext(t)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := m.validator.Load().Validate(newSignedRequest(t)); err != nil {
+				t.Fatalf("initial directory: %v", err)
+			}
+
+			err := m.refresh(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("refresh error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if fetcher.calls != 2 {
+				t.Errorf("fetched %d times, want 2", fetcher.calls)
+			}
+
+			_, err = m.validator.Load().Validate(newSignedRequest(t))
+			if got := err == nil; got != tt.wantTest {
+				t.Errorf("test key accepted = %v, want %v (err: %v)", got, tt.wantTest, err)
+			}
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+			signRequest(t, r, otherKey, otherKeyID)
+			_, err = m.validator.Load().Validate(r)
+			if got := err == nil; got != tt.wantOther {
+				t.Errorf("other key accepted = %v, want %v (err: %v)", got, tt.wantOther, err)
+			}
+		})
+	}
+}
+
+func TestAllDirectoryKeys(t *testing.T) {
+	keys := make([]ed25519.PrivateKey, 3)
+	jwks := make([]json.RawMessage, 3)
+	keyids := make([]string, 3)
+	for i := range keys {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = key
+		jwks[i], keyids[i] = publicJWK(t, key)
+	}
+	m := &Middleware{DirectoryBase: "example.com", Fetcher: &fakeFetcher{responses: []fakeResponse{{dir: directoryOf(jwks...)}}}}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each key is looked up by the keyid of the signature, not only the first key of the directory
+	for i, key := range keys {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		signRequest(t, r, key, keyids[i])
+		result, err := m.validator.Load().Validate(r)
+		if err != nil {
+			t.Errorf("key %d: %v", i, err)
+		} else if result.KeyID != keyids[i] {
+			t.Errorf("key %d verified as %s, want %s", i, result.KeyID, keyids[i])
+		}
+	}
+}
+
+func TestProvisionFetchFailure(t *testing.T) {
+	m := &Middleware{DirectoryBase: "example.com", Fetcher: &fakeFetcher{responses: []fakeResponse{{err: errors.New("timeout")}}}}
+	if err := m.Provision(newTestContext(t)); err == nil {
+		t.Fatal("Provision succeeded without a directory")
+	}
+}
+
+func TestHTTPDirectoryFetcher(t *testing.T) {
+	status := http.StatusOK
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != wellKnownDirectory {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			fmt.Fprintf(w, `{"keys":[%s]}`, ed25519JWK(testPrivateKey))
+		}
+	}))
+	defer srv.Close()
+
+	f := &HTTPDirectoryFetcher{Client: srv.Client()}
+	dir, meta, err := f.Fetch(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dir.Keys) != 1 || meta.NotModified || meta.URL != srv.URL+wellKnownDirectory {
+		t.Errorf("Fetch = %d keys, %+v", len(dir.Keys), meta)
+	}
+
+	status = http.StatusNotModified
+	if _, meta, err = f.Fetch(context.Background(), srv.URL); err != nil || !meta.NotModified {
+		t.Errorf("304: meta = %+v, err = %v", meta, err)
+	}
+
+	status = http.StatusInternalServerError
+	if _, _, err = f.Fetch(context.Background(), srv.URL); err == nil {
+		t.Error("500: expected an error")
+	}
+}
+
+func TestInsecureHTTP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"keys":[%s]}`, ed25519JWK(testPrivateKey))
+	}))
+	defer srv.Close()
+
+	f := &HTTPDirectoryFetcher{Client: srv.Client()}
+	if _, _, err := f.Fetch(context.Background(), srv.URL); err == nil {
+		t.Error("fetched a plaintext directory without InsecureHTTP")
+	}
+	m := &Middleware{DirectoryBase: srv.URL}
+	if err := m.Provision(newTestContext(t)); err == nil {
+		t.Error("provisioned a plaintext directory_base without insecure_http")
+	}
+
+	m = &Middleware{DirectoryBase: srv.URL, InsecureHTTP: true}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.validate(newSignedRequest(t)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHTTPDirectoryFetcherLimits(t *testing.T) {
+	keys := 3
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwks := make([]string, keys)
+		for i := range jwks {
+			jwks[i] = string(ed25519JWK(testPrivateKey))
+		}
+		fmt.Fprintf(w, `{"keys":[%s]}`, strings.Join(jwks, ","))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		fetcher *HTTPDirectoryFetcher
+		wantErr bool
+	}{
+		{name: "defaults", fetcher: &HTTPDirectoryFetcher{}},
+		{name: "too large", fetcher: &HTTPDirectoryFetcher{MaxSize: 64}, wantErr: true},
+		{name: "too many keys", fetcher: &HTTPDirectoryFetcher{MaxKeys: 2}, wantErr: true},
+		{name: "at the key limit", fetcher: &HTTPDirectoryFetcher{MaxKeys: 3}},
+	}
+	for _, tt := range tests {
+		tt.fetcher.Client = srv.Client()
+		_, _, err := tt.fetcher.Fetch(context.Background(), srv.URL)
+		if tt.wantErr != errors.Is(err, ErrDirectoryTooLarge) || !tt.wantErr && err != nil {
+			t.Errorf("%s: err = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	keys = DefaultMaxDirectoryKeys + 1
+	if _, _, err := (&HTTPDirectoryFetcher{Client: srv.Client()}).Fetch(context.Background(), srv.URL); !errors.Is(err, ErrDirectoryTooLarge) {
+		t.Errorf("%d keys: err = %v", keys, err)
+	}
+
+	var m Middleware
+	if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\nmax_directory_size 64KiB\nmax_directory_keys 10\n}")); err != nil {
+		t.Fatal(err)
+	}
+	if m.MaxDirectorySize != 64<<10 || m.MaxDirectoryKeys != 10 {
+		t.Errorf("parsed size %d, keys %d", m.MaxDirectorySize, m.MaxDirectoryKeys)
+	}
+	if err := (&Middleware{}).UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\nmax_directory_keys 0\n}")); err == nil {
+		t.Error("max_directory_keys 0 accepted")
+	}
+}
+
+func TestHTTPDirectoryFetcherCaching(t *testing.T) {
+	var requests []http.Header
+	cacheControl := "max-age=60"
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Clone())
+		w.Header().Set("Cache-Control", cacheControl)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Wed, 01 Jan 2025 00:00:00 GMT")
+		fmt.Fprintf(w, `{"keys":[%s]}`, ed25519JWK(testPrivateKey))
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	// A fresh directory is not fetched again
+	f := &HTTPDirectoryFetcher{Client: srv.Client()}
+	if _, _, err := f.Fetch(ctx, srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	dir, meta, err := f.Fetch(ctx, srv.URL)
+	if err != nil || !meta.NotModified || len(dir.Keys) != 1 || len(requests) != 1 {
+		t.Errorf("fresh directory: %d keys, %+v, %v after %d requests", len(dir.Keys), meta, err, len(requests))
+	}
+
+	// A stale one is revalidated, and a 304 returns the previous directory
+	cacheControl = "no-cache"
+	f = &HTTPDirectoryFetcher{Client: srv.Client()}
+	requests = nil
+	for range 2 {
+		if dir, meta, err = f.Fetch(ctx, srv.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(requests) != 2 || requests[1].Get("If-None-Match") != `"v1"` || requests[1].Get("If-Modified-Since") == "" {
+		t.Fatalf("requests = %v, want a conditional second request", requests)
+	}
+	if !meta.NotModified || len(dir.Keys) != 1 {
+		t.Errorf("304: %d keys, %+v", len(dir.Keys), meta)
+	}
+
+	// Responses that must not be stored are fetched in full every time
+	cacheControl = "no-store, max-age=60"
+	f = &HTTPDirectoryFetcher{Client: srv.Client()}
+	requests = nil
+	for range 2 {
+		if _, meta, err = f.Fetch(ctx, srv.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(requests) != 2 || requests[1].Get("If-None-Match") != "" || meta.NotModified {
+		t.Errorf("no-store: requests = %v, meta = %+v", requests, meta)
+	}
+}
+
+func TestHTTPDirectoryFetcherSingleflight(t *testing.T) {
+	const callers = 20
+	var mu sync.Mutex
+	requests := map[string]int{}
+	var started sync.WaitGroup
+	started.Add(callers)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		// The response waits for every caller, so that they all share the flight. Those joining once it landed use
+		// the response, fresh for a minute, without another request.
+		started.Wait()
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprintf(w, `{"keys":[%s]}`, ed25519JWK(testPrivateKey))
+	}))
+	defer srv.Close()
+
+	// A burst for one directory, and one for another directory of the same host
+	f := &HTTPDirectoryFetcher{Client: srv.Client()}
+	bases := []string{srv.URL, srv.URL + "/other.json"}
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			base := bases[i%2]
+			started.Done()
+			_, meta, err := f.Fetch(context.Background(), base)
+			if err == nil && meta.URL != base && meta.URL != base+wellKnownDirectory {
+				err = fmt.Errorf("fetched %s for %s", meta.URL, base)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if len(requests) != 2 || requests[wellKnownDirectory] != 1 || requests["/other.json"] != 1 {
+		t.Errorf("requests = %v, want one per directory", requests)
+	}
+}
+
+func TestHTTPDirectoryFetcherSlowDirectory(t *testing.T) {
+	arrived, release := make(chan struct{}), make(chan struct{})
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow.json" {
+			close(arrived)
+			<-release
+		}
+		fmt.Fprintf(w, `{"keys":[%s]}`, ed25519JWK(testPrivateKey))
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	// A directory of the same host is fetched while another hangs
+	f := &HTTPDirectoryFetcher{Client: srv.Client()}
+	go f.Fetch(context.Background(), srv.URL+"/slow.json")
+	<-arrived
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, _, err := f.Fetch(ctx, srv.URL+"/fast.json"); err != nil {
+		t.Errorf("fetch while another directory of the host hangs: %v", err)
+	}
+}
+
+func TestFreshness(t *testing.T) {
+	for _, tt := range []struct {
+		cacheControl, age string
+		want              time.Duration
+		storable          bool
+	}{
+		{"", "", 0, true},
+		{"max-age=300", "", 5 * time.Minute, true},
+		{"public, MAX-AGE=300", "100", 200 * time.Second, true},
+		{"max-age=300", "600", 0, true},
+		{"max-age=31536000", "", maxDirectoryFreshness, true},
+		{"no-cache, max-age=300", "", 0, true},
+		{"no-store", "", 0, false},
+		{"max-age=invalid", "", 0, true},
+	} {
+		header := http.Header{"Cache-Control": {tt.cacheControl}, "Age": {tt.age}}
+		if got, storable := freshness(header); got != tt.want || storable != tt.storable {
+			t.Errorf("freshness(%q, Age %q) = %v, %v, want %v, %v", tt.cacheControl, tt.age, got, storable, tt.want, tt.storable)
+		}
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint16
+		wantErr bool
+	}{
+		{in: "1.2", want: tls.VersionTLS12},
+		{in: "1.3", want: tls.VersionTLS13},
+		{in: "1.1", wantErr: true},
+		{in: "tls1.3", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTLSVersion(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseTLSVersion(%q) = %v, %v; want %v, wantErr %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestProvisionInvalidMinTLS(t *testing.T) {
+	m := &Middleware{DirectoryBase: "example.com", DirectoryMinTLS: "1.0"}
+	if err := m.Provision(newTestContext(t)); err == nil {
+		t.Fatal("Provision accepted directory_min_tls 1.0")
+	}
+}
+
+func TestDirectoryClientMinTLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"keys":[%s]}`, ed25519JWK(testPrivateKey))
+	}))
+	srv.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	srv.StartTLS()
+	defer srv.Close()
+	roots := srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	tests := []struct {
+		minVersion uint16
+		wantErr    bool
+	}{
+		{minVersion: tls.VersionTLS12},
+		{minVersion: tls.VersionTLS13, wantErr: true},
+	}
+	for _, tt := range tests {
+		client := newDirectoryClient(directoryClientConfig{minVersion: tt.minVersion})
+		transport := client.Transport.(*http.Transport)
+		if transport.TLSClientConfig.MinVersion != tt.minVersion {
+			t.Errorf("MinVersion = %x, want %x", transport.TLSClientConfig.MinVersion, tt.minVersion)
+		}
+		transport.TLSClientConfig.RootCAs = roots
+
+		_, _, err := (&HTTPDirectoryFetcher{Client: client}).Fetch(context.Background(), srv.URL)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("min %x against a TLS 1.2 server: err = %v, wantErr %v", tt.minVersion, err, tt.wantErr)
+		}
+	}
+}
+
+func TestDirectoryClientSettings(t *testing.T) {
+	var userAgent string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+		fmt.Fprintf(w, `{"keys":[%s]}`, ed25519JWK(testPrivateKey))
+	}))
+	defer srv.Close()
+	caFile, notPEM := filepath.Join(t.TempDir(), "ca.pem"), filepath.Join(t.TempDir(), "ca.der")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(notPEM, srv.Certificate().Raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Middleware{DirectoryBase: srv.URL, DirectoryRootCAs: []string{caFile}, DirectoryUserAgent: "example-verifier/1.0"}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	if userAgent != "example-verifier/1.0" {
+		t.Errorf("User-Agent = %q", userAgent)
+	}
+	if client := m.Fetcher.(*HTTPDirectoryFetcher).Client; client.Timeout != DefaultDirectoryTimeout {
+		t.Errorf("Timeout = %v, want %v", client.Timeout, DefaultDirectoryTimeout)
+	}
+
+	config, err := (&Middleware{DirectoryTimeout: caddy.Duration(time.Second), DirectoryProxy: "http://proxy.internal:3128"}).directoryClientConfig(tls.VersionTLS12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, client := range []*http.Client{newDirectoryClient(config), newDiscoveryClient(config)} {
+		proxy, _ := client.Transport.(*http.Transport).Proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: "bot.example"}})
+		if client.Timeout != time.Second || proxy == nil || proxy.Host != "proxy.internal:3128" {
+			t.Errorf("timeout = %v, proxy = %v", client.Timeout, proxy)
+		}
+	}
+
+	for i, m := range []*Middleware{
+		{DirectoryProxy: "proxy.internal:3128"},
+		{DirectoryRootCAs: []string{filepath.Join(t.TempDir(), "missing.pem")}},
+		{DirectoryRootCAs: []string{notPEM}},
+		{DirectoryTimeout: -1},
+	} {
+		if _, err := m.directoryClientConfig(tls.VersionTLS12); err == nil {
+			t.Errorf("invalid settings %d accepted", i)
+		}
+	}
+
+	var parsed Middleware
+	d := caddyfile.NewTestDispenser("httpsig {\ndirectory_timeout 5s\ndirectory_proxy http://proxy:3128\ndirectory_root_cas a.pem b.pem\ndirectory_user_agent \"example-verifier/1.0\"\n}")
+	if err := parsed.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(parsed.DirectoryTimeout) != 5*time.Second || parsed.DirectoryProxy != "http://proxy:3128" || len(parsed.DirectoryRootCAs) != 2 || parsed.DirectoryUserAgent != "example-verifier/1.0" {
+		t.Errorf("parsed %v, %q, %v, %q", parsed.DirectoryTimeout, parsed.DirectoryProxy, parsed.DirectoryRootCAs, parsed.DirectoryUserAgent)
+	}
+}
+
+func TestStaticKeys(t *testing.T) {
+	_, staticKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	staticJWK, staticKeyID := publicJWK(t, staticKey)
+
+	tests := []struct {
+		name          string
+		directoryBase string
+		wantDirectory bool
+	}{
+		{name: "with directory", directoryBase: "example.com", wantDirectory: true},
+		{name: "static only", wantDirectory: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := &fakeFetcher{responses: []fakeResponse{{dir: directoryOf(ed25519JWK(testPrivateKey))}}}
+			m := &Middleware{DirectoryBase: tt.directoryBase, StaticKeys: []json.RawMessage{staticJWK}, Fetcher: fetcher}
+			if err := m.Provision(newTestContext(t)); err != nil {
+				t.Fatal(err)
+			}
+			// Static keys survive directory refreshes
+			if err := m.refresh(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := m.validator.Load().Validate(newSignedRequest(t))
+			if got := err == nil; got != tt.wantDirectory {
+				t.Errorf("directory key accepted = %v, want %v (err: %v)", got, tt.wantDirectory, err)
+			}
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+			signRequest(t, r, staticKey, staticKeyID)
+			if _, err := m.validator.Load().Validate(r); err != nil {
+				t.Errorf("static key: %v", err)
+			}
+			if !tt.wantDirectory && fetcher.calls != 0 {
+				t.Errorf("fetched %d times without directory_base", fetcher.calls)
+			}
+		})
+	}
+}
+
+func TestStaticKeysWinCollisions(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned, keyid := publicJWK(t, rsaKey)
+	// The directory publishes the same key, hence the same keyid, restricted to RS256
+	var published map[string]any
+	if err := json.Unmarshal(pinned, &published); err != nil {
+		t.Fatal(err)
+	}
+	published["alg"] = "RS256"
+	directoryKey, err := json.Marshal(published)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Middleware{
+		DirectoryBase:     "example.com",
+		StaticKeys:        []json.RawMessage{pinned},
+		AllowedAlgorithms: []string{"rsa-pss-sha512", "rsa-v1_5-sha256"},
+		Fetcher:           &fakeFetcher{responses: []fakeResponse{{dir: directoryOf(directoryKey)}}},
+	}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	v := m.validator.Load()
+	if got := v.keys[keyid].Algo; got != httpsig.Algo_RSA_PSS_SHA512 {
+		t.Fatalf("key algorithm = %s, want the pinned %s", got, httpsig.Algo_RSA_PSS_SHA512)
+	}
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	signRequestWith(t, r, httpsig.Algo_RSA_PSS_SHA512, rsaKey, keyid)
+	if _, err := v.Validate(r); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProvisionWithoutKeys(t *testing.T) {
+	if err := (&Middleware{}).Provision(newTestContext(t)); err == nil {
+		t.Fatal("Provision succeeded without directory_base or static_keys")
+	}
+}
+
+func TestUnmarshalStaticKeys(t *testing.T) {
+	d := caddyfile.NewTestDispenser("httpsig {\n" +
+		"\tstatic_keys `{\"kty\":\"OKP\",\"crv\":\"Ed25519\",\"x\":\"a\"}`\n" +
+		"\tstatic_keys {\n" +
+		"\t\t`{\"kty\":\"OKP\",\"crv\":\"Ed25519\",\"x\":\"b\"}`\n" +
+		"\t\t`{\"kty\":\"OKP\",\"crv\":\"Ed25519\",\"x\":\"c\"}`\n" +
+		"\t}\n" +
+		"}")
+	var m Middleware
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.StaticKeys) != 3 {
+		t.Fatalf("parsed %d static keys, want 3: %s", len(m.StaticKeys), m.StaticKeys)
+	}
+	if got, want := string(m.StaticKeys[2]), `{"kty":"OKP","crv":"Ed25519","x":"c"}`; got != want {
+		t.Errorf("third key = %s, want %s", got, want)
+	}
+
+	d = caddyfile.NewTestDispenser("httpsig {\n\tstatic_keys not-json\n}")
+	if err := (&Middleware{}).UnmarshalCaddyfile(d); err == nil {
+		t.Error("invalid JWK accepted")
+	}
+}
+
+func TestStringWrappedKeys(t *testing.T) {
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherJWK, otherKeyID := publicJWK(t, otherKey)
+
+	var dir Directory
+	body := fmt.Sprintf(`{"keys":[%s,%q]}`, ed25519JWK(testPrivateKey), otherJWK)
+	if err := json.Unmarshal([]byte(body), &dir); err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewValidator(dir.Keys, ValidatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := v.Validate(newSignedRequest(t)); err != nil {
+		t.Errorf("object key: %v", err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	signRequest(t, r, otherKey, otherKeyID)
+	if _, err := v.Validate(r); err != nil {
+		t.Errorf("string-wrapped key: %v", err)
+	}
+
+	if _, err := NewValidator([]json.RawMessage{json.RawMessage(`"not a jwk"`)}, ValidatorOptions{}); err == nil {
+		t.Error("string that is not a JWK accepted")
+	}
+}
+
+// withValidity adds nbf and exp members to a JWK, omitting zero times
+func withValidity(t *testing.T, key json.RawMessage, nbf, exp time.Time) json.RawMessage {
+	t.Helper()
+	var members map[string]any
+	if err := json.Unmarshal(key, &members); err != nil {
+		t.Fatal(err)
+	}
+	if !nbf.IsZero() {
+		members["nbf"] = nbf.Unix()
+	}
+	if !exp.IsZero() {
+		members["exp"] = exp.Unix()
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestKeyActivationGrace(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		nbf     time.Time
+		exp     time.Time
+		grace   time.Duration
+		wantErr bool
+	}{
+		{name: "active", nbf: now.Add(-time.Hour)},
+		{name: "not yet active", nbf: now.Add(30 * time.Second), wantErr: true},
+		{name: "within grace", nbf: now.Add(30 * time.Second), grace: time.Minute},
+		{name: "beyond grace", nbf: now.Add(5 * time.Minute), grace: time.Minute, wantErr: true},
+		{name: "expired", exp: now.Add(-time.Second), grace: time.Minute, wantErr: true},
+		{name: "not expired", exp: now.Add(time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The key is added to a directory that first published another key only
+			_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			otherJWK, _ := publicJWK(t, otherKey)
+			added := withValidity(t, ed25519JWK(testPrivateKey), tt.nbf, tt.exp)
+			m := &Middleware{
+				DirectoryBase:      "example.com",
+				KeyActivationGrace: caddy.Duration(tt.grace),
+				Fetcher: &fakeFetcher{responses: []fakeResponse{
+					{dir: directoryOf(otherJWK)},
+					{dir: directoryOf(otherJWK, added)},
+				}},
+			}
+			if err := m.Provision(newTestContext(t)); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.refresh(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := m.validator.Load().Validate(newSignedRequest(t)); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseKeyValidity(t *testing.T) {
+	if _, err := parseKeySpec([]byte(`{"kty":"OKP","crv":"Ed25519","x":"JrQLj5P_89iXES9-vFgrIy29clF9CC_oPPsw3c5D0bs","nbf":"soon"}`)); err == nil {
+		t.Error("non-numeric nbf accepted")
+	}
+}
+
+func TestDirectoryPathFallback(t *testing.T) {
+	const nextPath = "/.well-known/http-message-signatures-directory/v2"
+	var requested []string
+	limited := false
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		switch {
+		case limited:
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case r.URL.Path == nextPath:
+			fmt.Fprintf(w, `{"keys":[%s]}`, ed25519JWK(testPrivateKey))
+		case r.URL.Path == "/other-format":
+			fmt.Fprint(w, `{"not":"a directory"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	f := &HTTPDirectoryFetcher{Client: srv.Client(), Paths: []string{wellKnownDirectory, "/other-format", nextPath}}
+	dir, meta, err := f.Fetch(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dir.Keys) != 1 || meta.URL != srv.URL+nextPath {
+		t.Errorf("Fetch = %d keys from %s, want 1 from %s", len(dir.Keys), meta.URL, srv.URL+nextPath)
+	}
+	if want := []string{wellKnownDirectory, "/other-format", nextPath}; !slices.Equal(requested, want) {
+		t.Errorf("requested %v, want %v", requested, want)
+	}
+
+	// A rate limited host is not asked for the following paths
+	requested, limited = nil, true
+	var rle *RateLimitedError
+	if _, _, err := f.Fetch(context.Background(), srv.URL); !errors.As(err, &rle) || len(requested) != 1 {
+		t.Errorf("rate limited fetch: err = %v after %d requests", err, len(requested))
+	}
+}
+
+func TestDirectoryStatus(t *testing.T) {
+	m := &Middleware{DirectoryBase: "signer.example.com", Fetcher: &fakeFetcher{responses: []fakeResponse{
+		{dir: directoryOf(ed25519JWK(testPrivateKey))},
+		{notModified: true},
+	}}}
+	if _, ok := m.DirectoryStatus("signer.example.com"); ok {
+		t.Error("status reported before provisioning")
+	}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	first, ok := m.DirectoryStatus("signer.example.com")
+	if !ok || first.URL != "signer.example.com" || first.Keys != 1 {
+		t.Errorf("status = %+v, %v", first, ok)
+	}
+
+	time.Sleep(time.Millisecond)
+	if err := m.refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if second, _ := m.DirectoryStatus("signer.example.com"); second.Keys != 1 || !second.FetchedAt.After(first.FetchedAt) {
+		t.Errorf("status after not modified = %+v, want the keys of %+v fetched later", second, first)
+	}
+}
+
+func TestMultipleDirectories(t *testing.T) {
+	otherKey, otherJWK, otherKeyID := generateKey(t)
+	fetchers := fakeFetchers{
+		"signer.example.com": {responses: []fakeResponse{
+			{dir: directoryOf(ed25519JWK(testPrivateKey))},
+			{err: errors.New("unreachable")},
+		}},
+		"vendor.example": {responses: []fakeResponse{{dir: directoryOf(otherJWK)}}},
+	}
+	m := &Middleware{DirectoryBase: "signer.example.com", DirectoryBases: []string{"vendor.example"}, RequireSignatureAgent: true, Fetcher: fetchers}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each key is bound to the directory publishing it, so Signature-Agent must name that directory
+	check := func(key ed25519.PrivateKey, keyid, agent string, wantDirectory string, wantErr error) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		r.Header.Set("Signature-Agent", agent)
+		signRequestWith(t, r, httpsig.Algo_ED25519, key, keyid, "@authority", "signature-agent")
+		result, err := m.validate(r)
+		if !errors.Is(err, wantErr) {
+			t.Errorf("keyid %s with agent %s: err = %v, want %v", keyid, agent, err, wantErr)
+		}
+		if err == nil && result.Directory != wantDirectory {
+			t.Errorf("keyid %s: directory = %q, want %q", keyid, result.Directory, wantDirectory)
+		}
+	}
+	check(testPrivateKey, testKeyID, `"https://signer.example.com"`, "signer.example.com", nil)
+	check(otherKey, otherKeyID, `"https://vendor.example"`, "vendor.example", nil)
+	check(otherKey, otherKeyID, `"https://signer.example.com"`, "", ErrSignatureAgentMismatch)
+
+	// A directory failing to refresh keeps its keys while the others are still refreshed
+	if err := m.refresh(context.Background()); err == nil {
+		t.Error("refresh with a failing directory succeeded")
+	}
+	check(testPrivateKey, testKeyID, `"https://signer.example.com"`, "signer.example.com", nil)
+	if fetchers["vendor.example"].calls != 2 {
+		t.Errorf("vendor.example fetched %d times, want 2", fetchers["vendor.example"].calls)
+	}
+	if status, ok := m.DirectoryStatus("vendor.example"); !ok || status.Keys != 1 {
+		t.Errorf("vendor.example status = %+v, %v", status, ok)
+	}
+}
+
+func TestRequiredPurpose(t *testing.T) {
+	otherKey, otherJWK, otherKeyID := generateKey(t)
+	search, training := "search", "ai-training"
+	signer := directoryOf(ed25519JWK(testPrivateKey))
+	signer.Purpose = &search
+	vendor := directoryOf(otherJWK)
+	vendor.Purpose = &training
+	m := &Middleware{
+		DirectoryBase:       "signer.example.com",
+		DirectoryBases:      []string{"vendor.example"},
+		DiscoverDirectories: true,
+		RequiredPurpose:     []string{"rag", "search"},
+		Fetcher: fakeFetchers{
+			"signer.example.com":  {responses: []fakeResponse{{dir: signer}}},
+			"vendor.example":      {responses: []fakeResponse{{dir: vendor}}},
+			"https://bot.example": {responses: []fakeResponse{{dir: directoryOf(otherJWK)}}},
+		},
+	}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.validate(newSignedRequest(t)); err != nil {
+		t.Errorf("search directory: %v", err)
+	}
+	if _, err := m.validate(agentRequest(t, otherKey, otherKeyID, `"https://vendor.example"`)); err == nil {
+		t.Error("key of an ai-training directory verified")
+	}
+	if status, _ := m.DirectoryStatus("vendor.example"); status.Keys != 0 {
+		t.Errorf("vendor.example status = %+v, want no keys", status)
+	}
+	if _, err := m.validate(agentRequest(t, otherKey, otherKeyID, `"https://bot.example"`)); !errors.Is(err, ErrPurposeMismatch) {
+		t.Errorf("discovered directory without purpose: err = %v, want ErrPurposeMismatch", err)
+	}
+
+	if err := (&Middleware{RequiredPurpose: []string{"rag"}, StaticKeys: []json.RawMessage{ed25519JWK(testPrivateKey)}}).Provision(newTestContext(t)); err == nil {
+		t.Error("required_purpose accepted without directories")
+	}
+	var parsed Middleware
+	if err := parsed.UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\nrequired_purpose rag search\n}")); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(parsed.RequiredPurpose, []string{"rag", "search"}) {
+		t.Errorf("required_purpose = %v", parsed.RequiredPurpose)
+	}
+}
+
+func TestUnmarshalDirectoryBases(t *testing.T) {
+	var m Middleware
+	d := caddyfile.NewTestDispenser(`httpsig {
+		directory_base signer.example.com
+		directory_base vendor.example
+		directory_base other.example
+	}`)
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"signer.example.com", "vendor.example", "other.example"}; !slices.Equal(m.directories(), want) {
+		t.Errorf("directories = %v, want %v", m.directories(), want)
+	}
+}
+
+func TestDirectoryAlgorithms(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []struct {
+		algo httpsig.Algorithm
+		key  crypto.Signer
+	}{
+		{httpsig.Algo_ECDSA_P256_SHA256, p256},
+		{httpsig.Algo_ECDSA_P384_SHA384, p384},
+		{httpsig.Algo_RSA_PSS_SHA512, rsaKey},
+	}
+
+	var m Middleware
+	d := caddyfile.NewTestDispenser(`httpsig {
+		directory_base signer.example.com
+		algorithms ecdsa-p256-sha256 ecdsa-p384-sha384 rsa-pss-sha512
+	}`)
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	var jwks []json.RawMessage
+	keyids := make([]string, len(keys))
+	for i, k := range keys {
+		var jwk json.RawMessage
+		jwk, keyids[i] = publicJWK(t, k.key)
+		jwks = append(jwks, jwk)
+	}
+	m.Fetcher = &fakeFetcher{responses: []fakeResponse{{dir: directoryOf(append(jwks, ed25519JWK(testPrivateKey))...)}}}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each key type is parsed from its JWK and verifies with its algorithm
+	for i, k := range keys {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		signRequestWith(t, r, k.algo, k.key, keyids[i])
+		if _, err := m.validate(r); err != nil {
+			t.Errorf("%s: %v", k.algo, err)
+		}
+	}
+	// Listing algorithms replaces the ed25519 default
+	if _, err := m.validate(newSignedRequest(t)); err == nil {
+		t.Error("ed25519 signature accepted without being listed")
+	}
+}
@@ -0,0 +1,232 @@
+package httpsig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/cloudflareresearch/web-bot-auth/go/webbotauth/httpmsgsig"
+	sfv "github.com/dunglas/httpsfv"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
+func init() {
+	caddy.RegisterModule(new(DirectoryServer))
+	httpcaddyfile.RegisterHandlerDirective("httpsig_directory", func(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+		var ds DirectoryServer
+		err := ds.UnmarshalCaddyfile(h.Dispenser)
+		return &ds, err
+	})
+}
+
+// directoryMediaType is the media type of key directories
+const directoryMediaType = "application/http-message-signatures-directory+json"
+
+// DirectoryServer publishes a key directory, so bot operators can serve theirs from Caddy. Each response is signed by
+// every key, as require_signed_directory verification expects, over the authority and the Content-Digest of the response.
+type DirectoryServer struct {
+	// KeyFiles hold the private keys published, as JWKs or PEM. Only their public part is served.
+	KeyFiles []string `json:"key_files"`
+	// Purpose is the purpose member of the directory, omitted when empty
+	Purpose string `json:"purpose,omitempty"`
+	// Validity is how long response signatures are valid. Defaults to DefaultResponseValidity.
+	Validity caddy.Duration `json:"validity,omitempty"`
+
+	signers []*ResponseSigner
+	body    []byte
+	digest  string
+}
+
+// CaddyModule returns the Caddy module information
+func (*DirectoryServer) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.httpsig_directory",
+		New: func() caddy.Module { return new(DirectoryServer) },
+	}
+}
+
+// Provision loads the keys and encodes the directory
+func (ds *DirectoryServer) Provision(ctx caddy.Context) error {
+	if len(ds.KeyFiles) == 0 {
+		return fmt.Errorf("key_files is required")
+	}
+	dir := struct {
+		Keys    []jwk.Key `json:"keys"`
+		Purpose string    `json:"purpose,omitempty"`
+	}{Purpose: ds.Purpose}
+	for _, file := range ds.KeyFiles {
+		rs := &ResponseSigner{KeyFile: file, Validity: ds.Validity}
+		if err := rs.Provision(ctx); err != nil {
+			return err
+		}
+		if err := rs.public.Set(jwk.KeyIDKey, rs.key.MetaKeyID); err != nil {
+			return fmt.Errorf("key_file %s: %w", file, err)
+		}
+		ds.signers = append(ds.signers, rs)
+		dir.Keys = append(dir.Keys, rs.public)
+	}
+	body, err := json.Marshal(dir)
+	if err != nil {
+		return fmt.Errorf("encoding directory: %w", err)
+	}
+	digest := sha256.Sum256(body)
+	ds.body = body
+	ds.digest = "sha-256=:" + base64.StdEncoding.EncodeToString(digest[:]) + ":"
+	return nil
+}
+
+// ServeHTTP answers GET and HEAD requests with the signed directory. Other requests are passed on to next.
+func (ds *DirectoryServer) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return next.ServeHTTP(w, r)
+	}
+	header := w.Header()
+	header.Set("Content-Type", directoryMediaType)
+	header.Set("Content-Digest", ds.digest)
+	if err := ds.sign(r, header); err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, fmt.Errorf("signing directory: %w", err))
+	}
+	header.Set("Content-Length", strconv.Itoa(len(ds.body)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		_, err := w.Write(ds.body)
+		return err
+	}
+	return nil
+}
+
+// sign sets the signatures of every key over the directory response to r, labeled binding0 onwards in the order of
+// KeyFiles. They cover "@authority";req, which httpsig-go cannot derive for responses, so the signature base is built
+// by httpmsgsig.
+func (ds *DirectoryServer) sign(r *http.Request, header http.Header) error {
+	created := time.Now()
+	inputs := sfv.NewDictionary()
+	signatures := sfv.NewDictionary()
+	for i, rs := range ds.signers {
+		nonce := make([]byte, 32)
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		authority := sfv.NewItem("@authority")
+		authority.Params.Add("req", true)
+		input := sfv.InnerList{Items: []sfv.Item{authority, sfv.NewItem("content-digest")}, Params: sfv.NewParams()}
+		input.Params.Add("alg", string(rs.algo))
+		input.Params.Add("created", created.Unix())
+		input.Params.Add("expires", created.Add(time.Duration(rs.Validity)).Unix())
+		input.Params.Add("keyid", rs.key.MetaKeyID)
+		input.Params.Add("nonce", base64.StdEncoding.EncodeToString(nonce))
+		input.Params.Add("tag", directorySignatureTag)
+
+		base, err := httpmsgsig.ResponseBase(r, http.StatusOK, header, input)
+		if err != nil {
+			return err
+		}
+		sig, err := signRaw(rs.key.Key, base)
+		if err != nil {
+			return err
+		}
+		label := "binding" + strconv.Itoa(i)
+		inputs.Add(label, input)
+		signatures.Add(label, sfv.NewItem(sig))
+	}
+	signatureInput, err := sfv.Marshal(inputs)
+	if err != nil {
+		return err
+	}
+	signature, err := sfv.Marshal(signatures)
+	if err != nil {
+		return err
+	}
+	header.Set("Signature-Input", signatureInput)
+	header.Set("Signature", signature)
+	return nil
+}
+
+// signRaw signs base with key, with the encodings of RFC 9421 section 3.3 that webbotauth.VerifyBase checks
+func signRaw(key crypto.PrivateKey, base []byte) ([]byte, error) {
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		return ed25519.Sign(key, base), nil
+	case *ecdsa.PrivateKey:
+		var digest []byte
+		size := (key.Curve.Params().BitSize + 7) / 8
+		switch size {
+		case 32:
+			d := sha256.Sum256(base)
+			digest = d[:]
+		case 48:
+			d := sha512.Sum384(base)
+			digest = d[:]
+		default:
+			return nil, fmt.Errorf("unsupported elliptic curve %s", key.Curve.Params().Name)
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+		if err != nil {
+			return nil, err
+		}
+		sig := make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+		return sig, nil
+	case *rsa.PrivateKey:
+		digest := sha512.Sum512(base)
+		return rsa.SignPSS(rand.Reader, key, crypto.SHA512, digest[:], &rsa.PSSOptions{SaltLength: 64})
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", key)
+}
+
+// UnmarshalCaddyfile sets up the directory server from Caddyfile tokens:
+//
+//	httpsig_directory {
+//	    key_file <path>
+//	    purpose <purpose>
+//	    validity <duration>
+//	}
+//
+// key_file may be repeated, or given several paths, to publish more keys.
+func (ds *DirectoryServer) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for d.NextBlock(0) {
+			option := d.Val()
+			switch option {
+			case "key_file":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				ds.KeyFiles = append(ds.KeyFiles, args...)
+			case "purpose":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				ds.Purpose = d.Val()
+			case "validity":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				validity, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid validity: %v", err)
+				}
+				ds.Validity = caddy.Duration(validity)
+			default:
+				return d.Errf("unknown option '%s'", option)
+			}
+		}
+	}
+	return nil
+}
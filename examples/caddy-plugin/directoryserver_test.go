@@ -0,0 +1,104 @@
+package httpsig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestDirectoryServer(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecFile := filepath.Join(t.TempDir(), "ec.pem")
+	if err := os.WriteFile(ecFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, ecKeyID := publicJWK(t, ecKey)
+
+	ds := &DirectoryServer{KeyFiles: []string{writeKeyFile(t, false), ecFile}, Purpose: "rag"}
+	if err := ds.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "https://signer.example.com"+wellKnownDirectory, nil)
+	w := httptest.NewRecorder()
+	if err := ds.ServeHTTP(w, r, okHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	resp := w.Result()
+	resp.Request = r
+	if ct := resp.Header.Get("Content-Type"); ct != directoryMediaType {
+		t.Errorf("Content-Type = %s, want %s", ct, directoryMediaType)
+	}
+	var dir Directory
+	if err := json.Unmarshal(w.Body.Bytes(), &dir); err != nil {
+		t.Fatal(err)
+	}
+	if len(dir.Keys) != 2 || dir.Purpose == nil || *dir.Purpose != "rag" {
+		t.Fatalf("directory = %s", w.Body)
+	}
+	if strings.Contains(w.Body.String(), `"d"`) {
+		t.Errorf("directory publishes private keys: %s", w.Body)
+	}
+	for _, keyid := range []string{testKeyID, ecKeyID} {
+		if !strings.Contains(w.Body.String(), `"kid":"`+keyid+`"`) {
+			t.Errorf("directory has no key %s: %s", keyid, w.Body)
+		}
+	}
+
+	// The response passes the checks require_signed_directory applies, for the authority it was served to only
+	if err := verifySelfSigned(resp, dir, time.Now()); err != nil {
+		t.Errorf("directory is not self-signed: %v", err)
+	}
+	resp.Request = httptest.NewRequest(http.MethodGet, "https://other.example"+wellKnownDirectory, nil)
+	if err := verifySelfSigned(resp, dir, time.Now()); err == nil {
+		t.Error("directory signatures verify for another authority")
+	}
+	if err := verifySelfSigned(resp, dir, time.Now().Add(time.Hour)); err == nil {
+		t.Error("directory signatures verify after they expired")
+	}
+
+	w = httptest.NewRecorder()
+	if err := ds.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "https://signer.example.com"+wellKnownDirectory, nil), okHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	if w.Header().Get("Signature") != "" {
+		t.Error("POST request answered with the directory")
+	}
+}
+
+func TestUnmarshalDirectoryServer(t *testing.T) {
+	var ds DirectoryServer
+	d := caddyfile.NewTestDispenser(`httpsig_directory {
+		key_file /etc/caddy/bot.jwk
+		key_file /etc/caddy/next.pem /etc/caddy/old.pem
+		purpose rag
+		validity 10m
+	}`)
+	if err := ds.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	if len(ds.KeyFiles) != 3 || ds.Purpose != "rag" || ds.Validity == 0 {
+		t.Errorf("directory server = %+v", ds)
+	}
+	if err := (&DirectoryServer{}).Provision(newTestContext(t)); err == nil {
+		t.Error("Provision succeeded without keys")
+	}
+}
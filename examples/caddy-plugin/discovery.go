@@ -0,0 +1,179 @@
+package httpsig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDiscoveryTTL is how long a directory discovered through Signature-Agent is cached
+	DefaultDiscoveryTTL = time.Hour
+	// DefaultDiscoveryFailureTTL is how long a failed discovery is cached, so that requests advertising a directory
+	// that fails to load, such as one that 404s or times out, do not each cost an outbound fetch
+	DefaultDiscoveryFailureTTL = minRetryDelay
+	// discoveryTimeout bounds a discovery fetch, which outlives the request that triggered it
+	discoveryTimeout = 10 * time.Second
+	// maxDiscoveredDirectories bounds the cache, since any client can advertise a new directory
+	maxDiscoveredDirectories = 1024
+)
+
+// discoveredDirectory is the validator of a directory fetched on demand. ready is closed once the fetch completed.
+type discoveredDirectory struct {
+	ready     chan struct{}
+	validator *SignatureValidator
+	err       error
+	expires   time.Time
+}
+
+// discovery fetches and caches the directories advertised by Signature-Agent
+type discovery struct {
+	fetcher DirectoryFetcher
+	opts    ValidatorOptions
+	// staticKeys are verified alongside each discovered directory, so bots with static keys may still send Signature-Agent
+	staticKeys []keySpec
+	ttl        time.Duration
+	failureTTL time.Duration
+	now        func() time.Time
+	// allowHosts and denyHosts restrict the hosts directories are discovered at
+	allowHosts []string
+	denyHosts  []string
+	// purposes, when set, are the purposes discovered directories must declare one of
+	purposes []string
+	// pins are the thumbprints discovered directories with pinned keys may serve
+	pins map[string][]string
+	// keyCertificates, when set, certifies the keys of discovered directories
+	keyCertificates *keyCertificates
+
+	mu      sync.Mutex
+	entries map[string]*discoveredDirectory
+}
+
+// validator returns the validator of the directory advertised as agent, fetching it when it is not cached.
+// Concurrent requests for the same directory wait for a single fetch.
+func (d *discovery) validator(ctx context.Context, agent string) (*SignatureValidator, error) {
+	u, err := resolveDirectory(agent)
+	if err != nil {
+		return nil, fmt.Errorf("discovering directory %s: %w", agent, err)
+	}
+	u.Host = strings.ToLower(u.Host)
+	if err := d.checkDiscoveryHost(u.Hostname()); err != nil {
+		return nil, err
+	}
+	key := u.String()
+
+	d.mu.Lock()
+	entry, ok := d.entries[key]
+	hit := ok && !d.expired(entry)
+	setCacheHit(ctx, hit)
+	if !hit {
+		var previous *SignatureValidator
+		if ok {
+			previous = entry.validator
+		}
+		entry = &discoveredDirectory{ready: make(chan struct{})}
+		d.store(key, entry)
+		go d.fetch(ctx, agent, entry, previous)
+	}
+	d.mu.Unlock()
+
+	select {
+	case <-entry.ready:
+		return entry.validator, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// expired reports whether entry completed and is past its expiry. It is called with mu held.
+func (d *discovery) expired(entry *discoveredDirectory) bool {
+	select {
+	case <-entry.ready:
+		return !d.now().Before(entry.expires)
+	default:
+		return false
+	}
+}
+
+// store caches entry under key, evicting expired entries, then an arbitrary one, when the cache is full.
+// It is called with mu held.
+func (d *discovery) store(key string, entry *discoveredDirectory) {
+	if d.entries == nil {
+		d.entries = map[string]*discoveredDirectory{}
+	}
+	if _, ok := d.entries[key]; !ok && len(d.entries) >= maxDiscoveredDirectories {
+		for k, e := range d.entries {
+			if d.expired(e) {
+				delete(d.entries, k)
+			}
+		}
+		for k := range d.entries {
+			if len(d.entries) < maxDiscoveredDirectories {
+				break
+			}
+			delete(d.entries, k)
+		}
+	}
+	d.entries[key] = entry
+}
+
+// fetch loads the directory advertised as agent into entry. A directory that is not modified keeps previous.
+// The fetch is detached from ctx so that a client going away does not cache a failure for everyone else.
+func (d *discovery) fetch(ctx context.Context, agent string, entry *discoveredDirectory, previous *SignatureValidator) {
+	defer close(entry.ready)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), discoveryTimeout)
+	defer cancel()
+
+	entry.validator, entry.err = d.load(ctx, agent, previous)
+	if entry.err != nil {
+		entry.validator = nil
+		entry.expires = d.now().Add(d.failureTTL)
+		// A host asking to be left alone for longer is not fetched again before then
+		var rle *RateLimitedError
+		if errors.As(entry.err, &rle) && rle.RetryAfter.After(entry.expires) {
+			entry.expires = rle.RetryAfter
+		}
+		return
+	}
+	entry.expires = d.now().Add(d.ttl)
+}
+
+// load fetches the directory advertised as agent and builds its validator
+func (d *discovery) load(ctx context.Context, agent string, previous *SignatureValidator) (*SignatureValidator, error) {
+	dir, meta, err := d.fetcher.Fetch(ctx, agent)
+	if err != nil {
+		return nil, fmt.Errorf("discovering directory %s: %w", agent, err)
+	}
+	if meta.NotModified && previous != nil {
+		return previous, nil
+	}
+	keys, err := parseKeySpecs(dir.Keys)
+	if err != nil {
+		return nil, fmt.Errorf("discovering directory %s: %w", agent, err)
+	}
+	if err := dir.checkPurpose(d.purposes); err != nil {
+		return nil, fmt.Errorf("discovering directory %s: %w", agent, err)
+	}
+	if err := checkPinnedKeys(d.pins, agent, keys); err != nil {
+		return nil, fmt.Errorf("discovering directory %s: %w", agent, err)
+	}
+	// Uncertified keys are dropped, and the directory rejected when none is left
+	if keys, err = d.keyCertificates.certified(keys); err != nil && len(keys) == 0 {
+		return nil, fmt.Errorf("discovering directory %s: %w", agent, err)
+	}
+	for i := range keys {
+		keys[i].Directory = agent
+		if dir.Purpose != nil {
+			keys[i].Purpose = *dir.Purpose
+		}
+	}
+	// Static keys come last so that they win keyid collisions, as with configured directories
+	validator, err := newValidator(append(keys, d.staticKeys...), d.opts)
+	if err != nil {
+		return nil, fmt.Errorf("discovering directory %s: %w", agent, err)
+	}
+	return validator, nil
+}
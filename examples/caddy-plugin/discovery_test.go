@@ -0,0 +1,165 @@
+package httpsig
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// agentRequest returns a request signed by key that advertises agent in Signature-Agent
+func agentRequest(t *testing.T, key ed25519.PrivateKey, keyid, agent string) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	if agent != "" {
+		r.Header.Set("Signature-Agent", agent)
+	}
+	signRequest(t, r, key, keyid)
+	return r
+}
+
+func TestDiscoverDirectories(t *testing.T) {
+	vendorKey, vendorJWK, vendorKeyID := generateKey(t)
+	// The clock starts behind so that advancing it past the TTL still accepts freshly signed requests
+	now := time.Now().Add(-30 * time.Second)
+	vendor := &fakeFetcher{responses: []fakeResponse{{dir: directoryOf(vendorJWK)}}}
+	m := &Middleware{
+		DiscoverDirectories: true,
+		DiscoveryTTL:        caddy.Duration(30 * time.Second),
+		Fetcher:             fakeFetchers{"https://vendor.example": vendor},
+		Now:                 func() time.Time { return now },
+	}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := m.validate(agentRequest(t, vendorKey, vendorKeyID, `"https://vendor.example"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.KeyID != vendorKeyID || result.Directory != "https://vendor.example" {
+		t.Errorf("result = %+v", result)
+	}
+
+	// The directory is cached until the TTL elapses
+	if _, err := m.validate(agentRequest(t, vendorKey, vendorKeyID, `"https://vendor.example"`)); err != nil {
+		t.Fatal(err)
+	}
+	if vendor.calls != 1 {
+		t.Errorf("fetched %d times within the TTL, want 1", vendor.calls)
+	}
+	now = now.Add(30 * time.Second)
+	if _, err := m.validate(agentRequest(t, vendorKey, vendorKeyID, `"https://vendor.example"`)); err != nil {
+		t.Fatal(err)
+	}
+	if vendor.calls != 2 {
+		t.Errorf("fetched %d times after the TTL, want 2", vendor.calls)
+	}
+
+	// Keys are only trusted for the directory publishing them
+	if _, err := m.validate(agentRequest(t, vendorKey, vendorKeyID, `"https://other.example"`)); err == nil {
+		t.Error("key verified for a directory not publishing it")
+	}
+	if _, err := m.validate(agentRequest(t, vendorKey, vendorKeyID, "")); err == nil {
+		t.Error("request without Signature-Agent verified")
+	}
+}
+
+func TestDiscoveryFailureCached(t *testing.T) {
+	vendor := &fakeFetcher{responses: []fakeResponse{{err: errors.New("unreachable")}}}
+	m := &Middleware{DiscoverDirectories: true, Fetcher: fakeFetchers{"https://vendor.example": vendor}}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if _, err := m.validate(agentRequest(t, testPrivateKey, testKeyID, `"https://vendor.example"`)); err == nil {
+			t.Fatal("request verified against an unreachable directory")
+		}
+	}
+	if vendor.calls != 1 {
+		t.Errorf("failing directory fetched %d times, want 1", vendor.calls)
+	}
+}
+
+func TestDiscoveryFailureTTL(t *testing.T) {
+	now := time.Now()
+	vendor := &fakeFetcher{responses: []fakeResponse{{err: errors.New("not found")}}}
+	limited := &fakeFetcher{responses: []fakeResponse{{err: &RateLimitedError{Status: http.StatusTooManyRequests, RetryAfter: now.Add(time.Hour)}}}}
+	m := &Middleware{
+		DiscoverDirectories: true,
+		DiscoveryFailureTTL: caddy.Duration(5 * time.Minute),
+		Fetcher:             fakeFetchers{"https://vendor.example": vendor, "https://limited.example": limited},
+		Now:                 func() time.Time { return now },
+	}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	fetch := func(agent string) {
+		t.Helper()
+		if _, err := m.validate(agentRequest(t, testPrivateKey, testKeyID, agent)); err == nil {
+			t.Fatal("request verified against a failing directory")
+		}
+	}
+	fetch(`"https://vendor.example"`)
+	fetch(`"https://limited.example"`)
+
+	now = now.Add(4 * time.Minute)
+	fetch(`"https://vendor.example"`)
+	now = now.Add(2 * time.Minute)
+	fetch(`"https://vendor.example"`)
+	fetch(`"https://limited.example"`)
+	if vendor.calls != 2 || limited.calls != 1 {
+		t.Errorf("fetches = %d and %d, want 2 after discovery_failure_ttl and 1 before Retry-After", vendor.calls, limited.calls)
+	}
+}
+
+func TestDiscoveryConfiguredDirectory(t *testing.T) {
+	_, vendorJWK, _ := generateKey(t)
+	signer := &fakeFetcher{responses: []fakeResponse{{dir: directoryOf(ed25519JWK(testPrivateKey))}}}
+	vendor := &fakeFetcher{responses: []fakeResponse{{dir: directoryOf(vendorJWK)}}}
+	m := &Middleware{
+		DirectoryBase:       "signer.example.com",
+		DiscoverDirectories: true,
+		RevokedDirectories:  []string{"revoked.example"},
+		Fetcher:             fakeFetchers{"signer.example.com": signer, "https://revoked.example": vendor},
+	}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A configured directory is served from its loaded keys rather than discovered again
+	if _, err := m.validate(agentRequest(t, testPrivateKey, testKeyID, `"https://signer.example.com"`)); err != nil {
+		t.Fatal(err)
+	}
+	if signer.calls != 1 {
+		t.Errorf("configured directory fetched %d times, want 1", signer.calls)
+	}
+
+	// Revoked directories are not fetched
+	if _, err := m.validate(agentRequest(t, testPrivateKey, testKeyID, `"https://revoked.example"`)); !errors.Is(err, ErrRevoked) {
+		t.Errorf("err = %v, want %v", err, ErrRevoked)
+	}
+	if vendor.calls != 0 {
+		t.Errorf("revoked directory fetched %d times", vendor.calls)
+	}
+}
+
+func TestUnmarshalDiscovery(t *testing.T) {
+	var m Middleware
+	d := caddyfile.NewTestDispenser(`httpsig {
+		discover_directories
+		discovery_ttl 10m
+		discovery_failure_ttl 2m
+	}`)
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	if !m.DiscoverDirectories || time.Duration(m.DiscoveryTTL) != 10*time.Minute || time.Duration(m.DiscoveryFailureTTL) != 2*time.Minute {
+		t.Errorf("discover_directories = %v, discovery_ttl = %v, discovery_failure_ttl = %v", m.DiscoverDirectories, m.DiscoveryTTL, m.DiscoveryFailureTTL)
+	}
+}
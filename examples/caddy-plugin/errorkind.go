@@ -0,0 +1,115 @@
+package httpsig
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+
+	"github.com/remitly-oss/httpsig-go"
+)
+
+var (
+	// ErrNoSignature is returned for requests that carry no signature at all, a bot that forgot to sign
+	ErrNoSignature = errors.New("no signature")
+	// ErrMalformedSignatureInput is returned when Signature or Signature-Input cannot be parsed
+	ErrMalformedSignatureInput = errors.New("malformed signature")
+	// ErrUnknownKey is returned when no trusted key has the keyid of the signature
+	ErrUnknownKey = errors.New("unknown key")
+	// ErrInvalidSignature is returned when a signature by a known key does not verify, as for a forged or altered
+	// request
+	ErrInvalidSignature = errors.New("signature does not verify")
+	// ErrDigestMismatch is returned when Content-Digest does not match the body, or uses an unsupported algorithm
+	ErrDigestMismatch = errors.New("content digest does not match the body")
+)
+
+// errorKind names the errors matching err
+type errorKind struct {
+	err  error
+	kind string
+}
+
+// errorKinds are the names of the errors rejections are classified by, in the order they are matched, for the
+// {http.httpsig.error} placeholder, logs and error_status
+var errorKinds = []errorKind{
+	{ErrNoSignature, "no_signature"},
+	{ErrNoWebBotAuthSignature, "no_signature"},
+	{ErrMalformedSignatureInput, "malformed"},
+	{ErrUnknownKey, "unknown_key"},
+	{ErrInvalidSignature, "invalid_signature"},
+	{ErrDigestMismatch, "digest_mismatch"},
+	{ErrBodyTooLarge, "body_too_large"},
+	{ErrSignatureExpired, "expired"},
+	{ErrMissingExpires, "missing_expires"},
+	{ErrSignatureNotYetValid, "not_yet_valid"},
+	{ErrSignatureTooOld, "too_old"},
+	{ErrDateSkew, "date_skew"},
+	{ErrReplayedNonce, "replayed"},
+	{ErrMissingNonce, "missing_nonce"},
+	{ErrRevoked, "revoked"},
+	{ErrMissingComponent, "missing_component"},
+	{ErrDisallowedComponent, "disallowed_component"},
+	{ErrAuthorityMismatch, "authority_mismatch"},
+	{ErrSignatureAgentMismatch, "agent_mismatch"},
+	{ErrDiscoveryBlocked, "discovery_blocked"},
+	{ErrPurposeMismatch, "purpose_mismatch"},
+	{ErrUnpinnedKey, "unpinned_key"},
+}
+
+// ErrorKind returns the name of the kind of err, such as no_signature or invalid_signature, "other" for errors
+// of no known kind, and "" for nil
+func ErrorKind(err error) string {
+	if err == nil {
+		return ""
+	}
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.kind
+		}
+	}
+	return "other"
+}
+
+// isErrorKind reports whether kind names errors ErrorKind returns
+func isErrorKind(kind string) bool {
+	return kind == "other" || slices.ContainsFunc(errorKinds, func(k errorKind) bool { return k.kind == kind })
+}
+
+// verifierErrors map the codes of the httpsig verifier to the errors they are reported as
+var verifierErrors = map[httpsig.ErrCode]error{
+	httpsig.ErrNoSigMissingSignature:  ErrNoSignature,
+	httpsig.ErrNoSigInvalidHeader:     ErrMalformedSignatureInput,
+	httpsig.ErrNoSigInvalidSignature:  ErrMalformedSignatureInput,
+	httpsig.ErrSigInvalidSignature:    ErrMalformedSignatureInput,
+	httpsig.ErrInvalidMetadata:        ErrMalformedSignatureInput,
+	httpsig.ErrInvalidComponent:       ErrMalformedSignatureInput,
+	httpsig.ErrNoSigWrongDigest:       ErrDigestMismatch,
+	httpsig.ErrNoSigUnsupportedDigest: ErrDigestMismatch,
+	httpsig.ErrSigKeyFetch:            ErrUnknownKey,
+	httpsig.ErrSigVerification:        ErrInvalidSignature,
+}
+
+// classifyVerifyError wraps an error of the httpsig verifier for r in the error its code is reported as, keeping
+// the original in the chain. The verifier skips signatures it cannot parse, so a request whose only signatures are
+// unparseable is reported as malformed rather than unsigned.
+func classifyVerifyError(r *http.Request, err error) error {
+	var se *httpsig.SignatureError
+	if !errors.As(err, &se) {
+		return err
+	}
+	if se.Code == httpsig.ErrNoSigMissingSignature && (len(r.Header.Values("Signature")) > 0 || len(r.Header.Values("Signature-Input")) > 0) {
+		return fmt.Errorf("%w: %w", ErrMalformedSignatureInput, err)
+	}
+	if kind, ok := verifierErrors[se.Code]; ok {
+		return fmt.Errorf("%w: %w", kind, err)
+	}
+	return err
+}
+
+// classifyInvalidSignatures returns the error of the first invalid signature of result for r, by label, classified
+func classifyInvalidSignatures(r *http.Request, result httpsig.VerifyResult) error {
+	label := slices.Min(slices.Collect(maps.Keys(result.InvalidSignatures)))
+	sigErr := result.InvalidSignatures[label].Error
+	return fmt.Errorf("invalid signature %s: %w", label, classifyVerifyError(r, &sigErr))
+}
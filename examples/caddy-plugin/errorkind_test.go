@@ -0,0 +1,116 @@
+package httpsig
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestErrorKinds(t *testing.T) {
+	v := newTestValidator(t)
+	otherKey, _, otherKeyID := generateKey(t)
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		want    error
+		kind    string
+	}{
+		{
+			name:    "unsigned",
+			request: func() *http.Request { return httptest.NewRequest(http.MethodGet, "https://example.com/", nil) },
+			want:    ErrNoSignature, kind: "no_signature",
+		},
+		{
+			name: "unknown key",
+			request: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+				signRequest(t, r, otherKey, otherKeyID)
+				return r
+			},
+			want: ErrUnknownKey, kind: "unknown_key",
+		},
+		{
+			name: "forged",
+			request: func() *http.Request {
+				r := newSignedRequest(t)
+				r.Host = "other.example.com"
+				return r
+			},
+			want: ErrInvalidSignature, kind: "invalid_signature",
+		},
+		{
+			name: "malformed",
+			request: func() *http.Request {
+				r := newSignedRequest(t)
+				r.Header.Set("Signature", "sig1=not-a-byte-sequence")
+				return r
+			},
+			want: ErrMalformedSignatureInput, kind: "malformed",
+		},
+		{
+			name: "digest mismatch",
+			request: func() *http.Request {
+				r := httptest.NewRequest(http.MethodPost, "https://example.com/", strings.NewReader("tampered"))
+				r.Header.Set("Content-Digest", "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:")
+				signRequestWith(t, r, "ed25519", testPrivateKey, testKeyID, "@authority", "content-digest")
+				return r
+			},
+			want: ErrDigestMismatch, kind: "digest_mismatch",
+		},
+	}
+	for _, tt := range tests {
+		_, err := v.Validate(tt.request())
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+		if kind := ErrorKind(err); kind != tt.kind {
+			t.Errorf("%s: ErrorKind = %q, want %q", tt.name, kind, tt.kind)
+		}
+	}
+	if kind := ErrorKind(errors.New("boom")); kind != "other" {
+		t.Errorf("ErrorKind of an unknown error = %q", kind)
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	m := &Middleware{StaticKeys: []json.RawMessage{ed25519JWK(testPrivateKey)}, ErrorStatus: map[string]int{"invalid_signature": http.StatusForbidden}}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	forged := newSignedRequest(t)
+	forged.Host = "other.example.com"
+	for _, tt := range []struct {
+		r    *http.Request
+		want int
+	}{
+		{httptest.NewRequest(http.MethodGet, "https://example.com/", nil), http.StatusUnauthorized},
+		{forged, http.StatusForbidden},
+	} {
+		r, repl := withReplacer(tt.r)
+		w := httptest.NewRecorder()
+		if err := m.ServeHTTP(w, r, okHandler{}); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", repl.ReplaceAll("{http.httpsig.error}", ""), w.Code, tt.want)
+		}
+	}
+
+	for _, status := range []map[string]int{{"forged": 403}, {"invalid_signature": 500}} {
+		if err := (&Middleware{StaticKeys: m.StaticKeys, ErrorStatus: status}).Provision(newTestContext(t)); err == nil {
+			t.Errorf("error_status %v accepted", status)
+		}
+	}
+	var parsed Middleware
+	if err := parsed.UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\nerror_status malformed 400\nerror_status invalid_signature 403\n}")); err != nil {
+		t.Fatal(err)
+	}
+	if parsed.ErrorStatus["malformed"] != 400 || parsed.ErrorStatus["invalid_signature"] != 403 {
+		t.Errorf("error_status = %v", parsed.ErrorStatus)
+	}
+}
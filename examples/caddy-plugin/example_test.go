@@ -0,0 +1,26 @@
+package httpsig_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	httpsig "github.com/cloudflareresearch/web-bot-auth/examples/caddy-plugin"
+)
+
+// Signatures are verified before the mux routes the request, so @path is checked against the path the bot requested.
+func ExampleSignatureValidator_Handler() {
+	key := json.RawMessage(`{"kty":"OKP","crv":"Ed25519","x":"JrQLj5P_89iXES9-vFgrIy29clF9CC_oPPsw3c5D0bs"}`)
+	validator, err := httpsig.NewValidator([]json.RawMessage{key}, httpsig.ValidatorOptions{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "item %s", r.PathValue("id"))
+	})
+
+	log.Fatal(http.ListenAndServe(":8080", validator.Handler(mux)))
+}
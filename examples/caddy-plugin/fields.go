@@ -0,0 +1,77 @@
+package httpsig
+
+import (
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// HeaderWhitespace controls how whitespace in covered header values is normalized before verification
+type HeaderWhitespace string
+
+const (
+	// HeaderWhitespaceStrict applies the RFC 9421 rules only: values are trimmed and multiple field lines joined with ", "
+	HeaderWhitespaceStrict HeaderWhitespace = "strict"
+	// HeaderWhitespaceLenient also accepts signatures over values whose runs of internal whitespace differ,
+	// as introduced or stripped by some intermediaries
+	HeaderWhitespaceLenient HeaderWhitespace = "lenient"
+)
+
+// ParseHeaderWhitespace returns the header whitespace mode named s
+func ParseHeaderWhitespace(s string) (HeaderWhitespace, error) {
+	switch mode := HeaderWhitespace(s); mode {
+	case HeaderWhitespaceStrict, HeaderWhitespaceLenient:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown header whitespace mode %q, must be strict or lenient", s)
+}
+
+// coveredFields returns the names of the header fields covered by any signature of r
+func coveredFields(r *http.Request) []string {
+	inputs, err := parseSignatureInputs(r.Header)
+	if err != nil {
+		return nil
+	}
+	var fields []string
+	for _, input := range inputs {
+		for _, name := range input.Components {
+			if !strings.HasPrefix(name, "@") {
+				fields = append(fields, name)
+			}
+		}
+	}
+	return fields
+}
+
+// withCollapsedFields returns r with each covered header field set to its RFC 9421 component value with runs of
+// internal whitespace replaced by a single space: every field line trimmed and collapsed, and lines joined with ", ".
+// It reports whether any field changed.
+func withCollapsedFields(r *http.Request, fields []string) (*http.Request, bool) {
+	var header http.Header
+	for _, name := range fields {
+		key := textproto.CanonicalMIMEHeaderKey(name)
+		values := r.Header[key]
+		if len(values) == 0 {
+			continue
+		}
+		collapsed := make([]string, len(values))
+		for i, value := range values {
+			collapsed[i] = strings.Join(strings.Fields(value), " ")
+		}
+		canonical := strings.Join(collapsed, ", ")
+		if len(values) == 1 && values[0] == canonical {
+			continue
+		}
+		if header == nil {
+			header = r.Header.Clone()
+		}
+		header[key] = []string{canonical}
+	}
+	if header == nil {
+		return r, false
+	}
+	candidate := *r
+	candidate.Header = header
+	return &candidate, true
+}
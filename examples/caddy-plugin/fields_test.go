@@ -0,0 +1,56 @@
+package httpsig
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/remitly-oss/httpsig-go"
+)
+
+func TestHeaderWhitespace(t *testing.T) {
+	tests := []struct {
+		name        string
+		signed      string
+		received    []string
+		wantStrict  bool
+		wantLenient bool
+	}{
+		{name: "unchanged", signed: "a, b", received: []string{"a, b"}, wantStrict: true, wantLenient: true},
+		{name: "surrounding whitespace", signed: "a, b", received: []string{"  a, b\t"}, wantStrict: true, wantLenient: true},
+		{name: "multiple field lines", signed: "a, b", received: []string{"a ", " b"}, wantStrict: true, wantLenient: true},
+		{name: "internal whitespace", signed: "a, b", received: []string{"a,   b"}, wantStrict: false, wantLenient: true},
+		{name: "different value", signed: "a, b", received: []string{"a, c"}, wantStrict: false, wantLenient: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for mode, want := range map[HeaderWhitespace]bool{
+				HeaderWhitespaceStrict:  tt.wantStrict,
+				HeaderWhitespaceLenient: tt.wantLenient,
+			} {
+				v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{HeaderWhitespace: mode})
+				if err != nil {
+					t.Fatal(err)
+				}
+				r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+				r.Header.Set("X-List", tt.signed)
+				signRequestWith(t, r, httpsig.Algo_ED25519, testPrivateKey, testKeyID, "@authority", "x-list")
+				r.Header["X-List"] = tt.received
+
+				if _, err := v.Validate(r); (err == nil) != want {
+					t.Errorf("%s: err = %v, want accepted %v", mode, err, want)
+				}
+				if got := r.Header["X-List"]; len(got) != len(tt.received) || got[0] != tt.received[0] {
+					t.Errorf("%s: request header modified to %q", mode, got)
+				}
+			}
+		})
+	}
+}
+
+func TestParseHeaderWhitespace(t *testing.T) {
+	if _, err := ParseHeaderWhitespace("loose"); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
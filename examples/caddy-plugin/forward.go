@@ -0,0 +1,59 @@
+package httpsig
+
+import (
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// stripForwardHeaders removes the headers configured with set_header from r, so that clients cannot pass off
+// a request as verified by sending them themselves
+func (m *Middleware) stripForwardHeaders(r *http.Request) {
+	for name := range m.SetHeaders {
+		r.Header.Del(name)
+	}
+}
+
+// signatureHeaders are the fields carrying signature material, removed with strip_signature_headers
+var signatureHeaders = []string{"Signature", "Signature-Input", "Signature-Agent"}
+
+// stripSignatureHeaders removes the signature of the verified request r, so that the upstream cannot mistake
+// the presence of signature fields for verification
+func stripSignatureHeaders(r *http.Request) {
+	for _, name := range signatureHeaders {
+		r.Header.Del(name)
+	}
+}
+
+// setForwardHeaders sets the headers configured with set_header on the verified request r, expanding {bot},
+// {keyid}, {label} and {directory}, the host of the verifying key's directory, along with Caddy's placeholders
+func (m *Middleware) setForwardHeaders(r *http.Request, result ValidationResult, bot *Bot) {
+	if len(m.SetHeaders) == 0 {
+		return
+	}
+	identity := map[string]string{"keyid": result.KeyID, "label": result.Label, "bot": "", "directory": ""}
+	if bot != nil {
+		identity["bot"] = bot.Name
+	}
+	if result.Directory != "" {
+		if u, err := resolveDirectory(result.Directory); err == nil {
+			identity["directory"] = u.Host
+		}
+	}
+	repl, _ := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	expand := caddy.NewEmptyReplacer()
+	expand.Map(func(key string) (any, bool) {
+		if val, ok := identity[key]; ok {
+			return val, true
+		}
+		if repl == nil {
+			return nil, false
+		}
+		return repl.Get(key)
+	})
+	for name, value := range m.SetHeaders {
+		if value = expand.ReplaceKnown(value, ""); value != "" {
+			r.Header.Set(name, value)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package httpsig
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// headerRecorder is a next handler recording the request headers it receives
+type headerRecorder struct{ header http.Header }
+
+func (h *headerRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	h.header = r.Header.Clone()
+	return nil
+}
+
+func TestSetHeaders(t *testing.T) {
+	m := &Middleware{
+		StaticKeys:      []json.RawMessage{ed25519JWK(testPrivateKey)},
+		AllowUnverified: true,
+		Bots:            []Bot{{Name: "crawler", KeyIDs: []string{testKeyID}}},
+		SetHeaders: map[string]string{
+			"X-Verified-Bot":   "{bot}",
+			"X-Verified-Keyid": "{keyid}",
+			"X-Bot-Directory":  "{directory}",
+			"X-Bot-Label":      "{http.httpsig.label}",
+		},
+	}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		sign   bool
+		want   map[string]string
+		absent []string
+	}{
+		{
+			name:   "verified",
+			sign:   true,
+			want:   map[string]string{"X-Verified-Bot": "crawler", "X-Verified-Keyid": testKeyID, "X-Bot-Label": "sig1"},
+			absent: []string{"X-Bot-Directory"},
+		},
+		{name: "unverified", absent: []string{"X-Verified-Bot", "X-Verified-Keyid", "X-Bot-Directory", "X-Bot-Label"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := withReplacer(httptest.NewRequest(http.MethodGet, "https://example.com/", nil))
+			for name := range m.SetHeaders {
+				r.Header.Set(name, "spoofed")
+			}
+			if tt.sign {
+				signRequest(t, r, testPrivateKey, testKeyID)
+			}
+			next := &headerRecorder{}
+			if err := m.ServeHTTP(httptest.NewRecorder(), r, next); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tt.want {
+				if got := next.header.Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+			for _, name := range tt.absent {
+				if got, ok := next.header[name]; ok {
+					t.Errorf("%s = %q, want it removed", name, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSetHeadersDirectory(t *testing.T) {
+	m := &Middleware{SetHeaders: map[string]string{"X-Bot-Directory": "{directory}"}}
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	m.setForwardHeaders(r, ValidationResult{KeyID: testKeyID, Directory: "https://Signer.example.com/.well-known/http-message-signatures-directory"}, nil)
+	if got := r.Header.Get("X-Bot-Directory"); got != "Signer.example.com" {
+		t.Errorf("X-Bot-Directory = %q, want Signer.example.com", got)
+	}
+}
+
+func TestUnmarshalSetHeader(t *testing.T) {
+	var m Middleware
+	d := caddyfile.NewTestDispenser(`httpsig {
+		set_header X-Verified-Bot {bot}
+		set_header X-Verified-Keyid {keyid}
+	}`)
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	if m.SetHeaders["X-Verified-Bot"] != "{bot}" || m.SetHeaders["X-Verified-Keyid"] != "{keyid}" {
+		t.Errorf("set_headers = %v", m.SetHeaders)
+	}
+	if err := (&Middleware{}).UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\nset_header X-Verified-Bot\n}")); err == nil {
+		t.Error("set_header without a value accepted")
+	}
+}
+
+func TestStripSignatureHeaders(t *testing.T) {
+	for _, strip := range []bool{false, true} {
+		m := &Middleware{StaticKeys: []json.RawMessage{ed25519JWK(testPrivateKey)}, StripSignatureHeaders: strip}
+		if err := m.Provision(newTestContext(t)); err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		r.Header.Set("Signature-Agent", `"https://signer.example.com"`)
+		signRequest(t, r, testPrivateKey, testKeyID)
+		next := &headerRecorder{}
+		if err := m.ServeHTTP(httptest.NewRecorder(), r, next); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range signatureHeaders {
+			if _, ok := next.header[name]; ok == strip {
+				t.Errorf("strip_signature_headers %v: %s present = %v", strip, name, ok)
+			}
+		}
+	}
+}
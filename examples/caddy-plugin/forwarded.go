@@ -0,0 +1,97 @@
+package httpsig
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// privateRanges are the ranges the private_ranges shorthand of trusted_proxies stands for, as in Caddy
+var privateRanges = []string{"192.168.0.0/16", "172.16.0.0/12", "10.0.0.0/8", "127.0.0.1/8", "fd00::/8", "::1"}
+
+// parseTrustedProxies parses ranges of trusted proxies, given as CIDR prefixes or single addresses, expanding
+// private_ranges
+func parseTrustedProxies(ranges []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range ranges {
+		if s == "private_ranges" {
+			expanded, _ := parseTrustedProxies(privateRanges)
+			prefixes = append(prefixes, expanded...)
+			continue
+		}
+		if strings.Contains(s, "/") {
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy range %q: %v", s, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy address %q: %v", s, err)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// trustedPeer reports whether the peer r came from is in one of trusted
+func trustedPeer(r *http.Request, trusted []netip.Prefix) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	return slices.ContainsFunc(trusted, func(prefix netip.Prefix) bool { return prefix.Contains(addr) })
+}
+
+// withForwardedOrigin returns r with its host set to X-Forwarded-Host and its URL scheme to X-Forwarded-Proto, when
+// r comes from a trusted proxy, so that @authority, @scheme and @target-uri are derived from the public origin.
+// Behind a load balancer, bots sign the public host and scheme, which the request Caddy sees may not carry. The
+// headers of other peers are ignored, as any client can send them.
+func withForwardedOrigin(r *http.Request, trusted []netip.Prefix) *http.Request {
+	if len(trusted) == 0 || !trustedPeer(r, trusted) {
+		return r
+	}
+	host := firstForwarded(r.Header.Get("X-Forwarded-Host"))
+	proto := strings.ToLower(firstForwarded(r.Header.Get("X-Forwarded-Proto")))
+	if proto != "http" && proto != "https" {
+		proto = ""
+	}
+	if host == "" && proto == "" {
+		return r
+	}
+	candidate := *r
+	if host != "" {
+		candidate.Host = host
+	}
+	if proto != "" {
+		u := *r.URL
+		u.Scheme = proto
+		candidate.URL = &u
+	}
+	// The default port of the authority variants follows whether the request came over TLS
+	switch {
+	case proto == "https" && r.TLS == nil:
+		candidate.TLS = &tls.ConnectionState{}
+	case proto == "http":
+		candidate.TLS = nil
+	}
+	return &candidate
+}
+
+// firstForwarded returns the first value of a comma-separated X-Forwarded header, that of the original request
+// when proxies append to it
+func firstForwarded(value string) string {
+	first, _, _ := strings.Cut(value, ",")
+	return strings.TrimSpace(first)
+}
@@ -0,0 +1,126 @@
+package httpsig
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/cloudflareresearch/web-bot-auth/go/webbotauth"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	prefixes, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.7", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.0.2.7/32"), netip.MustParsePrefix("2001:db8::/32")}
+	if len(prefixes) != len(want) {
+		t.Fatalf("prefixes = %v, want %v", prefixes, want)
+	}
+	for i := range want {
+		if prefixes[i] != want[i] {
+			t.Errorf("prefix %d = %v, want %v", i, prefixes[i], want[i])
+		}
+	}
+	if private, err := parseTrustedProxies([]string{"private_ranges"}); err != nil || len(private) != len(privateRanges) {
+		t.Errorf("private_ranges = %v, %v", private, err)
+	}
+	for _, invalid := range []string{"10.0.0.0/33", "proxy.example"} {
+		if _, err := parseTrustedProxies([]string{invalid}); err == nil {
+			t.Errorf("%s accepted", invalid)
+		}
+	}
+}
+
+func TestForwardedOrigin(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	forwarded := func(remote string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "http://backend:8080/", nil)
+		r.RemoteAddr = remote
+		r.Header.Set("X-Forwarded-Host", "public.example, backend:8080")
+		r.Header.Set("X-Forwarded-Proto", "https")
+		return r
+	}
+
+	r := forwarded("10.1.2.3:4567")
+	got := withForwardedOrigin(r, trusted)
+	if got.Host != "public.example" || got.URL.Scheme != "https" || got.TLS == nil {
+		t.Errorf("host = %s, scheme = %s, tls = %v, want public.example over https", got.Host, got.URL.Scheme, got.TLS != nil)
+	}
+	if r.URL.Scheme != "http" {
+		t.Errorf("the URL of the request was modified, scheme = %s", r.URL.Scheme)
+	}
+	if got := withForwardedOrigin(forwarded("203.0.113.9:4567"), trusted); got.Host != "backend:8080" || got.TLS != nil {
+		t.Errorf("untrusted peer: host = %s, tls = %v", got.Host, got.TLS != nil)
+	}
+	if got := withForwardedOrigin(forwarded("10.1.2.3:4567"), nil); got.Host != "backend:8080" {
+		t.Errorf("no trusted proxies: host = %s", got.Host)
+	}
+}
+
+func TestVerifyBehindProxy(t *testing.T) {
+	signed := httptest.NewRequest(http.MethodGet, "https://public.example/", nil)
+	signRequest(t, signed, testPrivateKey, testKeyID)
+	behind := func(remote string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "http://backend:8080/", nil)
+		r.RemoteAddr = remote
+		r.Header = signed.Header.Clone()
+		r.Header.Set("X-Forwarded-Host", "public.example")
+		r.Header.Set("X-Forwarded-Proto", "https")
+		return r
+	}
+
+	v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Validate(behind("10.0.0.2:4567")); err != nil {
+		t.Errorf("request from a trusted proxy: %v", err)
+	}
+	// Anyone could claim the signed host in X-Forwarded-Host
+	if _, err := v.Validate(behind("203.0.113.9:4567")); err == nil {
+		t.Error("forwarded host of an untrusted peer accepted")
+	}
+
+	// A signature covering @scheme verifies against the scheme of X-Forwarded-Proto, the proxy speaking plain http
+	signer, err := webbotauth.NewSigner(testPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer.Components = []string{"@authority", "@scheme", "@target-uri"}
+	schemeSigned := httptest.NewRequest(http.MethodGet, "https://public.example/", nil)
+	if err := signer.Sign(schemeSigned); err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range []string{"/", "http://backend:8080/"} {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Host, r.RemoteAddr, r.TLS = "backend:8080", "10.0.0.2:4567", nil
+		r.Header = schemeSigned.Header.Clone()
+		r.Header.Set("X-Forwarded-Host", "public.example")
+		r.Header.Set("X-Forwarded-Proto", "https")
+		if _, err := v.Validate(r); err != nil {
+			t.Errorf("request for %s covering @scheme from a trusted proxy: %v", target, err)
+		}
+		r.Header.Set("X-Forwarded-Proto", "http")
+		if _, err := v.Validate(r); err == nil {
+			t.Errorf("request for %s covering @scheme forwarded over http accepted", target)
+		}
+	}
+
+	var m Middleware
+	if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\n\ttrusted_proxies 10.0.0.0/8 private_ranges\n}")); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.TrustedProxies) != 2 {
+		t.Errorf("trusted_proxies = %v", m.TrustedProxies)
+	}
+	bad := Middleware{StaticKeys: []json.RawMessage{ed25519JWK(testPrivateKey)}, TrustedProxies: []string{"not an address"}}
+	if err := bad.Provision(newTestContext(t)); err == nil {
+		t.Error("invalid trusted_proxies accepted")
+	}
+}
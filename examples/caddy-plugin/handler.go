@@ -1,68 +1,842 @@
 package httpsig
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
+	"net"
 	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/lestrrat-go/jwx/v3/jwa"
 	"github.com/lestrrat-go/jwx/v3/jwk"
 	"github.com/remitly-oss/httpsig-go"
-	"github.com/remitly-oss/httpsig-go/keyman"
 )
 
+// ErrDisallowedComponent is returned when a signature covers a component the operator forbids
+var ErrDisallowedComponent = errors.New("signature covers a disallowed component")
+
+// ErrMissingComponent is returned when a signature does not cover a required component
+var ErrMissingComponent = errors.New("signature does not cover a required component")
+
+// ErrSignatureExpired is returned when a signature is verified after its expires parameter
+var ErrSignatureExpired = errors.New("signature has expired")
+
+// ErrMissingExpires is returned when expires is required but a signature does not set it
+var ErrMissingExpires = errors.New("signature has no expires parameter")
+
+// ErrSignatureNotYetValid is returned when a signature is created further in the future than the created skew
+var ErrSignatureNotYetValid = errors.New("signature is created in the future")
+
+// ErrSignatureTooOld is returned when a signature was created longer ago than the maximum signature age
+var ErrSignatureTooOld = errors.New("signature is too old")
+
+// ErrDateSkew is returned when the covered Date header is further from the created parameter than the date skew
+var ErrDateSkew = errors.New("Date header does not match the signature creation time")
+
+// ErrNoWebBotAuthSignature is returned when a request carries signatures, but none tagged web-bot-auth
+var ErrNoWebBotAuthSignature = errors.New("no signature is tagged web-bot-auth")
+
+// DefaultCreatedSkew is how far in the future signatures may be created, absorbing clock differences with bots
+const DefaultCreatedSkew = time.Minute
+
+// DefaultMaxSignatureAge is how long after their creation signatures are accepted when no maximum age is configured,
+// bounding the lifetime of signatures without expires
+const DefaultMaxSignatureAge = 5 * time.Hour
+
+// DefaultAllowedAlgorithms is used when no algorithm allowlist is configured
+var DefaultAllowedAlgorithms = []httpsig.Algorithm{httpsig.Algo_ED25519}
+
+// supportedAlgorithms are the algorithms a directory key can be used with
+var supportedAlgorithms = []httpsig.Algorithm{
+	httpsig.Algo_ED25519,
+	httpsig.Algo_ECDSA_P256_SHA256,
+	httpsig.Algo_ECDSA_P384_SHA384,
+	httpsig.Algo_RSA_PSS_SHA512,
+	httpsig.Algo_RSA_v1_5_sha256,
+}
+
+// ParseAlgorithm returns the signature algorithm registered under name
+func ParseAlgorithm(name string) (httpsig.Algorithm, error) {
+	algo := httpsig.Algorithm(name)
+	if !slices.Contains(supportedAlgorithms, algo) {
+		return "", fmt.Errorf("unsupported signature algorithm %q", name)
+	}
+	return algo, nil
+}
+
+// AuthorityNormalization controls how the signed @authority is matched against the request host
+type AuthorityNormalization string
+
+const (
+	// AuthorityStrict requires @authority to be signed as the request host, lowercased as RFC 9421 derives it
+	AuthorityStrict AuthorityNormalization = "strict"
+	// AuthorityLenient also accepts the host with or without the default port of the request scheme
+	AuthorityLenient AuthorityNormalization = "lenient"
+)
+
+// ParseAuthorityNormalization returns the normalization mode named s
+func ParseAuthorityNormalization(s string) (AuthorityNormalization, error) {
+	switch mode := AuthorityNormalization(s); mode {
+	case AuthorityStrict, AuthorityLenient:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown authority normalization %q, must be strict or lenient", s)
+}
+
+// ParameterCase controls whether signature parameter keys must be lowercase
+type ParameterCase string
+
+const (
+	// ParameterCaseStrict requires lowercase parameter keys, as structured fields do
+	ParameterCaseStrict ParameterCase = "strict"
+	// ParameterCaseLenient recognizes standard parameter keys such as Created or KeyID in any case
+	ParameterCaseLenient ParameterCase = "lenient"
+)
+
+// ParseParameterCase returns the parameter case mode named s
+func ParseParameterCase(s string) (ParameterCase, error) {
+	switch mode := ParameterCase(s); mode {
+	case ParameterCaseStrict, ParameterCaseLenient:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown parameter case %q, must be strict or lenient", s)
+}
+
+// webBotAuthTag is the signature tag identifying web-bot-auth signatures
+const webBotAuthTag = "web-bot-auth"
+
+// TagEnforcement controls how the tag signature parameter is checked
+type TagEnforcement string
+
+const (
+	// TagStrict requires the web-bot-auth tag
+	TagStrict TagEnforcement = "strict"
+	// TagLenient accepts signatures without a tag but rejects any other tag, for bots migrating to web-bot-auth
+	TagLenient TagEnforcement = "lenient"
+	// TagOff ignores the tag
+	TagOff TagEnforcement = "off"
+)
+
+// ParseTagEnforcement returns the tag enforcement mode named s
+func ParseTagEnforcement(s string) (TagEnforcement, error) {
+	switch mode := TagEnforcement(s); mode {
+	case TagStrict, TagLenient, TagOff:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown tag enforcement %q, must be strict, lenient or off", s)
+}
+
 type SignatureValidator struct {
-	Verifier *httpsig.Verifier
+	verifier      *requestVerifier
+	keys          map[string]keySpec
+	fetcher       *keyFetcher
+	allowed       []httpsig.Algorithm
+	required      []string
+	disallowed    []string
+	authority     AuthorityNormalization
+	trusted       []netip.Prefix
+	authorities   []string
+	tag           TagEnforcement
+	challengeMode ChallengeMode
+	paramCase     ParameterCase
+	whitespace    HeaderWhitespace
+	nonces        *NonceCache
+	revoked       map[string]struct{}
+	grace         time.Duration
+	skew          time.Duration
+	maxAge        time.Duration
+	expiresGrace  time.Duration
+	needExpires   bool
+	needNonce     bool
+	dateSkew      time.Duration
+	maxBody       int64
+	now           func() time.Time
 }
 
-func NewValidator(keyData []byte) (*SignatureValidator, error) {
+// ValidatorOptions configures which signatures a SignatureValidator accepts
+type ValidatorOptions struct {
+	// AllowedAlgorithms restricts the algorithms signatures may use, regardless of the keys a directory publishes.
+	// Defaults to DefaultAllowedAlgorithms.
+	AllowedAlgorithms []httpsig.Algorithm
+	// DropDisallowedKeys drops keys whose algorithm is not allowed instead of loading them and rejecting their signatures.
+	DropDisallowedKeys bool
+	// RequiredFields are components every signature must cover. Defaults to DefaultRequiredFields.
+	RequiredFields []string
+	// AuthorityComponent adds the host or @authority components to the required fields, replacing the default
+	AuthorityComponent AuthorityComponent
+	// DisallowedFields are components a signature must not cover, such as "@query" on routes where it is meaningless.
+	DisallowedFields []string
+	// AuthorityNormalization defaults to AuthorityStrict
+	AuthorityNormalization AuthorityNormalization
+	// TrustedProxies are the peers whose X-Forwarded-Host and X-Forwarded-Proto give the host and scheme
+	// signatures are verified against, for validators behind a load balancer
+	TrustedProxies []netip.Prefix
+	// ExpectedAuthorities are the authorities signatures are verified against in place of the request host, tried
+	// in order, for requests whose host internal rewrites changed from the public one bots signed
+	ExpectedAuthorities []string
+	// Challenge selects the challenge headers sent with rejections. Defaults to ChallengeBoth.
+	Challenge ChallengeMode
+	// TagEnforcement defaults to TagStrict. Signatures it does not accept are ignored rather than verified.
+	TagEnforcement TagEnforcement
+	// ParameterCase defaults to ParameterCaseStrict
+	ParameterCase ParameterCase
+	// HeaderWhitespace defaults to HeaderWhitespaceStrict
+	HeaderWhitespace HeaderWhitespace
+	// KeyActivationGrace accepts keys whose nbf is at most this far in the future, absorbing clock skew with bots
+	// that start signing with a newly published key slightly early
+	KeyActivationGrace time.Duration
+	// CreatedSkew accepts signatures created at most this far ahead of Now. Defaults to DefaultCreatedSkew.
+	CreatedSkew time.Duration
+	// MaxSignatureAge rejects signatures created longer ago than this, whatever their expires.
+	// Defaults to DefaultMaxSignatureAge.
+	MaxSignatureAge time.Duration
+	// ExpiresGrace accepts signatures verified at most this long after their expires, absorbing clock drift with
+	// bots. It is independent of CreatedSkew and MaxSignatureAge.
+	ExpiresGrace time.Duration
+	// RequireExpires rejects signatures without an expires parameter with ErrMissingExpires, instead of leaving
+	// their lifetime to MaxSignatureAge
+	RequireExpires bool
+	// DateSkew rejects signatures covering a Date header further than this from their created parameter.
+	// Zero does not compare them.
+	DateSkew time.Duration
+	// MaxBodySize is the largest body, in bytes, buffered to check Content-Digest. Larger bodies are rejected with
+	// ErrBodyTooLarge. Zero does not limit the size.
+	MaxBodySize int64
+	// RequireNonce rejects signatures without a nonce parameter with ErrMissingNonce, even if otherwise valid
+	RequireNonce bool
+	// Nonces rejects signatures replaying a nonce. Share one cache between validators replacing each other
+	// so that a directory refresh does not forget accepted nonces. Nil disables replay protection.
+	Nonces *NonceCache
+	// RevokedKeyIDs rejects signatures by these keys with ErrRevoked, even though they verify
+	RevokedKeyIDs []string
+	// KeyStores look up keyids the keys of the validator do not hold, in order
+	KeyStores []KeyStore
+	// Now is the clock signature expiry and key validity are checked against. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// ValidationResult describes the signature that was accepted for a request.
+type ValidationResult struct {
+	// KeyID is the keyid of the directory key that verified the signature.
+	KeyID string
+	// Thumbprint is the RFC 7638 JWK thumbprint of the verifying key, which is also its keyid unless configured otherwise
+	Thumbprint string
+	// Algorithm is the algorithm of the verifying key
+	Algorithm httpsig.Algorithm
+	// Label is the label of the signature that verified, such as sig1. The signer chooses it, so it distinguishes
+	// signatures on the same request, a bot's own from a re-signing proxy's, but does not identify the signer.
+	Label string
+	// Purpose is the purpose published by the directory of the verifying key, if any
+	Purpose string
+	// Directory is the directory_base the verifying key was loaded from, empty for static keys
+	Directory string
+	// Created and Expires are the created and expires parameters of the signature, zero when absent
+	Created, Expires time.Time
+	// Nonce is the nonce parameter of the signature, if any
+	Nonce string
+	// Tag is the tag parameter of the signature, web-bot-auth unless tag enforcement is relaxed
+	Tag string
+	// Components are the components covered by the signature, in signing order.
+	Components []string
+	// Warnings describe non-compliant signatures that were accepted because of lenient options.
+	Warnings []string
+}
+
+// keySpec is a directory key and its validity period
+type keySpec struct {
+	httpsig.KeySpec
+	// NotBefore and NotAfter come from the nbf and exp JWK members. They are zero when absent.
+	NotBefore, NotAfter time.Time
+	// Purpose is the purpose of the directory publishing the key, empty for static keys
+	Purpose string
+	// Directory is the directory_base publishing the key, empty for static keys
+	Directory string
+	// Thumbprint is the RFC 7638 thumbprint of the key, which keyid may differ from
+	Thumbprint string
+	// Certificates is the x5c chain of the JWK, leaf first, if any
+	Certificates []*x509.Certificate
+}
+
+// parseKeySpec parses a public JWK, deriving its keyid from the RFC 7638 thumbprint and its algorithm from the key type
+// Some directories wrap each JWK in a JSON string, which is unwrapped first.
+func parseKeySpec(keyData []byte) (keySpec, error) {
+	if trimmed := bytes.TrimSpace(keyData); len(trimmed) > 0 && trimmed[0] == '"' {
+		var wrapped string
+		if err := json.Unmarshal(trimmed, &wrapped); err != nil {
+			return keySpec{}, fmt.Errorf("decoding string-wrapped key: %w", err)
+		}
+		keyData = []byte(wrapped)
+	}
+
 	pubKey, err := jwk.ParseKey(keyData)
 	if err != nil {
-		return nil, fmt.Errorf("parsing public key: %w", err)
+		return keySpec{}, fmt.Errorf("parsing public key: %w", err)
 	}
 
 	thumbprint, err := pubKey.Thumbprint(crypto.SHA256)
 	if err != nil {
-		return nil, fmt.Errorf("cannot generate key id from key: %w", err)
+		return keySpec{}, fmt.Errorf("cannot generate key id from key: %w", err)
 	}
 	keyid := base64.RawURLEncoding.EncodeToString(thumbprint)
-	pk, _ := jwk.PublicRawKeyOf(pubKey)
-
-	kf := keyman.NewKeyFetchInMemory(map[string]httpsig.KeySpec{
-		keyid: {
-			KeyID:  keyid,
-			Algo:   httpsig.Algo_ED25519,
-			PubKey: pk,
-		},
-	})
-
-	verifier, err := httpsig.NewVerifier(kf, httpsig.VerifyProfile{
-		AllowedAlgorithms:         []httpsig.Algorithm{httpsig.Algo_ED25519},
-		RequiredFields:            httpsig.Fields("@authority"),
-		RequiredMetadata:          httpsig.DefaultVerifyProfile.RequiredMetadata,
-		DisallowedMetadata:        []httpsig.Metadata{},
-		DisableMultipleSignatures: httpsig.DefaultVerifyProfile.DisableMultipleSignatures,
-		CreatedValidDuration:      time.Hour * 5, // Signatures must have been created within within the last 5 minutes
-		DateFieldSkew:             time.Minute,   // If the created parameter is present, the Date header cannot be more than a minute off.
-	})
+	pk, err := jwk.PublicRawKeyOf(pubKey)
+	if err != nil {
+		return keySpec{}, fmt.Errorf("reading public key: %w", err)
+	}
+
+	algo, err := keyAlgorithm(pubKey, pk)
 	if err != nil {
-		return nil, fmt.Errorf("creating verifier: %w", err)
+		return keySpec{}, err
 	}
 
-	return &SignatureValidator{Verifier: verifier}, nil
+	ks := keySpec{KeySpec: httpsig.KeySpec{KeyID: keyid, Algo: algo, PubKey: pk}, Thumbprint: keyid}
+	if ks.NotBefore, err = jwkTime(pubKey, "nbf"); err != nil {
+		return keySpec{}, err
+	}
+	if ks.NotAfter, err = jwkTime(pubKey, "exp"); err != nil {
+		return keySpec{}, err
+	}
+	if ks.Certificates, err = parseX5C(keyData); err != nil {
+		return keySpec{}, err
+	}
+	return ks, nil
+}
+
+// jwkTime reads the NumericDate member name of key, returning the zero time when it is absent
+func jwkTime(key jwk.Key, name string) (time.Time, error) {
+	if !key.Has(name) {
+		return time.Time{}, nil
+	}
+	var v any
+	if err := key.Get(name, &v); err != nil {
+		return time.Time{}, fmt.Errorf("reading %s: %w", name, err)
+	}
+	seconds, ok := v.(float64)
+	if !ok {
+		return time.Time{}, fmt.Errorf("%s is not a number", name)
+	}
+	return time.Unix(int64(seconds), 0), nil
+}
+
+// keyAlgorithm derives the signature algorithm from the type of a public key
+func keyAlgorithm(key jwk.Key, pk any) (httpsig.Algorithm, error) {
+	switch pk := pk.(type) {
+	case ed25519.PublicKey:
+		return httpsig.Algo_ED25519, nil
+	case *ecdsa.PublicKey:
+		switch pk.Curve {
+		case elliptic.P256():
+			return httpsig.Algo_ECDSA_P256_SHA256, nil
+		case elliptic.P384():
+			return httpsig.Algo_ECDSA_P384_SHA384, nil
+		}
+		return "", fmt.Errorf("unsupported elliptic curve %s", pk.Curve.Params().Name)
+	case *rsa.PublicKey:
+		// RSA keys are used with RSA-PSS unless the JWK says otherwise
+		if alg, ok := key.Algorithm(); ok && alg.String() == jwa.RS256().String() {
+			return httpsig.Algo_RSA_v1_5_sha256, nil
+		}
+		return httpsig.Algo_RSA_PSS_SHA512, nil
+	}
+	return "", fmt.Errorf("unsupported public key type %T", pk)
 }
 
-func (v *SignatureValidator) Validate(r *http.Request) error {
-	result, err := v.Verifier.Verify(r)
+// parseKeySpecs parses a list of public JWKs, failing on the first invalid one
+func parseKeySpecs(keys []json.RawMessage) ([]keySpec, error) {
+	specs := make([]keySpec, 0, len(keys))
+	for i, keyData := range keys {
+		ks, err := parseKeySpec(keyData)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", i, err)
+		}
+		specs = append(specs, ks)
+	}
+	return specs, nil
+}
+
+func NewValidator(keys []json.RawMessage, opts ValidatorOptions) (*SignatureValidator, error) {
+	specs, err := parseKeySpecs(keys)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	return newValidator(specs, opts)
+}
+
+// newValidator returns a validator trusting the given keys, which are looked up by their KeyID.
+// When two keys share a KeyID, the last one wins.
+func newValidator(keys []keySpec, opts ValidatorOptions) (*SignatureValidator, error) {
+	allowed := opts.AllowedAlgorithms
+	if len(allowed) == 0 {
+		allowed = DefaultAllowedAlgorithms
 	}
 
-	if len(result.InvalidSignatures) > 0 {
-		return errors.New("invalid signatures")
+	specs := map[string]keySpec{}
+	for _, ks := range keys {
+		if opts.DropDisallowedKeys && !slices.Contains(allowed, ks.Algo) {
+			continue
+		}
+		specs[ks.KeyID] = ks
+	}
+
+	required := DefaultRequiredFields
+	if len(opts.RequiredFields) > 0 || opts.AuthorityComponent != "" {
+		required = make([]string, 0, len(opts.RequiredFields))
+		for _, field := range opts.RequiredFields {
+			field = strings.ToLower(field)
+			if err := checkComponent(field); err != nil {
+				return nil, fmt.Errorf("required field: %w", err)
+			}
+			required = append(required, field)
+		}
+	}
+	if opts.AuthorityComponent != "" {
+		for _, field := range opts.AuthorityComponent.fields() {
+			if !slices.Contains(required, field) {
+				required = append(required, field)
+			}
+		}
+	}
+
+	kf := &keyFetcher{keys: specs, stores: opts.KeyStores}
+
+	disallowed := make([]string, 0, len(opts.DisallowedFields))
+	for _, field := range opts.DisallowedFields {
+		field = strings.ToLower(field)
+		if err := checkComponent(field); err != nil {
+			return nil, fmt.Errorf("disallowed field: %w", err)
+		}
+		if slices.Contains(required, field) {
+			return nil, fmt.Errorf("%s is both required and disallowed", field)
+		}
+		disallowed = append(disallowed, field)
+	}
+
+	authority := opts.AuthorityNormalization
+	if authority == "" {
+		authority = AuthorityStrict
+	}
+
+	tag := opts.TagEnforcement
+	if tag == "" {
+		tag = TagStrict
+	}
+
+	now := opts.Now
+	if now == nil {
+		now = time.Now
+	}
+	skew := opts.CreatedSkew
+	if skew == 0 {
+		skew = DefaultCreatedSkew
+	}
+	maxAge := opts.MaxSignatureAge
+	if maxAge == 0 {
+		maxAge = DefaultMaxSignatureAge
+	}
+
+	revoked := make(map[string]struct{}, len(opts.RevokedKeyIDs))
+	for _, keyid := range opts.RevokedKeyIDs {
+		revoked[keyid] = struct{}{}
+	}
+
+	return &SignatureValidator{
+		verifier:      &requestVerifier{keys: kf},
+		keys:          specs,
+		fetcher:       kf,
+		allowed:       allowed,
+		required:      required,
+		disallowed:    disallowed,
+		authority:     authority,
+		trusted:       opts.TrustedProxies,
+		authorities:   opts.ExpectedAuthorities,
+		tag:           tag,
+		challengeMode: opts.Challenge,
+		paramCase:     opts.ParameterCase,
+		whitespace:    opts.HeaderWhitespace,
+		nonces:        opts.Nonces,
+		revoked:       revoked,
+		grace:         opts.KeyActivationGrace,
+		skew:          skew,
+		maxAge:        maxAge,
+		expiresGrace:  opts.ExpiresGrace,
+		needExpires:   opts.RequireExpires,
+		needNonce:     opts.RequireNonce,
+		dateSkew:      opts.DateSkew,
+		maxBody:       opts.MaxBodySize,
+		now:           now,
+	}, nil
+}
+
+// verify runs the verifier on r, retrying with the expected authorities in place of its host, with whitespace
+// collapsed in covered headers in lenient header whitespace mode, and with normalized hosts in lenient authority mode
+func (v *SignatureValidator) verify(r *http.Request) (httpsig.VerifyResult, error) {
+	if len(v.authorities) == 0 {
+		return v.verifyFields(r)
+	}
+	// Internal rewrites may have changed the host, so it is replaced by each expected authority in turn. If none
+	// verifies every signature, the attempt verifying the most is kept.
+	var best httpsig.VerifyResult
+	var bestErr error
+	for i, authority := range v.authorities {
+		candidate := *r
+		candidate.Host = authority
+		result, err := v.verifyFields(&candidate)
+		if err == nil {
+			return result, nil
+		}
+		if i == 0 || len(result.Signatures) > len(best.Signatures) {
+			best, bestErr = result, err
+		}
+	}
+	return best, bestErr
+}
+
+// verifyFields runs the verifier on r, retrying with whitespace collapsed in covered headers in lenient header
+// whitespace mode
+func (v *SignatureValidator) verifyFields(r *http.Request) (httpsig.VerifyResult, error) {
+	fields := coveredFields(r)
+	r = withHostField(r, fields)
+	result, err := v.verifyAuthority(r)
+	if v.whitespace != HeaderWhitespaceLenient || !isVerificationFailure(err) {
+		return result, err
+	}
+	if collapsed, ok := withCollapsedFields(r, fields); ok {
+		if result, verr := v.verifyAuthority(collapsed); verr == nil {
+			return result, nil
+		}
+	}
+	return result, err
+}
+
+// verifyAuthority runs the verifier, retrying with normalized forms of the request host in lenient authority mode
+func (v *SignatureValidator) verifyAuthority(r *http.Request) (httpsig.VerifyResult, error) {
+	result, err := v.verifier.Verify(withFreshBody(r))
+	if v.authority != AuthorityLenient || !isVerificationFailure(err) {
+		return result, err
 	}
 
+	for _, host := range authorityVariants(r.Host, r.TLS != nil) {
+		// The verifier derives @authority from Host, so each candidate is tried on a shallow copy
+		candidate := *r
+		candidate.Host = host
+		if result, verr := v.verifier.Verify(withFreshBody(&candidate)); verr == nil {
+			return result, nil
+		}
+	}
+	return result, err
+}
+
+// checkValidity rejects keys outside of their nbf and exp validity period, allowing the activation grace before nbf
+func (v *SignatureValidator) checkValidity(ks keySpec) error {
+	now := v.now()
+	if !ks.NotBefore.IsZero() && now.Add(v.grace).Before(ks.NotBefore) {
+		return fmt.Errorf("key %s is not valid before %s", ks.KeyID, ks.NotBefore.Format(time.RFC3339))
+	}
+	if !ks.NotAfter.IsZero() && !now.Before(ks.NotAfter) {
+		return fmt.Errorf("key %s expired at %s", ks.KeyID, ks.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// checkTimes rejects signatures created beyond the created skew or longer ago than the maximum age,
+// or verified more than the expires grace after they expire. Signatures with neither created nor expires would
+// never expire, and are rejected.
+func (v *SignatureValidator) checkTimes(sig httpsig.VerifiedSignature) error {
+	now := v.now()
+	created, createdErr := sig.Created()
+	if createdErr == nil {
+		at := time.Unix(int64(created), 0)
+		if at.After(now.Add(v.skew)) {
+			return fmt.Errorf("%w: %s", ErrSignatureNotYetValid, at.UTC().Format(time.RFC3339))
+		}
+		if now.Sub(at) > v.maxAge {
+			return fmt.Errorf("%w: created at %s", ErrSignatureTooOld, at.UTC().Format(time.RFC3339))
+		}
+	}
+	exp, err := sig.Expires()
+	if err != nil {
+		if v.needExpires || createdErr != nil {
+			return ErrMissingExpires
+		}
+		return nil
+	}
+	if at := time.Unix(int64(exp), 0); !now.Before(at.Add(v.expiresGrace)) {
+		return fmt.Errorf("%w at %s", ErrSignatureExpired, at.UTC().Format(time.RFC3339))
+	}
 	return nil
 }
+
+// checkDate rejects signatures covering a Date header further from their created parameter than the date skew.
+// The header is only trusted when covered, as it could otherwise be rewritten to match.
+func (v *SignatureValidator) checkDate(r *http.Request, input signatureInput, sig httpsig.VerifiedSignature) error {
+	if v.dateSkew == 0 || !input.covers("date") {
+		return nil
+	}
+	created, err := sig.Created()
+	if err != nil {
+		return nil
+	}
+	date, err := http.ParseTime(r.Header.Get("Date"))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDateSkew, err)
+	}
+	if diff := date.Sub(time.Unix(int64(created), 0)).Abs(); diff > v.dateSkew {
+		return fmt.Errorf("%w: %s apart", ErrDateSkew, diff)
+	}
+	return nil
+}
+
+// checkCoverage rejects signatures missing a required component or covering a disallowed one
+func (v *SignatureValidator) checkCoverage(input signatureInput) error {
+	for _, field := range v.required {
+		if !input.covers(field) {
+			return fmt.Errorf("%w: %s", ErrMissingComponent, field)
+		}
+	}
+	for _, field := range v.disallowed {
+		if input.covers(field) {
+			return fmt.Errorf("%w: %s", ErrDisallowedComponent, field)
+		}
+	}
+	return nil
+}
+
+// checkTag applies the tag enforcement mode to a verified signature
+func (v *SignatureValidator) checkTag(sig httpsig.VerifiedSignature) error {
+	if v.tag == TagOff {
+		return nil
+	}
+	tag, err := sig.Tag()
+	if err != nil {
+		if v.tag == TagLenient {
+			return nil
+		}
+		return fmt.Errorf("signature has no tag, want %q", webBotAuthTag)
+	}
+	if tag != webBotAuthTag {
+		return fmt.Errorf("signature tag is %q, want %q", tag, webBotAuthTag)
+	}
+	return nil
+}
+
+// withLowercaseParams returns a copy of r whose Signature-Input has lowercase parameter keys, if it had any others.
+// The signature base is built from the lowercase keys, the only form structured fields can serialize.
+func withLowercaseParams(r *http.Request) (*http.Request, bool) {
+	values := r.Header.Values("Signature-Input")
+	fixed := make([]string, len(values))
+	changed := false
+	for i, value := range values {
+		var ok bool
+		fixed[i], ok = lowercaseParams(value)
+		changed = changed || ok
+	}
+	if !changed {
+		return r, false
+	}
+	candidate := *r
+	candidate.Header = r.Header.Clone()
+	candidate.Header["Signature-Input"] = fixed
+	return &candidate, true
+}
+
+// isVerificationFailure reports whether err comes from a signature whose base did not verify
+func isVerificationFailure(err error) bool {
+	var se *httpsig.SignatureError
+	return errors.As(err, &se) && se.Code == httpsig.ErrSigVerification
+}
+
+// authorityVariants returns the lenient forms of host other than host itself:
+// lowercased, without the default port of the scheme, and with it
+func authorityVariants(host string, tls bool) []string {
+	defaultPort := "80"
+	if tls {
+		defaultPort = "443"
+	}
+
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		name, port = host, ""
+	}
+	name = strings.ToLower(name)
+
+	var variants []string
+	if port == "" || port == defaultPort {
+		variants = append(variants, hostPort(name, ""), hostPort(name, defaultPort))
+	} else {
+		variants = append(variants, hostPort(name, port))
+	}
+	return slices.DeleteFunc(variants, func(v string) bool { return v == host })
+}
+
+// hostPort joins name and an optional port, bracketing IPv6 literals
+func hostPort(name, port string) string {
+	if port == "" {
+		if strings.Contains(name, ":") {
+			return "[" + name + "]"
+		}
+		return name
+	}
+	return net.JoinHostPort(name, port)
+}
+
+// Validate verifies the signature of r, within an httpsig.validate span recording the outcome
+func (v *SignatureValidator) Validate(r *http.Request) (ValidationResult, error) {
+	// The span is not put in the context of r, whose body validate buffers for the next handlers
+	_, span := tracer(r.Context()).Start(r.Context(), "httpsig.validate")
+	defer span.End()
+	result, err := v.validate(r)
+	endValidation(span, r, result, err)
+	return result, err
+}
+
+// normalize returns r with the origin forwarded by trusted proxies and, in lenient parameter case mode, with
+// lowercase Signature-Input parameter keys, along with warnings about the fixes that were needed
+func (v *SignatureValidator) normalize(r *http.Request) (*http.Request, []string) {
+	r = withForwardedOrigin(r, v.trusted)
+	var warnings []string
+	if v.paramCase == ParameterCaseLenient {
+		if fixed, ok := withLowercaseParams(r); ok {
+			r = fixed
+			warnings = append(warnings, "Signature-Input parameter keys are not lowercase")
+		}
+	}
+	return r, warnings
+}
+
+func (v *SignatureValidator) validate(r *http.Request) (ValidationResult, error) {
+	if expectsContinue(r) && r.Header.Get("Content-Digest") != "" {
+		if err := v.precheck(r); err != nil {
+			return ValidationResult{}, err
+		}
+	}
+	if err := bufferBody(r, v.maxBody); err != nil {
+		return ValidationResult{}, err
+	}
+	r, warnings := v.normalize(r)
+	r, err := withTaggedSignatures(r, v.tag)
+	if err != nil {
+		return ValidationResult{}, err
+	}
+
+	// The verifier reports an error when any signature fails, even if another verified. A request may carry several
+	// signatures, such as one added by a CDN besides that of the bot, so it is accepted when any verified signature
+	// passes every check, trying them by label so repeated requests report the same one.
+	result, verr := v.verify(r)
+	var rejected error
+	for _, label := range slices.Sorted(maps.Keys(result.Signatures)) {
+		// Signatures failing the profile are reported both as verified and invalid
+		if _, invalid := result.InvalidSignatures[label]; invalid {
+			continue
+		}
+		verified, err := v.checkSignature(r, result.Signatures[label], warnings)
+		if err == nil {
+			return verified, nil
+		}
+		if rejected == nil {
+			rejected = err
+		}
+	}
+	switch {
+	case rejected != nil:
+		return ValidationResult{}, rejected
+	case verr != nil:
+		return ValidationResult{}, classifyVerifyError(r, verr)
+	case len(result.InvalidSignatures) > 0:
+		return ValidationResult{}, classifyInvalidSignatures(r, result)
+	}
+	return ValidationResult{}, ErrNoSignature
+}
+
+// checkSignature applies the checks of the profile the verifier does not enforce to sig, a signature of r that
+// verified, and returns the result of accepting it
+func (v *SignatureValidator) checkSignature(r *http.Request, sig httpsig.VerifiedSignature, warnings []string) (ValidationResult, error) {
+	ks, err := sig.KeySpec.KeySpec()
+	if err != nil {
+		return ValidationResult{}, fmt.Errorf("reading verified key: %w", err)
+	}
+
+	if err := v.checkRevokedKey(ks.KeyID); err != nil {
+		return ValidationResult{}, err
+	}
+
+	// The verifier does not enforce the profile algorithms, so keys with disallowed algorithms are rejected here
+	if !slices.Contains(v.allowed, ks.Algo) {
+		return ValidationResult{}, fmt.Errorf("signature algorithm %q is not allowed", ks.Algo)
+	}
+
+	key, _ := v.fetcher.key(ks.KeyID)
+	if err := v.checkValidity(key); err != nil {
+		return ValidationResult{}, err
+	}
+
+	// Nor does it enforce created and expires
+	if err := v.checkTimes(sig); err != nil {
+		return ValidationResult{}, err
+	}
+
+	if err := v.checkTag(sig); err != nil {
+		return ValidationResult{}, err
+	}
+
+	inputs, err := parseSignatureInputs(r.Header)
+	if err != nil {
+		return ValidationResult{}, err
+	}
+	input := inputs[sig.Label]
+	// Neither does it enforce the required fields
+	if err := v.checkCoverage(input); err != nil {
+		return ValidationResult{}, err
+	}
+	if err := checkHostMatch(r, input); err != nil {
+		return ValidationResult{}, err
+	}
+	if err := v.checkDate(r, input, sig); err != nil {
+		return ValidationResult{}, err
+	}
+
+	// Signatures without a nonce are accepted unless required, web-bot-auth makes it optional
+	nonce, err := sig.Nonce()
+	if err != nil && v.needNonce {
+		return ValidationResult{}, ErrMissingNonce
+	}
+	if v.nonces != nil && err == nil {
+		// The nonce is remembered for as long as checkTimes accepts the signature, expires grace included, or up to
+		// the maximum age of signatures without expires
+		var expires time.Time
+		if exp, err := sig.Expires(); err == nil {
+			expires = time.Unix(int64(exp), 0).Add(v.expiresGrace)
+		} else if created, err := sig.Created(); err == nil {
+			expires = time.Unix(int64(created), 0).Add(v.maxAge)
+		}
+		fresh, err := v.nonces.use(r.Context(), ks.KeyID, nonce, expires)
+		if err != nil {
+			return ValidationResult{}, fmt.Errorf("checking nonce: %w", err)
+		}
+		if !fresh {
+			return ValidationResult{}, ErrReplayedNonce
+		}
+	}
+
+	verified := ValidationResult{KeyID: ks.KeyID, Thumbprint: key.Thumbprint, Algorithm: ks.Algo, Label: sig.Label, Purpose: key.Purpose, Directory: key.Directory, Components: input.Components, Warnings: warnings}
+	if created, err := sig.Created(); err == nil {
+		verified.Created = time.Unix(int64(created), 0)
+	}
+	if expires, err := sig.Expires(); err == nil {
+		verified.Expires = time.Unix(int64(expires), 0)
+	}
+	verified.Nonce, _ = sig.Nonce()
+	verified.Tag, _ = sig.Tag()
+	return verified, nil
+}
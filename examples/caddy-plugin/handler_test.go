@@ -0,0 +1,840 @@
+package httpsig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/cloudflareresearch/web-bot-auth/go/webbotauth"
+	"github.com/remitly-oss/httpsig-go"
+)
+
+func TestAllowedAlgorithms(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecJWK, ecKeyID := publicJWK(t, ecKey)
+	directory := []json.RawMessage{ed25519JWK(testPrivateKey), ecJWK}
+
+	tests := []struct {
+		name        string
+		opts        ValidatorOptions
+		wantKeys    int
+		wantEd25519 bool
+		wantECDSA   bool
+	}{
+		{
+			name:        "default allows only ed25519",
+			opts:        ValidatorOptions{},
+			wantKeys:    2,
+			wantEd25519: true,
+		},
+		{
+			name:        "ecdsa allowed",
+			opts:        ValidatorOptions{AllowedAlgorithms: []httpsig.Algorithm{httpsig.Algo_ED25519, httpsig.Algo_ECDSA_P256_SHA256}},
+			wantKeys:    2,
+			wantEd25519: true,
+			wantECDSA:   true,
+		},
+		{
+			name:      "ecdsa only",
+			opts:      ValidatorOptions{AllowedAlgorithms: []httpsig.Algorithm{httpsig.Algo_ECDSA_P256_SHA256}},
+			wantKeys:  2,
+			wantECDSA: true,
+		},
+		{
+			name:        "disallowed keys dropped",
+			opts:        ValidatorOptions{DropDisallowedKeys: true},
+			wantKeys:    1,
+			wantEd25519: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := NewValidator(directory, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(v.keys) != tt.wantKeys {
+				t.Errorf("loaded %d keys, want %d", len(v.keys), tt.wantKeys)
+			}
+
+			_, err = v.Validate(newSignedRequest(t))
+			if got := err == nil; got != tt.wantEd25519 {
+				t.Errorf("ed25519 signature accepted = %v, want %v (err: %v)", got, tt.wantEd25519, err)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+			signRequestWith(t, r, httpsig.Algo_ECDSA_P256_SHA256, ecKey, ecKeyID)
+			result, err := v.Validate(r)
+			if got := err == nil; got != tt.wantECDSA {
+				t.Errorf("ecdsa signature accepted = %v, want %v (err: %v)", got, tt.wantECDSA, err)
+			}
+			if err == nil && result.KeyID != ecKeyID {
+				t.Errorf("keyid = %q, want %q", result.KeyID, ecKeyID)
+			}
+		})
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	if _, err := ParseAlgorithm("ecdsa-p384-sha384"); err != nil {
+		t.Errorf("ParseAlgorithm(ecdsa-p384-sha384) = %v", err)
+	}
+	if _, err := ParseAlgorithm("hmac-sha256"); err == nil {
+		t.Error("ParseAlgorithm(hmac-sha256) accepted a symmetric algorithm")
+	}
+}
+
+func TestDisallowedFields(t *testing.T) {
+	v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{DisallowedFields: []string{"@query", "Cookie"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		fields  []string
+		wantErr bool
+	}{
+		{name: "authority only", fields: []string{"@authority"}},
+		{name: "authority and path", fields: []string{"@authority", "@path"}},
+		{name: "covers @query", fields: []string{"@authority", "@query"}, wantErr: true},
+		{name: "covers cookie", fields: []string{"@authority", "cookie"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/search?q=bots", nil)
+			r.Header.Set("Cookie", "session=1")
+			signRequestWith(t, r, httpsig.Algo_ED25519, testPrivateKey, testKeyID, tt.fields...)
+
+			_, err := v.Validate(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrDisallowedComponent) {
+				t.Errorf("error = %v, want ErrDisallowedComponent", err)
+			}
+		})
+	}
+}
+
+func TestAuthorityNormalization(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		signed      string
+		live        string
+		wantStrict  bool
+		wantLenient bool
+	}{
+		{name: "exact", target: "https://example.com/", signed: "example.com", live: "example.com", wantStrict: true, wantLenient: true},
+		{name: "live has default port", target: "https://example.com/", signed: "example.com", live: "example.com:443", wantLenient: true},
+		{name: "signed default port", target: "https://example.com/", signed: "example.com:443", live: "example.com", wantLenient: true},
+		// RFC 9421 derives @authority lowercased, so case never matters
+		{name: "live uppercase", target: "https://example.com/", signed: "example.com", live: "EXAMPLE.com", wantStrict: true, wantLenient: true},
+		{name: "live uppercase with port", target: "https://example.com/", signed: "example.com", live: "Example.COM:443", wantLenient: true},
+		{name: "plain http default port", target: "http://example.com/", signed: "example.com:80", live: "example.com", wantLenient: true},
+		{name: "https port on plain http", target: "http://example.com/", signed: "example.com:443", live: "example.com"},
+		{name: "non-default port", target: "https://example.com/", signed: "example.com", live: "example.com:8443"},
+		{name: "different host", target: "https://example.com/", signed: "example.com", live: "example.org"},
+		{name: "ipv6", target: "https://[::1]/", signed: "[::1]", live: "[::1]:443", wantLenient: true},
+	}
+	for _, tt := range tests {
+		for _, mode := range []AuthorityNormalization{AuthorityStrict, AuthorityLenient} {
+			t.Run(tt.name+"/"+string(mode), func(t *testing.T) {
+				v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{AuthorityNormalization: mode})
+				if err != nil {
+					t.Fatal(err)
+				}
+				r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+				r.Host = tt.signed
+				signRequest(t, r, testPrivateKey, testKeyID)
+				r.Host = tt.live
+
+				want := tt.wantStrict
+				if mode == AuthorityLenient {
+					want = tt.wantLenient
+				}
+				_, err = v.Validate(r)
+				if got := err == nil; got != want {
+					t.Errorf("accepted = %v, want %v (err: %v)", got, want, err)
+				}
+			})
+		}
+	}
+}
+
+func TestParseAuthorityNormalization(t *testing.T) {
+	if _, err := ParseAuthorityNormalization("lenient"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseAuthorityNormalization("loose"); err == nil {
+		t.Error("accepted an unknown mode")
+	}
+}
+
+func TestValidationResultComponents(t *testing.T) {
+	v := newTestValidator(t)
+	r := httptest.NewRequest(http.MethodPost, "https://example.com/upload?draft=1", nil)
+	r.Header.Set("Content-Type", "application/json")
+	signRequestWith(t, r, httpsig.Algo_ED25519, testPrivateKey, testKeyID, "@method", "@authority", "@path", "content-type")
+
+	result, err := v.Validate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"@method", "@authority", "@path", "content-type"}
+	if !slices.Equal(result.Components, want) {
+		t.Errorf("Components = %v, want %v", result.Components, want)
+	}
+}
+
+func TestEmptyPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		signURL    string
+		requestURL string
+		wantErr    bool
+	}{
+		{name: "signed as slash, bare origin", signURL: "https://example.com/", requestURL: "https://example.com"},
+		{name: "signed as slash, root path", signURL: "https://example.com/", requestURL: "https://example.com/"},
+		{name: "signed empty", signURL: "https://example.com", requestURL: "https://example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signed, err := http.NewRequest(http.MethodGet, tt.signURL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signRequestWith(t, signed, httpsig.Algo_ED25519, testPrivateKey, testKeyID, "@authority", "@path")
+
+			r, err := http.NewRequest(http.MethodGet, tt.requestURL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header = signed.Header
+			if _, err := newTestValidator(t).Validate(r); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if r.URL.Path != "" && tt.requestURL == "https://example.com" {
+				t.Errorf("request path modified to %q", r.URL.Path)
+			}
+		})
+	}
+}
+
+// signRequestTagged signs r over @authority with the test key, with tag omitted when empty
+func signRequestTagged(t *testing.T, r *http.Request, tag string) {
+	t.Helper()
+	metadata := []httpsig.Metadata{httpsig.MetaCreated, httpsig.MetaExpires, httpsig.MetaKeyID}
+	if tag != "" {
+		metadata = append(metadata, httpsig.MetaTag)
+	}
+	err := httpsig.Sign(r, httpsig.SigningProfile{
+		Algorithm: httpsig.Algo_ED25519,
+		Fields:    httpsig.Fields("@authority"),
+		Metadata:  metadata,
+	}, httpsig.SigningKey{Key: testPrivateKey, MetaKeyID: testKeyID, MetaTag: tag})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTagEnforcement(t *testing.T) {
+	tests := []struct {
+		mode        TagEnforcement
+		wantTagged  bool
+		wantMissing bool
+		wantWrong   bool
+	}{
+		{mode: "", wantTagged: true},
+		{mode: TagStrict, wantTagged: true},
+		{mode: TagLenient, wantTagged: true, wantMissing: true},
+		{mode: TagOff, wantTagged: true, wantMissing: true, wantWrong: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{TagEnforcement: tt.mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for tag, want := range map[string]bool{
+				"web-bot-auth": tt.wantTagged,
+				"":             tt.wantMissing,
+				"other-bot":    tt.wantWrong,
+			} {
+				r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+				signRequestTagged(t, r, tag)
+				if _, err := v.Validate(r); (err == nil) != want {
+					t.Errorf("tag %q: err = %v, want accepted %v", tag, err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseTagEnforcement(t *testing.T) {
+	if _, err := ParseTagEnforcement("permissive"); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
+
+func TestEscapedKeyID(t *testing.T) {
+	// Structured field strings escape quotes and backslashes, which must be undone before looking up the key
+	const keyid = `bot "alpha"\beta`
+	v, err := newValidator([]keySpec{{KeySpec: httpsig.KeySpec{
+		KeyID:  keyid,
+		Algo:   httpsig.Algo_ED25519,
+		PubKey: testPrivateKey.Public(),
+	}}}, ValidatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	signRequest(t, r, testPrivateKey, keyid)
+	if want := `keyid="bot \"alpha\"\\beta"`; !strings.Contains(r.Header.Get("Signature-Input"), want) {
+		t.Fatalf("Signature-Input = %s, want it to contain %s", r.Header.Get("Signature-Input"), want)
+	}
+
+	result, err := v.Validate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.KeyID != keyid {
+		t.Errorf("KeyID = %q, want %q", result.KeyID, keyid)
+	}
+}
+
+func TestParameterCase(t *testing.T) {
+	tests := []struct {
+		mode    ParameterCase
+		wantErr bool
+	}{
+		{mode: "", wantErr: true},
+		{mode: ParameterCaseStrict, wantErr: true},
+		{mode: ParameterCaseLenient, wantErr: false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{ParameterCase: tt.mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			compliant := newSignedRequest(t)
+			if result, err := v.Validate(compliant); err != nil || len(result.Warnings) > 0 {
+				t.Fatalf("lowercase parameters: warnings %v, err %v", result.Warnings, err)
+			}
+
+			r := newSignedRequest(t)
+			input := r.Header.Get("Signature-Input")
+			for _, key := range []string{"created", "keyid", "tag"} {
+				input = strings.Replace(input, ";"+key+"=", ";"+strings.ToUpper(key[:1])+key[1:]+"=", 1)
+			}
+			input = strings.Replace(input, ";Keyid=", ";KeyID=", 1)
+			r.Header.Set("Signature-Input", input)
+
+			result, err := v.Validate(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Signature-Input %s: err = %v, wantErr %v", input, err, tt.wantErr)
+			}
+			if err == nil && len(result.Warnings) != 1 {
+				t.Errorf("Warnings = %v, want the non-compliance reported", result.Warnings)
+			}
+			if got := r.Header.Get("Signature-Input"); got != input {
+				t.Errorf("request header modified to %s", got)
+			}
+		})
+	}
+}
+
+func TestLowercaseParams(t *testing.T) {
+	tests := []struct {
+		in, want    string
+		wantChanged bool
+	}{
+		{`sig1=("@authority");created=1;keyid="k"`, `sig1=("@authority");created=1;keyid="k"`, false},
+		{`sig1=("@authority");Created=1;KeyID="k";TAG="web-bot-auth"`, `sig1=("@authority");created=1;keyid="k";tag="web-bot-auth"`, true},
+		// Quoted strings and unknown keys are left alone
+		{`sig1=("@authority");keyid="a;Created=\";Created=1";Foo=1`, `sig1=("@authority");keyid="a;Created=\";Created=1";Foo=1`, false},
+		{`sig1=("@authority";Key="x");Nonce="n"`, `sig1=("@authority";Key="x");nonce="n"`, true},
+	}
+	for _, tt := range tests {
+		got, changed := lowercaseParams(tt.in)
+		if got != tt.want || changed != tt.wantChanged {
+			t.Errorf("lowercaseParams(%s) = %s, %v, want %s, %v", tt.in, got, changed, tt.want, tt.wantChanged)
+		}
+	}
+}
+
+func TestPercentEncodedPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		signedPath string // @path the bot signed
+		target     string // request target as sent on the wire
+		wantErr    bool
+	}{
+		{name: "encoded space", signedPath: "/a%20b", target: "/a%20b"},
+		{name: "encoded slash", signedPath: "/files/a%2Fb", target: "/files/a%2Fb"},
+		{name: "non-default encoding", signedPath: "/%7Euser", target: "/%7Euser"},
+		{name: "decoded signature", signedPath: "/a b", target: "/a%20b", wantErr: true},
+		{name: "different encoding", signedPath: "/~user", target: "/%7Euser", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The signing library derives @path from URL.Path, so the signed form is set there verbatim
+			signed := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+			signed.URL.Path = tt.signedPath
+			signRequestWith(t, signed, httpsig.Algo_ED25519, testPrivateKey, testKeyID, "@authority", "@path")
+
+			r := httptest.NewRequest(http.MethodGet, "https://example.com"+tt.target, nil)
+			r.Header = signed.Header
+			if _, err := newTestValidator(t).Validate(r); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTargetURI(t *testing.T) {
+	for _, target := range []string{"/", "/a%20b?q=1&r=a%2Fb", "/search?"} {
+		signed := httptest.NewRequest(http.MethodGet, "https://example.com"+target, nil)
+		signRequestWith(t, signed, httpsig.Algo_ED25519, testPrivateKey, testKeyID, "@authority", "@target-uri")
+
+		// Servers receive the request target in origin form
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.Host = "example.com"
+		r.TLS = &tls.ConnectionState{}
+		r.Header = signed.Header
+		if _, err := newTestValidator(t).Validate(r); err != nil {
+			t.Errorf("%s: %v", target, err)
+		}
+		r.TLS = nil
+		if _, err := newTestValidator(t).Validate(r); err == nil {
+			t.Errorf("%s: signature over https accepted over http", target)
+		}
+	}
+}
+
+func TestDerivedComponents(t *testing.T) {
+	signer, err := webbotauth.NewSigner(testPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, component := range []string{"@scheme", "@request-target", "@target-uri", "@path", "@query", webbotauth.QueryParam("q")} {
+		signer.Components = []string{component}
+		signed := httptest.NewRequest(http.MethodGet, "https://example.com/a/b?q=hello%20world", nil)
+		if err := signer.Sign(signed); err != nil {
+			t.Fatal(err)
+		}
+
+		// Servers receive the request target in origin form
+		r := httptest.NewRequest(http.MethodGet, "/a/b?q=hello%20world", nil)
+		r.Host = "example.com"
+		r.TLS = &tls.ConnectionState{}
+		r.Header = signed.Header
+		if _, err := newTestValidator(t).Validate(r); err != nil {
+			t.Errorf("%s: %v", component, err)
+		}
+
+		// Over plain http for @scheme, for another path and query otherwise, the component differs
+		altered := httptest.NewRequest(http.MethodGet, "/a/c?q=hello", nil)
+		altered.Host, altered.TLS = "example.com", &tls.ConnectionState{}
+		if component == "@scheme" {
+			altered.URL, altered.TLS = r.URL, nil
+		}
+		altered.Header = signed.Header
+		if _, err := newTestValidator(t).Validate(altered); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s of another request: err = %v, want ErrInvalidSignature", component, err)
+		}
+	}
+}
+
+// signPSS signs r over @authority with RSA-PSS SHA-512 using saltLength, building the signature base by hand
+func signPSS(t *testing.T, r *http.Request, key *rsa.PrivateKey, keyid string, saltLength int) {
+	t.Helper()
+	params := fmt.Sprintf(`("@authority");created=%d;expires=%d;keyid="%s";tag="web-bot-auth"`,
+		time.Now().Unix(), time.Now().Add(time.Minute).Unix(), keyid)
+	base := fmt.Sprintf("\"@authority\": %s\n\"@signature-params\": %s", r.Host, params)
+	digest := sha512.Sum512([]byte(base))
+	sig, err := rsa.SignPSS(rand.Reader, key, crypto.SHA512, digest[:], &rsa.PSSOptions{SaltLength: saltLength})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Signature-Input", "sig1="+params)
+	r.Header.Set("Signature", "sig1=:"+base64.StdEncoding.EncodeToString(sig)+":")
+}
+
+func TestRSAPSS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaJWK, rsaKeyID := publicJWK(t, rsaKey)
+	m := &Middleware{
+		StaticKeys:        []json.RawMessage{rsaJWK},
+		AllowedAlgorithms: []string{string(httpsig.Algo_RSA_PSS_SHA512)},
+	}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(r *http.Request) int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		if err := m.ServeHTTP(w, r, okHandler{}); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code
+	}
+
+	// The salt is random, so signing the same request twice gives different signatures that both verify
+	var signatures []string
+	for range 2 {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		signRequestWith(t, r, httpsig.Algo_RSA_PSS_SHA512, rsaKey, rsaKeyID)
+		signatures = append(signatures, r.Header.Get("Signature"))
+		if code := serve(r); code != http.StatusOK {
+			t.Errorf("RSA-PSS signature: status = %d, want %d", code, http.StatusOK)
+		}
+	}
+	if signatures[0] == signatures[1] {
+		t.Error("RSA-PSS signatures are deterministic")
+	}
+
+	// RFC 9421 Section 3.3.1 fixes the salt length at 64 bytes, the SHA-512 digest size
+	tests := []struct {
+		name       string
+		saltLength int
+		want       int
+	}{
+		{name: "salt length equals hash", saltLength: rsa.PSSSaltLengthEqualsHash, want: http.StatusOK},
+		{name: "64 byte salt", saltLength: 64, want: http.StatusOK},
+		{name: "maximum salt", saltLength: rsa.PSSSaltLengthAuto, want: http.StatusUnauthorized},
+		{name: "32 byte salt", saltLength: 32, want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+			signPSS(t, r, rsaKey, rsaKeyID, tt.saltLength)
+			if code := serve(r); code != tt.want {
+				t.Errorf("status = %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
+
+// signLabeled signs r with the test key under label. httpsig-go always signs as sig1, so the label is
+// rewritten afterwards. It is not part of the signature base.
+func signLabeled(t *testing.T, r *http.Request, label string) {
+	t.Helper()
+	signRequest(t, r, testPrivateKey, testKeyID)
+	for _, name := range []string{"Signature-Input", "Signature"} {
+		r.Header.Set(name, label+strings.TrimPrefix(r.Header.Get(name), "sig1"))
+	}
+}
+
+func TestSignatureLabel(t *testing.T) {
+	v := newTestValidator(t)
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	signLabeled(t, r, "bot-sig")
+	result, err := v.Validate(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Label != "bot-sig" {
+		t.Errorf("label = %q, want %q", result.Label, "bot-sig")
+	}
+
+	// With several verifying signatures, the first label is reported every time
+	proxy := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	signLabeled(t, proxy, "reverse-proxy-sig")
+	bot := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	signLabeled(t, bot, "bot-sig")
+	for range 10 {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		for _, name := range []string{"Signature-Input", "Signature"} {
+			r.Header.Set(name, proxy.Header.Get(name)+", "+bot.Header.Get(name))
+		}
+		result, err := v.Validate(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Label != "bot-sig" {
+			t.Fatalf("label = %q, want %q", result.Label, "bot-sig")
+		}
+	}
+}
+
+func TestSignatureExpiry(t *testing.T) {
+	now := time.Now()
+	v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{Now: func() time.Time { return now }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newSignedRequest(t)
+	if _, err := v.Validate(r); err != nil {
+		t.Fatal(err)
+	}
+	// Test signatures expire after five minutes
+	now = now.Add(5*time.Minute + time.Second)
+	if _, err := v.Validate(r); !errors.Is(err, ErrSignatureExpired) {
+		t.Errorf("err = %v, want %v", err, ErrSignatureExpired)
+	}
+}
+
+func TestExpiresGrace(t *testing.T) {
+	now := time.Now()
+	v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{
+		ExpiresGrace: 30 * time.Second,
+		Now:          func() time.Time { return now },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newSignedRequest(t)
+	// Within the grace after expiry
+	now = now.Add(5*time.Minute + 10*time.Second)
+	if _, err := v.Validate(r); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(30 * time.Second)
+	if _, err := v.Validate(r); !errors.Is(err, ErrSignatureExpired) {
+		t.Errorf("err = %v, want %v", err, ErrSignatureExpired)
+	}
+}
+
+func TestRequireExpires(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	if err := httpsig.Sign(r, httpsig.SigningProfile{
+		Algorithm: httpsig.Algo_ED25519,
+		Fields:    httpsig.Fields("@authority"),
+		Metadata:  []httpsig.Metadata{httpsig.MetaCreated, httpsig.MetaKeyID, httpsig.MetaTag},
+	}, httpsig.SigningKey{Key: testPrivateKey, MetaKeyID: testKeyID, MetaTag: "web-bot-auth"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTestValidator(t).Validate(r); err != nil {
+		t.Fatalf("signature without expires: %v", err)
+	}
+	v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{RequireExpires: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Validate(r); !errors.Is(err, ErrMissingExpires) {
+		t.Errorf("err = %v, want %v", err, ErrMissingExpires)
+	}
+	if _, err := v.Validate(newSignedRequest(t)); err != nil {
+		t.Errorf("signature with expires: %v", err)
+	}
+}
+
+func TestMaxSignatureAge(t *testing.T) {
+	now := time.Now()
+	v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{
+		MaxSignatureAge: time.Minute,
+		Now:             func() time.Time { return now },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newSignedRequest(t)
+	if _, err := v.Validate(r); err != nil {
+		t.Fatal(err)
+	}
+	// The signature has not expired yet, but is older than the maximum age
+	now = now.Add(2 * time.Minute)
+	if _, err := v.Validate(r); !errors.Is(err, ErrSignatureTooOld) {
+		t.Errorf("err = %v, want %v", err, ErrSignatureTooOld)
+	}
+}
+
+func TestDefaultMaxSignatureAge(t *testing.T) {
+	now := time.Now()
+	v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{Now: func() time.Time { return now }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(metadata ...httpsig.Metadata) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		if err := httpsig.Sign(r, httpsig.SigningProfile{
+			Algorithm: httpsig.Algo_ED25519,
+			Fields:    httpsig.Fields("@authority"),
+			Metadata:  append(metadata, httpsig.MetaKeyID, httpsig.MetaTag),
+		}, httpsig.SigningKey{Key: testPrivateKey, MetaKeyID: testKeyID, MetaTag: "web-bot-auth"}); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	// Without expires, a signature lives for the default maximum age
+	r := sign(httpsig.MetaCreated)
+	now = now.Add(DefaultMaxSignatureAge - time.Minute)
+	if _, err := v.Validate(r); err != nil {
+		t.Fatalf("signature within the default maximum age: %v", err)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := v.Validate(r); !errors.Is(err, ErrSignatureTooOld) {
+		t.Errorf("signature older than the default maximum age: err = %v, want %v", err, ErrSignatureTooOld)
+	}
+
+	// Without created either, nothing bounds it
+	if _, err := v.Validate(sign()); !errors.Is(err, ErrMissingExpires) {
+		t.Errorf("signature without created and expires: err = %v, want %v", err, ErrMissingExpires)
+	}
+}
+
+func TestDateSkew(t *testing.T) {
+	v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{DateSkew: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		date    time.Time
+		fields  []string
+		wantErr bool
+	}{
+		{name: "covered and close", date: time.Now(), fields: []string{"@authority", "date"}},
+		{name: "covered and skewed", date: time.Now().Add(-5 * time.Minute), fields: []string{"@authority", "date"}, wantErr: true},
+		{name: "not covered", date: time.Now().Add(-5 * time.Minute), fields: []string{"@authority"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+			r.Header.Set("Date", tt.date.UTC().Format(http.TimeFormat))
+			signRequestWith(t, r, httpsig.Algo_ED25519, testPrivateKey, testKeyID, tt.fields...)
+			_, err := v.Validate(r)
+			if tt.wantErr {
+				if !errors.Is(err, ErrDateSkew) {
+					t.Errorf("err = %v, want %v", err, ErrDateSkew)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestUnmarshalVerifyProfile(t *testing.T) {
+	var m Middleware
+	d := caddyfile.NewTestDispenser(`httpsig {
+		max_signature_age 10m
+		date_skew 30s
+		expires_grace 15s
+		require_expires
+		required_fields @authority @path
+	}`)
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(m.MaxSignatureAge) != 10*time.Minute || time.Duration(m.DateSkew) != 30*time.Second {
+		t.Errorf("max_signature_age = %v, date_skew = %v", m.MaxSignatureAge, m.DateSkew)
+	}
+	if time.Duration(m.ExpiresGrace) != 15*time.Second || !m.RequireExpires {
+		t.Errorf("expires_grace = %v, require_expires = %v", m.ExpiresGrace, m.RequireExpires)
+	}
+	if want := []string{"@authority", "@path"}; !slices.Equal(m.RequiredFields, want) {
+		t.Errorf("required_fields = %v, want %v", m.RequiredFields, want)
+	}
+	if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\n\tdate_skew soon\n}")); err == nil {
+		t.Error("invalid date_skew accepted")
+	}
+}
+
+func TestUnrelatedSignaturesIgnored(t *testing.T) {
+	proxyKey, _, proxyKeyID := generateKey(t)
+	// The proxy key is not trusted, so its signature does not verify
+	proxy := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	if err := httpsig.Sign(proxy, httpsig.SigningProfile{
+		Algorithm: httpsig.Algo_ED25519,
+		Fields:    httpsig.Fields("@authority"),
+		Metadata:  []httpsig.Metadata{httpsig.MetaCreated, httpsig.MetaExpires, httpsig.MetaKeyID, httpsig.MetaTag},
+	}, httpsig.SigningKey{Key: proxyKey, MetaKeyID: proxyKeyID, MetaTag: "proxy"}); err != nil {
+		t.Fatal(err)
+	}
+	untagged := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	if err := httpsig.Sign(untagged, httpsig.SigningProfile{
+		Algorithm: httpsig.Algo_ED25519,
+		Fields:    httpsig.Fields("@authority"),
+		Metadata:  []httpsig.Metadata{httpsig.MetaCreated, httpsig.MetaExpires, httpsig.MetaKeyID},
+	}, httpsig.SigningKey{Key: proxyKey, MetaKeyID: proxyKeyID}); err != nil {
+		t.Fatal(err)
+	}
+	bot := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	signLabeled(t, bot, "bot-sig")
+	// Signed for another host, so the bot signature does not verify either
+	forged := httptest.NewRequest(http.MethodGet, "https://other.example/", nil)
+	signLabeled(t, forged, "bot-sig")
+
+	combine := func(reqs ...*http.Request) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		for _, name := range []string{"Signature-Input", "Signature"} {
+			var values []string
+			for _, req := range reqs {
+				values = append(values, req.Header.Get(name))
+			}
+			r.Header.Set(name, strings.Join(values, ", "))
+		}
+		return r
+	}
+
+	tests := []struct {
+		name     string
+		tag      TagEnforcement
+		r        *http.Request
+		rejected bool
+		// wantErr is the error of rejected requests, when known
+		wantErr error
+	}{
+		{name: "invalid proxy signature", tag: TagStrict, r: combine(proxy, bot)},
+		{name: "proxy signature only", tag: TagStrict, r: combine(proxy), rejected: true, wantErr: ErrNoWebBotAuthSignature},
+		{name: "untagged signature in strict mode", tag: TagStrict, r: combine(untagged, bot)},
+		{name: "untagged signature in lenient mode", tag: TagLenient, r: combine(untagged, bot)},
+		{name: "invalid proxy signature with tags off", tag: TagOff, r: combine(proxy, bot)},
+		{name: "invalid proxy and bot signatures", tag: TagOff, r: combine(proxy, forged), rejected: true},
+		{name: "invalid bot signature", tag: TagStrict, r: combine(proxy, forged), rejected: true, wantErr: ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{TagEnforcement: tt.tag})
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := v.Validate(tt.r)
+			if tt.rejected {
+				if err == nil {
+					t.Fatal("request without a valid signature verified")
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Label != "bot-sig" {
+				t.Errorf("label = %q, want bot-sig", result.Label)
+			}
+		})
+	}
+}
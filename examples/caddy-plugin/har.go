@@ -0,0 +1,86 @@
+package httpsig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// harFile is the subset of the HAR 1.2 format needed to rebuild requests
+type harFile struct {
+	Log struct {
+		Entries []struct {
+			Request harRequest `json:"request"`
+		} `json:"entries"`
+	} `json:"log"`
+}
+
+type harRequest struct {
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Headers []harHeader `json:"headers"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HAREntryResult is the verification outcome of one request captured in a HAR file
+type HAREntryResult struct {
+	// Index is the position of the entry in the HAR log
+	Index  int
+	Method string
+	URL    string
+	// Signed is false when the request carried no Signature header
+	Signed bool
+	Result ValidationResult
+	// Err is nil when the signature verified
+	Err error
+}
+
+// requestFromHAR rebuilds an *http.Request from its HAR description.
+// HTTP/2 pseudo-headers are dropped, except :authority which sets the request host.
+func requestFromHAR(hr harRequest) (*http.Request, error) {
+	r, err := http.NewRequest(hr.Method, hr.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range hr.Headers {
+		switch {
+		case strings.EqualFold(h.Name, ":authority"):
+			r.Host = h.Value
+		case strings.HasPrefix(h.Name, ":"):
+		case strings.EqualFold(h.Name, "Host"):
+			r.Host = h.Value
+		default:
+			r.Header.Add(h.Name, h.Value)
+		}
+	}
+	return r, nil
+}
+
+// VerifyHAR verifies every request captured in the HAR file read from src
+func VerifyHAR(v *SignatureValidator, src io.Reader) ([]HAREntryResult, error) {
+	var har harFile
+	if err := json.NewDecoder(src).Decode(&har); err != nil {
+		return nil, fmt.Errorf("decoding HAR: %w", err)
+	}
+
+	results := make([]HAREntryResult, 0, len(har.Log.Entries))
+	for i, entry := range har.Log.Entries {
+		res := HAREntryResult{Index: i, Method: entry.Request.Method, URL: entry.Request.URL}
+		r, err := requestFromHAR(entry.Request)
+		if err != nil {
+			res.Err = fmt.Errorf("rebuilding request: %w", err)
+			results = append(results, res)
+			continue
+		}
+		res.Signed = r.Header.Get("Signature") != ""
+		res.Result, res.Err = v.Validate(r)
+		results = append(results, res)
+	}
+	return results, nil
+}
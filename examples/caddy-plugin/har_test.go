@@ -0,0 +1,62 @@
+package httpsig
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestVerifyHAR(t *testing.T) {
+	f, err := os.Open("testdata/signed.har")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// The capture is verified as of when it was signed, since its signatures age out
+	signedAt := time.Unix(1791998178, 0)
+	v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{Now: func() time.Time { return signedAt }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := VerifyHAR(v, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+
+	if signed := results[0]; !signed.Signed || signed.Err != nil || signed.Result.KeyID != testKeyID {
+		t.Errorf("signed entry: %+v", signed)
+	}
+	if unsigned := results[1]; unsigned.Signed || unsigned.Err == nil {
+		t.Errorf("unsigned entry: %+v", unsigned)
+	}
+	// The signature was replayed against another host, so @authority does not match
+	if replayed := results[2]; !replayed.Signed || replayed.Err == nil {
+		t.Errorf("replayed entry: %+v", replayed)
+	}
+}
+
+func TestRequestFromHAR(t *testing.T) {
+	hr := harRequest{
+		Method: "POST",
+		URL:    "https://example.com/api?x=1",
+		Headers: []harHeader{
+			{Name: ":authority", Value: "api.example.com"},
+			{Name: "content-type", Value: "application/json"},
+		},
+	}
+	r, err := requestFromHAR(hr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Method != "POST" || r.Host != "api.example.com" || r.URL.RawQuery != "x=1" || r.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("request = %s %s host=%s headers=%v", r.Method, r.URL, r.Host, r.Header)
+	}
+	if _, ok := r.Header[":authority"]; ok {
+		t.Error("pseudo-header copied into headers")
+	}
+}
@@ -0,0 +1,151 @@
+package httpsig
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/remitly-oss/httpsig-go"
+)
+
+// testKeyID is the RFC 7638 thumbprint of the Ed25519 test key from RFC 9421 Appendix B.1.4
+const testKeyID = "poqkLGiymh_W0uP6PZFw-dvez3QJT5SolqXBCW38r0U"
+
+var testPrivateKey = ed25519.NewKeyFromSeed(mustDecode("n4Ni-HpISpVObnQMW0wOhCKROaIKqKtW_2ZYb2p9KcU"))
+
+func mustDecode(s string) []byte {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// ed25519JWK returns the public JWK for key
+func ed25519JWK(key ed25519.PrivateKey) []byte {
+	x := base64.RawURLEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+	return fmt.Appendf(nil, `{"kty":"OKP","crv":"Ed25519","x":"%s"}`, x)
+}
+
+// newTestValidator returns a validator trusting the RFC 9421 test key
+func newTestValidator(t *testing.T) *SignatureValidator {
+	t.Helper()
+	v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// publicJWK returns the public JWK of key and its thumbprint keyid
+func publicJWK(t *testing.T, key crypto.Signer) (json.RawMessage, string) {
+	t.Helper()
+	pub, err := jwk.Import(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumbprint, err := pub.Thumbprint(crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, base64.RawURLEncoding.EncodeToString(thumbprint)
+}
+
+// signRequest signs r over @authority with the Ed25519 key, advertising keyid
+func signRequest(t *testing.T, r *http.Request, key ed25519.PrivateKey, keyid string) {
+	t.Helper()
+	signRequestWith(t, r, httpsig.Algo_ED25519, key, keyid)
+}
+
+// signRequestWith signs r using algo over fields, which default to @authority
+func signRequestWith(t *testing.T, r *http.Request, algo httpsig.Algorithm, key crypto.PrivateKey, keyid string, fields ...string) {
+	t.Helper()
+	if len(fields) == 0 {
+		fields = []string{"@authority"}
+	}
+	err := httpsig.Sign(r, httpsig.SigningProfile{
+		Algorithm: algo,
+		Fields:    httpsig.Fields(fields...),
+		Metadata:  []httpsig.Metadata{httpsig.MetaCreated, httpsig.MetaExpires, httpsig.MetaKeyID, httpsig.MetaTag},
+	}, httpsig.SigningKey{
+		Key:       key,
+		MetaKeyID: keyid,
+		MetaTag:   "web-bot-auth",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newSignedRequest returns a GET request to example.com signed with the test key
+func newSignedRequest(t *testing.T) *http.Request {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	signRequest(t, r, testPrivateKey, testKeyID)
+	return r
+}
+
+// okHandler is a terminal caddyhttp.Handler that responds with 200
+type okHandler struct{}
+
+func (okHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// fakeResponse is one result returned by fakeFetcher
+type fakeResponse struct {
+	dir         Directory
+	err         error
+	notModified bool
+}
+
+// fakeFetcher returns its responses in order, repeating the last one once exhausted
+type fakeFetcher struct {
+	responses []fakeResponse
+	calls     int
+}
+
+func (f *fakeFetcher) Fetch(ctx context.Context, base string) (Directory, FetchMeta, error) {
+	resp := f.responses[min(f.calls, len(f.responses)-1)]
+	f.calls++
+	meta := FetchMeta{URL: base, FetchedAt: time.Now(), NotModified: resp.notModified}
+	return resp.dir, meta, resp.err
+}
+
+// fakeFetchers routes each directory_base to its own fakeFetcher. Other directories fail to fetch.
+type fakeFetchers map[string]*fakeFetcher
+
+func (f fakeFetchers) Fetch(ctx context.Context, base string) (Directory, FetchMeta, error) {
+	fetcher, ok := f[base]
+	if !ok {
+		return Directory{}, FetchMeta{URL: base}, fmt.Errorf("no directory at %s", base)
+	}
+	return fetcher.Fetch(ctx, base)
+}
+
+// directoryOf returns a directory publishing the given JWKs
+func directoryOf(keys ...json.RawMessage) Directory {
+	return Directory{Keys: keys}
+}
+
+// newTestContext returns a caddy.Context suitable for Provision
+func newTestContext(t *testing.T) caddy.Context {
+	t.Helper()
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	t.Cleanup(cancel)
+	return ctx
+}
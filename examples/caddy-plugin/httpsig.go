@@ -1,18 +1,33 @@
 package httpsig
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/dustin/go-humanize"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 func init() {
-	caddy.RegisterModule(Middleware{})
+	caddy.RegisterModule(new(Middleware))
 	httpcaddyfile.RegisterHandlerDirective("httpsig", func(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
 		var m Middleware
 		err := m.UnmarshalCaddyfile(h.Dispenser)
@@ -21,19 +36,254 @@ func init() {
 	)
 }
 
-type Directory struct {
-	Keys    []json.RawMessage `json:"keys"`
-	Purpose *string           `json:"purpose,omitempty"`
-}
-
 // Middleware struct to hold the configuration for the handler
 type Middleware struct {
-	DirectoryBase string `json:"directory_base"`
-	validator     *SignatureValidator
+	DirectoryBase string `json:"directory_base,omitempty"`
+	// DirectoryBases are further directories whose keys are trusted alongside those of DirectoryBase.
+	// Keys are merged by keyid, a later directory winning a collision.
+	DirectoryBases []string `json:"directory_bases,omitempty"`
+	// StaticKeys are public JWKs trusted in addition to the directory keys, such as an internal monitoring bot.
+	// They take precedence over directory keys with the same keyid. Either DirectoryBase or StaticKeys is required.
+	StaticKeys []json.RawMessage `json:"static_keys,omitempty"`
+	// Keys are public keys configured inline with an optional explicit keyid, trusted like StaticKeys.
+	// They allow verifying bots entirely offline.
+	Keys []StaticKey `json:"keys,omitempty"`
+	// StaticKeysEnv names environment variables holding static keys, as a JWK, a JSON array of JWKs, a JWK Set or PEM
+	StaticKeysEnv []string `json:"static_keys_env,omitempty"`
+	// StaticKeysDir is a directory of .json, .jwk and .pem files holding static keys, such as a mounted secret.
+	// It is read at provision, so reload the config to pick up changes.
+	StaticKeysDir string `json:"static_keys_dir,omitempty"`
+	// DevKey trusts a throwaway Ed25519 key for local testing without a directory. The key is generated at provision,
+	// or kept in DevKeyFile, and its private JWK and keyid are logged for the bot under test to sign with.
+	DevKey bool `json:"dev_key,omitempty"`
+	// DevKeyFile holds the dev_key private key, as a JWK or PEM. It is written with a new key when missing.
+	DevKeyFile string `json:"dev_key_file,omitempty"`
+	// KeyStoresRaw are KeyStore modules looking up keyids no other key matches, such as a database or Vault
+	KeyStoresRaw []json.RawMessage `json:"key_stores,omitempty" caddy:"namespace=http.handlers.httpsig.key_stores inline_key=store"`
+	// KeyStores are consulted after those of KeyStoresRaw, for stores set up in Go rather than as modules
+	KeyStores []KeyStore `json:"-"`
+	// Mode is "enforce" (default) or "observe", which never rejects or defers requests but records the outcome
+	Mode string `json:"mode,omitempty"`
+	// AllowUnverified passes requests without a valid signature to the next handler instead of rejecting them,
+	// which can route on {http.httpsig.valid} and {http.httpsig.reason}
+	AllowUnverified bool `json:"allow_unverified,omitempty"`
+	// RequireSignatureAgent rejects signatures by directory keys unless the request's Signature-Agent points at
+	// directory_base, so a bot cannot advertise one directory while signing with a key from another.
+	// Static keys are not discovered through a directory and are exempt.
+	RequireSignatureAgent bool `json:"require_signature_agent,omitempty"`
+	// DiscoverDirectories verifies requests advertising another directory in Signature-Agent against the keys of
+	// that directory, fetched on demand, so bots need not be configured one by one
+	DiscoverDirectories bool `json:"discover_directories,omitempty"`
+	// DiscoveryTTL is how long a discovered directory is cached. Defaults to DefaultDiscoveryTTL.
+	DiscoveryTTL caddy.Duration `json:"discovery_ttl,omitempty"`
+	// DiscoveryFailureTTL is how long a discovered directory that failed to load is not fetched again, its
+	// signatures being rejected meanwhile. Defaults to DefaultDiscoveryFailureTTL.
+	DiscoveryFailureTTL caddy.Duration `json:"discovery_failure_ttl,omitempty"`
+	// DiscoveryAllowHosts restricts discovery to directories at these hosts, such as bot.example or *.bot.example
+	DiscoveryAllowHosts []string `json:"discovery_allow_hosts,omitempty"`
+	// DiscoveryDenyHosts are hosts directories are never discovered at, in the same form
+	DiscoveryDenyHosts []string `json:"discovery_deny_hosts,omitempty"`
+	// DirectoryPaths are the well-known locations tried in order on a bare directory_base host, the first serving
+	// a directory being used. Defaults to DefaultDirectoryPaths.
+	DirectoryPaths []string `json:"directory_paths,omitempty"`
+	// DirectoryRootKey is a public JWK that directory responses must be signed with, over content-digest.
+	// Directories without a valid root signature are rejected, so a compromised directory host cannot add keys.
+	DirectoryRootKey json.RawMessage `json:"directory_root_key,omitempty"`
+	// RequireSignedDirectory rejects directories whose response is not signed by each of the keys they publish,
+	// tagged http-message-signatures-directory, proving the directory holds the matching private keys
+	RequireSignedDirectory bool `json:"require_signed_directory,omitempty"`
+	// DirectoryMinTLS is the minimum TLS version for directory fetches, "1.2" (default) or "1.3"
+	DirectoryMinTLS string `json:"directory_min_tls,omitempty"`
+	// DirectoryTimeout bounds each directory request. Defaults to DefaultDirectoryTimeout.
+	DirectoryTimeout caddy.Duration `json:"directory_timeout,omitempty"`
+	// DirectoryProxy is the URL of an HTTP proxy directories are fetched through, overriding HTTPS_PROXY. It applies
+	// to discovered directories too, so the proxy must refuse internal destinations.
+	DirectoryProxy string `json:"directory_proxy,omitempty"`
+	// DirectoryRootCAs are PEM files of CA certificates trusted for directory hosts, in addition to the system roots
+	DirectoryRootCAs []string `json:"directory_root_cas,omitempty"`
+	// DirectoryUserAgent is the User-Agent of directory requests. Defaults to Go's.
+	DirectoryUserAgent string `json:"directory_user_agent,omitempty"`
+	// InsecureHTTP allows directory_base URLs with http://, such as http://localhost:8080, for local development.
+	// Their keys can be replaced by anyone on the network path, so it must not be used in production.
+	// Discovered directories are always fetched over https.
+	InsecureHTTP bool `json:"insecure_http,omitempty"`
+	// MaxDirectorySize is the largest directory body read, in bytes. Defaults to DefaultMaxDirectorySize.
+	MaxDirectorySize int64 `json:"max_directory_size,omitempty"`
+	// MaxDirectoryKeys rejects directories publishing more keys than this. Defaults to DefaultMaxDirectoryKeys.
+	MaxDirectoryKeys int `json:"max_directory_keys,omitempty"`
+	// PinnedKeys maps directories, configured or discovered, to the RFC 7638 thumbprints of the only keys they may
+	// serve. A directory serving any other key fails to load with ErrUnpinnedKey, keeping the keys loaded before.
+	PinnedKeys map[string][]string `json:"pinned_keys,omitempty"`
+	// KeyCAs are PEM files of CA certificates that directory keys carrying an x5c chain must be certified by, the
+	// leaf certifying the key itself. Keys whose chain does not verify are dropped.
+	KeyCAs []string `json:"key_cas,omitempty"`
+	// RequireKeyCertificates also drops directory keys without an x5c chain. It needs KeyCAs.
+	RequireKeyCertificates bool `json:"require_key_certificates,omitempty"`
+	// RequiredPurpose accepts only keys from directories declaring one of these purposes, such as "rag" or "search".
+	// Configured directories declaring another purpose or none are loaded without keys, and discovered ones are
+	// rejected with ErrPurposeMismatch.
+	RequiredPurpose []string `json:"required_purpose,omitempty"`
+	// OnDirectoryError is "block", "allow" or "retry", the DirectoryErrorPolicy applied when a directory cannot be
+	// loaded at provision. When unset, provisioning fails.
+	OnDirectoryError string `json:"on_directory_error,omitempty"`
+	// Fetcher retrieves the directory. Defaults to an HTTPDirectoryFetcher honoring the Directory settings above
+	// and RequireSignedDirectory.
+	Fetcher DirectoryFetcher `json:"-"`
+	// DirectoryCache shares fetched directories between Caddy instances, so that a fleet fetches each directory
+	// once per TTL rather than once per instance
+	DirectoryCache *DirectoryCacheConfig `json:"directory_cache,omitempty"`
+	// Now is the clock signatures and keys are checked against. Defaults to time.Now.
+	Now func() time.Time `json:"-"`
+	// PersistDirectories keeps the last directory fetched from each directory_base on disk, so that a restart while
+	// a directory host is down starts with the keys it last served rather than without them
+	PersistDirectories bool `json:"persist_directories,omitempty"`
+	// PersistDirectoriesDir is where directories are persisted. Defaults to httpsig/directories in Caddy's data directory.
+	PersistDirectoriesDir string `json:"persist_directories_dir,omitempty"`
+	// RefreshInterval is how often the directory is fetched again to pick up rotated keys. Zero disables refreshing.
+	RefreshInterval caddy.Duration `json:"refresh_interval,omitempty"`
+
+	// KeyActivationGrace accepts directory keys whose nbf is at most this far in the future
+	KeyActivationGrace caddy.Duration `json:"key_activation_grace,omitempty"`
+	// CreatedSkew accepts signatures created at most this far in the future. Defaults to DefaultCreatedSkew.
+	CreatedSkew caddy.Duration `json:"created_skew,omitempty"`
+	// MaxSignatureAge rejects signatures created longer ago than this, even before they expire. Defaults to
+	// DefaultMaxSignatureAge, which also bounds the lifetime of signatures without expires.
+	MaxSignatureAge caddy.Duration `json:"max_signature_age,omitempty"`
+	// ExpiresGrace accepts signatures at most this long after their expires, for bots whose clock runs behind
+	ExpiresGrace caddy.Duration `json:"expires_grace,omitempty"`
+	// RequireExpires rejects signatures without an expires parameter
+	RequireExpires bool `json:"require_expires,omitempty"`
+	// DateSkew rejects signatures covering a Date header further than this from their created time
+	DateSkew caddy.Duration `json:"date_skew,omitempty"`
+	// MaxBodySize is the largest request body, in bytes, buffered to verify Content-Digest. Zero does not limit it.
+	MaxBodySize int64 `json:"max_body_size,omitempty"`
+	// ClockReference is a URL whose Date header corrects the local clock for signature time checks,
+	// measured at provision and every 15 minutes
+	ClockReference string `json:"clock_reference,omitempty"`
+
+	// RevokedKeyIDs rejects signatures by these keys with ErrRevoked, even though they verify
+	RevokedKeyIDs []string `json:"revoked_keyids,omitempty"`
+	// RevokedDirectories rejects signatures by keys of these directories, either as directory_base or as
+	// advertised in Signature-Agent. It stops trusting a vendor without removing its directory from the config.
+	RevokedDirectories []string `json:"revoked_directories,omitempty"`
+	// RevocationList is fetched on an interval for thumbprints of compromised keys, whose signatures are rejected with
+	// ErrRevoked even while their directory still publishes them
+	RevocationList *RevocationListConfig `json:"revocation_list,omitempty"`
+
+	// AllowedAlgorithms restricts the signature algorithms accepted from directory keys. Defaults to ed25519.
+	AllowedAlgorithms []string `json:"allowed_algorithms,omitempty"`
+	// DropDisallowedKeys drops directory keys with a disallowed algorithm instead of rejecting their signatures
+	DropDisallowedKeys bool `json:"drop_disallowed_keys,omitempty"`
+
+	// RequiredFields are components every signature must cover. Defaults to @authority.
+	RequiredFields []string `json:"required_fields,omitempty"`
+	// DisallowedFields rejects signatures covering any of these components
+	DisallowedFields []string `json:"disallowed_fields,omitempty"`
+	// AuthorityComponent is "@authority" (default), "host" or "both", the components binding signatures to the host
+	AuthorityComponent string `json:"authority_component,omitempty"`
+
+	// AuthorityNormalization is "strict" (default) or "lenient", which tolerates host casing and default port differences
+	AuthorityNormalization string `json:"authority_normalization,omitempty"`
+	// TrustedProxies are the addresses or CIDR ranges of the proxies in front of Caddy whose X-Forwarded-Host and
+	// X-Forwarded-Proto name the host and scheme bots signed. private_ranges stands for the private networks.
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+	// ExpectedAuthorities replace the request host when verifying signatures, such as the public host name of
+	// routes that rewrite the host. Each is tried in order.
+	ExpectedAuthorities []string `json:"expected_authorities,omitempty"`
+
+	// TagEnforcement is "strict" (default), "lenient", accepting signatures without a tag, or "off"
+	TagEnforcement string `json:"tag_enforcement,omitempty"`
+
+	// Challenge is "both" (default), sending Accept-Signature and WWW-Authenticate with rejections so bots can retry
+	// with a suitable signature, "accept-signature" or "off"
+	Challenge string `json:"challenge,omitempty"`
+
+	// ParameterCase is "strict" (default) or "lenient", which recognizes parameter keys such as Created in any case
+	ParameterCase string `json:"parameter_case,omitempty"`
+
+	// HeaderWhitespace is "strict" (default), trimming covered header values as RFC 9421 requires, or "lenient",
+	// which also tolerates differences in internal whitespace
+	HeaderWhitespace string `json:"header_whitespace,omitempty"`
+
+	// RequireNonce rejects signatures without a nonce parameter
+	RequireNonce bool `json:"require_nonce,omitempty"`
+	// NonceScope is "keyid" (default), requiring nonces to be unique per keyid, or "global"
+	NonceScope string `json:"nonce_scope,omitempty"`
+	// NonceStore is where nonces are recorded, in memory by default. Use Redis to reject replays across instances.
+	NonceStore *NonceStoreConfig `json:"nonce_store,omitempty"`
+
+	// WarmUp exercises every loaded key before it serves traffic, lowering the latency of first requests
+	WarmUp bool `json:"warm_up,omitempty"`
+
+	// Reject is the response to requests without a valid signature. Defaults to a plain text 401.
+	Reject *RejectResponse `json:"reject,omitempty"`
+	// ErrorStatus overrides the status of rejections by the kind of their error, as returned by ErrorKind, such as
+	// 400 for "malformed" or 403 for "invalid_signature", telling a bot that forgot to sign from one forging
+	ErrorStatus map[string]int `json:"error_status,omitempty"`
+
+	// Bots name the signers of verified requests and set what each may request. The first bot identified by the
+	// verifying key applies. Signers that are not named bots are not restricted.
+	Bots []Bot `json:"bots,omitempty"`
+
+	// SetHeaders are request headers set to the identity of verified bots for the upstream, such as
+	// X-Verified-Bot: {bot}. They are removed from every incoming request first, so clients cannot spoof them.
+	SetHeaders map[string]string `json:"set_headers,omitempty"`
+	// StripSignatureHeaders removes Signature, Signature-Input and Signature-Agent from verified requests before
+	// passing them on, so the upstream never sees raw signature material
+	StripSignatureHeaders bool `json:"strip_signature_headers,omitempty"`
+
+	// AllowedWindows defers verified bot requests outside of these daily windows with 503 and a Retry-After
+	AllowedWindows *AllowedWindows `json:"allowed_windows,omitempty"`
+
+	// DebugToken enables answering requests whose Web-Bot-Auth-Debug header carries it with a DebugReport of their
+	// verification, including the signature bases computed, instead of passing them on or rejecting them
+	DebugToken string `json:"debug_token,omitempty"`
+
+	// LogLevelValid is the level verified signatures are logged at, debug by default
+	LogLevelValid string `json:"log_level_valid,omitempty"`
+	// LogLevelInvalid is the level rejected signatures are logged at, info by default
+	LogLevelInvalid string `json:"log_level_invalid,omitempty"`
+
+	// AuditLog is a file, or "stdout", receiving one JSON record per checked request, whether its signature was accepted
+	// or rejected, rejections passed on by observe mode included. The file is reopened on SIGHUP.
+	AuditLog string `json:"audit_log,omitempty"`
+
+	// Metrics enables the httpsig_requests_total and httpsig_directory_fetch_failures_total counters
+	// and the httpsig_keys_loaded gauge
+	Metrics bool `json:"metrics,omitempty"`
+	// KeyIDMetrics enables the httpsig_requests_by_keyid_total counter
+	KeyIDMetrics bool `json:"keyid_metrics,omitempty"`
+	// KeyIDMetricsAllowlist restricts the keyid label to these values. Other keyids are counted as "other".
+	KeyIDMetricsAllowlist []string `json:"keyid_metrics_allowlist,omitempty"`
+	// ComponentMetrics enables the httpsig_requests_by_components_total counter of coverage patterns
+	ComponentMetrics bool `json:"component_metrics,omitempty"`
+
+	staticKeys       []keySpec
+	opts             ValidatorOptions
+	validator        atomic.Pointer[SignatureValidator]
+	mu               sync.Mutex
+	loaded           map[string]loadedDirectory
+	discovery        *discovery
+	persisted        *directoryStore
+	revocations      *revocationList
+	keyCertificates  *keyCertificates
+	policy           DirectoryErrorPolicy
+	mode             Mode
+	unavailable      atomic.Bool
+	validLogLevel    zapcore.Level
+	invalidLogLevel  zapcore.Level
+	outcomeMetrics   *outcomeMetrics
+	metrics          *keyIDMetrics
+	componentMetrics *componentMetrics
+	audit            AuditSink
+	// closers are the connections of the stores and caches to close on Cleanup
+	closers []io.Closer
+	windows *timeWindows
+	logger  *zap.Logger
 }
 
 // CaddyModule function to provide module information to Caddy
-func (m Middleware) CaddyModule() caddy.ModuleInfo {
+func (*Middleware) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
 		ID:  "http.handlers.httpsig",
 		New: func() caddy.Module { return new(Middleware) },
@@ -42,51 +292,1217 @@ func (m Middleware) CaddyModule() caddy.ModuleInfo {
 
 // Provision method for setting up the validator with the public key
 func (m *Middleware) Provision(ctx caddy.Context) error {
-	// consider the case where the directory ios localhost
-	resp, err := http.Get("https://" + m.DirectoryBase + "/.well-known/http-message-signatures-directory")
+	m.logger = ctx.Logger()
+	var err error
+	if m.validLogLevel, err = parseLogLevel("log_level_valid", m.LogLevelValid, defaultValidLogLevel); err != nil {
+		return err
+	}
+	if m.invalidLogLevel, err = parseLogLevel("log_level_invalid", m.LogLevelInvalid, defaultInvalidLogLevel); err != nil {
+		return err
+	}
+
+	if m.Metrics {
+		metrics, err := newOutcomeMetrics(ctx.GetMetricsRegistry())
+		if err != nil {
+			return fmt.Errorf("registering metrics: %w", err)
+		}
+		m.outcomeMetrics = metrics
+	}
+	if m.KeyIDMetrics {
+		metrics, err := newKeyIDMetrics(ctx.GetMetricsRegistry(), m.KeyIDMetricsAllowlist)
+		if err != nil {
+			return fmt.Errorf("registering keyid metrics: %w", err)
+		}
+		m.metrics = metrics
+	}
+	if m.ComponentMetrics {
+		metrics, err := newComponentMetrics(ctx.GetMetricsRegistry())
+		if err != nil {
+			return fmt.Errorf("registering component metrics: %w", err)
+		}
+		m.componentMetrics = metrics
+	}
+
+	if m.AuditLog != "" {
+		sink, err := NewJSONLAuditSink(m.AuditLog)
+		if err != nil {
+			return err
+		}
+		m.audit = sink
+		go reopenOnSIGHUP(ctx, sink, m.logger)
+	}
+
+	if m.AllowedWindows != nil {
+		windows, err := newTimeWindows(*m.AllowedWindows)
+		if err != nil {
+			return err
+		}
+		m.windows = windows
+	}
+	for i := range m.Bots {
+		if err := m.Bots[i].validate(); err != nil {
+			return err
+		}
+	}
+	if m.Reject != nil {
+		if err := m.Reject.validate(); err != nil {
+			return err
+		}
+	}
+	for kind, status := range m.ErrorStatus {
+		if !isErrorKind(kind) {
+			return fmt.Errorf("error_status: unknown error kind %q", kind)
+		}
+		if status < 400 || status > 499 {
+			return fmt.Errorf("error_status: %s status %d is not a 4xx status code", kind, status)
+		}
+	}
+
+	m.mode = ModeEnforce
+	if m.Mode != "" {
+		mode, err := ParseMode(m.Mode)
+		if err != nil {
+			return err
+		}
+		m.mode = mode
+	}
+
+	if m.ExpiresGrace < 0 {
+		return errors.New("expires_grace must not be negative")
+	}
+	m.opts = ValidatorOptions{
+		DropDisallowedKeys: m.DropDisallowedKeys,
+		RequiredFields:     m.RequiredFields,
+		KeyActivationGrace: time.Duration(m.KeyActivationGrace),
+		CreatedSkew:        time.Duration(m.CreatedSkew),
+		MaxSignatureAge:    time.Duration(m.MaxSignatureAge),
+		ExpiresGrace:       time.Duration(m.ExpiresGrace),
+		RequireExpires:     m.RequireExpires,
+		RequireNonce:       m.RequireNonce,
+		DateSkew:           time.Duration(m.DateSkew),
+		MaxBodySize:        m.MaxBodySize,
+		DisallowedFields:   m.DisallowedFields,
+		RevokedKeyIDs:      m.RevokedKeyIDs,
+		Now:                m.Now,
+	}
+	for _, base := range m.RevokedDirectories {
+		if _, err := directoryURL(base); err != nil {
+			return fmt.Errorf("revoked_directories: %w", err)
+		}
+	}
+	for _, name := range m.AllowedAlgorithms {
+		algo, err := ParseAlgorithm(name)
+		if err != nil {
+			return err
+		}
+		m.opts.AllowedAlgorithms = append(m.opts.AllowedAlgorithms, algo)
+	}
+
+	if m.AuthorityNormalization != "" {
+		authority, err := ParseAuthorityNormalization(m.AuthorityNormalization)
+		if err != nil {
+			return err
+		}
+		m.opts.AuthorityNormalization = authority
+	}
+	trusted, err := parseTrustedProxies(m.TrustedProxies)
 	if err != nil {
-		return nil
+		return fmt.Errorf("trusted_proxies: %w", err)
+	}
+	m.opts.TrustedProxies = trusted
+	for _, authority := range m.ExpectedAuthorities {
+		if authority == "" || strings.ContainsAny(authority, "/ ") {
+			return fmt.Errorf("expected_authority: %q is not a host with an optional port", authority)
+		}
+	}
+	m.opts.ExpectedAuthorities = m.ExpectedAuthorities
+
+	if m.AuthorityComponent != "" {
+		component, err := ParseAuthorityComponent(m.AuthorityComponent)
+		if err != nil {
+			return err
+		}
+		m.opts.AuthorityComponent = component
+	}
+
+	if m.TagEnforcement != "" {
+		tag, err := ParseTagEnforcement(m.TagEnforcement)
+		if err != nil {
+			return err
+		}
+		m.opts.TagEnforcement = tag
+	}
+
+	if m.Challenge != "" {
+		challenge, err := ParseChallengeMode(m.Challenge)
+		if err != nil {
+			return err
+		}
+		m.opts.Challenge = challenge
+	}
+
+	if m.ParameterCase != "" {
+		paramCase, err := ParseParameterCase(m.ParameterCase)
+		if err != nil {
+			return err
+		}
+		m.opts.ParameterCase = paramCase
 	}
-	defer resp.Body.Close()
 
-	var dir Directory
-	err = json.NewDecoder(resp.Body).Decode(&dir)
+	if m.HeaderWhitespace != "" {
+		whitespace, err := ParseHeaderWhitespace(m.HeaderWhitespace)
+		if err != nil {
+			return err
+		}
+		m.opts.HeaderWhitespace = whitespace
+	}
+
+	var scope NonceScope
+	if m.NonceScope != "" {
+		parsed, err := ParseNonceScope(m.NonceScope)
+		if err != nil {
+			return err
+		}
+		scope = parsed
+	}
+	var store NonceStore = NewMemoryNonceStore(0)
+	if m.NonceStore != nil {
+		configured, err := newNonceStore(*m.NonceStore)
+		if err != nil {
+			return fmt.Errorf("nonce_store: %w", err)
+		}
+		store = configured
+		if closer, ok := configured.(io.Closer); ok {
+			m.closers = append(m.closers, closer)
+		}
+	}
+	m.opts.Nonces = NewNonceCacheWithStore(scope, store)
+
+	minTLS := uint16(tls.VersionTLS12)
+	if m.DirectoryMinTLS != "" {
+		version, err := ParseTLSVersion(m.DirectoryMinTLS)
+		if err != nil {
+			return fmt.Errorf("directory_min_tls: %w", err)
+		}
+		minTLS = version
+	}
+	clientConfig, err := m.directoryClientConfig(minTLS)
 	if err != nil {
 		return err
 	}
 
-	validator, err := NewValidator(dir.Keys[0])
+	if m.ClockReference != "" {
+		clock := NewOffsetClock(m.Now)
+		client := newDirectoryClient(clientConfig)
+		// An unreachable reference must not keep the server from starting, signatures are then checked uncorrected
+		if _, err := clock.Measure(ctx, client, m.ClockReference); err != nil {
+			m.logger.Warn("measuring clock offset failed", zap.String("clock_reference", m.ClockReference), zap.Error(err))
+		}
+		m.opts.Now = clock.Now
+		go m.measureClockLoop(ctx, clock, client)
+	}
+
+	if m.RevocationList != nil {
+		interval := time.Duration(m.RevocationList.Interval)
+		if interval < 0 {
+			return errors.New("revocation_list: interval must not be negative")
+		}
+		if interval == 0 {
+			interval = DefaultRevocationListInterval
+		}
+		m.revocations = &revocationList{url: m.RevocationList.URL, client: newDirectoryClient(clientConfig)}
+		// Starting without the list would accept the keys it revokes
+		if err := m.revocations.fetch(ctx); err != nil {
+			return fmt.Errorf("revocation_list: %w", err)
+		}
+		go m.revocationListLoop(ctx, interval)
+	}
+
+	stores := make([]KeyStore, 0, len(m.KeyStoresRaw))
+	for i, raw := range m.KeyStoresRaw {
+		store, err := loadKeyStore(ctx, raw)
+		if err != nil {
+			return fmt.Errorf("key_stores %d: %w", i, err)
+		}
+		stores = append(stores, store)
+	}
+	m.KeyStoresRaw = nil
+	m.KeyStores = append(stores, m.KeyStores...)
+	m.opts.KeyStores = m.KeyStores
+
+	if len(m.directories()) == 0 && !m.DiscoverDirectories && len(m.StaticKeys) == 0 && len(m.Keys) == 0 && len(m.StaticKeysEnv) == 0 && m.StaticKeysDir == "" && len(m.KeyStores) == 0 && !m.DevKey {
+		return errors.New("directory_base, discover_directories, static_keys, key_store or dev_key is required")
+	}
+	if m.RequireSignatureAgent && len(m.directories()) == 0 && !m.DiscoverDirectories {
+		return errors.New("require_signature_agent needs directory_base")
+	}
+	for _, base := range m.directories() {
+		directory, err := directoryURL(base)
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(directory, "http://") {
+			continue
+		}
+		if !m.InsecureHTTP {
+			return fmt.Errorf("directory_base %s uses plaintext http, which needs insecure_http", base)
+		}
+		m.logger.Warn("INSECURE: fetching directory keys over plaintext http, which anyone on the network path can replace; use insecure_http for local development only",
+			zap.String("directory_base", base))
+	}
+	staticKeys, err := parseKeySpecs(m.StaticKeys)
 	if err != nil {
+		return fmt.Errorf("static_keys: %w", err)
+	}
+	m.staticKeys = staticKeys
+	if err := m.loadStaticKeys(); err != nil {
 		return err
 	}
-	m.validator = validator
+	if m.DevKey {
+		if err := m.loadDevKey(); err != nil {
+			return err
+		}
+	} else if m.DevKeyFile != "" {
+		return errors.New("dev_key_file needs dev_key")
+	}
+
+	for _, path := range m.DirectoryPaths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("directory_paths: %q is not an absolute path", path)
+		}
+	}
+	var root *DirectoryRoot
+	if len(m.DirectoryRootKey) > 0 {
+		if len(m.directories()) == 0 && !m.DiscoverDirectories {
+			return errors.New("directory_root_key needs directory_base or discover_directories")
+		}
+		if root, err = NewDirectoryRoot(m.DirectoryRootKey); err != nil {
+			return fmt.Errorf("directory_root_key: %w", err)
+		}
+	}
+	if m.RequireSignedDirectory && len(m.directories()) == 0 && !m.DiscoverDirectories {
+		return errors.New("require_signed_directory needs directory_base or discover_directories")
+	}
+	if len(m.RequiredPurpose) > 0 && len(m.directories()) == 0 && !m.DiscoverDirectories {
+		return errors.New("required_purpose needs directory_base or discover_directories")
+	}
+	if len(m.KeyCAs) > 0 {
+		roots, err := loadCertPool(m.KeyCAs)
+		if err != nil {
+			return fmt.Errorf("key_cas: %w", err)
+		}
+		m.keyCertificates = &keyCertificates{roots: roots, required: m.RequireKeyCertificates}
+	} else if m.RequireKeyCertificates {
+		return errors.New("require_key_certificates needs key_cas")
+	}
+	for base, thumbprints := range m.PinnedKeys {
+		if _, err := directoryURL(base); err != nil {
+			return fmt.Errorf("pinned_keys: %w", err)
+		}
+		if len(thumbprints) == 0 {
+			return fmt.Errorf("pinned_keys: no thumbprints pinned for %s", base)
+		}
+		if !m.DiscoverDirectories && !slices.ContainsFunc(m.directories(), func(d string) bool { return sameDirectory(d, base) }) {
+			return fmt.Errorf("pinned_keys: %s is not a directory_base and discover_directories is off", base)
+		}
+	}
+	if m.MaxDirectorySize < 0 || m.MaxDirectoryKeys < 0 {
+		return errors.New("max_directory_size and max_directory_keys must not be negative")
+	}
+	// Discovered directories come from request headers, so the default fetcher only reaches public addresses
+	discoveryFetcher := m.Fetcher
+	if m.Fetcher == nil {
+		m.Fetcher = &HTTPDirectoryFetcher{
+			Client:            newDirectoryClient(clientConfig),
+			Root:              root,
+			Paths:             m.DirectoryPaths,
+			RequireSelfSigned: m.RequireSignedDirectory,
+			UserAgent:         m.DirectoryUserAgent,
+			MaxSize:           m.MaxDirectorySize,
+			MaxKeys:           m.MaxDirectoryKeys,
+			InsecureHTTP:      m.InsecureHTTP,
+		}
+		discoveryFetcher = &HTTPDirectoryFetcher{
+			Client:            newDiscoveryClient(clientConfig),
+			Root:              root,
+			Paths:             m.DirectoryPaths,
+			RequireSelfSigned: m.RequireSignedDirectory,
+			UserAgent:         m.DirectoryUserAgent,
+			MaxSize:           m.MaxDirectorySize,
+			MaxKeys:           m.MaxDirectoryKeys,
+		}
+	}
+	if m.DirectoryCache != nil {
+		if m.Fetcher, err = m.cacheDirectories(m.Fetcher); err != nil {
+			return err
+		}
+		if discoveryFetcher, err = m.cacheDirectories(discoveryFetcher); err != nil {
+			return err
+		}
+	}
+	if m.DiscoverDirectories {
+		ttl := time.Duration(m.DiscoveryTTL)
+		if ttl == 0 {
+			ttl = DefaultDiscoveryTTL
+		}
+		failureTTL := time.Duration(m.DiscoveryFailureTTL)
+		if failureTTL == 0 {
+			failureTTL = DefaultDiscoveryFailureTTL
+		}
+		now := m.opts.Now
+		if now == nil {
+			now = time.Now
+		}
+		m.discovery = &discovery{
+			fetcher:         discoveryFetcher,
+			opts:            m.opts,
+			staticKeys:      m.staticKeys,
+			ttl:             ttl,
+			failureTTL:      failureTTL,
+			now:             now,
+			allowHosts:      m.DiscoveryAllowHosts,
+			denyHosts:       m.DiscoveryDenyHosts,
+			purposes:        m.RequiredPurpose,
+			pins:            m.PinnedKeys,
+			keyCertificates: m.keyCertificates,
+		}
+	} else if len(m.DiscoveryAllowHosts) > 0 || len(m.DiscoveryDenyHosts) > 0 {
+		return errors.New("discovery_allow_hosts and discovery_deny_hosts need discover_directories")
+	}
+	if m.OnDirectoryError != "" {
+		if m.policy, err = ParseDirectoryErrorPolicy(m.OnDirectoryError); err != nil {
+			return err
+		}
+	}
+	if m.PersistDirectories {
+		dir := m.PersistDirectoriesDir
+		if dir == "" {
+			dir = defaultPersistDirectoriesDir()
+		}
+		m.persisted = &directoryStore{dir: dir}
+	} else if m.PersistDirectoriesDir != "" {
+		return errors.New("persist_directories_dir needs persist_directories")
+	}
+	// Invalid options fail provisioning here, before the directory error policy applies to fetch errors
+	if _, err := newValidator(m.staticKeys, m.opts); err != nil {
+		return err
+	}
+	// The directories this config loads are kept for the config replacing it, until both are done
+	lastKnownDirectories.acquire(ctx, m.directories())
+	rf := &refresher{interval: time.Duration(m.RefreshInterval), now: time.Now}
+	delay, missing, err := m.load(ctx, rf)
+	if err != nil {
+		return err
+	}
+
+	if len(m.directories()) > 0 && (m.RefreshInterval > 0 || missing) {
+		go m.refreshLoop(ctx, rf, delay)
+	}
 	return nil
 }
 
+// Cleanup closes the audit log and the connections of the stores and caches
+func (m *Middleware) Cleanup() error {
+	var errs []error
+	if closer, ok := m.audit.(io.Closer); ok {
+		errs = append(errs, closer.Close())
+	}
+	for _, closer := range m.closers {
+		errs = append(errs, closer.Close())
+	}
+	return errors.Join(errs...)
+}
+
 // ServeHTTP method to handle the request and validate the signature
 func (m *Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
-	if err := m.validator.Validate(r); err != nil {
-		fmt.Println(err)
-		http.Error(w, "Invalid HTTP signature", http.StatusUnauthorized)
+	start := time.Now()
+	// The validator is loaded once per request, so that a refresh replacing it meanwhile does not verify and
+	// challenge with different ones
+	validator, err := m.discoveredValidator(r)
+	var result ValidationResult
+	if err == nil {
+		result, err = m.validateWith(validator, r)
+	}
+	m.logVerification(r, result, err, time.Since(start))
+	if m.audit != nil {
+		if aerr := m.audit.Record(newAuditRecord(r, result, err)); aerr != nil && m.logger != nil {
+			m.logger.Error("writing audit record", zap.Error(aerr))
+		}
+	}
+	setPlaceholders(r, result, err)
+	m.stripForwardHeaders(r)
+	if m.outcomeMetrics != nil {
+		m.outcomeMetrics.observe(r, err)
+	}
+	if m.debugRequested(r) {
+		m.writeDebugReport(w, r, validator, result, err)
+		return nil
+	}
+	if err != nil {
+		if errors.Is(err, ErrBodyConsumed) && m.logger != nil {
+			m.logger.Warn("request body was read before httpsig, order httpsig before body-consuming handlers",
+				zap.String("path", r.URL.Path))
+		}
+		if m.mode == ModeObserve {
+			if m.logger != nil {
+				m.logger.Info("observe mode, passing on request that would be rejected",
+					zap.String("path", r.URL.Path),
+					zap.Error(err))
+			}
+			return next.ServeHTTP(w, r)
+		}
+		if m.AllowUnverified || (m.policy == DirectoryErrorAllow && m.unavailable.Load()) {
+			return next.ServeHTTP(w, r)
+		}
+		if validator == nil {
+			validator = m.validator.Load()
+		}
+		validator.challenge(w)
+		m.Reject.write(w, r, m.ErrorStatus[ErrorKind(err)])
 		return nil
 	}
-	return next.ServeHTTP(w, r)
+	bot := matchBot(m.Bots, result)
+	setBotPlaceholders(r, bot)
+	if bot != nil {
+		if err := bot.allows(r); err != nil {
+			if m.logger != nil {
+				m.logger.Info("bot policy denied request",
+					zap.String("bot", bot.Name),
+					zap.String("keyid", result.KeyID),
+					zap.Error(err))
+			}
+			if m.mode != ModeObserve {
+				http.Error(w, "Request not allowed for this bot", http.StatusForbidden)
+				return nil
+			}
+		}
+	}
+	if m.windows != nil && m.mode != ModeObserve && (bot == nil || !bot.Bypass) {
+		if wait := m.windows.untilOpen(); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Verified bot traffic is not accepted at this time", http.StatusServiceUnavailable)
+			return nil
+		}
+	}
+	if len(result.Warnings) > 0 && m.logger != nil {
+		m.logger.Warn("accepted non-compliant signature",
+			zap.String("keyid", result.KeyID),
+			zap.Strings("warnings", result.Warnings))
+	}
+	if m.metrics != nil {
+		m.metrics.observe(result.KeyID)
+	}
+	if m.componentMetrics != nil {
+		m.componentMetrics.observe(result.Components)
+	}
+	if m.StripSignatureHeaders {
+		stripSignatureHeaders(r)
+	}
+	m.setForwardHeaders(r, result, bot)
+	return next.ServeHTTP(w, withResult(r, result))
+}
+
+// validate verifies the request signature, that its directory is not revoked and, when required,
+// that Signature-Agent names the directory of the verifying key
+func (m *Middleware) validate(r *http.Request) (ValidationResult, error) {
+	validator, err := m.discoveredValidator(r)
+	if err != nil {
+		return ValidationResult{}, err
+	}
+	return m.validateWith(validator, r)
+}
+
+// validateWith is validate with the validator for r, loaded by the caller
+func (m *Middleware) validateWith(validator *SignatureValidator, r *http.Request) (ValidationResult, error) {
+	result, err := validator.Validate(r)
+	if err != nil {
+		return result, err
+	}
+	if err := m.checkRevokedDirectory(r.Header, result.Directory); err != nil {
+		return ValidationResult{}, err
+	}
+	if err := m.checkRevocationList(result.Thumbprint); err != nil {
+		return ValidationResult{}, err
+	}
+	// Static keys do not come from a directory
+	if !m.RequireSignatureAgent || result.Directory == "" {
+		return result, nil
+	}
+	if err := checkSignatureAgent(r.Header, result.Directory); err != nil {
+		return ValidationResult{}, err
+	}
+	return result, nil
+}
+
+// directoryClientConfig returns the settings of the clients fetching directories
+func (m *Middleware) directoryClientConfig(minTLS uint16) (directoryClientConfig, error) {
+	config := directoryClientConfig{minVersion: minTLS, timeout: time.Duration(m.DirectoryTimeout)}
+	if config.timeout < 0 {
+		return config, errors.New("directory_timeout must not be negative")
+	}
+	if config.timeout == 0 {
+		config.timeout = DefaultDirectoryTimeout
+	}
+	if m.DirectoryProxy != "" {
+		proxy, err := url.Parse(m.DirectoryProxy)
+		if err != nil {
+			return config, fmt.Errorf("directory_proxy: %w", err)
+		}
+		if proxy.Scheme != "http" && proxy.Scheme != "https" && proxy.Scheme != "socks5" || proxy.Host == "" {
+			return config, fmt.Errorf("directory_proxy: %q is not an http, https or socks5 URL", m.DirectoryProxy)
+		}
+		config.proxy = proxy
+	}
+	if len(m.DirectoryRootCAs) > 0 {
+		pool, err := loadRootCAs(m.DirectoryRootCAs)
+		if err != nil {
+			return config, fmt.Errorf("directory_root_cas: %w", err)
+		}
+		config.rootCAs = pool
+	}
+	return config, nil
+}
+
+// cacheDirectories wraps fetcher in the directory_cache
+func (m *Middleware) cacheDirectories(fetcher DirectoryFetcher) (DirectoryFetcher, error) {
+	cache, err := newDirectoryCache(*m.DirectoryCache, fetcher)
+	if err != nil {
+		return nil, fmt.Errorf("directory_cache: %w", err)
+	}
+	if redis, ok := cache.(*RedisDirectoryCache); ok {
+		redis.Logger = m.logger
+		m.closers = append(m.closers, redis)
+	}
+	return cache, nil
+}
+
+// discoveredValidator returns the validator of the directory advertised in Signature-Agent when discovery is enabled
+// and it is not a configured directory, and the validator of the configured keys otherwise.
+// Revoked directories are rejected before they are fetched.
+func (m *Middleware) discoveredValidator(r *http.Request) (*SignatureValidator, error) {
+	if m.discovery == nil || len(r.Header.Values("Signature-Agent")) == 0 {
+		return m.validator.Load(), nil
+	}
+	agent, err := parseSignatureAgent(r.Header)
+	if err != nil {
+		return nil, err
+	}
+	for _, base := range m.directories() {
+		if sameDirectory(agent, base) {
+			return m.validator.Load(), nil
+		}
+	}
+	if isRevokedDirectory(m.RevokedDirectories, agent) {
+		return nil, fmt.Errorf("%w: directory %s", ErrRevoked, agent)
+	}
+	return m.discovery.validator(r.Context(), agent)
 }
 
 // UnmarshalCaddyfile method to allow configuration via the Caddyfile
 func (m *Middleware) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	for d.Next() {
 		for d.NextBlock(0) {
-			switch d.Val() {
-			case "directory_base":
+			if err := m.unmarshalOption(d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// unmarshalOption parses the Caddyfile option at the cursor of d. The matcher shares it to accept the same options.
+func (m *Middleware) unmarshalOption(d *caddyfile.Dispenser) error {
+	switch d.Val() {
+	case "directory_base":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		// Repeated directory_base lines add directories
+		if m.DirectoryBase == "" {
+			m.DirectoryBase = d.Val()
+		} else {
+			m.DirectoryBases = append(m.DirectoryBases, d.Val())
+		}
+	case "key":
+		// The key is either the single argument or given with jwk or pem in a block, which may also set its keyid
+		// and validity period
+		var key StaticKey
+		if d.NextArg() {
+			if err := setKeyMaterial(&key, d.Val()); err != nil {
+				return d.Errf("key: %v", err)
+			}
+		}
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			option := d.Val()
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			switch option {
+			case "keyid":
+				key.KeyID = d.Val()
+			case "jwk", "pem":
+				if err := setKeyMaterial(&key, d.Val()); err != nil {
+					return d.Errf("key: %v", err)
+				}
+			case "not_before", "not_after":
+				t, err := time.Parse(time.RFC3339, d.Val())
+				if err != nil {
+					return d.Errf("key: invalid %s: %v", option, err)
+				}
+				if option == "not_before" {
+					key.NotBefore = t
+				} else {
+					key.NotAfter = t
+				}
+			default:
+				return d.Errf("key: unknown option '%s'", option)
+			}
+		}
+		if len(key.JWK) == 0 && key.PEM == "" {
+			return d.Errf("key: jwk or pem is required")
+		}
+		m.Keys = append(m.Keys, key)
+	case "static_keys":
+		// Keys are JSON, quoted with backticks, either as arguments or one per line in a block
+		keys := d.RemainingArgs()
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			keys = append(keys, d.Val())
+			keys = append(keys, d.RemainingArgs()...)
+		}
+		if len(keys) == 0 {
+			return d.ArgErr()
+		}
+		for _, key := range keys {
+			if !json.Valid([]byte(key)) {
+				return d.Errf("static_keys: invalid JWK %s", key)
+			}
+			m.StaticKeys = append(m.StaticKeys, json.RawMessage(key))
+		}
+	case "static_keys_env":
+		names := d.RemainingArgs()
+		if len(names) == 0 {
+			return d.ArgErr()
+		}
+		m.StaticKeysEnv = append(m.StaticKeysEnv, names...)
+	case "static_keys_dir":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		m.StaticKeysDir = d.Val()
+	case "dev_key":
+		m.DevKey = true
+		if d.NextArg() {
+			m.DevKeyFile = d.Val()
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "key_store":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		name := d.Val()
+		unm, err := caddyfile.UnmarshalModule(d, "http.handlers.httpsig.key_stores."+name)
+		if err != nil {
+			return err
+		}
+		m.KeyStoresRaw = append(m.KeyStoresRaw, caddyconfig.JSONModuleObject(unm, "store", name, nil))
+	case "revoked_keyids":
+		keyids := d.RemainingArgs()
+		if len(keyids) == 0 {
+			return d.ArgErr()
+		}
+		m.RevokedKeyIDs = append(m.RevokedKeyIDs, keyids...)
+	case "revoked_directories":
+		bases := d.RemainingArgs()
+		if len(bases) == 0 {
+			return d.ArgErr()
+		}
+		m.RevokedDirectories = append(m.RevokedDirectories, bases...)
+	case "revocation_list":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		config := RevocationListConfig{URL: d.Val()}
+		if d.NextArg() {
+			interval, err := caddy.ParseDuration(d.Val())
+			if err != nil || interval <= 0 {
+				return d.Errf("invalid revocation_list interval %q", d.Val())
+			}
+			config.Interval = caddy.Duration(interval)
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		m.RevocationList = &config
+	case "mode":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		m.Mode = d.Val()
+	case "allow_unverified":
+		m.AllowUnverified = true
+	case "require_signature_agent":
+		m.RequireSignatureAgent = true
+	case "directory_paths":
+		paths := d.RemainingArgs()
+		if len(paths) == 0 {
+			return d.ArgErr()
+		}
+		m.DirectoryPaths = append(m.DirectoryPaths, paths...)
+	case "directory_root_key":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		if !json.Valid([]byte(d.Val())) {
+			return d.Errf("directory_root_key: invalid JWK %s", d.Val())
+		}
+		m.DirectoryRootKey = json.RawMessage(d.Val())
+	case "require_signed_directory":
+		m.RequireSignedDirectory = true
+	case "directory_min_tls":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		m.DirectoryMinTLS = d.Val()
+	case "directory_timeout":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		timeout, err := caddy.ParseDuration(d.Val())
+		if err != nil {
+			return d.Errf("invalid directory_timeout: %v", err)
+		}
+		m.DirectoryTimeout = caddy.Duration(timeout)
+	case "directory_proxy":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		m.DirectoryProxy = d.Val()
+	case "directory_root_cas":
+		m.DirectoryRootCAs = append(m.DirectoryRootCAs, d.RemainingArgs()...)
+		if len(m.DirectoryRootCAs) == 0 {
+			return d.ArgErr()
+		}
+	case "insecure_http":
+		m.InsecureHTTP = true
+	case "directory_user_agent":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		m.DirectoryUserAgent = d.Val()
+	case "max_directory_size":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		size, err := humanize.ParseBytes(d.Val())
+		if err != nil {
+			return d.Errf("invalid max_directory_size %q: %v", d.Val(), err)
+		}
+		m.MaxDirectorySize = int64(size)
+	case "max_directory_keys":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		keys, err := strconv.Atoi(d.Val())
+		if err != nil || keys <= 0 {
+			return d.Errf("invalid max_directory_keys %q", d.Val())
+		}
+		m.MaxDirectoryKeys = keys
+	case "key_cas":
+		m.KeyCAs = append(m.KeyCAs, d.RemainingArgs()...)
+		if len(m.KeyCAs) == 0 {
+			return d.ArgErr()
+		}
+	case "require_key_certificates":
+		m.RequireKeyCertificates = true
+	case "pin_keys":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		base := d.Val()
+		thumbprints := d.RemainingArgs()
+		if len(thumbprints) == 0 {
+			return d.ArgErr()
+		}
+		if m.PinnedKeys == nil {
+			m.PinnedKeys = make(map[string][]string)
+		}
+		m.PinnedKeys[base] = append(m.PinnedKeys[base], thumbprints...)
+	case "required_purpose":
+		m.RequiredPurpose = append(m.RequiredPurpose, d.RemainingArgs()...)
+		if len(m.RequiredPurpose) == 0 {
+			return d.ArgErr()
+		}
+	case "discover_directories":
+		m.DiscoverDirectories = true
+	case "discovery_ttl":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		ttl, err := caddy.ParseDuration(d.Val())
+		if err != nil {
+			return d.Errf("invalid discovery_ttl: %v", err)
+		}
+		m.DiscoveryTTL = caddy.Duration(ttl)
+	case "discovery_allow_hosts":
+		hosts := d.RemainingArgs()
+		if len(hosts) == 0 {
+			return d.ArgErr()
+		}
+		m.DiscoveryAllowHosts = append(m.DiscoveryAllowHosts, hosts...)
+	case "discovery_deny_hosts":
+		hosts := d.RemainingArgs()
+		if len(hosts) == 0 {
+			return d.ArgErr()
+		}
+		m.DiscoveryDenyHosts = append(m.DiscoveryDenyHosts, hosts...)
+	case "discovery_failure_ttl":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		ttl, err := caddy.ParseDuration(d.Val())
+		if err != nil {
+			return d.Errf("invalid discovery_failure_ttl: %v", err)
+		}
+		m.DiscoveryFailureTTL = caddy.Duration(ttl)
+	case "on_directory_error":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		m.OnDirectoryError = d.Val()
+	case "persist_directories":
+		m.PersistDirectories = true
+		if d.NextArg() {
+			m.PersistDirectoriesDir = d.Val()
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	case "refresh_interval":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		interval, err := caddy.ParseDuration(d.Val())
+		if err != nil {
+			return d.Errf("invalid refresh_interval: %v", err)
+		}
+		m.RefreshInterval = caddy.Duration(interval)
+	case "key_activation_grace":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		grace, err := caddy.ParseDuration(d.Val())
+		if err != nil {
+			return d.Errf("invalid key_activation_grace: %v", err)
+		}
+		m.KeyActivationGrace = caddy.Duration(grace)
+	case "created_skew":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		skew, err := caddy.ParseDuration(d.Val())
+		if err != nil {
+			return d.Errf("invalid created_skew: %v", err)
+		}
+		m.CreatedSkew = caddy.Duration(skew)
+	case "max_signature_age":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		age, err := caddy.ParseDuration(d.Val())
+		if err != nil {
+			return d.Errf("invalid max_signature_age: %v", err)
+		}
+		m.MaxSignatureAge = caddy.Duration(age)
+	case "expires_grace":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		grace, err := caddy.ParseDuration(d.Val())
+		if err != nil {
+			return d.Errf("invalid expires_grace: %v", err)
+		}
+		m.ExpiresGrace = caddy.Duration(grace)
+	case "require_expires":
+		m.RequireExpires = true
+	case "date_skew":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		skew, err := caddy.ParseDuration(d.Val())
+		if err != nil {
+			return d.Errf("invalid date_skew: %v", err)
+		}
+		m.DateSkew = caddy.Duration(skew)
+	case "max_body_size":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		size, err := humanize.ParseBytes(d.Val())
+		if err != nil {
+			return d.Errf("invalid max_body_size %q: %v", d.Val(), err)
+		}
+		m.MaxBodySize = int64(size)
+	case "clock_reference":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		m.ClockReference = d.Val()
+	case "allowed_algorithms", "algorithms":
+		args := d.RemainingArgs()
+		if len(args) == 0 {
+			return d.ArgErr()
+		}
+		m.AllowedAlgorithms = append(m.AllowedAlgorithms, args...)
+	case "drop_disallowed_keys":
+		m.DropDisallowedKeys = true
+	case "required_fields":
+		args := d.RemainingArgs()
+		if len(args) == 0 {
+			return d.ArgErr()
+		}
+		m.RequiredFields = append(m.RequiredFields, args...)
+	case "disallowed_fields":
+		args := d.RemainingArgs()
+		if len(args) == 0 {
+			return d.ArgErr()
+		}
+		m.DisallowedFields = append(m.DisallowedFields, args...)
+	case "expected_authority":
+		authorities := d.RemainingArgs()
+		if len(authorities) == 0 {
+			return d.ArgErr()
+		}
+		m.ExpectedAuthorities = append(m.ExpectedAuthorities, authorities...)
+	case "trusted_proxies":
+		ranges := d.RemainingArgs()
+		if len(ranges) == 0 {
+			return d.ArgErr()
+		}
+		m.TrustedProxies = append(m.TrustedProxies, ranges...)
+	case "authority_normalization":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		m.AuthorityNormalization = d.Val()
+	case "authority_component":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		m.AuthorityComponent = d.Val()
+	case "tag_enforcement":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		m.TagEnforcement = d.Val()
+	case "challenge":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		m.Challenge = d.Val()
+	case "parameter_case":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		m.ParameterCase = d.Val()
+	case "header_whitespace":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		m.HeaderWhitespace = d.Val()
+	case "debug_token":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		m.DebugToken = d.Val()
+	case "require_nonce":
+		m.RequireNonce = true
+	case "nonce_scope":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		m.NonceScope = d.Val()
+	case "nonce_store":
+		args := d.RemainingArgs()
+		if len(args) == 0 {
+			return d.ArgErr()
+		}
+		config := &NonceStoreConfig{Type: args[0]}
+		switch {
+		case args[0] == "memory" && len(args) <= 2:
+			if len(args) == 2 {
+				capacity, err := strconv.Atoi(args[1])
+				if err != nil || capacity <= 0 {
+					return d.Errf("invalid nonce_store capacity %q", args[1])
+				}
+				config.Capacity = capacity
+			}
+		case args[0] == "redis" && len(args) == 2:
+			config.URL = args[1]
+		default:
+			return d.ArgErr()
+		}
+		m.NonceStore = config
+	case "directory_cache":
+		args := d.RemainingArgs()
+		if len(args) < 2 || len(args) > 3 || args[0] != "redis" {
+			return d.ArgErr()
+		}
+		config := &DirectoryCacheConfig{Type: args[0], URL: args[1]}
+		if len(args) == 3 {
+			ttl, err := caddy.ParseDuration(args[2])
+			if err != nil || ttl <= 0 {
+				return d.Errf("invalid directory_cache ttl %q", args[2])
+			}
+			config.TTL = caddy.Duration(ttl)
+		}
+		m.DirectoryCache = config
+	case "error_status":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		kind := d.Val()
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		status, err := strconv.Atoi(d.Val())
+		if err != nil {
+			return d.Errf("invalid error_status %q: %v", d.Val(), err)
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+		if m.ErrorStatus == nil {
+			m.ErrorStatus = make(map[string]int)
+		}
+		m.ErrorStatus[kind] = status
+	case "reject":
+		reject := new(RejectResponse)
+		if d.NextArg() {
+			status, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("invalid reject status %q", d.Val())
+			}
+			reject.Status = status
+		}
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			option := d.Val()
+			switch option {
+			case "status":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				status, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return d.Errf("invalid reject status %q", d.Val())
+				}
+				reject.Status = status
+			case "body":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				reject.Body = d.Val()
+			case "content_type":
 				if !d.NextArg() {
 					return d.ArgErr()
 				}
-				m.DirectoryBase = d.Val()
+				reject.ContentType = d.Val()
+			case "json":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				if !json.Valid([]byte(d.Val())) {
+					return d.Errf("reject: invalid JSON %s", d.Val())
+				}
+				reject.Body, reject.ContentType = d.Val(), "application/json"
+			case "header":
+				args := d.RemainingArgs()
+				if len(args) != 2 {
+					return d.ArgErr()
+				}
+				if reject.Headers == nil {
+					reject.Headers = map[string]string{}
+				}
+				reject.Headers[args[0]] = args[1]
+			default:
+				return d.Errf("reject: unknown option '%s'", option)
+			}
+		}
+		m.Reject = reject
+	case "warm_up":
+		m.WarmUp = true
+	case "bot":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		bot := Bot{Name: d.Val()}
+		for nesting := d.Nesting(); d.NextBlock(nesting); {
+			option := d.Val()
+			args := d.RemainingArgs()
+			switch option {
+			case "keyids":
+				bot.KeyIDs = append(bot.KeyIDs, args...)
+			case "directories":
+				bot.Directories = append(bot.Directories, args...)
+			case "paths":
+				bot.Paths = append(bot.Paths, args...)
+			case "methods":
+				bot.Methods = append(bot.Methods, args...)
+			case "bypass":
+				if len(args) > 0 {
+					return d.ArgErr()
+				}
+				bot.Bypass = true
 			default:
-				return d.Errf("unknown option '%s'", d.Val())
+				return d.Errf("bot: unknown option '%s'", option)
 			}
+			if option != "bypass" && len(args) == 0 {
+				return d.ArgErr()
+			}
+		}
+		m.Bots = append(m.Bots, bot)
+	case "set_header":
+		args := d.RemainingArgs()
+		if len(args) != 2 {
+			return d.ArgErr()
+		}
+		if m.SetHeaders == nil {
+			m.SetHeaders = map[string]string{}
+		}
+		m.SetHeaders[args[0]] = args[1]
+	case "strip_signature_headers":
+		m.StripSignatureHeaders = true
+	case "allowed_windows":
+		args := d.RemainingArgs()
+		if len(args) < 2 {
+			return d.ArgErr()
+		}
+		m.AllowedWindows = &AllowedWindows{Timezone: args[0], Ranges: args[1:]}
+	case "log_level_valid":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		m.LogLevelValid = d.Val()
+	case "log_level_invalid":
+		if !d.NextArg() {
+			return d.ArgErr()
+		}
+		m.LogLevelInvalid = d.Val()
+	case "audit_log":
+		if !d.NextArg() {
+			return d.ArgErr()
 		}
+		m.AuditLog = d.Val()
+	case "metrics":
+		m.Metrics = true
+	case "keyid_metrics":
+		m.KeyIDMetrics = true
+		m.KeyIDMetricsAllowlist = append(m.KeyIDMetricsAllowlist, d.RemainingArgs()...)
+	case "component_metrics":
+		m.ComponentMetrics = true
+	default:
+		return d.Errf("unknown option '%s'", d.Val())
 	}
 	return nil
 }
@@ -0,0 +1,110 @@
+package httpsig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/remitly-oss/httpsig-go"
+)
+
+// ErrKeyNotFound is returned by a KeyStore holding no key for a keyid
+var ErrKeyNotFound = errors.New("key not found")
+
+// KeyStore looks up the public keys of bots by keyid, for keys kept outside of directories and the config, such as
+// in Vault, a database, a Kubernetes secret or an internal HTTP service. Stores are consulted for keyids the
+// configured keys do not hold.
+//
+// Stores are Caddy modules in the http.handlers.httpsig.key_stores namespace, configured with key_store. Their keys
+// are trusted like static keys, so a store must only return keys of bots it vouches for.
+type KeyStore interface {
+	// LookupKey returns the public JWK of keyid, or an error wrapping ErrKeyNotFound
+	LookupKey(ctx context.Context, keyid string) (json.RawMessage, error)
+}
+
+// keyFetcher is the httpsig.KeyFetcher of a validator, resolving keyids to its keys, then through its stores
+type keyFetcher struct {
+	keys   map[string]keySpec
+	stores []KeyStore
+	// fetched holds the last key each store lookup returned, so that the validity and purpose of keys that verified
+	// a signature can be read afterwards. Only keys found by a store are held.
+	fetched sync.Map
+}
+
+// FetchByKeyID implements httpsig.KeyFetcher
+func (kf *keyFetcher) FetchByKeyID(ctx context.Context, _ http.Header, keyid string) (httpsig.KeySpecer, error) {
+	if ks, ok := kf.keys[keyid]; ok {
+		return ks.KeySpec, nil
+	}
+	for _, store := range kf.stores {
+		data, err := store.LookupKey(ctx, keyid)
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("looking up key %s: %w", keyid, err)
+		}
+		ks, err := parseKeySpec(data)
+		if err != nil {
+			return nil, fmt.Errorf("key %s from store: %w", keyid, err)
+		}
+		// The store maps keyids to keys, which need not be keyed by their thumbprint
+		ks.KeyID = keyid
+		kf.fetched.Store(keyid, ks)
+		return ks.KeySpec, nil
+	}
+	return nil, fmt.Errorf("Key for keyid '%s' not found", keyid)
+}
+
+// Fetch implements httpsig.KeyFetcher. Signatures without a keyid are not supported.
+func (kf *keyFetcher) Fetch(context.Context, http.Header, httpsig.MetadataProvider) (httpsig.KeySpecer, error) {
+	return nil, fmt.Errorf("Fetch without keyid not supported")
+}
+
+// key returns the key of keyid, configured or last found by a store
+func (kf *keyFetcher) key(keyid string) (keySpec, bool) {
+	if ks, ok := kf.keys[keyid]; ok {
+		return ks, true
+	}
+	if ks, ok := kf.fetched.Load(keyid); ok {
+		return ks.(keySpec), true
+	}
+	return keySpec{}, false
+}
+
+// loadKeyStore loads the KeyStore module of raw, named by its "store" member. Modules are loaded one by one with
+// LoadModuleByID rather than with LoadModule, which does not recognise json.RawMessage once it aliases
+// jsontext.Value.
+func loadKeyStore(ctx caddy.Context, raw json.RawMessage) (KeyStore, error) {
+	var named struct {
+		Store string `json:"store"`
+	}
+	if err := json.Unmarshal(raw, &named); err != nil {
+		return nil, err
+	}
+	if named.Store == "" {
+		return nil, errors.New(`missing "store" module name`)
+	}
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, err
+	}
+	delete(config, "store")
+	rest, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	mod, err := ctx.LoadModuleByID("http.handlers.httpsig.key_stores."+named.Store, rest)
+	if err != nil {
+		return nil, err
+	}
+	store, ok := mod.(KeyStore)
+	if !ok {
+		return nil, fmt.Errorf("module %s is not a KeyStore", named.Store)
+	}
+	return store, nil
+}
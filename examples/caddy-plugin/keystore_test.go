@@ -0,0 +1,125 @@
+package httpsig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(new(mapKeyStore))
+}
+
+// mapKeyStore is a KeyStore module of keys by keyid, failing lookups of keyids mapped to nil
+type mapKeyStore struct {
+	Keys map[string]json.RawMessage `json:"keys,omitempty"`
+}
+
+func (*mapKeyStore) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.httpsig.key_stores.map",
+		New: func() caddy.Module { return new(mapKeyStore) },
+	}
+}
+
+func (s *mapKeyStore) LookupKey(ctx context.Context, keyid string) (json.RawMessage, error) {
+	key, ok := s.Keys[keyid]
+	switch {
+	case !ok:
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, keyid)
+	case key == nil:
+		return nil, errors.New("store unavailable")
+	}
+	return key, nil
+}
+
+// UnmarshalCaddyfile reads "map { <keyid> <jwk> }" blocks
+func (s *mapKeyStore) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	s.Keys = map[string]json.RawMessage{}
+	for d.Next() {
+		for d.NextBlock(0) {
+			keyid := d.Val()
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			s.Keys[keyid] = json.RawMessage(d.Val())
+		}
+	}
+	return nil
+}
+
+func TestKeyStore(t *testing.T) {
+	stored, storedJWK, storedKeyID := generateKey(t)
+	static, staticJWK, staticKeyID := generateKey(t)
+	stranger, _, strangerKeyID := generateKey(t)
+	expiring, expiringJWK, expiringKeyID := generateKey(t)
+	store := &mapKeyStore{Keys: map[string]json.RawMessage{
+		storedKeyID:   storedJWK,
+		"custom-id":   storedJWK,
+		expiringKeyID: withValidity(t, expiringJWK, time.Time{}, time.Now().Add(-time.Hour)),
+		"broken":      nil,
+	}}
+
+	m := &Middleware{StaticKeys: []json.RawMessage{staticJWK}, KeyStores: []KeyStore{store}}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name    string
+		key     []byte
+		keyid   string
+		wantErr bool
+	}{
+		{name: "static key", key: static, keyid: staticKeyID},
+		{name: "stored key", key: stored, keyid: storedKeyID},
+		{name: "stored under another keyid", key: stored, keyid: "custom-id"},
+		{name: "unknown key", key: stranger, keyid: strangerKeyID, wantErr: true},
+		{name: "expired stored key", key: expiring, keyid: expiringKeyID, wantErr: true},
+		{name: "store error", key: stored, keyid: "broken", wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+			signRequest(t, r, tt.key, tt.keyid)
+			result, err := m.validator.Load().Validate(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && result.KeyID != tt.keyid {
+				t.Errorf("keyid = %s, want %s", result.KeyID, tt.keyid)
+			}
+		})
+	}
+}
+
+func TestKeyStoreModule(t *testing.T) {
+	key, jwk, keyid := generateKey(t)
+	var m Middleware
+	d := caddyfile.NewTestDispenser(fmt.Sprintf("httpsig {\nkey_store map {\n%s %s\n}\n}", keyid, jwk))
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.KeyStoresRaw) != 1 {
+		t.Fatalf("key stores = %s", m.KeyStoresRaw)
+	}
+	// Key stores alone are enough keys
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	signRequest(t, r, key, keyid)
+	if _, err := m.validator.Load().Validate(r); err != nil {
+		t.Errorf("stored key: %v", err)
+	}
+
+	if err := (&Middleware{}).UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\nkey_store missing\n}")); err == nil {
+		t.Error("unknown key store module accepted")
+	}
+}
@@ -0,0 +1,75 @@
+package httpsig
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Default levels of verification log entries: accepted signatures are only logged when debugging,
+// rejections by default
+const (
+	defaultValidLogLevel   = zapcore.DebugLevel
+	defaultInvalidLogLevel = zapcore.InfoLevel
+)
+
+// parseLogLevel returns the zap level named s for option, or def when s is empty
+func parseLogLevel(option, s string, def zapcore.Level) (zapcore.Level, error) {
+	if s == "" {
+		return def, nil
+	}
+	level, err := zapcore.ParseLevel(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", option, err)
+	}
+	return level, nil
+}
+
+// logVerification logs the outcome of verifying r, which took latency, at the level configured for it
+func (m *Middleware) logVerification(r *http.Request, result ValidationResult, err error, latency time.Duration) {
+	if m.logger == nil {
+		return
+	}
+	level, msg := m.validLogLevel, "verified signature"
+	keyid := result.KeyID
+	if err != nil {
+		level, msg = m.invalidLogLevel, "rejected signature"
+		keyid = claimedKeyID(r.Header)
+	}
+	entry := m.logger.Check(level, msg)
+	if entry == nil {
+		return
+	}
+	fields := []zap.Field{
+		zap.String("result", outcomeOf(r, err)),
+		zap.String("remote_ip", clientIP(r)),
+		zap.String("host", r.Host),
+		zap.String("path", r.URL.Path),
+		zap.String("keyid", keyid),
+		zap.String("signature_agent", r.Header.Get("Signature-Agent")),
+		zap.Duration("latency", latency),
+	}
+	if err != nil {
+		fields = append(fields, zap.String("reason", err.Error()), zap.String("error", ErrorKind(err)))
+	} else if result.Directory != "" {
+		fields = append(fields, zap.String("directory", result.Directory))
+	}
+	entry.Write(fields...)
+}
+
+// clientIP returns the client IP Caddy determined for r, honoring trusted proxies, or the address of the peer
+func clientIP(r *http.Request) string {
+	if ip, ok := caddyhttp.GetVar(r.Context(), caddyhttp.ClientIPVarKey).(string); ok && ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
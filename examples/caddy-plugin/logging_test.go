@@ -0,0 +1,87 @@
+package httpsig
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogVerification(t *testing.T) {
+	tests := []struct {
+		name         string
+		validLevel   string
+		invalidLevel string
+		wantValid    zapcore.Level
+		wantInvalid  zapcore.Level
+	}{
+		{name: "defaults", wantValid: zapcore.DebugLevel, wantInvalid: zapcore.InfoLevel},
+		{name: "configured", validLevel: "info", invalidLevel: "warn", wantValid: zapcore.InfoLevel, wantInvalid: zapcore.WarnLevel},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Middleware{StaticKeys: []json.RawMessage{ed25519JWK(testPrivateKey)}, LogLevelValid: tt.validLevel, LogLevelInvalid: tt.invalidLevel}
+			if err := m.Provision(newTestContext(t)); err != nil {
+				t.Fatal(err)
+			}
+			core, logs := observer.New(zapcore.DebugLevel)
+			m.logger = zap.New(core)
+
+			valid := newSignedRequest(t)
+			valid.RemoteAddr = "192.0.2.1:4321"
+			invalid := newSignedRequest(t)
+			invalid.Host = "other.example.com"
+			invalid.Header.Set("Signature-Agent", `"https://signer.example.com"`)
+			for _, r := range []*http.Request{valid, invalid} {
+				if err := m.ServeHTTP(httptest.NewRecorder(), r, okHandler{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			verified := logs.FilterMessage("verified signature").All()
+			if len(verified) != 1 || verified[0].Level != tt.wantValid {
+				t.Fatalf("verified entries = %v, want one at %s", verified, tt.wantValid)
+			}
+			fields := verified[0].ContextMap()
+			if fields["remote_ip"] != "192.0.2.1" || fields["keyid"] != testKeyID || fields["result"] != "valid" {
+				t.Errorf("verified fields = %v", fields)
+			}
+			if _, ok := fields["latency"]; !ok {
+				t.Error("no latency field")
+			}
+
+			rejected := logs.FilterMessage("rejected signature").All()
+			if len(rejected) != 1 || rejected[0].Level != tt.wantInvalid {
+				t.Fatalf("rejected entries = %v, want one at %s", rejected, tt.wantInvalid)
+			}
+			fields = rejected[0].ContextMap()
+			if fields["keyid"] != testKeyID || fields["signature_agent"] != `"https://signer.example.com"` || fields["reason"] == "" {
+				t.Errorf("rejected fields = %v", fields)
+			}
+		})
+	}
+}
+
+func TestUnmarshalLogLevels(t *testing.T) {
+	var m Middleware
+	d := caddyfile.NewTestDispenser(`httpsig {
+		log_level_valid info
+		log_level_invalid warn
+	}`)
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	if m.LogLevelValid != "info" || m.LogLevelInvalid != "warn" {
+		t.Errorf("log_level_valid = %q, log_level_invalid = %q", m.LogLevelValid, m.LogLevelInvalid)
+	}
+
+	bad := &Middleware{StaticKeys: []json.RawMessage{ed25519JWK(testPrivateKey)}, LogLevelInvalid: "loud"}
+	if err := bad.Provision(newTestContext(t)); err == nil {
+		t.Error("Provision accepted an unknown log level")
+	}
+}
@@ -0,0 +1,127 @@
+package httpsig
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy.RegisterModule(new(Matcher))
+}
+
+// Matcher matches requests carrying a valid web-bot-auth signature, optionally only from some keys or directories.
+// It verifies the request itself, with the same options as the handler, so routes can treat verified bots differently
+// from everyone else without rejecting the others.
+type Matcher struct {
+	// Verifier holds the handler options used to verify the request, such as directory_base and static_keys
+	Verifier *Middleware `json:"verifier,omitempty"`
+	// KeyIDs restricts matches to signatures by these keyids
+	KeyIDs []string `json:"keyids,omitempty"`
+	// Directories restricts matches to keys loaded from these directories, either as directory_base or discovered
+	// through Signature-Agent
+	Directories []string `json:"directories,omitempty"`
+}
+
+// matchOutcome is the verification of a request by a matcher, cached so that matching it again does not
+// verify it a second time and reject the signature nonce as a replay
+type matchOutcome struct {
+	result ValidationResult
+	err    error
+}
+
+// CaddyModule returns the Caddy module information
+func (*Matcher) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.matchers.httpsig",
+		New: func() caddy.Module { return new(Matcher) },
+	}
+}
+
+// Provision loads the keys of the verifier
+func (m *Matcher) Provision(ctx caddy.Context) error {
+	if m.Verifier == nil {
+		m.Verifier = new(Middleware)
+	}
+	for _, base := range m.Directories {
+		if _, err := directoryURL(base); err != nil {
+			return fmt.Errorf("directories: %w", err)
+		}
+	}
+	return m.Verifier.Provision(ctx)
+}
+
+// Cleanup closes the audit log of the verifier
+func (m *Matcher) Cleanup() error {
+	return m.Verifier.Cleanup()
+}
+
+// Match reports whether r carries a valid signature from an accepted key
+func (m *Matcher) Match(r *http.Request) bool {
+	match, _ := m.MatchWithError(r)
+	return match
+}
+
+// MatchWithError reports whether r carries a valid signature from an accepted key.
+// Rejected signatures do not match, and are not errors. The outcome is set in the {http.httpsig.*} placeholders.
+func (m *Matcher) MatchWithError(r *http.Request) (bool, error) {
+	key := fmt.Sprintf("httpsig.matcher.%p", m)
+	outcome, ok := caddyhttp.GetVar(r.Context(), key).(matchOutcome)
+	if !ok {
+		outcome.result, outcome.err = m.Verifier.validate(r)
+		caddyhttp.SetVar(r.Context(), key, outcome)
+	}
+	setPlaceholders(r, outcome.result, outcome.err)
+	if outcome.err != nil {
+		return false, nil
+	}
+	if len(m.KeyIDs) > 0 && !slices.Contains(m.KeyIDs, outcome.result.KeyID) {
+		return false, nil
+	}
+	if len(m.Directories) > 0 && !slices.ContainsFunc(m.Directories, func(base string) bool {
+		return outcome.result.Directory != "" && sameDirectory(base, outcome.result.Directory)
+	}) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// UnmarshalCaddyfile accepts the handler options, along with keyids and directories to filter matches:
+//
+//	@bots httpsig {
+//	    directory_base signer.example.com
+//	    keyids <keyid...>
+//	    directories <host|url...>
+//	}
+func (m *Matcher) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	if m.Verifier == nil {
+		m.Verifier = new(Middleware)
+	}
+	for d.Next() {
+		for d.NextBlock(0) {
+			switch d.Val() {
+			case "keyids":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				m.KeyIDs = append(m.KeyIDs, args...)
+			case "directories":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				m.Directories = append(m.Directories, args...)
+			default:
+				if err := m.Verifier.unmarshalOption(d); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
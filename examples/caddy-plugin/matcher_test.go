@@ -0,0 +1,104 @@
+package httpsig
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/remitly-oss/httpsig-go"
+)
+
+func TestMatcher(t *testing.T) {
+	staticKey, staticJWK, staticKeyID := generateKey(t)
+	newMatcher := func(m *Matcher) *Matcher {
+		t.Helper()
+		m.Verifier = &Middleware{
+			DirectoryBase: "signer.example.com",
+			StaticKeys:    []json.RawMessage{staticJWK},
+			Fetcher:       &fakeFetcher{responses: []fakeResponse{{dir: directoryOf(ed25519JWK(testPrivateKey))}}},
+		}
+		if err := m.Provision(newTestContext(t)); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	static := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		signRequest(t, r, staticKey, staticKeyID)
+		return r
+	}
+
+	tests := []struct {
+		name    string
+		matcher *Matcher
+		r       *http.Request
+		want    bool
+	}{
+		{name: "directory key", matcher: &Matcher{}, r: newSignedRequest(t), want: true},
+		{name: "static key", matcher: &Matcher{}, r: static(), want: true},
+		{name: "unsigned", matcher: &Matcher{}, r: httptest.NewRequest(http.MethodGet, "https://example.com/", nil)},
+		{name: "keyid listed", matcher: &Matcher{KeyIDs: []string{testKeyID}}, r: newSignedRequest(t), want: true},
+		{name: "keyid not listed", matcher: &Matcher{KeyIDs: []string{testKeyID}}, r: static()},
+		{name: "directory listed", matcher: &Matcher{Directories: []string{"https://Signer.example.com"}}, r: newSignedRequest(t), want: true},
+		{name: "static key with directories", matcher: &Matcher{Directories: []string{"signer.example.com"}}, r: static()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, err := newMatcher(tt.matcher).MatchWithError(tt.r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if match != tt.want {
+				t.Errorf("match = %v, want %v", match, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatcherNonceMatchedTwice(t *testing.T) {
+	m := &Matcher{Verifier: &Middleware{StaticKeys: []json.RawMessage{ed25519JWK(testPrivateKey)}}}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	err := httpsig.Sign(r, httpsig.SigningProfile{
+		Algorithm: httpsig.Algo_ED25519,
+		Fields:    httpsig.Fields("@authority"),
+		Metadata:  []httpsig.Metadata{httpsig.MetaCreated, httpsig.MetaExpires, httpsig.MetaKeyID, httpsig.MetaNonce, httpsig.MetaTag},
+	}, httpsig.SigningKey{Key: testPrivateKey, MetaKeyID: testKeyID, MetaTag: "web-bot-auth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = r.WithContext(context.WithValue(r.Context(), caddyhttp.VarsCtxKey, map[string]any{}))
+
+	// Routes may evaluate the same matcher more than once per request, which must not count as a replay
+	for i := range 2 {
+		if !m.Match(r) {
+			t.Errorf("match %d failed", i+1)
+		}
+	}
+}
+
+func TestUnmarshalMatcher(t *testing.T) {
+	var m Matcher
+	d := caddyfile.NewTestDispenser(`httpsig {
+		directory_base signer.example.com
+		keyids a b
+		directories signer.example.com
+	}`)
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	if m.Verifier.DirectoryBase != "signer.example.com" || len(m.KeyIDs) != 2 || len(m.Directories) != 1 {
+		t.Errorf("matcher = %+v, verifier directory_base = %q", m, m.Verifier.DirectoryBase)
+	}
+	if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`httpsig {
+		unknown
+	}`)); err == nil {
+		t.Error("unknown option accepted")
+	}
+}
@@ -0,0 +1,161 @@
+package httpsig
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// otherKeyID is the label value used for keyids outside of the metrics allowlist
+const otherKeyID = "other"
+
+// registerCounterVec registers a counter with registry, reusing the existing one when several httpsig handlers
+// in the same config register it
+func registerCounterVec(registry prometheus.Registerer, opts prometheus.CounterOpts, labels ...string) (*prometheus.CounterVec, error) {
+	counter := prometheus.NewCounterVec(opts, labels)
+	if err := registry.Register(counter); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if !errors.As(err, &are) {
+			return nil, err
+		}
+		return are.ExistingCollector.(*prometheus.CounterVec), nil
+	}
+	return counter, nil
+}
+
+// registerGaugeVec registers a gauge with registry, reusing the existing one like registerCounterVec
+func registerGaugeVec(registry prometheus.Registerer, opts prometheus.GaugeOpts, labels ...string) (*prometheus.GaugeVec, error) {
+	gauge := prometheus.NewGaugeVec(opts, labels)
+	if err := registry.Register(gauge); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if !errors.As(err, &are) {
+			return nil, err
+		}
+		return are.ExistingCollector.(*prometheus.GaugeVec), nil
+	}
+	return gauge, nil
+}
+
+// Verification outcomes, the result label of httpsig_requests_total
+const (
+	outcomeValid   = "valid"
+	outcomeInvalid = "invalid"
+	outcomeMissing = "missing"
+)
+
+// staticDirectory is the directory label of the keys configured in the Caddyfile rather than fetched
+const staticDirectory = "static"
+
+// outcomeMetrics counts verification outcomes and directory fetch failures, and tracks the number of loaded keys
+type outcomeMetrics struct {
+	requests      *prometheus.CounterVec
+	fetchFailures *prometheus.CounterVec
+	keys          *prometheus.GaugeVec
+}
+
+// newOutcomeMetrics registers the outcome counters and the loaded keys gauge with the given registry
+func newOutcomeMetrics(registry prometheus.Registerer) (*outcomeMetrics, error) {
+	requests, err := registerCounterVec(registry, prometheus.CounterOpts{
+		Name: "httpsig_requests_total",
+		Help: "Number of requests checked for a signature, by result: valid, invalid or missing.",
+	}, "result")
+	if err != nil {
+		return nil, err
+	}
+	fetchFailures, err := registerCounterVec(registry, prometheus.CounterOpts{
+		Name: "httpsig_directory_fetch_failures_total",
+		Help: "Number of failed fetches of a configured key directory, by directory_base.",
+	}, "directory")
+	if err != nil {
+		return nil, err
+	}
+	keys, err := registerGaugeVec(registry, prometheus.GaugeOpts{
+		Name: "httpsig_keys_loaded",
+		Help: "Number of keys loaded, by directory_base, or static for configured keys.",
+	}, "directory")
+	if err != nil {
+		return nil, err
+	}
+	return &outcomeMetrics{requests: requests, fetchFailures: fetchFailures, keys: keys}, nil
+}
+
+// outcomeOf returns the outcome of verifying r: valid, missing when it carries no signature, or invalid
+func outcomeOf(r *http.Request, err error) string {
+	switch {
+	case err == nil:
+		return outcomeValid
+	case len(r.Header.Values("Signature")) == 0 && len(r.Header.Values("Signature-Input")) == 0:
+		return outcomeMissing
+	}
+	return outcomeInvalid
+}
+
+// observe records the verification outcome of r
+func (om *outcomeMetrics) observe(r *http.Request, err error) {
+	om.requests.WithLabelValues(outcomeOf(r, err)).Inc()
+}
+
+// keyIDMetrics counts verified requests per keyid
+type keyIDMetrics struct {
+	requests  *prometheus.CounterVec
+	allowlist map[string]struct{}
+}
+
+// newKeyIDMetrics registers the per-keyid counter with the given registry.
+// When allowlist is not empty, keyids outside of it are counted under "other" to bound cardinality.
+func newKeyIDMetrics(registry prometheus.Registerer, allowlist []string) (*keyIDMetrics, error) {
+	requests, err := registerCounterVec(registry, prometheus.CounterOpts{
+		Name: "httpsig_requests_by_keyid_total",
+		Help: "Number of requests with a verified signature, by keyid.",
+	}, "keyid")
+	if err != nil {
+		return nil, err
+	}
+
+	km := &keyIDMetrics{requests: requests}
+	if len(allowlist) > 0 {
+		km.allowlist = make(map[string]struct{}, len(allowlist))
+		for _, keyid := range allowlist {
+			km.allowlist[keyid] = struct{}{}
+		}
+	}
+	return km, nil
+}
+
+// observe records a verified request for keyid
+func (km *keyIDMetrics) observe(keyid string) {
+	if km.allowlist != nil {
+		if _, ok := km.allowlist[keyid]; !ok {
+			keyid = otherKeyID
+		}
+	}
+	km.requests.WithLabelValues(keyid).Inc()
+}
+
+// componentMetrics counts verified requests by the set of components their signature covers
+type componentMetrics struct {
+	requests *prometheus.CounterVec
+}
+
+// newComponentMetrics registers the coverage pattern counter with the given registry
+func newComponentMetrics(registry prometheus.Registerer) (*componentMetrics, error) {
+	requests, err := registerCounterVec(registry, prometheus.CounterOpts{
+		Name: "httpsig_requests_by_components_total",
+		Help: "Number of requests with a verified signature, by covered components.",
+	}, "components")
+	if err != nil {
+		return nil, err
+	}
+	return &componentMetrics{requests: requests}, nil
+}
+
+// observe records a verified request covering components.
+// Components are sorted so that signing order does not split a coverage pattern.
+func (cm *componentMetrics) observe(components []string) {
+	pattern := slices.Clone(components)
+	slices.Sort(pattern)
+	cm.requests.WithLabelValues(strings.Join(pattern, " ")).Inc()
+}
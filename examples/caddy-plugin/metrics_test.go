@@ -0,0 +1,195 @@
+package httpsig
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/remitly-oss/httpsig-go"
+)
+
+// keyIDCounts returns the httpsig_requests_by_keyid_total values by keyid, or nil if the metric is not registered
+func keyIDCounts(t *testing.T, registry *prometheus.Registry) map[string]float64 {
+	t.Helper()
+	return counterValues(t, registry, "httpsig_requests_by_keyid_total", "keyid")
+}
+
+// counterValues returns the values of counter or gauge name by label, or nil if the metric is not registered
+func counterValues(t *testing.T, registry *prometheus.Registry, name, labelName string) map[string]float64 {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		counts := map[string]float64{}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == labelName {
+					counts[label.GetValue()] = m.GetCounter().GetValue() + m.GetGauge().GetValue()
+				}
+			}
+		}
+		return counts
+	}
+	return nil
+}
+
+func TestKeyIDMetrics(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		allowlist []string
+		want      map[string]float64
+	}{
+		{name: "disabled", enabled: false, want: nil},
+		{name: "enabled", enabled: true, want: map[string]float64{testKeyID: 2}},
+		{name: "allowlisted", enabled: true, allowlist: []string{testKeyID}, want: map[string]float64{testKeyID: 2}},
+		{name: "not allowlisted", enabled: true, allowlist: []string{"another-bot"}, want: map[string]float64{otherKeyID: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := prometheus.NewPedanticRegistry()
+			m := &Middleware{}
+			m.validator.Store(newTestValidator(t))
+			if tt.enabled {
+				metrics, err := newKeyIDMetrics(registry, tt.allowlist)
+				if err != nil {
+					t.Fatal(err)
+				}
+				m.metrics = metrics
+			}
+
+			for range 2 {
+				w := httptest.NewRecorder()
+				if err := m.ServeHTTP(w, newSignedRequest(t), okHandler{}); err != nil {
+					t.Fatal(err)
+				}
+				if w.Code != http.StatusOK {
+					t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+				}
+			}
+			// Rejected requests are not counted
+			w := httptest.NewRecorder()
+			if err := m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/", nil), okHandler{}); err != nil {
+				t.Fatal(err)
+			}
+
+			got := keyIDCounts(t, registry)
+			if len(got) != len(tt.want) {
+				t.Fatalf("counts = %v, want %v", got, tt.want)
+			}
+			for keyid, want := range tt.want {
+				if got[keyid] != want {
+					t.Errorf("count[%s] = %v, want %v", keyid, got[keyid], want)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyIDMetricsSharedRegistry(t *testing.T) {
+	registry := prometheus.NewPedanticRegistry()
+	first, err := newKeyIDMetrics(registry, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := newKeyIDMetrics(registry, nil)
+	if err != nil {
+		t.Fatalf("registering twice: %v", err)
+	}
+	first.observe(testKeyID)
+	second.observe(testKeyID)
+	if got := keyIDCounts(t, registry)[testKeyID]; got != 2 {
+		t.Errorf("count = %v, want 2", got)
+	}
+}
+
+func TestComponentMetrics(t *testing.T) {
+	registry := prometheus.NewPedanticRegistry()
+	metrics, err := newComponentMetrics(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Middleware{componentMetrics: metrics}
+	m.validator.Store(newTestValidator(t))
+
+	for _, fields := range [][]string{
+		{"@authority"},
+		{"@authority"},
+		{"@authority", "@path"},
+		{"@path", "@authority"},
+		{"@authority", "@method", "@path"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		signRequestWith(t, r, httpsig.Algo_ED25519, testPrivateKey, testKeyID, fields...)
+		w := httptest.NewRecorder()
+		if err := m.ServeHTTP(w, r, okHandler{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := counterValues(t, registry, "httpsig_requests_by_components_total", "components")
+	want := map[string]float64{"@authority": 2, "@authority @path": 2, "@authority @method @path": 1}
+	if len(got) != len(want) {
+		t.Fatalf("counts = %v, want %v", got, want)
+	}
+	for pattern, count := range want {
+		if got[pattern] != count {
+			t.Errorf("count[%q] = %v, want %v", pattern, got[pattern], count)
+		}
+	}
+}
+
+func TestOutcomeMetrics(t *testing.T) {
+	ctx := newTestContext(t)
+	m := &Middleware{
+		DirectoryBases:   []string{"signer.example.com", "down.example"},
+		StaticKeys:       []json.RawMessage{ed25519JWK(testPrivateKey)},
+		Metrics:          true,
+		OnDirectoryError: "block",
+		Fetcher: fakeFetchers{
+			"signer.example.com": &fakeFetcher{responses: []fakeResponse{{dir: directoryOf(ed25519JWK(testPrivateKey))}}},
+			"down.example":       &fakeFetcher{responses: []fakeResponse{{err: errors.New("unreachable")}}},
+		},
+	}
+	if err := m.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Cleanup() })
+
+	invalid := newSignedRequest(t)
+	invalid.Host = "other.example.com"
+	for _, r := range []*http.Request{newSignedRequest(t), newSignedRequest(t), invalid, httptest.NewRequest(http.MethodGet, "https://example.com/", nil)} {
+		if err := m.ServeHTTP(httptest.NewRecorder(), r, okHandler{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	registry := ctx.GetMetricsRegistry()
+	for _, tt := range []struct {
+		name, label string
+		want        map[string]float64
+	}{
+		{name: "httpsig_requests_total", label: "result", want: map[string]float64{"valid": 2, "invalid": 1, "missing": 1}},
+		{name: "httpsig_directory_fetch_failures_total", label: "directory", want: map[string]float64{"down.example": 1}},
+		{name: "httpsig_keys_loaded", label: "directory", want: map[string]float64{"signer.example.com": 1, "down.example": 0, "static": 1}},
+	} {
+		got := counterValues(t, registry, tt.name, tt.label)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for label, want := range tt.want {
+			if got[label] != want {
+				t.Errorf("%s{%s=%q} = %v, want %v", tt.name, tt.label, label, got[label], want)
+			}
+		}
+	}
+}
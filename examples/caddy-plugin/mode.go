@@ -0,0 +1,23 @@
+package httpsig
+
+import "fmt"
+
+// Mode controls whether the verification outcome is enforced
+type Mode string
+
+const (
+	// ModeEnforce rejects requests without a valid signature, and defers verified ones outside allowed windows
+	ModeEnforce Mode = "enforce"
+	// ModeObserve passes every request on, only recording the outcome in placeholders, logs and the audit log,
+	// to measure the effect of enforcing before rolling it out
+	ModeObserve Mode = "observe"
+)
+
+// ParseMode returns the mode named s
+func ParseMode(s string) (Mode, error) {
+	switch mode := Mode(s); mode {
+	case ModeEnforce, ModeObserve:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown mode %q, must be enforce or observe", s)
+}
@@ -0,0 +1,64 @@
+package httpsig
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestObserveMode(t *testing.T) {
+	// A window that is closed now, so enforcing would defer verified requests
+	now := time.Now().UTC()
+	closed := now.Add(time.Hour).Format("15:04") + "-" + now.Add(2*time.Hour).Format("15:04")
+	m := &Middleware{
+		StaticKeys:     []json.RawMessage{ed25519JWK(testPrivateKey)},
+		Mode:           "observe",
+		AllowedWindows: &AllowedWindows{Ranges: []string{closed}},
+	}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		r         *http.Request
+		wantValid string
+	}{
+		{name: "unsigned", r: httptest.NewRequest(http.MethodGet, "https://example.com/", nil), wantValid: "false"},
+		{name: "outside allowed windows", r: newSignedRequest(t), wantValid: "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, repl := withReplacer(tt.r)
+			w := httptest.NewRecorder()
+			if err := m.ServeHTTP(w, r, okHandler{}); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != http.StatusOK {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if valid, _ := repl.GetString("http.httpsig.valid"); valid != tt.wantValid {
+				t.Errorf("{http.httpsig.valid} = %q, want %q", valid, tt.wantValid)
+			}
+		})
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	if _, err := ParseMode("audit"); err == nil {
+		t.Error("unknown mode accepted")
+	}
+	var m Middleware
+	if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`httpsig {
+		mode observe
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	if mode, err := ParseMode(m.Mode); err != nil || mode != ModeObserve {
+		t.Errorf("mode = %q, %v", mode, err)
+	}
+}
@@ -0,0 +1,54 @@
+package httpsig
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cloudflareresearch/web-bot-auth/go/webbotauth"
+)
+
+// resultKey is the context key of the ValidationResult of verified requests
+type resultKey struct{}
+
+// withResult returns r with result recorded in its context, for ResultFromContext
+func withResult(r *http.Request, result ValidationResult) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), resultKey{}, result))
+}
+
+// ResultFromContext returns the verification result of the request ctx belongs to, as recorded by the Caddy
+// handler and by Handler for requests whose signature was accepted. Go handlers and other Caddy modules after them
+// read the keyid, algorithm, times and directory of the signature from it rather than from placeholders.
+func ResultFromContext(ctx context.Context) (ValidationResult, bool) {
+	result, ok := ctx.Value(resultKey{}).(ValidationResult)
+	return result, ok
+}
+
+// Handler returns a net/http middleware that rejects requests without a valid signature before calling next,
+// which reads the result with ResultFromContext, or the signature alone with webbotauth.ResultFromContext. It is
+// webbotauth.Middleware applying the checks of v rather than those of webbotauth.Verifier.
+//
+// Wrap the whole http.ServeMux rather than handlers registered on it when signatures cover @path.
+// ServeMux pattern matching, including wildcards such as "GET /items/{id}", leaves the request path untouched,
+// but handlers like http.StripPrefix rewrite it, after which @path no longer matches what the bot signed.
+func (v *SignatureValidator) Handler(next http.Handler) http.Handler {
+	return webbotauth.Middleware(next,
+		webbotauth.WithVerifier(func(r *http.Request) (*http.Request, *webbotauth.Result, error) {
+			result, err := v.Validate(r)
+			if err != nil {
+				return r, nil, err
+			}
+			return withResult(r, result), &webbotauth.Result{
+				Label:      result.Label,
+				KeyID:      result.KeyID,
+				Algorithm:  webbotauth.Algorithm(result.Algorithm),
+				Created:    result.Created,
+				Expires:    result.Expires,
+				Nonce:      result.Nonce,
+				Components: result.Components,
+			}, nil
+		}),
+		webbotauth.WithRejection(func(w http.ResponseWriter, r *http.Request, err error) {
+			v.challenge(w)
+			http.Error(w, "Invalid HTTP signature", http.StatusUnauthorized)
+		}))
+}
@@ -0,0 +1,119 @@
+package httpsig
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/cloudflareresearch/web-bot-auth/go/webbotauth"
+	"github.com/remitly-oss/httpsig-go"
+)
+
+// newItemsMux returns a mux with a wildcard route echoing the matched id
+func newItemsMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.PathValue("id"))
+	})
+	return mux
+}
+
+func TestHandlerServeMux(t *testing.T) {
+	v := newTestValidator(t)
+
+	perRoute := http.NewServeMux()
+	perRoute.Handle("GET /items/{id}", v.Handler(newItemsMux()))
+
+	stripped := http.NewServeMux()
+	stripped.Handle("/api/", http.StripPrefix("/api", v.Handler(newItemsMux())))
+
+	api := http.NewServeMux()
+	api.Handle("/api/", http.StripPrefix("/api", newItemsMux()))
+
+	tests := []struct {
+		name     string
+		handler  http.Handler
+		target   string
+		signed   bool
+		wantCode int
+		wantBody string
+	}{
+		{name: "wrapping the mux", handler: v.Handler(newItemsMux()), target: "https://example.com/items/42", signed: true, wantCode: http.StatusOK, wantBody: "42"},
+		{name: "unsigned", handler: v.Handler(newItemsMux()), target: "https://example.com/items/42", wantCode: http.StatusUnauthorized},
+		{name: "wrapping a pattern handler", handler: perRoute, target: "https://example.com/items/42", signed: true, wantCode: http.StatusOK, wantBody: "42"},
+		// StripPrefix rewrites the path before verification, so the signed @path no longer matches
+		{name: "after StripPrefix", handler: stripped, target: "https://example.com/api/items/42", signed: true, wantCode: http.StatusUnauthorized},
+		{name: "before StripPrefix", handler: v.Handler(api), target: "https://example.com/api/items/42", signed: true, wantCode: http.StatusOK, wantBody: "42"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.signed {
+				signRequestWith(t, r, httpsig.Algo_ED25519, testPrivateKey, testKeyID, "@authority", "@path")
+			}
+			w := httptest.NewRecorder()
+			tt.handler.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestResultFromContext(t *testing.T) {
+	var got []ValidationResult
+	record := func(r *http.Request) {
+		if result, ok := ResultFromContext(r.Context()); ok {
+			got = append(got, result)
+		}
+	}
+
+	v := newTestValidator(t)
+	v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { record(r) })).ServeHTTP(httptest.NewRecorder(), newSignedRequest(t))
+
+	m := &Middleware{StaticKeys: []json.RawMessage{ed25519JWK(testPrivateKey)}, AllowUnverified: true}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		record(r)
+		return nil
+	})
+	for _, r := range []*http.Request{newSignedRequest(t), httptest.NewRequest(http.MethodGet, "https://example.com/", nil)} {
+		if err := m.ServeHTTP(httptest.NewRecorder(), r, next); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Unverified requests passed on carry no result
+	if len(got) != 2 {
+		t.Fatalf("results = %+v, want 2", got)
+	}
+	for _, result := range got {
+		if result.KeyID != testKeyID || result.Algorithm != httpsig.Algo_ED25519 || result.Tag != "web-bot-auth" ||
+			result.Created.IsZero() || !result.Expires.After(result.Created) {
+			t.Errorf("result = %+v", result)
+		}
+	}
+}
+
+func TestHandlerLibraryResult(t *testing.T) {
+	var got *webbotauth.Result
+	v := newTestValidator(t)
+	v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = webbotauth.ResultFromContext(r.Context())
+	})).ServeHTTP(httptest.NewRecorder(), newSignedRequest(t))
+
+	if got == nil {
+		t.Fatal("no webbotauth result recorded")
+	}
+	if got.KeyID != testKeyID || got.Algorithm != webbotauth.Ed25519 || got.Created.IsZero() || !got.Expires.After(got.Created) {
+		t.Errorf("result = %+v", got)
+	}
+}
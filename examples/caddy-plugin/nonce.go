@@ -0,0 +1,170 @@
+package httpsig
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrReplayedNonce is returned when a signature reuses a nonce that was already accepted
+var ErrReplayedNonce = errors.New("signature nonce was already used")
+
+// ErrMissingNonce is returned when a nonce is required but a signature does not set one
+var ErrMissingNonce = errors.New("signature has no nonce parameter")
+
+// nonceRetention is how long a nonce is remembered when no expiry is given for it
+const nonceRetention = DefaultMaxSignatureAge
+
+// NonceScope controls the space in which signature nonces must be unique
+type NonceScope string
+
+const (
+	// NonceScopeKeyID requires nonces to be unique per keyid, so bots cannot collide with each other
+	NonceScopeKeyID NonceScope = "keyid"
+	// NonceScopeGlobal requires nonces to be unique across all keyids
+	NonceScopeGlobal NonceScope = "global"
+)
+
+// ParseNonceScope returns the nonce scope named s
+func ParseNonceScope(s string) (NonceScope, error) {
+	switch scope := NonceScope(s); scope {
+	case NonceScopeKeyID, NonceScopeGlobal:
+		return scope, nil
+	}
+	return "", fmt.Errorf("unknown nonce scope %q, must be keyid or global", s)
+}
+
+// DefaultNonceCapacity is the number of nonces MemoryNonceStore remembers by default
+const DefaultNonceCapacity = 100_000
+
+// NonceStoreConfig selects the NonceStore of the middleware
+type NonceStoreConfig struct {
+	// Type is "memory" (default) or "redis"
+	Type string `json:"type,omitempty"`
+	// Capacity is the number of nonces the memory store holds. Defaults to DefaultNonceCapacity.
+	Capacity int `json:"capacity,omitempty"`
+	// URL is the redis:// or rediss:// URL of the Redis store
+	URL string `json:"url,omitempty"`
+}
+
+// newNonceStore returns the store described by config
+func newNonceStore(config NonceStoreConfig) (NonceStore, error) {
+	switch config.Type {
+	case "", "memory":
+		return NewMemoryNonceStore(config.Capacity), nil
+	case "redis":
+		return NewRedisNonceStore(config.URL)
+	}
+	return nil, fmt.Errorf("unknown nonce store %q, must be memory or redis", config.Type)
+}
+
+// NonceStore records the nonces of accepted signatures. A store shared by several Caddy instances,
+// such as RedisNonceStore, rejects replays across all of them.
+type NonceStore interface {
+	// Use records key until expires, reporting false if it is already recorded and has not expired
+	Use(ctx context.Context, key string, expires time.Time) (bool, error)
+}
+
+// NonceCache remembers the nonces of accepted signatures in a NonceStore until they expire, to reject replays.
+// It is safe for concurrent use and can be shared by validators built from successive directory fetches.
+type NonceCache struct {
+	scope NonceScope
+	store NonceStore
+	now   func() time.Time
+}
+
+// NewNonceCache returns a cache backed by a MemoryNonceStore of DefaultNonceCapacity.
+// The scope defaults to NonceScopeKeyID.
+func NewNonceCache(scope NonceScope) *NonceCache {
+	return NewNonceCacheWithStore(scope, NewMemoryNonceStore(0))
+}
+
+// NewNonceCacheWithStore returns a cache backed by store. The scope defaults to NonceScopeKeyID.
+func NewNonceCacheWithStore(scope NonceScope, store NonceStore) *NonceCache {
+	if scope == "" {
+		scope = NonceScopeKeyID
+	}
+	return &NonceCache{scope: scope, store: store, now: time.Now}
+}
+
+// use records nonce for keyid until expires, reporting false if it was already recorded in the cache's scope
+func (c *NonceCache) use(ctx context.Context, keyid, nonce string, expires time.Time) (bool, error) {
+	key := nonce
+	if c.scope == NonceScopeKeyID {
+		key = keyid + " " + nonce
+	}
+	if expires.IsZero() {
+		expires = c.now().Add(nonceRetention)
+	}
+	return c.store.Use(ctx, key, expires)
+}
+
+// MemoryNonceStore is a NonceStore local to the process. Once it holds its capacity of nonces, the one expiring
+// first is evicted to make room: it is the one whose replay window closes soonest, and it could be replayed until
+// then, so size the store above the number of nonces accepted within the signature validity window.
+type MemoryNonceStore struct {
+	capacity int
+	now      func() time.Time
+
+	mu      sync.Mutex
+	seen    map[string]*nonceEntry
+	expires nonceHeap
+}
+
+// nonceEntry is a nonce recorded in MemoryNonceStore
+type nonceEntry struct {
+	key     string
+	expires time.Time
+}
+
+// nonceHeap is a container/heap of the nonces of MemoryNonceStore, the one expiring first at the top
+type nonceHeap []*nonceEntry
+
+func (h nonceHeap) Len() int           { return len(h) }
+func (h nonceHeap) Less(i, j int) bool { return h[i].expires.Before(h[j].expires) }
+func (h nonceHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *nonceHeap) Push(x any)        { *h = append(*h, x.(*nonceEntry)) }
+func (h *nonceHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// NewMemoryNonceStore returns an empty store holding up to capacity nonces, DefaultNonceCapacity when zero
+func NewMemoryNonceStore(capacity int) *MemoryNonceStore {
+	if capacity <= 0 {
+		capacity = DefaultNonceCapacity
+	}
+	return &MemoryNonceStore{capacity: capacity, now: time.Now, seen: map[string]*nonceEntry{}}
+}
+
+// Use implements NonceStore
+func (s *MemoryNonceStore) Use(ctx context.Context, key string, expires time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for len(s.expires) > 0 && !now.Before(s.expires[0].expires) {
+		s.pop()
+	}
+	if _, ok := s.seen[key]; ok {
+		return false, nil
+	}
+	for len(s.seen) >= s.capacity {
+		s.pop()
+	}
+	entry := &nonceEntry{key: key, expires: expires}
+	heap.Push(&s.expires, entry)
+	s.seen[key] = entry
+	return true, nil
+}
+
+// pop deletes the nonce expiring first from the store. It is called with mu held.
+func (s *MemoryNonceStore) pop() {
+	entry := heap.Pop(&s.expires).(*nonceEntry)
+	delete(s.seen, entry.key)
+}
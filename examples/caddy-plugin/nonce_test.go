@@ -0,0 +1,207 @@
+package httpsig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/remitly-oss/httpsig-go"
+)
+
+// mustUse records nonce for keyid in cache, failing the test on store errors
+func mustUse(t *testing.T, cache *NonceCache, keyid, nonce string, expires time.Time) bool {
+	t.Helper()
+	fresh, err := cache.use(context.Background(), keyid, nonce, expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fresh
+}
+
+func TestNonceScope(t *testing.T) {
+	expires := time.Now().Add(time.Minute)
+	tests := []struct {
+		scope      NonceScope
+		wantSecond bool
+	}{
+		{scope: NonceScopeKeyID, wantSecond: true},
+		{scope: NonceScopeGlobal, wantSecond: false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.scope), func(t *testing.T) {
+			cache := NewNonceCache(tt.scope)
+			if !mustUse(t, cache, "bot-a", "nonce", expires) {
+				t.Fatal("first use of the nonce was rejected")
+			}
+			if got := mustUse(t, cache, "bot-b", "nonce", expires); got != tt.wantSecond {
+				t.Errorf("same nonce from another keyid accepted = %v, want %v", got, tt.wantSecond)
+			}
+			if mustUse(t, cache, "bot-a", "nonce", expires) {
+				t.Error("nonce replayed by the same keyid was accepted")
+			}
+		})
+	}
+}
+
+func TestMemoryNonceStoreExpiry(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryNonceStore(0)
+	store.now = func() time.Time { return now }
+	cache := NewNonceCacheWithStore("", store)
+
+	if !mustUse(t, cache, testKeyID, "nonce", now.Add(time.Minute)) {
+		t.Fatal("first use of the nonce was rejected")
+	}
+	now = now.Add(2 * time.Minute)
+	if !mustUse(t, cache, testKeyID, "nonce", now.Add(time.Minute)) {
+		t.Error("nonce of an expired signature was still remembered")
+	}
+	if len(store.seen) != 1 {
+		t.Errorf("store holds %d nonces, want 1", len(store.seen))
+	}
+}
+
+func TestMemoryNonceStoreCapacity(t *testing.T) {
+	cache := NewNonceCacheWithStore("", NewMemoryNonceStore(2))
+	now := time.Now()
+	uses := []struct {
+		nonce   string
+		expires time.Time
+	}{{"a", now.Add(2 * time.Minute)}, {"b", now.Add(time.Minute)}, {"c", now.Add(3 * time.Minute)}}
+	for _, use := range uses {
+		if !mustUse(t, cache, testKeyID, use.nonce, use.expires) {
+			t.Fatalf("first use of nonce %s was rejected", use.nonce)
+		}
+	}
+	// The nonce expiring first was evicted to make room, although it was not recorded first
+	if mustUse(t, cache, testKeyID, "a", now.Add(2*time.Minute)) {
+		t.Error("nonce expiring later replayed")
+	}
+	if !mustUse(t, cache, testKeyID, "b", now.Add(time.Minute)) {
+		t.Error("evicted nonce still rejected")
+	}
+}
+
+func TestValidateReplayedNonce(t *testing.T) {
+	v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{Nonces: NewNonceCache("")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	err = httpsig.Sign(r, httpsig.SigningProfile{
+		Algorithm: httpsig.Algo_ED25519,
+		Fields:    httpsig.Fields("@authority"),
+		Metadata:  []httpsig.Metadata{httpsig.MetaCreated, httpsig.MetaExpires, httpsig.MetaKeyID, httpsig.MetaNonce, httpsig.MetaTag},
+	}, httpsig.SigningKey{Key: testPrivateKey, MetaKeyID: testKeyID, MetaTag: "web-bot-auth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := v.Validate(r); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	if _, err := v.Validate(r); !errors.Is(err, ErrReplayedNonce) {
+		t.Errorf("replayed request: err = %v, want %v", err, ErrReplayedNonce)
+	}
+	// Signatures without a nonce cannot be told apart from replays and are accepted
+	for range 2 {
+		if _, err := v.Validate(newSignedRequest(t)); err != nil {
+			t.Errorf("request without nonce: %v", err)
+		}
+	}
+}
+
+func TestReplayedNonceWithinExpiresGrace(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+	store := NewMemoryNonceStore(0)
+	store.now = clock
+	v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{
+		Nonces:       NewNonceCacheWithStore("", store),
+		ExpiresGrace: 30 * time.Second,
+		Now:          clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	if err := httpsig.Sign(r, httpsig.SigningProfile{
+		Algorithm: httpsig.Algo_ED25519,
+		Fields:    httpsig.Fields("@authority"),
+		Metadata:  []httpsig.Metadata{httpsig.MetaCreated, httpsig.MetaExpires, httpsig.MetaKeyID, httpsig.MetaNonce, httpsig.MetaTag},
+	}, httpsig.SigningKey{Key: testPrivateKey, MetaKeyID: testKeyID, MetaTag: "web-bot-auth"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Validate(r); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	// The signature expired five minutes after signing, but is still accepted within the grace
+	now = now.Add(5*time.Minute + 10*time.Second)
+	if _, err := v.Validate(r); !errors.Is(err, ErrReplayedNonce) {
+		t.Errorf("replayed within the expires grace: err = %v, want %v", err, ErrReplayedNonce)
+	}
+}
+
+func TestRequireNonce(t *testing.T) {
+	v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{RequireNonce: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Validate(newSignedRequest(t)); !errors.Is(err, ErrMissingNonce) {
+		t.Errorf("request without nonce: err = %v, want %v", err, ErrMissingNonce)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	if err := httpsig.Sign(r, httpsig.SigningProfile{
+		Algorithm: httpsig.Algo_ED25519,
+		Fields:    httpsig.Fields("@authority"),
+		Metadata:  []httpsig.Metadata{httpsig.MetaCreated, httpsig.MetaExpires, httpsig.MetaKeyID, httpsig.MetaNonce, httpsig.MetaTag},
+	}, httpsig.SigningKey{Key: testPrivateKey, MetaKeyID: testKeyID, MetaTag: "web-bot-auth"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Validate(r); err != nil {
+		t.Errorf("request with nonce: %v", err)
+	}
+
+	var m Middleware
+	if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\n\trequire_nonce\n}")); err != nil {
+		t.Fatal(err)
+	}
+	if !m.RequireNonce {
+		t.Error("require_nonce not set")
+	}
+}
+
+func TestUnmarshalNonceStore(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    NonceStoreConfig
+		wantErr bool
+	}{
+		{line: "nonce_store memory", want: NonceStoreConfig{Type: "memory"}},
+		{line: "nonce_store memory 500", want: NonceStoreConfig{Type: "memory", Capacity: 500}},
+		{line: "nonce_store redis redis://cache:6379", want: NonceStoreConfig{Type: "redis", URL: "redis://cache:6379"}},
+		{line: "nonce_store redis", wantErr: true},
+		{line: "nonce_store memory lots", wantErr: true},
+		{line: "nonce_store disk /tmp", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			var m Middleware
+			err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\n" + tt.line + "\n}"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && *m.NonceStore != tt.want {
+				t.Errorf("nonce_store = %+v, want %+v", *m.NonceStore, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package httpsig
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// defaultPersistDirectoriesDir is where persist_directories keeps directories, under Caddy's data directory
+func defaultPersistDirectoriesDir() string {
+	return filepath.Join(caddy.AppDataDir(), "httpsig", "directories")
+}
+
+// directoryStore keeps the last directory fetched from each directory_base on disk, so that a restart while a
+// directory host is down starts with the keys it last served
+type directoryStore struct {
+	dir string
+}
+
+// path returns the file of base, named by its hash since directory_base may be a URL
+func (s *directoryStore) path(base string) string {
+	sum := sha256.Sum256([]byte(base))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// save writes dir as the last known good directory of base. The file is replaced atomically, so a crash mid-write
+// keeps the previous copy.
+func (s *directoryStore) save(base string, dir cachedDirectory) error {
+	data, err := json.Marshal(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(s.dir, ".directory-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path(base))
+}
+
+// load returns the last known good directory of base
+func (s *directoryStore) load(base string) (cachedDirectory, error) {
+	data, err := os.ReadFile(s.path(base))
+	if err != nil {
+		return cachedDirectory{}, err
+	}
+	var dir cachedDirectory
+	if err := json.Unmarshal(data, &dir); err != nil {
+		return cachedDirectory{}, fmt.Errorf("decoding persisted directory %s: %w", base, err)
+	}
+	if dir.Directory.Keys == nil {
+		return cachedDirectory{}, fmt.Errorf("decoding persisted directory %s: no keys member", base)
+	}
+	return dir, nil
+}
+
+// knownDirectories holds the last directory loaded from each directory_base by the configs of this process. An entry
+// lives while a config using its directory runs, so that a config reload while a directory host is down starts with
+// the keys the previous config served, even without persist_directories.
+type knownDirectories struct {
+	mu   sync.Mutex
+	dirs map[string]*knownDirectory
+}
+
+// knownDirectory is the last directory loaded from a directory_base, and the contexts of the configs using it
+type knownDirectory struct {
+	users  []context.Context
+	dir    cachedDirectory
+	loaded bool
+}
+
+// lastKnownDirectories are the directories loaded by any config of this process
+var lastKnownDirectories = &knownDirectories{}
+
+// acquire keeps the directories of bases while ctx, that of a config using them, is not done
+func (k *knownDirectories) acquire(ctx context.Context, bases []string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.dirs == nil {
+		k.dirs = map[string]*knownDirectory{}
+	}
+	for _, base := range bases {
+		known := k.get(base)
+		if known == nil {
+			known = &knownDirectory{}
+			k.dirs[base] = known
+		}
+		known.users = append(known.users, ctx)
+	}
+}
+
+// get returns the entry of base, dropping it once every config using it is done. It is called with mu held.
+func (k *knownDirectories) get(base string) *knownDirectory {
+	known := k.dirs[base]
+	if known == nil {
+		return nil
+	}
+	known.users = slices.DeleteFunc(known.users, func(ctx context.Context) bool { return ctx.Err() != nil })
+	if len(known.users) == 0 {
+		delete(k.dirs, base)
+		return nil
+	}
+	return known
+}
+
+// save records dir as the last directory loaded from base, when a config using base runs
+func (k *knownDirectories) save(base string, dir cachedDirectory) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if known := k.get(base); known != nil {
+		known.dir, known.loaded = dir, true
+	}
+}
+
+// load returns the last directory loaded from base, if any
+func (k *knownDirectories) load(base string) (cachedDirectory, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	known := k.get(base)
+	if known == nil || !known.loaded {
+		return cachedDirectory{}, false
+	}
+	return known.dir, true
+}
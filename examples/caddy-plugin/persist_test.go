@@ -0,0 +1,63 @@
+package httpsig
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestPersistDirectories(t *testing.T) {
+	dir := t.TempDir()
+	fetcher := &fakeFetcher{responses: []fakeResponse{{dir: directoryOf(ed25519JWK(testPrivateKey))}}}
+	m := &Middleware{DirectoryBase: "signer.example.com", Fetcher: fetcher, PersistDirectories: true, PersistDirectoriesDir: dir}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("persisted files = %v, want 1", files)
+	}
+
+	// A restart while the directory is down starts with the persisted keys, and keeps retrying
+	fetcher = &fakeFetcher{responses: []fakeResponse{
+		{err: errors.New("unreachable")},
+		{dir: directoryOf(ed25519JWK(testPrivateKey))},
+	}}
+	m = &Middleware{DirectoryBase: "signer.example.com", Fetcher: fetcher, PersistDirectories: true, PersistDirectoriesDir: dir}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.validate(newSignedRequest(t)); err != nil {
+		t.Errorf("persisted key: %v", err)
+	}
+	if status, _ := m.DirectoryStatus("signer.example.com"); !status.Stale || status.Keys != 1 || status.FetchedAt.IsZero() {
+		t.Errorf("status = %+v, want stale", status)
+	}
+	if err := m.refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := m.DirectoryStatus("signer.example.com"); status.Stale {
+		t.Errorf("status = %+v after a successful refresh", status)
+	}
+
+	// Without a persisted copy, a directory that fails to load still fails provisioning
+	m = &Middleware{DirectoryBase: "other.example.com", Fetcher: &fakeFetcher{responses: []fakeResponse{{err: errors.New("unreachable")}}}, PersistDirectories: true, PersistDirectoriesDir: dir}
+	if err := m.Provision(newTestContext(t)); err == nil {
+		t.Error("provisioned with an unreachable directory")
+	}
+}
+
+func TestPersistDirectoriesCaddyfile(t *testing.T) {
+	var m Middleware
+	if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\npersist_directories /var/lib/httpsig\n}")); err != nil {
+		t.Fatal(err)
+	}
+	if !m.PersistDirectories || m.PersistDirectoriesDir != "/var/lib/httpsig" {
+		t.Errorf("persist_directories = %v, %q", m.PersistDirectories, m.PersistDirectoriesDir)
+	}
+	if err := (&Middleware{}).UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\npersist_directories a b\n}")); err == nil {
+		t.Error("persist_directories with two paths accepted")
+	}
+}
@@ -0,0 +1,39 @@
+package httpsig
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnpinnedKey is returned when a directory with pinned keys serves a key whose thumbprint is not pinned. The
+// directory is not loaded, so a taken over directory host cannot introduce keys.
+var ErrUnpinnedKey = errors.New("directory serves a key that is not pinned")
+
+// checkPinnedKeys rejects keys of base whose thumbprint is not pinned for it. Directories without pins accept any key.
+func checkPinnedKeys(pins map[string][]string, base string, keys []keySpec) error {
+	pinned, ok := pinsOf(pins, base)
+	if !ok {
+		return nil
+	}
+	for _, key := range keys {
+		if _, ok := pinned[key.Thumbprint]; !ok {
+			return fmt.Errorf("%w: %s serves %s", ErrUnpinnedKey, base, key.Thumbprint)
+		}
+	}
+	return nil
+}
+
+// pinsOf returns the thumbprints pinned for base, matching directories the way Signature-Agent does
+func pinsOf(pins map[string][]string, base string) (map[string]struct{}, bool) {
+	for directory, thumbprints := range pins {
+		if !sameDirectory(directory, base) {
+			continue
+		}
+		pinned := make(map[string]struct{}, len(thumbprints))
+		for _, thumbprint := range thumbprints {
+			pinned[thumbprint] = struct{}{}
+		}
+		return pinned, true
+	}
+	return nil, false
+}
@@ -0,0 +1,65 @@
+package httpsig
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestPinnedKeys(t *testing.T) {
+	otherKey, otherJWK, otherKeyID := generateKey(t)
+	signer := &fakeFetcher{responses: []fakeResponse{
+		{dir: directoryOf(ed25519JWK(testPrivateKey))},
+		{dir: directoryOf(ed25519JWK(testPrivateKey), otherJWK)},
+	}}
+	m := &Middleware{
+		DirectoryBase:       "signer.example.com",
+		DiscoverDirectories: true,
+		PinnedKeys: map[string][]string{
+			"signer.example.com":  {testKeyID},
+			"https://bot.example": {testKeyID},
+		},
+		Fetcher: fakeFetchers{
+			"signer.example.com":  signer,
+			"https://bot.example": {responses: []fakeResponse{{dir: directoryOf(otherJWK)}}},
+		},
+	}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory suddenly serving another key is not loaded, and its pinned key keeps verifying
+	if err := m.refresh(context.Background()); !errors.Is(err, ErrUnpinnedKey) {
+		t.Errorf("refresh: err = %v, want ErrUnpinnedKey", err)
+	}
+	if _, err := m.validate(newSignedRequest(t)); err != nil {
+		t.Errorf("pinned key: %v", err)
+	}
+	if _, err := m.validate(agentRequest(t, otherKey, otherKeyID, `"https://signer.example.com"`)); err == nil {
+		t.Error("unpinned key verified")
+	}
+	if _, err := m.validate(agentRequest(t, otherKey, otherKeyID, `"https://bot.example"`)); !errors.Is(err, ErrUnpinnedKey) {
+		t.Errorf("discovered directory: err = %v, want ErrUnpinnedKey", err)
+	}
+
+	for name, pins := range map[string]map[string][]string{
+		"no thumbprints": {"signer.example.com": nil},
+		"unknown":        {"other.example": {testKeyID}},
+		"invalid":        {"https://": {testKeyID}},
+	} {
+		m := &Middleware{DirectoryBase: "signer.example.com", PinnedKeys: pins, Fetcher: &fakeFetcher{responses: []fakeResponse{{dir: directoryOf(ed25519JWK(testPrivateKey))}}}}
+		if err := m.Provision(newTestContext(t)); err == nil {
+			t.Errorf("%s pins accepted", name)
+		}
+	}
+
+	var parsed Middleware
+	if err := parsed.UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\npin_keys signer.example.com a b\npin_keys signer.example.com c\n}")); err != nil {
+		t.Fatal(err)
+	}
+	if got := parsed.PinnedKeys["signer.example.com"]; len(got) != 3 {
+		t.Errorf("pin_keys = %v", parsed.PinnedKeys)
+	}
+}
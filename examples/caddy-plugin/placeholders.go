@@ -0,0 +1,60 @@
+package httpsig
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// setPlaceholders exposes the verification outcome of r to the handlers after the middleware, for matching
+// with map, vars or expression:
+//
+//	{http.httpsig.valid}    true when the signature was accepted, false otherwise
+//	{http.httpsig.keyid}    keyid of the verifying key
+//	{http.httpsig.label}    label of the verifying signature
+//	{http.httpsig.purpose}  purpose published by the directory the key comes from
+//	{http.httpsig.agent}    directory_base or Signature-Agent the verifying key was loaded from, empty for static keys
+//	{http.httpsig.reason}   why the signature was rejected, empty when valid
+//	{http.httpsig.error}    kind of that rejection, from ErrorKind, such as no_signature or invalid_signature
+//	{http.httpsig.bot}      name of the bot the verifying key belongs to, empty when it is not a named bot
+//	{http.httpsig.bypass}   true when that bot may bypass other protections
+//
+// {http.handlers.httpsig.verified}, {http.handlers.httpsig.keyid} and {http.handlers.httpsig.agent} are aliases of
+// valid, keyid and agent, under the namespace of the handler module.
+//
+// Only requests passed on with allow_unverified or in observe mode reach later handlers with valid set to false.
+func setPlaceholders(r *http.Request, result ValidationResult, err error) {
+	repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		return
+	}
+	repl.Set("http.httpsig.valid", strconv.FormatBool(err == nil))
+	repl.Set("http.httpsig.keyid", result.KeyID)
+	repl.Set("http.httpsig.label", result.Label)
+	repl.Set("http.httpsig.purpose", result.Purpose)
+	repl.Set("http.httpsig.agent", result.Directory)
+	repl.Set("http.handlers.httpsig.verified", strconv.FormatBool(err == nil))
+	repl.Set("http.handlers.httpsig.keyid", result.KeyID)
+	repl.Set("http.handlers.httpsig.agent", result.Directory)
+	reason := ""
+	if err != nil {
+		reason = err.Error()
+	}
+	repl.Set("http.httpsig.reason", reason)
+	repl.Set("http.httpsig.error", ErrorKind(err))
+}
+
+// setBotPlaceholders exposes the named bot that signed r, from matchBot, to the handlers after the middleware
+func setBotPlaceholders(r *http.Request, bot *Bot) {
+	repl, ok := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		return
+	}
+	name, bypass := "", false
+	if bot != nil {
+		name, bypass = bot.Name, bot.Bypass
+	}
+	repl.Set("http.httpsig.bot", name)
+	repl.Set("http.httpsig.bypass", strconv.FormatBool(bypass))
+}
@@ -0,0 +1,107 @@
+package httpsig
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// withReplacer returns r with a replacer in its context, as Caddy sets up for each request
+func withReplacer(r *http.Request) (*http.Request, *caddy.Replacer) {
+	repl := caddy.NewReplacer()
+	return r.WithContext(context.WithValue(r.Context(), caddy.ReplacerCtxKey, repl)), repl
+}
+
+func TestPlaceholders(t *testing.T) {
+	purpose := "ai-training"
+	m := &Middleware{
+		DirectoryBase:   "signer.example.com",
+		AllowUnverified: true,
+		Fetcher:         &fakeFetcher{responses: []fakeResponse{{dir: Directory{Keys: []json.RawMessage{ed25519JWK(testPrivateKey)}, Purpose: &purpose}}}},
+	}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := newSignedRequest(t)
+	invalid.Host = "other.example.com"
+	labeled := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	signLabeled(t, labeled, "bot-sig")
+
+	tests := []struct {
+		name       string
+		r          *http.Request
+		want       map[string]string
+		wantReason bool
+	}{
+		{
+			name: "valid",
+			r:    labeled,
+			want: map[string]string{"valid": "true", "keyid": testKeyID, "label": "bot-sig", "purpose": purpose, "agent": "signer.example.com", "reason": ""},
+		},
+		{
+			name:       "invalid",
+			r:          invalid,
+			want:       map[string]string{"valid": "false", "keyid": "", "purpose": "", "agent": ""},
+			wantReason: true,
+		},
+		{
+			name:       "unsigned",
+			r:          httptest.NewRequest(http.MethodGet, "https://example.com/", nil),
+			want:       map[string]string{"valid": "false", "keyid": "", "label": ""},
+			wantReason: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, repl := withReplacer(tt.r)
+			called := false
+			next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+				called = true
+				return nil
+			})
+			if err := m.ServeHTTP(httptest.NewRecorder(), r, next); err != nil {
+				t.Fatal(err)
+			}
+			if !called {
+				t.Fatal("next handler not called with allow_unverified")
+			}
+			for name, want := range tt.want {
+				if got, _ := repl.GetString("http.httpsig." + name); got != want {
+					t.Errorf("{http.httpsig.%s} = %q, want %q", name, got, want)
+				}
+			}
+			// The aliases in the namespace of the handler module match
+			for alias, name := range map[string]string{"verified": "valid", "keyid": "keyid", "agent": "agent"} {
+				got, _ := repl.GetString("http.handlers.httpsig." + alias)
+				if want, _ := repl.GetString("http.httpsig." + name); got != want {
+					t.Errorf("{http.handlers.httpsig.%s} = %q, want %q", alias, got, want)
+				}
+			}
+			if reason, _ := repl.GetString("http.httpsig.reason"); (reason != "") != tt.wantReason {
+				t.Errorf("{http.httpsig.reason} = %q", reason)
+			}
+		})
+	}
+}
+
+func TestAllowUnverifiedDisabled(t *testing.T) {
+	m := &Middleware{}
+	m.validator.Store(newTestValidator(t))
+	r, repl := withReplacer(httptest.NewRequest(http.MethodGet, "https://example.com/", nil))
+	w := httptest.NewRecorder()
+	if err := m.ServeHTTP(w, r, okHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if valid, _ := repl.GetString("http.httpsig.valid"); valid != "false" {
+		t.Errorf("{http.httpsig.valid} = %q, want false", valid)
+	}
+}
@@ -0,0 +1,129 @@
+package httpsig
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	sfv "github.com/dunglas/httpsfv"
+	"github.com/remitly-oss/httpsig-go"
+)
+
+// DefaultRequiredFields are the components a signature must cover when no others are configured
+var DefaultRequiredFields = []string{"@authority"}
+
+// requestComponents are the RFC 9421 derived components a request signature can be required to cover.
+// @query-param is left out, as it needs a name parameter.
+var requestComponents = []string{"@method", "@target-uri", "@authority", "@scheme", "@request-target", "@path", "@query"}
+
+// checkComponent rejects names that are neither a request derived component nor a header field name, such as a
+// misspelled derived component, which no signature could cover
+func checkComponent(name string) error {
+	if strings.HasPrefix(name, "@") {
+		if !slices.Contains(requestComponents, name) {
+			return fmt.Errorf("unknown derived component %q", name)
+		}
+		return nil
+	}
+	if name == "" || strings.ContainsFunc(name, func(r rune) bool {
+		return r <= ' ' || r >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r)
+	}) {
+		return fmt.Errorf("%q is not a header field name", name)
+	}
+	return nil
+}
+
+// challengeLabel is the signature label requested in Accept-Signature
+const challengeLabel = "sig1"
+
+// ChallengeMode controls which challenge headers are sent with rejections
+type ChallengeMode string
+
+const (
+	// ChallengeBoth sends Accept-Signature and a WWW-Authenticate challenge
+	ChallengeBoth ChallengeMode = "both"
+	// ChallengeAcceptSignature only sends Accept-Signature, for sites whose clients react to WWW-Authenticate
+	ChallengeAcceptSignature ChallengeMode = "accept-signature"
+	// ChallengeOff sends no challenge, not revealing what signatures are accepted
+	ChallengeOff ChallengeMode = "off"
+)
+
+// ParseChallengeMode returns the challenge mode named s
+func ParseChallengeMode(s string) (ChallengeMode, error) {
+	switch mode := ChallengeMode(s); mode {
+	case ChallengeBoth, ChallengeAcceptSignature, ChallengeOff:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown challenge mode %q, must be both, accept-signature or off", s)
+}
+
+// VerifyProfile describes the signatures a SignatureValidator accepts
+type VerifyProfile struct {
+	// RequiredFields are the components every signature must cover
+	RequiredFields []string
+	// AllowedAlgorithms are the algorithms signatures may use
+	AllowedAlgorithms []httpsig.Algorithm
+	// Tag is the tag signatures must carry, empty when tags are not enforced
+	Tag string
+}
+
+// Profile returns what v requires of signatures
+func (v *SignatureValidator) Profile() VerifyProfile {
+	profile := VerifyProfile{
+		RequiredFields:    slices.Clone(v.required),
+		AllowedAlgorithms: slices.Clone(v.allowed),
+	}
+	if v.tag != TagOff {
+		profile.Tag = webBotAuthTag
+	}
+	return profile
+}
+
+// ChallengeHeaders returns the Accept-Signature field of RFC 9421 Section 5.1 and a WWW-Authenticate challenge
+// asking for signatures that satisfy p. They are sent with rejections so that what is asked for matches what is enforced.
+// The algorithm is only requested in Accept-Signature when exactly one is allowed, as the field cannot offer a choice.
+func (p VerifyProfile) ChallengeHeaders() http.Header {
+	components := sfv.InnerList{Params: sfv.NewParams()}
+	for _, field := range p.RequiredFields {
+		components.Items = append(components.Items, sfv.NewItem(field))
+	}
+	components.Params.Add("created", true)
+	if p.Tag != "" {
+		components.Params.Add("tag", p.Tag)
+	}
+	if len(p.AllowedAlgorithms) == 1 {
+		components.Params.Add("alg", string(p.AllowedAlgorithms[0]))
+	}
+	accept := sfv.NewDictionary()
+	accept.Add(challengeLabel, components)
+
+	h := http.Header{}
+	if value, err := sfv.Marshal(accept); err == nil {
+		h.Set("Accept-Signature", value)
+	}
+
+	algorithms := make([]string, len(p.AllowedAlgorithms))
+	for i, algo := range p.AllowedAlgorithms {
+		algorithms[i] = string(algo)
+	}
+	challenge := `Signature algorithms="` + strings.Join(algorithms, " ") + `"`
+	if p.Tag != "" {
+		challenge += `, tag="` + p.Tag + `"`
+	}
+	h.Set("WWW-Authenticate", challenge)
+	return h
+}
+
+// challenge adds the challenge headers for v to a rejection, as selected by its challenge mode
+func (v *SignatureValidator) challenge(w http.ResponseWriter) {
+	if v.challengeMode == ChallengeOff {
+		return
+	}
+	for name, values := range v.Profile().ChallengeHeaders() {
+		if name == "Www-Authenticate" && v.challengeMode == ChallengeAcceptSignature {
+			continue
+		}
+		w.Header()[name] = values
+	}
+}
@@ -0,0 +1,136 @@
+package httpsig
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/remitly-oss/httpsig-go"
+)
+
+func TestChallengeHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       ValidatorOptions
+		wantAccept string
+		wantAuth   string
+	}{
+		{
+			name:       "default",
+			wantAccept: `sig1=("@authority");created;tag="web-bot-auth";alg="ed25519"`,
+			wantAuth:   `Signature algorithms="ed25519", tag="web-bot-auth"`,
+		},
+		{
+			name: "customized",
+			opts: ValidatorOptions{
+				RequiredFields:    []string{"@authority", "@Path", "content-digest"},
+				AllowedAlgorithms: []httpsig.Algorithm{httpsig.Algo_ED25519, httpsig.Algo_ECDSA_P256_SHA256},
+				TagEnforcement:    TagOff,
+			},
+			wantAccept: `sig1=("@authority" "@path" "content-digest");created`,
+			wantAuth:   `Signature algorithms="ed25519 ecdsa-p256-sha256"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := NewValidator(nil, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := v.Profile().ChallengeHeaders()
+			if got := h.Get("Accept-Signature"); got != tt.wantAccept {
+				t.Errorf("Accept-Signature = %s, want %s", got, tt.wantAccept)
+			}
+			if got := h.Get("WWW-Authenticate"); got != tt.wantAuth {
+				t.Errorf("WWW-Authenticate = %s, want %s", got, tt.wantAuth)
+			}
+		})
+	}
+}
+
+func TestRequiredFields(t *testing.T) {
+	v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{RequiredFields: []string{"@authority", "@path"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	signRequestWith(t, r, httpsig.Algo_ED25519, testPrivateKey, testKeyID, "@authority", "@path")
+	if _, err := v.Validate(r); err != nil {
+		t.Errorf("signature covering the required fields: %v", err)
+	}
+
+	if _, err := v.Validate(newSignedRequest(t)); !errors.Is(err, ErrMissingComponent) {
+		t.Errorf("signature without @path: err = %v, want %v", err, ErrMissingComponent)
+	}
+}
+
+func TestRequiredFieldsInvalid(t *testing.T) {
+	for _, opts := range []ValidatorOptions{
+		{RequiredFields: []string{"@authority", "@pth"}},
+		{RequiredFields: []string{"content digest"}},
+		{RequiredFields: []string{"@status"}},
+		{DisallowedFields: []string{"@signature-params"}},
+		{RequiredFields: []string{"@authority", "@query"}, DisallowedFields: []string{"@Query"}},
+	} {
+		if _, err := NewValidator(nil, opts); err == nil {
+			t.Errorf("%+v accepted", opts)
+		}
+	}
+}
+
+func TestRejectionChallenge(t *testing.T) {
+	m := &Middleware{}
+	m.validator.Store(newTestValidator(t))
+
+	w := httptest.NewRecorder()
+	if err := m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/", nil), okHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	want := m.validator.Load().Profile().ChallengeHeaders()
+	for _, name := range []string{"Accept-Signature", "WWW-Authenticate"} {
+		if got := w.Header().Get(name); got != want.Get(name) {
+			t.Errorf("%s = %q, want %q", name, got, want.Get(name))
+		}
+	}
+}
+
+func TestChallengeMode(t *testing.T) {
+	tests := []struct {
+		mode       string
+		wantAccept bool
+		wantAuth   bool
+	}{
+		{mode: "", wantAccept: true, wantAuth: true},
+		{mode: "both", wantAccept: true, wantAuth: true},
+		{mode: "accept-signature", wantAccept: true},
+		{mode: "off"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			m := &Middleware{StaticKeys: []json.RawMessage{ed25519JWK(testPrivateKey)}, Challenge: tt.mode}
+			if err := m.Provision(newTestContext(t)); err != nil {
+				t.Fatal(err)
+			}
+			w := httptest.NewRecorder()
+			if err := m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/", nil), okHandler{}); err != nil {
+				t.Fatal(err)
+			}
+			if got := w.Header().Get("Accept-Signature") != ""; got != tt.wantAccept {
+				t.Errorf("Accept-Signature sent = %v, want %v", got, tt.wantAccept)
+			}
+			if got := w.Header().Get("WWW-Authenticate") != ""; got != tt.wantAuth {
+				t.Errorf("WWW-Authenticate sent = %v, want %v", got, tt.wantAuth)
+			}
+		})
+	}
+
+	if _, err := ParseChallengeMode("loud"); err == nil {
+		t.Error("ParseChallengeMode accepted an unknown mode")
+	}
+}
@@ -0,0 +1,64 @@
+package httpsig
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// redisTimeout bounds dialing and each Redis command, unless the URL sets its own timeouts
+	redisTimeout = 2 * time.Second
+	// redisIdleConns is the number of connections kept open between commands
+	redisIdleConns = 16
+)
+
+// openRedis returns a go-redis client for the Redis server at rawURL, such as redis://:password@host:6379/0, and the
+// prefix namespacing its keys. rediss:// connects with TLS. The prefix query parameter replaces prefix, and the
+// other query parameters are the options of redis.ParseURL.
+func openRedis(rawURL, prefix string) (*redis.Client, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("parsing redis URL: %w", err)
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, "", fmt.Errorf("redis URL must use redis or rediss, got %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, "", fmt.Errorf("redis URL %q has no host", u.Redacted())
+	}
+	query := u.Query()
+	if p := query.Get("prefix"); p != "" {
+		prefix = p
+	}
+	query.Del("prefix")
+	u.RawQuery = query.Encode()
+	opts, err := redis.ParseURL(u.String())
+	if err != nil {
+		return nil, "", fmt.Errorf("parsing redis URL: %w", err)
+	}
+	// Commands are bounded by the context of the request they are for, and by redisTimeout
+	opts.ContextTimeoutEnabled = true
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = redisTimeout
+	}
+	if opts.ReadTimeout == 0 {
+		opts.ReadTimeout = redisTimeout
+	}
+	if opts.WriteTimeout == 0 {
+		opts.WriteTimeout = redisTimeout
+	}
+	// Requests wait on Redis, and fall back or fail rather than wait for retries when it is unavailable
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = -1
+	}
+	if opts.DialerRetries == 0 {
+		opts.DialerRetries = 1
+	}
+	if opts.MaxIdleConns == 0 {
+		opts.MaxIdleConns = redisIdleConns
+	}
+	return redis.NewClient(opts), prefix, nil
+}
@@ -0,0 +1,180 @@
+package httpsig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultDirectoryCacheTTL is how long a directory stays in a shared cache when directory_cache sets no TTL
+	DefaultDirectoryCacheTTL = 5 * time.Minute
+	// defaultRedisDirectoryPrefix namespaces the directory keys in Redis
+	defaultRedisDirectoryPrefix = "httpsig:directory:"
+	// redisDirectoryPoll is how often an instance waiting for another one's fetch checks the cache
+	redisDirectoryPoll = 100 * time.Millisecond
+)
+
+// DirectoryCacheConfig selects the cache directories are shared through between Caddy instances
+type DirectoryCacheConfig struct {
+	// Type is "redis", the only shared cache
+	Type string `json:"type,omitempty"`
+	// URL is the redis:// or rediss:// URL of the Redis cache
+	URL string `json:"url,omitempty"`
+	// TTL is how long a fetched directory is shared. Defaults to DefaultDirectoryCacheTTL.
+	TTL caddy.Duration `json:"ttl,omitempty"`
+}
+
+// cachedDirectory is a directory as stored in the shared cache and by persist_directories
+type cachedDirectory struct {
+	Directory Directory `json:"directory"`
+	URL       string    `json:"url"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// RedisDirectoryCache is a DirectoryFetcher sharing the directories of Fetcher through Redis, so that a fleet of
+// Caddy instances fetches each directory once per TTL instead of once per instance. The instance that misses takes
+// a lock for the fetch, and the others wait for its result. Keys are parsed by each instance.
+//
+// Directories are stored after Fetcher verified them, so instances sharing a cache must share the directory
+// settings, or use their own prefix. When Redis fails, directories are fetched directly.
+type RedisDirectoryCache struct {
+	// Fetcher retrieves the directories missing from the cache
+	Fetcher DirectoryFetcher
+	// TTL is how long a directory is shared. Defaults to DefaultDirectoryCacheTTL.
+	TTL time.Duration
+	// Logger receives Redis failures. Defaults to a no-op logger.
+	Logger *zap.Logger
+
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisDirectoryCache returns a cache of the directories of fetcher in the Redis server at rawURL, such as
+// redis://:password@host:6379/0. rediss:// connects with TLS. The prefix query parameter replaces the default
+// httpsig:directory: key prefix.
+func NewRedisDirectoryCache(rawURL string, fetcher DirectoryFetcher, ttl time.Duration) (*RedisDirectoryCache, error) {
+	client, prefix, err := openRedis(rawURL, defaultRedisDirectoryPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisDirectoryCache{Fetcher: fetcher, TTL: ttl, client: client, prefix: prefix}, nil
+}
+
+// Fetch implements DirectoryFetcher
+func (c *RedisDirectoryCache) Fetch(ctx context.Context, base string) (Directory, FetchMeta, error) {
+	key := c.prefix + base
+	if cached, ok := c.get(ctx, key); ok {
+		return cached.Directory, FetchMeta{URL: cached.URL, FetchedAt: cached.FetchedAt}, nil
+	}
+
+	// Only the instance holding the lock fetches. It expires on its own should that instance go away mid-fetch.
+	locked, err := c.client.SetNX(ctx, key+":lock", "1", discoveryTimeout).Result()
+	if err != nil {
+		c.logger().Warn("locking directory in the shared cache failed", zap.String("directory", base), zap.Error(err))
+		return c.Fetcher.Fetch(ctx, base)
+	}
+	if !locked {
+		if cached, ok := c.wait(ctx, key); ok {
+			return cached.Directory, FetchMeta{URL: cached.URL, FetchedAt: cached.FetchedAt}, nil
+		}
+		return c.Fetcher.Fetch(ctx, base)
+	}
+	defer func() {
+		if err := c.client.Del(context.WithoutCancel(ctx), key+":lock").Err(); err != nil {
+			c.logger().Warn("unlocking directory in the shared cache failed", zap.String("directory", base), zap.Error(err))
+		}
+	}()
+
+	dir, meta, err := c.Fetcher.Fetch(ctx, base)
+	if err != nil || dir.Keys == nil {
+		return dir, meta, err
+	}
+	c.set(ctx, key, cachedDirectory{Directory: dir, URL: meta.URL, FetchedAt: meta.FetchedAt})
+	return dir, meta, nil
+}
+
+// get returns the directory cached under key
+func (c *RedisDirectoryCache) get(ctx context.Context, key string) (cachedDirectory, bool) {
+	data, err := c.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return cachedDirectory{}, false
+	}
+	if err != nil {
+		c.logger().Warn("reading the shared directory cache failed", zap.String("key", key), zap.Error(err))
+		return cachedDirectory{}, false
+	}
+	var cached cachedDirectory
+	if err := json.Unmarshal(data, &cached); err != nil || cached.Directory.Keys == nil {
+		c.logger().Warn("ignoring malformed directory in the shared cache", zap.String("key", key), zap.Error(err))
+		return cachedDirectory{}, false
+	}
+	return cached, true
+}
+
+// wait polls key until another instance cached its directory, for as long as that instance holds the lock
+func (c *RedisDirectoryCache) wait(ctx context.Context, key string) (cachedDirectory, bool) {
+	ticker := time.NewTicker(redisDirectoryPoll)
+	defer ticker.Stop()
+	timeout := time.NewTimer(discoveryTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return cachedDirectory{}, false
+		case <-timeout.C:
+			return cachedDirectory{}, false
+		case <-ticker.C:
+		}
+		if cached, ok := c.get(ctx, key); ok {
+			return cached, true
+		}
+		// The lock is gone without a directory: the fetch failed, and this instance tries on its own
+		if n, err := c.client.Exists(ctx, key+":lock").Result(); err != nil || n == 0 {
+			return cachedDirectory{}, false
+		}
+	}
+}
+
+// set caches dir under key for the TTL
+func (c *RedisDirectoryCache) set(ctx context.Context, key string, dir cachedDirectory) {
+	data, err := json.Marshal(dir)
+	if err != nil {
+		return
+	}
+	ttl := c.TTL
+	if ttl <= 0 {
+		ttl = DefaultDirectoryCacheTTL
+	}
+	if err := c.client.Set(ctx, key, data, ttl).Err(); err != nil {
+		c.logger().Warn("writing the shared directory cache failed", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// Close closes the connections to Redis
+func (c *RedisDirectoryCache) Close() error {
+	return c.client.Close()
+}
+
+// logger returns Logger, or a no-op logger when unset
+func (c *RedisDirectoryCache) logger() *zap.Logger {
+	if c.Logger == nil {
+		return zap.NewNop()
+	}
+	return c.Logger
+}
+
+// newDirectoryCache wraps fetcher in the cache described by config
+func newDirectoryCache(config DirectoryCacheConfig, fetcher DirectoryFetcher) (DirectoryFetcher, error) {
+	switch config.Type {
+	case "redis":
+		return NewRedisDirectoryCache(config.URL, fetcher, time.Duration(config.TTL))
+	}
+	return nil, fmt.Errorf("unknown directory cache %q, must be redis", config.Type)
+}
@@ -0,0 +1,142 @@
+package httpsig
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// newTestDirectoryCache returns a cache of the directories of fetcher in server
+func newTestDirectoryCache(t *testing.T, server *miniredis.Miniredis, fetcher DirectoryFetcher) *RedisDirectoryCache {
+	t.Helper()
+	cache, err := NewRedisDirectoryCache("redis://"+server.Addr(), fetcher, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cache.Close() })
+	return cache
+}
+
+func TestRedisDirectoryCache(t *testing.T) {
+	server := miniredis.RunT(t)
+	_, jwk, _ := generateKey(t)
+	first := &fakeFetcher{responses: []fakeResponse{{dir: directoryOf(jwk)}}}
+	second := &fakeFetcher{responses: []fakeResponse{{err: errors.New("unreachable")}}}
+	cache := newTestDirectoryCache(t, server, first)
+	other := newTestDirectoryCache(t, server, second)
+
+	ctx := context.Background()
+	if _, _, err := cache.Fetch(ctx, "bot.example"); err != nil {
+		t.Fatal(err)
+	}
+	// Another instance sharing the server uses the cached directory rather than fetching it
+	dir, meta, err := other.Fetch(ctx, "bot.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dir.Keys) != 1 || string(dir.Keys[0]) != string(jwk) || meta.URL != "bot.example" || meta.FetchedAt.IsZero() {
+		t.Errorf("cached directory = %+v, %+v", dir, meta)
+	}
+	if first.calls != 1 || second.calls != 0 {
+		t.Errorf("fetches = %d and %d, want 1 and 0", first.calls, second.calls)
+	}
+	if server.Exists("httpsig:directory:bot.example:lock") {
+		t.Error("lock was not released")
+	}
+	if ttl := server.TTL("httpsig:directory:bot.example"); ttl != time.Minute {
+		t.Errorf("directory TTL = %v, want %v", ttl, time.Minute)
+	}
+
+	// Failures are not shared
+	if _, _, err := other.Fetch(ctx, "broken.example"); err == nil {
+		t.Error("failed fetch succeeded")
+	}
+	if _, _, err := cache.Fetch(ctx, "broken.example"); err != nil {
+		t.Errorf("fetch after another instance failed: %v", err)
+	}
+	if first.calls != 2 {
+		t.Errorf("fetches = %d, want 2", first.calls)
+	}
+}
+
+func TestRedisDirectoryCacheWaitsForLock(t *testing.T) {
+	server := miniredis.RunT(t)
+	_, jwk, _ := generateKey(t)
+	waiter := &fakeFetcher{responses: []fakeResponse{{dir: directoryOf(jwk)}}}
+	other := newTestDirectoryCache(t, server, waiter)
+
+	// Another instance is fetching: the directory it caches is used
+	if err := server.Set("httpsig:directory:bot.example:lock", "1"); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(2 * redisDirectoryPoll)
+		other.set(context.Background(), "httpsig:directory:bot.example", cachedDirectory{Directory: directoryOf(jwk), URL: "bot.example", FetchedAt: time.Now()})
+	}()
+	if _, _, err := other.Fetch(context.Background(), "bot.example"); err != nil {
+		t.Fatal(err)
+	}
+	if waiter.calls != 0 {
+		t.Errorf("waiting instance fetched %d times", waiter.calls)
+	}
+
+	// The lock is released without a directory: the waiting instance fetches on its own
+	if err := server.Set("httpsig:directory:other.example:lock", "1"); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(2 * redisDirectoryPoll)
+		server.Del("httpsig:directory:other.example:lock")
+	}()
+	if _, _, err := other.Fetch(context.Background(), "other.example"); err != nil {
+		t.Fatal(err)
+	}
+	if waiter.calls != 1 {
+		t.Errorf("waiting instance fetched %d times, want 1", waiter.calls)
+	}
+}
+
+func TestRedisDirectoryCacheUnavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	_, jwk, _ := generateKey(t)
+	fetcher := &fakeFetcher{responses: []fakeResponse{{dir: directoryOf(jwk)}}}
+	cache, err := NewRedisDirectoryCache("redis://"+addr, fetcher, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if dir, _, err := cache.Fetch(context.Background(), "bot.example"); err != nil || len(dir.Keys) != 1 {
+		t.Errorf("Fetch without Redis = %+v, %v", dir, err)
+	}
+}
+
+func TestDirectoryCacheCaddyfile(t *testing.T) {
+	var m Middleware
+	d := caddyfile.NewTestDispenser("httpsig {\ndirectory_cache redis redis://localhost:6379/1 10m\n}")
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	if c := m.DirectoryCache; c == nil || c.Type != "redis" || c.URL != "redis://localhost:6379/1" || time.Duration(c.TTL) != 10*time.Minute {
+		t.Errorf("directory_cache = %+v", m.DirectoryCache)
+	}
+
+	for _, config := range []string{
+		"directory_cache memcached localhost",
+		"directory_cache redis",
+		"directory_cache redis redis://localhost 0s",
+	} {
+		if err := (&Middleware{}).UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\n" + config + "\n}")); err == nil {
+			t.Errorf("%q accepted", config)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package httpsig
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// defaultRedisPrefix namespaces the nonce keys in Redis
+const defaultRedisPrefix = "httpsig:nonce:"
+
+// RedisNonceStore is a NonceStore in Redis, so that Caddy instances sharing it reject replays across the cluster.
+// Each nonce is set with SET NX and expires with its signature, leaving eviction to Redis.
+type RedisNonceStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisNonceStore returns a store for the Redis server at rawURL, such as redis://:password@host:6379/0.
+// rediss:// connects with TLS. The prefix query parameter replaces the default httpsig:nonce: key prefix.
+func NewRedisNonceStore(rawURL string) (*RedisNonceStore, error) {
+	client, prefix, err := openRedis(rawURL, defaultRedisPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisNonceStore{client: client, prefix: prefix}, nil
+}
+
+// Use implements NonceStore
+func (s *RedisNonceStore) Use(ctx context.Context, key string, expires time.Time) (bool, error) {
+	ttl := max(time.Until(expires), time.Millisecond)
+	// SET NX reports whether the key was set, which it is not when it already existed
+	return s.client.SetNX(ctx, s.prefix+key, "1", ttl).Result()
+}
+
+// Close closes the connections to Redis
+func (s *RedisNonceStore) Close() error {
+	return s.client.Close()
+}
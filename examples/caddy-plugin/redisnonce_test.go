@@ -0,0 +1,69 @@
+package httpsig
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestRedisNonceStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	server.RequireAuth("secret")
+	store, err := NewRedisNonceStore("redis://:secret@" + server.Addr() + "/2?prefix=bots:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	cache := NewNonceCacheWithStore("", store)
+	expires := time.Now().Add(time.Minute)
+
+	if !mustUse(t, cache, testKeyID, "nonce", expires) {
+		t.Fatal("first use of the nonce was rejected")
+	}
+	if mustUse(t, cache, testKeyID, "nonce", expires) {
+		t.Error("replayed nonce was accepted")
+	}
+	// Another instance sharing the server sees the nonce too
+	other, err := NewRedisNonceStore("redis://:secret@" + server.Addr() + "/2?prefix=bots:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { other.Close() })
+	if mustUse(t, NewNonceCacheWithStore("", other), testKeyID, "nonce", expires) {
+		t.Error("nonce replayed through another instance was accepted")
+	}
+
+	key := "bots:" + testKeyID + " nonce"
+	if !server.DB(2).Exists(key) {
+		t.Fatalf("keys = %v, want the prefixed nonce in database 2", server.DB(2).Keys())
+	}
+	if ttl := server.DB(2).TTL(key); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("nonce TTL = %v", ttl)
+	}
+	// Redis evicts the nonce once it expires, after which it is new again
+	server.FastForward(server.DB(2).TTL(key))
+	if !mustUse(t, cache, testKeyID, "nonce", expires) {
+		t.Error("nonce evicted by Redis was rejected")
+	}
+}
+
+func TestRedisNonceStoreErrors(t *testing.T) {
+	server := miniredis.RunT(t)
+	server.RequireAuth("secret")
+	store, err := NewRedisNonceStore("redis://:wrong@" + server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	if _, err := store.Use(context.Background(), "nonce", time.Now().Add(time.Minute)); err == nil {
+		t.Error("Use succeeded with a wrong password")
+	}
+
+	for _, url := range []string{"http://localhost", "redis://", "redis://localhost/db", "redis://localhost?unknown=1"} {
+		if _, err := NewRedisNonceStore(url); err == nil {
+			t.Errorf("NewRedisNonceStore(%q) succeeded", url)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package httpsig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// minRetryDelay is the first delay before retrying a failed refresh. It doubles with each consecutive failure.
+const minRetryDelay = 30 * time.Second
+
+// maxRetryDelay caps the delay between retries of a directory that failed to load when refreshing is disabled
+const maxRetryDelay = 10 * time.Minute
+
+// provisionRetryDelay is the first delay before fetching a directory again at provision under DirectoryErrorRetry.
+// It doubles with each of the provisionRetryAttempts.
+const (
+	provisionRetryDelay    = time.Second
+	provisionRetryAttempts = 3
+)
+
+// DirectoryErrorPolicy controls what happens when a directory cannot be loaded at provision
+type DirectoryErrorPolicy string
+
+const (
+	// DirectoryErrorBlock starts without the directory keys, rejecting their signatures, and loads them in the background
+	DirectoryErrorBlock DirectoryErrorPolicy = "block"
+	// DirectoryErrorAllow starts without the directory keys and passes on requests that do not verify
+	// until every directory is loaded, which it retries in the background
+	DirectoryErrorAllow DirectoryErrorPolicy = "allow"
+	// DirectoryErrorRetry fetches the directory again with backoff before failing provisioning
+	DirectoryErrorRetry DirectoryErrorPolicy = "retry"
+)
+
+// ParseDirectoryErrorPolicy returns the directory error policy named s
+func ParseDirectoryErrorPolicy(s string) (DirectoryErrorPolicy, error) {
+	switch policy := DirectoryErrorPolicy(s); policy {
+	case DirectoryErrorBlock, DirectoryErrorAllow, DirectoryErrorRetry:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown directory error policy %q, must be block, allow or retry", s)
+}
+
+// refresher schedules directory refreshes.
+// Failures back off exponentially up to the refresh interval, so a failing directory is not hammered,
+// and a Retry-After sent by the directory host is always honored.
+type refresher struct {
+	interval time.Duration
+	failures int
+	now      func() time.Time
+}
+
+// next returns the delay before the following refresh, given the outcome of the last one
+func (rf *refresher) next(err error) time.Duration {
+	if err == nil {
+		rf.failures = 0
+		return rf.interval
+	}
+
+	limit := rf.interval
+	if limit == 0 {
+		limit = maxRetryDelay
+	}
+	delay := min(minRetryDelay<<min(rf.failures, 16), limit)
+	rf.failures++
+
+	var rle *RateLimitedError
+	if errors.As(err, &rle) && !rle.RetryAfter.IsZero() {
+		delay = max(delay, rle.RetryAfter.Sub(rf.now()))
+	}
+	return delay
+}
+
+// DirectoryStatus describes a directory the current keys were loaded from
+type DirectoryStatus struct {
+	// URL is the location the directory was served at, which tells which of the directory paths is in use
+	URL string
+	// FetchedAt is when the directory was last fetched, including fetches that found it not modified
+	FetchedAt time.Time
+	// Keys is the number of keys the directory published
+	Keys int
+	// Stale is true while the keys are those loaded by the previous config or persisted by persist_directories,
+	// the directory having failed to load
+	Stale bool
+}
+
+// loadedDirectory holds the keys last loaded from a directory
+type loadedDirectory struct {
+	keys   []keySpec
+	status DirectoryStatus
+}
+
+// DirectoryStatus returns the status of the directory at base, or false before it was loaded
+func (m *Middleware) DirectoryStatus(base string) (DirectoryStatus, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	loaded, ok := m.loaded[base]
+	return loaded.status, ok
+}
+
+// directories returns every configured directory_base
+func (m *Middleware) directories() []string {
+	if m.DirectoryBase == "" {
+		return m.DirectoryBases
+	}
+	return append([]string{m.DirectoryBase}, m.DirectoryBases...)
+}
+
+// refresh fetches the directories and replaces the validator with one built from their keys and the static keys.
+// A directory that fails to load or is not modified keeps its current keys, and the error of each failure is returned.
+func (m *Middleware) refresh(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer func() { m.unavailable.Store(!m.directoriesLoaded()) }()
+	if m.loaded == nil {
+		m.loaded = map[string]loadedDirectory{}
+	}
+
+	changed := m.validator.Load() == nil
+	var errs []error
+	for _, base := range m.directories() {
+		dir, meta, err := m.Fetcher.Fetch(ctx, base)
+		stale := false
+		if err != nil {
+			if m.outcomeMetrics != nil {
+				m.outcomeMetrics.fetchFailures.WithLabelValues(base).Inc()
+			}
+			errs = append(errs, err)
+			if dir, meta, stale = m.persistedDirectory(base); !stale {
+				continue
+			}
+		}
+		if meta.NotModified {
+			if loaded, ok := m.loaded[base]; ok {
+				loaded.status.FetchedAt = meta.FetchedAt
+				m.loaded[base] = loaded
+				continue
+			}
+			if dir.Keys == nil {
+				continue
+			}
+		}
+		keys, err := parseKeySpecs(dir.Keys)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("loading directory %s: %w", base, err))
+			continue
+		}
+		if err := checkPinnedKeys(m.PinnedKeys, base, keys); err != nil {
+			errs = append(errs, fmt.Errorf("loading directory %s: %w", base, err))
+			continue
+		}
+		if keys, err = m.keyCertificates.certified(keys); err != nil {
+			m.logger.Warn("dropping uncertified directory keys", zap.String("directory_base", base), zap.Error(err))
+		}
+		// A directory serving another purpose is loaded without keys, so its signatures stop verifying
+		if err := dir.checkPurpose(m.RequiredPurpose); err != nil {
+			m.logger.Warn("skipping directory keys", zap.String("directory_base", base), zap.Error(err))
+			keys = nil
+		}
+		for i := range keys {
+			keys[i].Directory = base
+			if dir.Purpose != nil {
+				keys[i].Purpose = *dir.Purpose
+			}
+		}
+		m.loaded[base] = loadedDirectory{keys: keys, status: DirectoryStatus{URL: meta.URL, FetchedAt: meta.FetchedAt, Keys: len(keys), Stale: stale}}
+		changed = true
+		if stale {
+			continue
+		}
+		cached := cachedDirectory{Directory: dir, URL: meta.URL, FetchedAt: meta.FetchedAt}
+		lastKnownDirectories.save(base, cached)
+		if m.persisted != nil {
+			if err := m.persisted.save(base, cached); err != nil {
+				m.logger.Warn("persisting directory failed", zap.String("directory_base", base), zap.Error(err))
+			}
+		}
+	}
+	if !changed {
+		return errors.Join(errs...)
+	}
+
+	// Keys are merged by keyid in directory order, and static keys come last so that they win collisions
+	var keys []keySpec
+	for _, base := range m.directories() {
+		keys = append(keys, m.loaded[base].keys...)
+	}
+	validator, err := newValidator(append(keys, m.staticKeys...), m.opts)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	if m.WarmUp {
+		validator.WarmUp()
+	}
+	m.validator.Store(validator)
+	if m.outcomeMetrics != nil {
+		for _, base := range m.directories() {
+			m.outcomeMetrics.keys.WithLabelValues(base).Set(float64(len(m.loaded[base].keys)))
+		}
+		m.outcomeMetrics.keys.WithLabelValues(staticDirectory).Set(float64(len(m.staticKeys)))
+	}
+	return errors.Join(errs...)
+}
+
+// persistedDirectory returns the directory last loaded from base by the config this one replaces or, failing that,
+// the one persist_directories kept, for a directory that failed to load before any of its keys were. Keys already
+// loaded are kept instead. It is called with mu held.
+func (m *Middleware) persistedDirectory(base string) (Directory, FetchMeta, bool) {
+	if _, ok := m.loaded[base]; ok {
+		return Directory{}, FetchMeta{}, false
+	}
+	if known, ok := lastKnownDirectories.load(base); ok {
+		return known.Directory, FetchMeta{URL: known.URL, FetchedAt: known.FetchedAt}, true
+	}
+	if m.persisted == nil {
+		return Directory{}, FetchMeta{}, false
+	}
+	cached, err := m.persisted.load(base)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			m.logger.Warn("loading persisted directory failed", zap.String("directory_base", base), zap.Error(err))
+		}
+		return Directory{}, FetchMeta{}, false
+	}
+	return cached.Directory, FetchMeta{URL: cached.URL, FetchedAt: cached.FetchedAt}, true
+}
+
+// directoriesLoaded reports whether the keys of every directory were loaded. It is called with mu held.
+func (m *Middleware) directoriesLoaded() bool {
+	for _, base := range m.directories() {
+		if _, ok := m.loaded[base]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// load fetches the directories at provision, applying the directory error policy when one fails.
+// It returns the delay before the refresh loop first runs, and whether the loop must run to load missing directories.
+func (m *Middleware) load(ctx context.Context, rf *refresher) (time.Duration, bool, error) {
+	err := m.refresh(ctx)
+	for attempt, delay := 1, provisionRetryDelay; err != nil && m.policy == DirectoryErrorRetry && attempt < provisionRetryAttempts; attempt, delay = attempt+1, delay*2 {
+		m.logger.Warn("loading directory failed, retrying", zap.Duration("retry_in", delay), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return 0, false, err
+		case <-time.After(delay):
+		}
+		err = m.refresh(ctx)
+	}
+	if err == nil {
+		return rf.next(nil), false, nil
+	}
+	m.mu.Lock()
+	persisted := m.validator.Load() != nil && m.directoriesLoaded()
+	m.mu.Unlock()
+	if persisted {
+		// Directories that failed to load have their persisted keys, which serve until a fetch succeeds
+		delay := rf.next(err)
+		m.logger.Warn("loading directory failed, starting with the last keys it served",
+			zap.Duration("retry_in", delay),
+			zap.Error(err))
+		return delay, true, nil
+	}
+	if m.policy != DirectoryErrorBlock && m.policy != DirectoryErrorAllow {
+		return 0, false, err
+	}
+	delay := rf.next(err)
+	m.logger.Warn("loading directory failed, starting without its keys",
+		zap.String("on_directory_error", string(m.policy)),
+		zap.Duration("retry_in", delay),
+		zap.Error(err))
+	return delay, true, nil
+}
+
+// refreshLoop refreshes the directory on the refresher's schedule, starting after delay, until ctx is done.
+// Without a refresh interval it only retries until every directory is loaded.
+func (m *Middleware) refreshLoop(ctx context.Context, rf *refresher, delay time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		err := m.refresh(ctx)
+		if err == nil && rf.interval == 0 {
+			return
+		}
+		delay := rf.next(err)
+		if err != nil {
+			m.logger.Warn("directory refresh failed, keeping current keys",
+				zap.Strings("directory_base", m.directories()),
+				zap.Duration("retry_in", delay),
+				zap.Error(err))
+		}
+		timer.Reset(delay)
+	}
+}
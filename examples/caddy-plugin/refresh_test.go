@@ -0,0 +1,339 @@
+package httpsig
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestRefresherBackoff(t *testing.T) {
+	rf := &refresher{interval: 5 * time.Minute, now: time.Now}
+	failure := errors.New("connection refused")
+
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for i, w := range want {
+		if got := rf.next(failure); got != w {
+			t.Errorf("failure %d: delay = %v, want %v", i+1, got, w)
+		}
+	}
+	if got := rf.next(nil); got != rf.interval {
+		t.Errorf("after success: delay = %v, want %v", got, rf.interval)
+	}
+	if got := rf.next(failure); got != 30*time.Second {
+		t.Errorf("backoff not reset after success: delay = %v", got)
+	}
+}
+
+func TestRefresherRetryAfter(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		retryAfter time.Time
+		want       time.Duration
+	}{
+		{name: "beyond interval", retryAfter: now.Add(2 * time.Hour), want: 2 * time.Hour},
+		{name: "beyond backoff", retryAfter: now.Add(10 * time.Minute), want: 10 * time.Minute},
+		{name: "shorter than backoff", retryAfter: now.Add(5 * time.Second), want: minRetryDelay},
+		{name: "missing", want: minRetryDelay},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rf := &refresher{interval: time.Hour, now: func() time.Time { return now }}
+			err := &RateLimitedError{URL: "https://example.com", Status: http.StatusTooManyRequests, RetryAfter: tt.retryAfter}
+			if got := rf.next(err); got != tt.want {
+				t.Errorf("delay = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value  string
+		want   time.Time
+		wantOK bool
+	}{
+		{value: "120", want: now.Add(2 * time.Minute), wantOK: true},
+		{value: "0", want: now, wantOK: true},
+		{value: "Sun, 01 Jun 2025 13:30:00 GMT", want: now.Add(90 * time.Minute), wantOK: true},
+		{value: ""},
+		{value: "-5"},
+		{value: "soon"},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if ok != tt.wantOK || !got.Equal(tt.want) {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestHTTPDirectoryFetcherRateLimited(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	f := &HTTPDirectoryFetcher{Client: srv.Client()}
+	_, _, err := f.Fetch(context.Background(), srv.URL)
+	var rle *RateLimitedError
+	if !errors.As(err, &rle) {
+		t.Fatalf("error = %v, want RateLimitedError", err)
+	}
+	if d := time.Until(rle.RetryAfter); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("RetryAfter in %v, want about an hour", d)
+	}
+
+	// The refresh that hit the rate limit is deferred until Retry-After rather than retried immediately
+	rf := &refresher{interval: 10 * time.Minute, now: time.Now}
+	if d := rf.next(err); d < 59*time.Minute {
+		t.Errorf("next refresh in %v, want about an hour", d)
+	}
+}
+
+func TestRefreshRateLimitedKeepsKeys(t *testing.T) {
+	fetcher := &fakeFetcher{responses: []fakeResponse{
+		{dir: directoryOf(ed25519JWK(testPrivateKey))},
+		{err: &RateLimitedError{URL: "https://example.com", Status: http.StatusTooManyRequests, RetryAfter: time.Now().Add(time.Hour)}},
+	}}
+	m := &Middleware{DirectoryBase: "example.com", Fetcher: fetcher}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.refresh(context.Background()); err == nil {
+		t.Fatal("refresh succeeded while rate limited")
+	}
+	if _, err := m.validator.Load().Validate(newSignedRequest(t)); err != nil {
+		t.Errorf("keys dropped while rate limited: %v", err)
+	}
+}
+
+func TestRefreshLoopSwapsValidator(t *testing.T) {
+	other, otherJWK, otherKeyID := generateKey(t)
+	m := &Middleware{DirectoryBase: "example.com", Fetcher: &fakeFetcher{responses: []fakeResponse{
+		{dir: directoryOf(ed25519JWK(testPrivateKey))},
+		{dir: directoryOf(otherJWK)},
+	}}}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	initial := m.validator.Load()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.refreshLoop(ctx, &refresher{interval: 10 * time.Millisecond, now: time.Now}, 10*time.Millisecond)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Requests keep being served by the current validator until the rotated keys are swapped in
+	deadline := time.Now().Add(5 * time.Second)
+	for m.validator.Load() == initial {
+		if time.Now().After(deadline) {
+			t.Fatal("validator not swapped by the refresh loop")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	signRequest(t, r, other, otherKeyID)
+	if _, err := m.validator.Load().Validate(r); err != nil {
+		t.Errorf("rotated key: %v", err)
+	}
+}
+
+func TestOnDirectoryError(t *testing.T) {
+	serve := func(m *Middleware, r *http.Request) int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		if err := m.ServeHTTP(w, r, okHandler{}); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code
+	}
+	unsigned := func() *http.Request { return httptest.NewRequest(http.MethodGet, "https://example.com/", nil) }
+
+	tests := []struct {
+		policy string
+		// wantUnsigned is the status of an unsigned request while the directory is unavailable
+		wantUnsigned int
+	}{
+		{policy: "block", wantUnsigned: http.StatusUnauthorized},
+		{policy: "allow", wantUnsigned: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			fetcher := &fakeFetcher{responses: []fakeResponse{
+				{err: errors.New("unreachable")},
+				{dir: directoryOf(ed25519JWK(testPrivateKey))},
+			}}
+			m := &Middleware{DirectoryBase: "signer.example.com", OnDirectoryError: tt.policy, Fetcher: fetcher}
+			if err := m.Provision(newTestContext(t)); err != nil {
+				t.Fatal(err)
+			}
+			if code := serve(m, newSignedRequest(t)); code != tt.wantUnsigned {
+				t.Errorf("signed request without directory keys: status = %d, want %d", code, tt.wantUnsigned)
+			}
+			if code := serve(m, unsigned()); code != tt.wantUnsigned {
+				t.Errorf("unsigned request without directory keys: status = %d, want %d", code, tt.wantUnsigned)
+			}
+
+			// Once the directory loads, signatures are enforced whatever the policy
+			if err := m.refresh(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if code := serve(m, newSignedRequest(t)); code != http.StatusOK {
+				t.Errorf("signed request: status = %d, want %d", code, http.StatusOK)
+			}
+			if code := serve(m, unsigned()); code != http.StatusUnauthorized {
+				t.Errorf("unsigned request: status = %d, want %d", code, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+func TestOnDirectoryErrorInvalidOptions(t *testing.T) {
+	// The policy covers fetch errors only: invalid options fail provisioning rather than leave no validator
+	for _, policy := range []string{"block", "allow"} {
+		fetcher := &fakeFetcher{responses: []fakeResponse{{err: errors.New("unreachable")}}}
+		m := &Middleware{DirectoryBase: "signer.example.com", OnDirectoryError: policy, Fetcher: fetcher, RequiredFields: []string{"@unknown"}}
+		if err := m.Provision(newTestContext(t)); err == nil {
+			t.Errorf("%s: provisioned with an invalid required field", policy)
+		}
+	}
+
+	fetcher := &fakeFetcher{responses: []fakeResponse{{err: errors.New("unreachable")}}}
+	m := &Middleware{DirectoryBase: "signer.example.com", OnDirectoryError: "block", Fetcher: fetcher}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	if err := m.ServeHTTP(w, newSignedRequest(t), okHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("request while the directory is unreachable: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestOnDirectoryErrorRetry(t *testing.T) {
+	fetcher := &fakeFetcher{responses: []fakeResponse{
+		{err: errors.New("unreachable")},
+		{dir: directoryOf(ed25519JWK(testPrivateKey))},
+	}}
+	m := &Middleware{DirectoryBase: "signer.example.com", OnDirectoryError: "retry", Fetcher: fetcher}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := m.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if fetcher.calls != 2 {
+		t.Errorf("fetched %d times, want 2", fetcher.calls)
+	}
+	if _, err := m.validate(newSignedRequest(t)); err != nil {
+		t.Error(err)
+	}
+
+	// Provisioning still fails once the attempts are exhausted, when no running config has loaded the directory
+	cancel()
+	fetcher = &fakeFetcher{responses: []fakeResponse{{err: errors.New("unreachable")}}}
+	m = &Middleware{DirectoryBase: "signer.example.com", OnDirectoryError: "retry", Fetcher: fetcher}
+	if err := m.Provision(newTestContext(t)); err == nil {
+		t.Error("provisioned with an unreachable directory")
+	}
+	if fetcher.calls != provisionRetryAttempts {
+		t.Errorf("fetched %d times, want %d", fetcher.calls, provisionRetryAttempts)
+	}
+}
+
+func TestReloadKeepsKeys(t *testing.T) {
+	fetcher := &fakeFetcher{responses: []fakeResponse{{dir: directoryOf(ed25519JWK(testPrivateKey))}}}
+	old := &Middleware{DirectoryBase: "signer.example.com", Fetcher: fetcher}
+	oldCtx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := old.Provision(oldCtx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The config replacing it starts with its keys while the directory is down
+	fetcher = &fakeFetcher{responses: []fakeResponse{{err: errors.New("unreachable")}}}
+	m := &Middleware{DirectoryBase: "signer.example.com", OnDirectoryError: "block", Fetcher: fetcher}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.validate(newSignedRequest(t)); err != nil {
+		t.Error(err)
+	}
+	if status, _ := m.DirectoryStatus("signer.example.com"); !status.Stale || status.Keys != 1 {
+		t.Errorf("status = %+v, want 1 stale key", status)
+	}
+
+	// Once the old config is done, a new one only has the keys of the running one
+	cancel()
+	again := &Middleware{DirectoryBase: "signer.example.com", OnDirectoryError: "block", Fetcher: fetcher}
+	if err := again.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := again.validate(newSignedRequest(t)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRefreshDuringRequests(t *testing.T) {
+	fetcher := &fakeFetcher{responses: []fakeResponse{{dir: directoryOf(ed25519JWK(testPrivateKey))}}}
+	m := &Middleware{DirectoryBase: "signer.example.com", Fetcher: fetcher}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each refresh swaps in a new validator, which requests in flight must not notice
+	requests := make([]*http.Request, 400)
+	for i := range requests {
+		requests[i] = newSignedRequest(t)
+	}
+	var wg sync.WaitGroup
+	codes := make(chan int, len(requests))
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, r := range requests[i*100 : (i+1)*100] {
+				w := httptest.NewRecorder()
+				m.ServeHTTP(w, r, okHandler{})
+				codes <- w.Code
+			}
+		}()
+	}
+	for range 50 {
+		if err := m.refresh(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("status = %d during refreshes, want %d", code, http.StatusOK)
+		}
+	}
+}
+
+func TestParseDirectoryErrorPolicy(t *testing.T) {
+	if _, err := ParseDirectoryErrorPolicy("ignore"); err == nil {
+		t.Error("unknown policy accepted")
+	}
+	if policy, err := ParseDirectoryErrorPolicy("allow"); err != nil || policy != DirectoryErrorAllow {
+		t.Errorf("ParseDirectoryErrorPolicy(allow) = %q, %v", policy, err)
+	}
+}
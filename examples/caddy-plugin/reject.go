@@ -0,0 +1,91 @@
+package httpsig
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// defaultRejectBody is the plain text body of rejections when none is configured
+const defaultRejectBody = "Invalid HTTP signature"
+
+// RejectResponse is the response to requests without a valid signature, so rejections can match the error format
+// of the rest of the site. The Accept-Signature and WWW-Authenticate challenge is sent along, as set by challenge.
+type RejectResponse struct {
+	// Status is 401 (default) or another 4xx status code, such as 403
+	Status int `json:"status,omitempty"`
+	// Body replaces the plain text body. Placeholders such as {http.httpsig.reason} are expanded.
+	Body string `json:"body,omitempty"`
+	// ContentType is the media type of Body. Defaults to application/json when Body is a JSON document, and to
+	// text/plain otherwise. Placeholders in JSON bodies are expanded as JSON string contents.
+	ContentType string `json:"content_type,omitempty"`
+	// Headers are set on the response, overriding the challenge. Placeholders in values are expanded.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// validate checks the status code and fills in the defaults
+func (rr *RejectResponse) validate() error {
+	if rr.Status == 0 {
+		rr.Status = http.StatusUnauthorized
+	}
+	if rr.Status < 400 || rr.Status > 499 {
+		return fmt.Errorf("reject status %d is not a 4xx status code", rr.Status)
+	}
+	if rr.ContentType == "" {
+		rr.ContentType = "text/plain; charset=utf-8"
+		if trimmed := strings.TrimSpace(rr.Body); strings.HasPrefix(trimmed, "{") && json.Valid([]byte(trimmed)) {
+			rr.ContentType = "application/json"
+		}
+	}
+	return nil
+}
+
+// write sends the rejection of r, with status when it is not zero. A nil RejectResponse sends the default body,
+// with a 401 by default.
+func (rr *RejectResponse) write(w http.ResponseWriter, r *http.Request, status int) {
+	if rr == nil {
+		if status == 0 {
+			status = http.StatusUnauthorized
+		}
+		http.Error(w, defaultRejectBody, status)
+		return
+	}
+	if status == 0 {
+		status = rr.Status
+	}
+	repl, _ := r.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if repl == nil {
+		repl = caddy.NewReplacer()
+	}
+	for name, value := range rr.Headers {
+		w.Header().Set(name, repl.ReplaceKnown(value, ""))
+	}
+	body := defaultRejectBody
+	if rr.Body != "" {
+		body = rr.expand(repl, rr.Body)
+	}
+	w.Header().Set("Content-Type", rr.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	fmt.Fprintln(w, body)
+}
+
+// expand replaces the placeholders of body, escaping their values when the body is JSON
+func (rr *RejectResponse) expand(repl *caddy.Replacer, body string) string {
+	if !strings.Contains(rr.ContentType, "json") {
+		return repl.ReplaceKnown(body, "")
+	}
+	escaped := caddy.NewEmptyReplacer()
+	escaped.Map(func(key string) (any, bool) {
+		val, ok := repl.Get(key)
+		if !ok {
+			return nil, false
+		}
+		quoted, _ := json.Marshal(caddy.ToString(val))
+		return string(quoted[1 : len(quoted)-1]), true
+	})
+	return escaped.ReplaceKnown(body, "")
+}
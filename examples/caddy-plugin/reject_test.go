@@ -0,0 +1,133 @@
+package httpsig
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestRejectResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		reject      *RejectResponse
+		wantStatus  int
+		wantType    string
+		wantBody    string
+		wantHeaders map[string]string
+	}{
+		{name: "default", wantStatus: http.StatusUnauthorized, wantType: "text/plain; charset=utf-8", wantBody: "Invalid HTTP signature\n"},
+		{
+			name:       "forbidden with text",
+			reject:     &RejectResponse{Status: http.StatusForbidden, Body: "Bots must sign requests"},
+			wantStatus: http.StatusForbidden, wantType: "text/plain; charset=utf-8", wantBody: "Bots must sign requests\n",
+		},
+		{
+			name:       "json with placeholders",
+			reject:     &RejectResponse{Body: `{"error":"invalid_signature","valid":"{http.httpsig.valid}","reason":"{http.httpsig.reason}"}`},
+			wantStatus: http.StatusUnauthorized, wantType: "application/json",
+		},
+		{
+			name: "headers",
+			reject: &RejectResponse{
+				ContentType: "application/problem+json",
+				Body:        `{"type":"about:blank","status":401}`,
+				Headers:     map[string]string{"Cache-Control": "no-store", "X-Reason": "{http.httpsig.valid}"},
+			},
+			wantStatus: http.StatusUnauthorized, wantType: "application/problem+json", wantBody: `{"type":"about:blank","status":401}` + "\n",
+			wantHeaders: map[string]string{"Cache-Control": "no-store", "X-Reason": "false", "Accept-Signature": "sig1="},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Middleware{StaticKeys: []json.RawMessage{ed25519JWK(testPrivateKey)}, Reject: tt.reject}
+			if err := m.Provision(newTestContext(t)); err != nil {
+				t.Fatal(err)
+			}
+			// A signature naming another host does not verify
+			r, _ := withReplacer(httptest.NewRequest(http.MethodGet, "https://example.com/", nil))
+			signRequest(t, r, testPrivateKey, testKeyID)
+			r.Host = "other.example.com"
+			w := httptest.NewRecorder()
+			if err := m.ServeHTTP(w, r, okHandler{}); err != nil {
+				t.Fatal(err)
+			}
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+			if tt.wantType == "application/json" {
+				var doc map[string]string
+				if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+					t.Fatalf("body %s is not JSON: %v", w.Body, err)
+				}
+				if doc["valid"] != "false" || doc["reason"] == "" {
+					t.Errorf("placeholders not expanded in %s", w.Body)
+				}
+			}
+			for name, want := range tt.wantHeaders {
+				if got := w.Header().Get(name); !strings.HasPrefix(got, want) {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRejectJSONEscaping(t *testing.T) {
+	rr := &RejectResponse{Body: `{"reason":"{http.httpsig.reason}"}`}
+	if err := rr.validate(); err != nil {
+		t.Fatal(err)
+	}
+	r, repl := withReplacer(httptest.NewRequest(http.MethodGet, "https://example.com/", nil))
+	repl.Set("http.httpsig.reason", `signature tag is "proxy", want "web-bot-auth"`)
+	w := httptest.NewRecorder()
+	rr.write(w, r, 0)
+
+	var doc map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("body %s is not JSON: %v", w.Body, err)
+	}
+	if want := `signature tag is "proxy", want "web-bot-auth"`; doc["reason"] != want {
+		t.Errorf("reason = %q, want %q", doc["reason"], want)
+	}
+}
+
+func TestRejectStatusValidation(t *testing.T) {
+	m := &Middleware{StaticKeys: []json.RawMessage{ed25519JWK(testPrivateKey)}, Reject: &RejectResponse{Status: http.StatusFound}}
+	if err := m.Provision(newTestContext(t)); err == nil {
+		t.Error("Provision accepted a 302 rejection")
+	}
+}
+
+func TestUnmarshalReject(t *testing.T) {
+	var m Middleware
+	d := caddyfile.NewTestDispenser(`httpsig {
+		reject 403 {
+			json ` + "`" + `{"error":"{http.httpsig.reason}"}` + "`" + `
+			header Cache-Control no-store
+		}
+	}`)
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	want := RejectResponse{Status: 403, Body: `{"error":"{http.httpsig.reason}"}`, ContentType: "application/json", Headers: map[string]string{"Cache-Control": "no-store"}}
+	if got := m.Reject; got == nil || got.Status != want.Status || got.Body != want.Body || got.ContentType != want.ContentType || got.Headers["Cache-Control"] != "no-store" {
+		t.Errorf("reject = %+v, want %+v", got, want)
+	}
+
+	for _, config := range []string{"reject teapot", "reject {\n\tjson not-json\n}", "reject {\n\tstyle fancy\n}"} {
+		if err := (&Middleware{}).UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\n" + config + "\n}")); err == nil {
+			t.Errorf("%q accepted", config)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package httpsig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/remitly-oss/httpsig-go"
+)
+
+func init() {
+	caddy.RegisterModule(new(ResponseSigner))
+	httpcaddyfile.RegisterHandlerDirective("httpsig_sign_response", func(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+		var rs ResponseSigner
+		err := rs.UnmarshalCaddyfile(h.Dispenser)
+		return &rs, err
+	})
+}
+
+// DefaultResponseFields are the components response signatures cover when none are configured
+var DefaultResponseFields = []string{"@status", "content-digest"}
+
+// DefaultResponseValidity is how long response signatures are valid when no validity is configured
+const DefaultResponseValidity = 5 * time.Minute
+
+// ResponseSigner signs responses with an RFC 9421 signature, so that clients can verify they come from the origin.
+// Responses are buffered in memory to digest their content, and 1xx responses such as protocol switches are passed on unsigned.
+type ResponseSigner struct {
+	// KeyFile holds the private key responses are signed with, as a JWK or PEM
+	KeyFile string `json:"key_file"`
+	// KeyID is the keyid of the signatures. Defaults to the RFC 7638 thumbprint of the key.
+	KeyID string `json:"keyid,omitempty"`
+	// Fields are the response components covered, @status and header names. Defaults to DefaultResponseFields.
+	// Content-Digest is computed from the body when covered and not set upstream. Headers missing from a response
+	// are left out of its signature.
+	Fields []string `json:"fields,omitempty"`
+	// Tag is the tag parameter of the signatures, omitted when empty
+	Tag string `json:"tag,omitempty"`
+	// Validity is how long signatures are valid, setting their expires. Defaults to DefaultResponseValidity.
+	Validity caddy.Duration `json:"validity,omitempty"`
+
+	key    httpsig.SigningKey
+	algo   httpsig.Algorithm
+	public jwk.Key
+}
+
+// CaddyModule returns the Caddy module information
+func (*ResponseSigner) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.httpsig_sign_response",
+		New: func() caddy.Module { return new(ResponseSigner) },
+	}
+}
+
+// Provision loads the signing key and checks the covered fields
+func (rs *ResponseSigner) Provision(caddy.Context) error {
+	if rs.KeyFile == "" {
+		return fmt.Errorf("key_file is required")
+	}
+	data, err := os.ReadFile(rs.KeyFile)
+	if err != nil {
+		return fmt.Errorf("reading key_file: %w", err)
+	}
+	if err := rs.loadKey(data); err != nil {
+		return fmt.Errorf("key_file %s: %w", rs.KeyFile, err)
+	}
+	if len(rs.Fields) == 0 {
+		rs.Fields = slices.Clone(DefaultResponseFields)
+	}
+	for i, field := range rs.Fields {
+		field = strings.ToLower(field)
+		if strings.HasPrefix(field, "@") && field != "@status" {
+			return fmt.Errorf("fields: %s is not a response component", field)
+		}
+		rs.Fields[i] = field
+	}
+	if rs.Validity == 0 {
+		rs.Validity = caddy.Duration(DefaultResponseValidity)
+	}
+	return nil
+}
+
+// loadKey parses the private key in data, a JWK or PEM, and derives its algorithm and default keyid
+func (rs *ResponseSigner) loadKey(data []byte) error {
+	block, _ := pem.Decode(data)
+	key, err := jwk.ParseKey(data, jwk.WithPEM(block != nil))
+	if err != nil {
+		return fmt.Errorf("parsing private key: %w", err)
+	}
+	var private any
+	if err := jwk.Export(key, &private); err != nil {
+		return fmt.Errorf("reading private key: %w", err)
+	}
+	switch private.(type) {
+	case ed25519.PrivateKey, *ecdsa.PrivateKey, *rsa.PrivateKey:
+	default:
+		return fmt.Errorf("%T is not a private key", private)
+	}
+	public, err := jwk.PublicKeyOf(key)
+	if err != nil {
+		return fmt.Errorf("reading public key: %w", err)
+	}
+	pk, err := jwk.PublicRawKeyOf(public)
+	if err != nil {
+		return fmt.Errorf("reading public key: %w", err)
+	}
+	if rs.algo, err = keyAlgorithm(key, pk); err != nil {
+		return err
+	}
+	keyid := rs.KeyID
+	if keyid == "" {
+		thumbprint, err := public.Thumbprint(crypto.SHA256)
+		if err != nil {
+			return fmt.Errorf("cannot generate key id from key: %w", err)
+		}
+		keyid = base64.RawURLEncoding.EncodeToString(thumbprint)
+	}
+	rs.key = httpsig.SigningKey{Key: private, MetaKeyID: keyid, MetaTag: rs.Tag}
+	rs.public = public
+	return nil
+}
+
+// ServeHTTP buffers the response of next and sends it signed
+func (rs *ResponseSigner) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	buf := new(bytes.Buffer)
+	rec := caddyhttp.NewResponseRecorder(w, buf, func(status int, _ http.Header) bool {
+		return status >= http.StatusOK
+	})
+	if err := next.ServeHTTP(rec, r); err != nil {
+		return err
+	}
+	if !rec.Buffered() {
+		return nil
+	}
+	status := rec.Status()
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if err := rs.sign(r, status, rec.Header(), buf.Bytes()); err != nil {
+		return caddyhttp.Error(http.StatusInternalServerError, fmt.Errorf("signing response: %w", err))
+	}
+	return rec.WriteResponse()
+}
+
+// sign adds the signature of the response to r with status, header and body to header
+func (rs *ResponseSigner) sign(r *http.Request, status int, header http.Header, body []byte) error {
+	fields := make([]string, 0, len(rs.Fields))
+	for _, field := range rs.Fields {
+		switch {
+		case field == "content-digest" && header.Get("Content-Digest") == "":
+			if !hasContent(r, status) {
+				continue
+			}
+			digest := sha256.Sum256(body)
+			header.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(digest[:])+":")
+		case !strings.HasPrefix(field, "@") && len(header.Values(field)) == 0:
+			continue
+		}
+		fields = append(fields, field)
+	}
+	profile := httpsig.SigningProfile{
+		Algorithm:       rs.algo,
+		Fields:          httpsig.Fields(fields...),
+		Metadata:        []httpsig.Metadata{httpsig.MetaCreated, httpsig.MetaExpires, httpsig.MetaKeyID},
+		ExpiresDuration: time.Duration(rs.Validity),
+	}
+	if rs.Tag != "" {
+		profile.Metadata = append(profile.Metadata, httpsig.MetaTag)
+	}
+	signer, err := httpsig.NewSigner(profile, rs.key)
+	if err != nil {
+		return err
+	}
+	return signer.SignResponse(&http.Response{StatusCode: status, Header: header, Request: r})
+}
+
+// hasContent reports whether the response to r with status carries content, which responses to HEAD
+// and 204 and 304 responses do not
+func hasContent(r *http.Request, status int) bool {
+	return r.Method != http.MethodHead && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// UnmarshalCaddyfile sets up the response signer from Caddyfile tokens:
+//
+//	httpsig_sign_response {
+//	    key_file <path>
+//	    keyid <keyid>
+//	    fields <component...>
+//	    tag <tag>
+//	    validity <duration>
+//	}
+func (rs *ResponseSigner) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for d.NextBlock(0) {
+			option := d.Val()
+			switch option {
+			case "fields":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				rs.Fields = append(rs.Fields, args...)
+			case "key_file":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				rs.KeyFile = d.Val()
+			case "keyid":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				rs.KeyID = d.Val()
+			case "tag":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				rs.Tag = d.Val()
+			case "validity":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				validity, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid validity: %v", err)
+				}
+				rs.Validity = caddy.Duration(validity)
+			default:
+				return d.Errf("unknown option '%s'", option)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package httpsig
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/remitly-oss/httpsig-go"
+)
+
+// responseKeys resolves every keyid to the RFC 9421 test key
+type responseKeys struct{}
+
+func (responseKeys) FetchByKeyID(ctx context.Context, rh http.Header, keyID string) (httpsig.KeySpecer, error) {
+	return httpsig.KeySpec{KeyID: keyID, Algo: httpsig.Algo_ED25519, PubKey: testPrivateKey.Public()}, nil
+}
+
+func (responseKeys) Fetch(ctx context.Context, rh http.Header, md httpsig.MetadataProvider) (httpsig.KeySpecer, error) {
+	return nil, io.EOF
+}
+
+// writeKeyFile writes the private test key to a file as a JWK, or as PEM when asPEM is set
+func writeKeyFile(t *testing.T, asPEM bool) string {
+	t.Helper()
+	var data []byte
+	if asPEM {
+		der, err := x509.MarshalPKCS8PrivateKey(testPrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	} else {
+		key, err := jwk.Import(testPrivateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, err = json.Marshal(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestResponseSigner(t *testing.T) {
+	for _, asPEM := range []bool{false, true} {
+		rs := &ResponseSigner{KeyFile: writeKeyFile(t, asPEM), Fields: []string{"@status", "content-digest", "Content-Type", "X-Missing"}, Tag: "origin"}
+		if err := rs.Provision(newTestContext(t)); err != nil {
+			t.Fatal(err)
+		}
+		next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_, err := w.Write([]byte(testBody))
+			return err
+		})
+		w := httptest.NewRecorder()
+		if err := rs.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "https://example.com/items", nil), next); err != nil {
+			t.Fatal(err)
+		}
+
+		resp := w.Result()
+		if resp.StatusCode != http.StatusCreated {
+			t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusCreated)
+		}
+		input := resp.Header.Get("Signature-Input")
+		if !strings.Contains(input, `("@status" "content-digest" "content-type")`) || !strings.Contains(input, `keyid="`+testKeyID+`"`) || !strings.Contains(input, `tag="origin"`) {
+			t.Errorf("Signature-Input = %s", input)
+		}
+		result, err := httpsig.VerifyResponse(resp, responseKeys{}, httpsig.VerifyProfile{
+			RequiredFields:    httpsig.Fields("@status", "content-digest"),
+			AllowedAlgorithms: []httpsig.Algorithm{httpsig.Algo_ED25519},
+		})
+		if err != nil || !result.Verified() {
+			t.Fatalf("response signature does not verify: %v", err)
+		}
+	}
+}
+
+func TestResponseSignerNoContent(t *testing.T) {
+	rs := &ResponseSigner{KeyFile: writeKeyFile(t, false)}
+	if err := rs.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	if err := rs.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "https://example.com/", nil), okHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Header().Get("Content-Digest"); got != "" {
+		t.Errorf("HEAD response has Content-Digest %s", got)
+	}
+	if input := w.Header().Get("Signature-Input"); !strings.HasPrefix(input, `sig1=("@status")`) {
+		t.Errorf("Signature-Input = %s", input)
+	}
+}
+
+func TestResponseSignerProvision(t *testing.T) {
+	publicKey := filepath.Join(t.TempDir(), "public.jwk")
+	if err := os.WriteFile(publicKey, ed25519JWK(testPrivateKey), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for name, rs := range map[string]*ResponseSigner{
+		"no key":             {},
+		"public key":         {KeyFile: publicKey},
+		"request components": {KeyFile: writeKeyFile(t, false), Fields: []string{"@method"}},
+	} {
+		if err := rs.Provision(newTestContext(t)); err == nil {
+			t.Errorf("%s: Provision succeeded", name)
+		}
+	}
+}
+
+func TestUnmarshalResponseSigner(t *testing.T) {
+	var rs ResponseSigner
+	d := caddyfile.NewTestDispenser(`httpsig_sign_response {
+		key_file /etc/caddy/origin.jwk
+		fields @status content-digest content-type
+		tag origin
+		validity 1m
+	}`)
+	if err := rs.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	if rs.KeyFile != "/etc/caddy/origin.jwk" || len(rs.Fields) != 3 || rs.Tag != "origin" || rs.Validity == 0 {
+		t.Errorf("response signer = %+v", rs)
+	}
+	if err := (&ResponseSigner{}).UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig_sign_response {\nvalidity forever\n}")); err == nil {
+		t.Error("validity forever accepted")
+	}
+}
@@ -0,0 +1,113 @@
+package httpsig
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultRevocationListInterval is how often the revocation list is fetched when revocation_list sets no interval
+	DefaultRevocationListInterval = 5 * time.Minute
+	// maxRevocationListSize is the largest revocation list read, in bytes
+	maxRevocationListSize = 1 << 20
+)
+
+// RevocationListConfig points at a deny list of key thumbprints published by the operator, so that a compromised key
+// is revoked across a fleet without reloading each config
+type RevocationListConfig struct {
+	// URL serves the list, one RFC 7638 JWK thumbprint per line. Blank lines and lines starting with # are ignored.
+	URL string `json:"url,omitempty"`
+	// Interval is how often the list is fetched again. Defaults to DefaultRevocationListInterval.
+	Interval caddy.Duration `json:"interval,omitempty"`
+}
+
+// revocationList holds the thumbprints last fetched from a RevocationListConfig URL
+type revocationList struct {
+	url    string
+	client *http.Client
+
+	thumbprints atomic.Pointer[map[string]struct{}]
+	// etag revalidates the list, which changes rarely
+	mu   sync.Mutex
+	etag string
+}
+
+// revoked reports whether the key with thumbprint is on the list
+func (l *revocationList) revoked(thumbprint string) bool {
+	thumbprints := l.thumbprints.Load()
+	if thumbprints == nil {
+		return false
+	}
+	_, ok := (*thumbprints)[thumbprint]
+	return ok
+}
+
+// fetch replaces the thumbprints with those the URL serves, keeping them when it answers 304
+func (l *revocationList) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.url, nil)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.etag != "" && l.thumbprints.Load() != nil {
+		req.Header.Set("If-None-Match", l.etag)
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching revocation list %s: %w", l.url, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil
+	default:
+		return fmt.Errorf("fetching revocation list %s: unexpected status %s", l.url, resp.Status)
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(nil, resp.Body, maxRevocationListSize))
+	if err != nil {
+		return fmt.Errorf("reading revocation list %s: %w", l.url, err)
+	}
+	thumbprints := make(map[string]struct{})
+	for scanner := bufio.NewScanner(bytes.NewReader(body)); scanner.Scan(); {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		thumbprints[line] = struct{}{}
+	}
+	l.thumbprints.Store(&thumbprints)
+	l.etag = resp.Header.Get("ETag")
+	return nil
+}
+
+// revocationListLoop fetches the revocation list every interval until ctx is done, keeping the last list on failures
+func (m *Middleware) revocationListLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := m.revocations.fetch(ctx); err != nil {
+			m.logger.Warn("fetching revocation list failed, keeping the previous list",
+				zap.String("revocation_list", m.revocations.url),
+				zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package httpsig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestRevocationList(t *testing.T) {
+	var mu sync.Mutex
+	list, etag, revalidated := "# compromised keys\n\nnot-a-key-we-use\n", `"v1"`, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("If-None-Match") == etag {
+			revalidated++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, list)
+	}))
+	defer srv.Close()
+
+	// The key is listed by thumbprint even though it signs with another keyid
+	m := &Middleware{
+		Keys:           []StaticKey{{KeyID: "monitoring-bot", JWK: ed25519JWK(testPrivateKey)}},
+		RevocationList: &RevocationListConfig{URL: srv.URL},
+	}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	signed := func() *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		signRequest(t, r, testPrivateKey, "monitoring-bot")
+		return r
+	}
+	result, err := m.validate(signed())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Thumbprint != testKeyID {
+		t.Errorf("Thumbprint = %q, want %q", result.Thumbprint, testKeyID)
+	}
+
+	if err := m.revocations.fetch(context.Background()); err != nil || revalidated != 1 {
+		t.Errorf("revalidation: err = %v, 304s = %d", err, revalidated)
+	}
+	mu.Lock()
+	list, etag = list+testKeyID+"\n", `"v2"`
+	mu.Unlock()
+	if err := m.revocations.fetch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.validate(signed()); !errors.Is(err, ErrRevoked) {
+		t.Errorf("revoked key: err = %v, want ErrRevoked", err)
+	}
+
+	// A list that cannot be fetched at provision fails it rather than accepting the keys it revokes
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	unreachable := &Middleware{Keys: m.Keys, RevocationList: &RevocationListConfig{URL: down.URL}}
+	if err := unreachable.Provision(newTestContext(t)); err == nil {
+		t.Error("provisioned without the revocation list")
+	}
+}
+
+func TestRevocationListCaddyfile(t *testing.T) {
+	var m Middleware
+	if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\nrevocation_list https://ops.example/revoked.txt 1m\n}")); err != nil {
+		t.Fatal(err)
+	}
+	if c := m.RevocationList; c == nil || c.URL != "https://ops.example/revoked.txt" || time.Duration(c.Interval) != time.Minute {
+		t.Errorf("revocation_list = %+v", m.RevocationList)
+	}
+	for _, config := range []string{"revocation_list", "revocation_list https://ops.example 0s", "revocation_list https://ops.example 1m extra"} {
+		if err := (&Middleware{}).UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\n" + config + "\n}")); err == nil {
+			t.Errorf("%q accepted", config)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package httpsig
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// ErrRevoked is returned when a signature verifies with a key, or for a directory, that has been revoked.
+// The signature itself is valid, so it is reported apart from verification failures.
+var ErrRevoked = errors.New("signer is revoked")
+
+// checkRevokedKey rejects signatures by revoked keyids
+func (v *SignatureValidator) checkRevokedKey(keyid string) error {
+	if _, ok := v.revoked[keyid]; ok {
+		return fmt.Errorf("%w: keyid %s", ErrRevoked, keyid)
+	}
+	return nil
+}
+
+// checkRevocationList rejects signatures by keys whose thumbprint is on the revocation_list
+func (m *Middleware) checkRevocationList(thumbprint string) error {
+	if m.revocations != nil && m.revocations.revoked(thumbprint) {
+		return fmt.Errorf("%w: key thumbprint %s is on the revocation list", ErrRevoked, thumbprint)
+	}
+	return nil
+}
+
+// checkRevokedDirectory rejects signatures by keys of a revoked directory, the directory_base the key was loaded from,
+// and signatures whose Signature-Agent advertises a revoked directory. directory is empty for static keys.
+func (m *Middleware) checkRevokedDirectory(h http.Header, directory string) error {
+	if len(m.RevokedDirectories) == 0 {
+		return nil
+	}
+	if directory != "" && isRevokedDirectory(m.RevokedDirectories, directory) {
+		return fmt.Errorf("%w: directory %s", ErrRevoked, directory)
+	}
+	if len(h.Values("Signature-Agent")) == 0 {
+		return nil
+	}
+	agent, err := parseSignatureAgent(h)
+	if err != nil {
+		return err
+	}
+	if isRevokedDirectory(m.RevokedDirectories, agent) {
+		return fmt.Errorf("%w: directory %s", ErrRevoked, agent)
+	}
+	return nil
+}
+
+// isRevokedDirectory reports whether base resolves to one of the revoked directories
+func isRevokedDirectory(revoked []string, base string) bool {
+	return slices.ContainsFunc(revoked, func(r string) bool { return sameDirectory(r, base) })
+}
@@ -0,0 +1,71 @@
+package httpsig
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRevokedKeyIDs(t *testing.T) {
+	v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey)}, ValidatorOptions{RevokedKeyIDs: []string{testKeyID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Validate(newSignedRequest(t)); !errors.Is(err, ErrRevoked) {
+		t.Errorf("err = %v, want %v", err, ErrRevoked)
+	}
+
+	// Requests that do not verify are not reported as revoked
+	r := newSignedRequest(t)
+	r.Host = "other.example.com"
+	if _, err := v.Validate(r); err == nil || errors.Is(err, ErrRevoked) {
+		t.Errorf("err = %v, want a verification failure", err)
+	}
+}
+
+func TestRevokedDirectories(t *testing.T) {
+	staticKey, staticJWK, staticKeyID := generateKey(t)
+
+	tests := []struct {
+		name    string
+		revoked []string
+		agent   string
+		static  bool
+		want    error
+	}{
+		{name: "not revoked", revoked: []string{"other.example.com"}},
+		{name: "directory_base", revoked: []string{"signer.example.com"}, want: ErrRevoked},
+		{name: "directory_base as URL", revoked: []string{"https://Signer.Example.com/.well-known/http-message-signatures-directory"}, want: ErrRevoked},
+		{name: "static key", revoked: []string{"signer.example.com"}, static: true},
+		{name: "signature agent", revoked: []string{"vendor.example"}, agent: `"https://vendor.example"`, static: true, want: ErrRevoked},
+		{name: "other signature agent", revoked: []string{"vendor.example"}, agent: `"https://signer.example.com"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Middleware{
+				DirectoryBase:      "signer.example.com",
+				StaticKeys:         []json.RawMessage{staticJWK},
+				RevokedDirectories: tt.revoked,
+				Fetcher:            &fakeFetcher{responses: []fakeResponse{{dir: directoryOf(ed25519JWK(testPrivateKey))}}},
+			}
+			if err := m.Provision(newTestContext(t)); err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+			if tt.agent != "" {
+				r.Header.Set("Signature-Agent", tt.agent)
+			}
+			if tt.static {
+				signRequest(t, r, staticKey, staticKeyID)
+			} else {
+				signRequest(t, r, testPrivateKey, testKeyID)
+			}
+			if _, err := m.validate(r); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package httpsig
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/cloudflareresearch/web-bot-auth/go/webbotauth"
+	"github.com/cloudflareresearch/web-bot-auth/go/webbotauth/httpmsgsig"
+	sfv "github.com/dunglas/httpsfv"
+)
+
+// ErrDirectoryNotSelfSigned is returned when a key of a directory did not sign the directory response
+var ErrDirectoryNotSelfSigned = errors.New("directory is not signed by its keys")
+
+// directorySignatureTag is the tag of the signatures directories put on their responses
+const directorySignatureTag = "http-message-signatures-directory"
+
+// verifySelfSigned checks that every key of dir signed resp, as the HTTP Message Signatures Directory draft asks
+// directories to: one unexpired signature per key, tagged http-message-signatures-directory and covering
+// the authority of the request, "@authority";req. It proves the directory holds the private keys it publishes.
+func verifySelfSigned(resp *http.Response, dir Directory, now time.Time) error {
+	keys, err := parseKeySpecs(dir.Keys)
+	if err != nil {
+		return err
+	}
+	inputs, err := sfv.UnmarshalDictionary(resp.Header.Values("Signature-Input"))
+	if err != nil {
+		return fmt.Errorf("%w: parsing Signature-Input: %v", ErrDirectoryNotSelfSigned, err)
+	}
+	signatures, err := sfv.UnmarshalDictionary(resp.Header.Values("Signature"))
+	if err != nil {
+		return fmt.Errorf("%w: parsing Signature: %v", ErrDirectoryNotSelfSigned, err)
+	}
+
+	byKeyID := make(map[string]keySpec, len(keys))
+	for _, ks := range keys {
+		byKeyID[ks.KeyID] = ks
+	}
+	signed := map[string]bool{}
+	var errs []error
+	for _, label := range inputs.Names() {
+		member, _ := inputs.Get(label)
+		input, ok := member.(sfv.InnerList)
+		if !ok {
+			continue
+		}
+		if tag, _ := input.Params.Get("tag"); tag != directorySignatureTag {
+			continue
+		}
+		keyid, _ := input.Params.Get("keyid")
+		ks, ok := byKeyID[fmt.Sprint(keyid)]
+		if !ok {
+			continue
+		}
+		if err := verifyDirectorySignature(resp, label, input, signatures, ks, now); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", label, err))
+			continue
+		}
+		signed[ks.KeyID] = true
+	}
+	for _, ks := range keys {
+		if !signed[ks.KeyID] {
+			if len(errs) == 0 {
+				return fmt.Errorf("%w: no signature by %s", ErrDirectoryNotSelfSigned, ks.KeyID)
+			}
+			return fmt.Errorf("%w: no valid signature by %s: %w", ErrDirectoryNotSelfSigned, ks.KeyID, errors.Join(errs...))
+		}
+	}
+	return nil
+}
+
+// verifyDirectorySignature verifies the signature labeled label of resp, described by input, with ks
+func verifyDirectorySignature(resp *http.Response, label string, input sfv.InnerList, signatures *sfv.Dictionary, ks keySpec, now time.Time) error {
+	if alg, ok := input.Params.Get("alg"); ok && alg != string(ks.Algo) {
+		return fmt.Errorf("alg %v does not match the %s key", alg, ks.Algo)
+	}
+	if created, ok := input.Params.Get("created"); ok {
+		if c, _ := created.(int64); time.Unix(c, 0).After(now.Add(DefaultCreatedSkew)) {
+			return fmt.Errorf("created in the future")
+		}
+	}
+	if expires, ok := input.Params.Get("expires"); ok {
+		if e, _ := expires.(int64); !now.Before(time.Unix(e, 0)) {
+			return fmt.Errorf("expired")
+		}
+	}
+
+	coversAuthority := slices.ContainsFunc(input.Items, func(item sfv.Item) bool {
+		req, _ := item.Params.Get("req")
+		return item.Value == "@authority" && req == true
+	})
+	if !coversAuthority {
+		return fmt.Errorf(`signature does not cover "@authority";req`)
+	}
+	if resp.Request == nil {
+		return fmt.Errorf("no request to derive @authority from")
+	}
+	base, err := httpmsgsig.ResponseBase(resp.Request, resp.StatusCode, resp.Header, input)
+	if err != nil {
+		return err
+	}
+
+	member, ok := signatures.Get(label)
+	if !ok {
+		return fmt.Errorf("no Signature")
+	}
+	item, ok := member.(sfv.Item)
+	if !ok {
+		return fmt.Errorf("Signature is not a byte sequence")
+	}
+	sig, ok := item.Value.([]byte)
+	if !ok {
+		return fmt.Errorf("Signature is not a byte sequence")
+	}
+	return webbotauth.VerifyBase(webbotauth.Algorithm(ks.Algo), ks.PubKey, base, sig)
+}
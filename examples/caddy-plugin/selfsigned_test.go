@@ -0,0 +1,131 @@
+package httpsig
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// selfSign adds to h a directory signature labeled label over "@authority";req, as the directory draft specifies
+func selfSign(t *testing.T, h http.Header, authority, label string, key crypto.Signer, keyid, tag string, expires time.Time) {
+	t.Helper()
+	created := time.Now().Add(-time.Minute)
+	params := fmt.Sprintf(`("@authority";req);created=%d;expires=%d;keyid="%s";tag="%s"`, created.Unix(), expires.Unix(), keyid, tag)
+	base := fmt.Sprintf("\"@authority\";req: %s\n\"@signature-params\": %s", authority, params)
+
+	var sig []byte
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(base))
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256([]byte(base))
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	default:
+		t.Fatalf("unsupported key %T", key)
+	}
+	h.Add("Signature-Input", label+"="+params)
+	h.Add("Signature", label+"=:"+base64.StdEncoding.EncodeToString(sig)+":")
+}
+
+func TestSelfSignedDirectory(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecJWK, ecKeyID := publicJWK(t, ecKey)
+	otherKey, _, _ := generateKey(t)
+	directory := fmt.Appendf(nil, `{"keys":[%s,%s]}`, ed25519JWK(testPrivateKey), ecJWK)
+	tag := directorySignatureTag
+	valid := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		sign    func(h http.Header, authority string)
+		wantErr bool
+	}{
+		{name: "signed by every key", sign: func(h http.Header, authority string) {
+			selfSign(t, h, authority, "sig1", testPrivateKey, testKeyID, tag, valid)
+			selfSign(t, h, authority, "sig2", ecKey, ecKeyID, tag, valid)
+		}},
+		{name: "unsigned", sign: func(h http.Header, authority string) {}, wantErr: true},
+		{name: "one key unsigned", sign: func(h http.Header, authority string) {
+			selfSign(t, h, authority, "sig1", testPrivateKey, testKeyID, tag, valid)
+		}, wantErr: true},
+		{name: "other tag", sign: func(h http.Header, authority string) {
+			selfSign(t, h, authority, "sig1", testPrivateKey, testKeyID, "web-bot-auth", valid)
+			selfSign(t, h, authority, "sig2", ecKey, ecKeyID, tag, valid)
+		}, wantErr: true},
+		{name: "expired", sign: func(h http.Header, authority string) {
+			selfSign(t, h, authority, "sig1", testPrivateKey, testKeyID, tag, time.Now().Add(-time.Second))
+			selfSign(t, h, authority, "sig2", ecKey, ecKeyID, tag, valid)
+		}, wantErr: true},
+		{name: "other authority", sign: func(h http.Header, authority string) {
+			selfSign(t, h, "elsewhere.example", "sig1", testPrivateKey, testKeyID, tag, valid)
+			selfSign(t, h, authority, "sig2", ecKey, ecKeyID, tag, valid)
+		}, wantErr: true},
+		{name: "signed by another key", sign: func(h http.Header, authority string) {
+			selfSign(t, h, authority, "sig1", otherKey, testKeyID, tag, valid)
+			selfSign(t, h, authority, "sig2", ecKey, ecKeyID, tag, valid)
+		}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tt.sign(w.Header(), r.Host)
+				w.Write(directory)
+			}))
+			defer srv.Close()
+
+			f := &HTTPDirectoryFetcher{Client: srv.Client(), RequireSelfSigned: true}
+			dir, _, err := f.Fetch(context.Background(), srv.URL)
+			if tt.wantErr {
+				if !errors.Is(err, ErrDirectoryNotSelfSigned) {
+					t.Errorf("err = %v, want %v", err, ErrDirectoryNotSelfSigned)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(dir.Keys) != 2 {
+				t.Errorf("got %d keys, want 2", len(dir.Keys))
+			}
+		})
+	}
+}
+
+func TestRequireSignedDirectory(t *testing.T) {
+	m := &Middleware{StaticKeys: []json.RawMessage{ed25519JWK(testPrivateKey)}, RequireSignedDirectory: true}
+	if err := m.Provision(newTestContext(t)); err == nil || !strings.Contains(err.Error(), "require_signed_directory") {
+		t.Errorf("Provision without directory_base: err = %v", err)
+	}
+
+	var parsed Middleware
+	if err := parsed.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`httpsig {
+		require_signed_directory
+	}`)); err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.RequireSignedDirectory {
+		t.Error("require_signed_directory was not set")
+	}
+}
@@ -0,0 +1,156 @@
+package httpsig
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	sfv "github.com/dunglas/httpsfv"
+)
+
+// signatureInput is one member of the Signature-Input dictionary
+type signatureInput struct {
+	Label string
+	// Components are the names of the covered components, in signing order
+	Components []string
+	// KeyID is the keyid parameter, empty when absent
+	KeyID string
+}
+
+// covers reports whether the signature covers the named component
+func (si signatureInput) covers(name string) bool {
+	return slices.Contains(si.Components, name)
+}
+
+// parseSignatureInputs parses the Signature-Input field of h, keyed by signature label
+func parseSignatureInputs(h http.Header) (map[string]signatureInput, error) {
+	dict, err := sfv.UnmarshalDictionary(h.Values("Signature-Input"))
+	if err != nil {
+		return nil, fmt.Errorf("parsing Signature-Input: %w", err)
+	}
+
+	inputs := map[string]signatureInput{}
+	for _, label := range dict.Names() {
+		member, _ := dict.Get(label)
+		list, ok := member.(sfv.InnerList)
+		if !ok {
+			return nil, fmt.Errorf("Signature-Input %q is not an inner list", label)
+		}
+		input := signatureInput{Label: label}
+		for _, item := range list.Items {
+			name, ok := item.Value.(string)
+			if !ok {
+				return nil, fmt.Errorf("Signature-Input %q has a non-string component", label)
+			}
+			input.Components = append(input.Components, name)
+		}
+		if keyid, ok := list.Params.Get("keyid"); ok {
+			input.KeyID, _ = keyid.(string)
+		}
+		inputs[label] = input
+	}
+	return inputs, nil
+}
+
+// firstKeyID returns the keyid of the first signature, by label, that advertises one
+func firstKeyID(inputs map[string]signatureInput) string {
+	for _, label := range slices.Sorted(maps.Keys(inputs)) {
+		if keyid := inputs[label].KeyID; keyid != "" {
+			return keyid
+		}
+	}
+	return ""
+}
+
+// signatureParams are the signature parameters defined by RFC 9421
+var signatureParams = []string{"created", "expires", "nonce", "alg", "keyid", "tag"}
+
+// lowercaseParams rewrites the keys of standard signature parameters in a Signature-Input value to lowercase,
+// reporting whether any were changed. Structured fields forbid uppercase keys, so such a value does not parse as is.
+func lowercaseParams(value string) (string, bool) {
+	var b strings.Builder
+	changed := false
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		b.WriteByte(c)
+		switch {
+		case quoted && c == '\\' && i+1 < len(value):
+			i++
+			b.WriteByte(value[i])
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == ';':
+			end := i + 1
+			for end < len(value) && !strings.ContainsRune("=;,) ", rune(value[end])) {
+				end++
+			}
+			key := value[i+1 : end]
+			if lower := strings.ToLower(key); lower != key && slices.Contains(signatureParams, lower) {
+				key, changed = lower, true
+			}
+			b.WriteString(key)
+			i = end - 1
+		}
+	}
+	return b.String(), changed
+}
+
+// withTaggedSignatures returns a copy of r carrying only the signatures the tag enforcement applies to, so that
+// unrelated signatures, such as those added by intermediaries, are ignored rather than failing the request.
+// Strict keeps web-bot-auth signatures, lenient also keeps untagged ones, and off keeps every signature.
+// Fields that do not parse are left to the verifier to reject.
+func withTaggedSignatures(r *http.Request, mode TagEnforcement) (*http.Request, error) {
+	if mode == TagOff || len(r.Header.Values("Signature-Input")) == 0 {
+		return r, nil
+	}
+	inputs, err := sfv.UnmarshalDictionary(r.Header.Values("Signature-Input"))
+	if err != nil {
+		return r, nil
+	}
+	signatures, err := sfv.UnmarshalDictionary(r.Header.Values("Signature"))
+	if err != nil {
+		return r, nil
+	}
+
+	keptInputs, keptSignatures := sfv.NewDictionary(), sfv.NewDictionary()
+	dropped := false
+	for _, label := range inputs.Names() {
+		input, _ := inputs.Get(label)
+		list, ok := input.(sfv.InnerList)
+		if !ok {
+			return r, nil
+		}
+		tag, tagged := list.Params.Get("tag")
+		if tag != webBotAuthTag && (tagged || mode != TagLenient) {
+			dropped = true
+			continue
+		}
+		keptInputs.Add(label, input)
+		if sig, ok := signatures.Get(label); ok {
+			keptSignatures.Add(label, sig)
+		}
+	}
+	if len(keptInputs.Names()) == 0 {
+		return r, ErrNoWebBotAuthSignature
+	}
+	if !dropped {
+		return r, nil
+	}
+
+	input, err := sfv.Marshal(keptInputs)
+	if err != nil {
+		return r, nil
+	}
+	signature, err := sfv.Marshal(keptSignatures)
+	if err != nil {
+		return r, nil
+	}
+	candidate := *r
+	candidate.Header = r.Header.Clone()
+	candidate.Header.Set("Signature-Input", input)
+	candidate.Header.Set("Signature", signature)
+	return &candidate, nil
+}
@@ -0,0 +1,99 @@
+package httpsig
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/cloudflareresearch/web-bot-auth/go/webbotauth"
+	"github.com/cloudflareresearch/web-bot-auth/go/webbotauth/httpmsgsig"
+	sfv "github.com/dunglas/httpsfv"
+)
+
+// ErrUnsignedDirectory is returned when a directory response carries no valid signature by the root key
+var ErrUnsignedDirectory = errors.New("directory is not signed by the root key")
+
+// DirectoryRoot is a public key, distributed out of band, that directory responses must be signed with.
+// Trust then rests on the root rather than on the host serving the directory.
+type DirectoryRoot struct {
+	key keySpec
+	now func() time.Time
+}
+
+// NewDirectoryRoot returns the root for a public JWK. Signatures by the root carry its thumbprint as keyid.
+func NewDirectoryRoot(key json.RawMessage) (*DirectoryRoot, error) {
+	ks, err := parseKeySpec(key)
+	if err != nil {
+		return nil, fmt.Errorf("root key: %w", err)
+	}
+	return &DirectoryRoot{key: ks, now: time.Now}, nil
+}
+
+// Verify checks that resp carries an unexpired HTTP Message Signature by the root covering content-digest,
+// which binds the signature to the directory body. Other signatures, such as those by the directory keys
+// themselves, are ignored. Afterwards resp.Body holds the verified body.
+func (dr *DirectoryRoot) Verify(resp *http.Response) error {
+	inputs, signatures, err := httpmsgsig.ParseSignatures(resp.Header)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnsignedDirectory, err)
+	}
+	var body []byte
+	if resp.Body != nil {
+		if body, err = io.ReadAll(resp.Body); err != nil {
+			return fmt.Errorf("%w: reading body: %v", ErrUnsignedDirectory, err)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	err = errors.New("no signature")
+	for _, label := range signatures.Names() {
+		sig, input, serr := signatureOf(label, inputs, signatures)
+		if serr != nil {
+			err = serr
+			continue
+		}
+		if err = dr.verifySignature(resp, body, input, sig); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v", ErrUnsignedDirectory, err)
+}
+
+// verifySignature checks that sig, of input, is an unexpired signature by the root over the response resp with
+// body, covering content-digest
+func (dr *DirectoryRoot) verifySignature(resp *http.Response, body []byte, input sfv.InnerList, sig []byte) error {
+	if err := checkMetadata(input.Params); err != nil {
+		return err
+	}
+	if keyid, _ := input.Params.Get("keyid"); keyid != dr.key.KeyID {
+		return fmt.Errorf("signature is not by the root key")
+	}
+	if alg, ok := input.Params.Get("alg"); ok && alg != string(dr.key.Algo) {
+		return fmt.Errorf("alg %v does not match the %s root key", alg, dr.key.Algo)
+	}
+	// expires bounds how long an old directory can be replayed
+	if expires, ok := input.Params.Get("expires"); ok && !dr.now().Before(time.Unix(expires.(int64), 0)) {
+		return fmt.Errorf("expired")
+	}
+	if !slices.ContainsFunc(input.Items, func(item sfv.Item) bool {
+		return item.Value == "content-digest" && len(item.Params.Names()) == 0
+	}) {
+		return fmt.Errorf("signature does not cover content-digest")
+	}
+	if err := checkDigest(resp.Header, body); err != nil {
+		return err
+	}
+	if resp.Request == nil && slices.ContainsFunc(input.Items, func(item sfv.Item) bool { return len(item.Params.Names()) > 0 }) {
+		return fmt.Errorf("no request to derive request components from")
+	}
+	base, err := httpmsgsig.ResponseBase(resp.Request, resp.StatusCode, resp.Header, input)
+	if err != nil {
+		return err
+	}
+	return webbotauth.VerifyBase(webbotauth.Algorithm(dr.key.Algo), dr.key.PubKey, base, sig)
+}
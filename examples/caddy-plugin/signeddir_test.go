@@ -0,0 +1,92 @@
+package httpsig
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/remitly-oss/httpsig-go"
+)
+
+// signDirectory sets the Content-Digest of body on h and signs it with the root key
+func signDirectory(t *testing.T, h http.Header, body []byte, root ed25519.PrivateKey, keyid string) {
+	t.Helper()
+	digest := sha256.Sum256(body)
+	h.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(digest[:])+":")
+	resp := &http.Response{StatusCode: http.StatusOK, Header: h}
+	signer, err := httpsig.NewSigner(httpsig.SigningProfile{
+		Algorithm: httpsig.Algo_ED25519,
+		Fields:    httpsig.Fields("content-digest"),
+		Metadata:  []httpsig.Metadata{httpsig.MetaCreated, httpsig.MetaExpires, httpsig.MetaKeyID},
+	}, httpsig.SigningKey{Key: root, MetaKeyID: keyid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.SignResponse(resp); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignedDirectory(t *testing.T) {
+	rootKey, rootJWK, rootKeyID := generateKey(t)
+	otherKey, _, otherKeyID := generateKey(t)
+	_, attackerJWK, _ := generateKey(t)
+	directory := fmt.Appendf(nil, `{"keys":[%s]}`, ed25519JWK(testPrivateKey))
+	tampered := fmt.Appendf(nil, `{"keys":[%s,%s]}`, ed25519JWK(testPrivateKey), attackerJWK)
+
+	tests := []struct {
+		name    string
+		sign    func(h http.Header)
+		body    []byte
+		wantErr bool
+	}{
+		{name: "signed", sign: func(h http.Header) { signDirectory(t, h, directory, rootKey, rootKeyID) }, body: directory},
+		{name: "unsigned", sign: func(h http.Header) {}, body: directory, wantErr: true},
+		{name: "tampered", sign: func(h http.Header) { signDirectory(t, h, directory, rootKey, rootKeyID) }, body: tampered, wantErr: true},
+		{name: "signed by another key", sign: func(h http.Header) { signDirectory(t, h, directory, otherKey, otherKeyID) }, body: directory, wantErr: true},
+		{name: "signed by another key with the root keyid", sign: func(h http.Header) { signDirectory(t, h, directory, otherKey, rootKeyID) }, body: directory, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tt.sign(w.Header())
+				w.Write(tt.body)
+			}))
+			defer srv.Close()
+
+			root, err := NewDirectoryRoot(rootJWK)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f := &HTTPDirectoryFetcher{Client: srv.Client(), Root: root}
+			dir, _, err := f.Fetch(context.Background(), srv.URL)
+			if tt.wantErr {
+				if !errors.Is(err, ErrUnsignedDirectory) {
+					t.Errorf("err = %v, want %v", err, ErrUnsignedDirectory)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(dir.Keys) != 1 {
+				t.Errorf("got %d keys, want 1", len(dir.Keys))
+			}
+		})
+	}
+}
+
+func TestDirectoryRootKeyWithoutDirectory(t *testing.T) {
+	_, rootJWK, _ := generateKey(t)
+	m := &Middleware{StaticKeys: []json.RawMessage{ed25519JWK(testPrivateKey)}, DirectoryRootKey: rootJWK}
+	if err := m.Provision(newTestContext(t)); err == nil {
+		t.Fatal("Provision succeeded without directory_base")
+	}
+}
@@ -0,0 +1,124 @@
+package httpsig
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrDiscoveryBlocked is returned when a directory advertised in Signature-Agent may not be fetched, because of
+// discovery_allow_hosts or discovery_deny_hosts, or because its host resolves to a non-public address
+var ErrDiscoveryBlocked = errors.New("directory discovery blocked")
+
+// maxDiscoveryRedirects caps the redirects followed when fetching a discovered directory
+const maxDiscoveryRedirects = 3
+
+// nonPublicPrefixes are the ranges discovered directories may not be fetched from, beyond those the netip
+// predicates cover: shared address space, IETF protocol assignments, benchmarking, reserved, and NAT64, which
+// translates to any IPv4 address
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+}
+
+// isPublicAddr reports whether ip is a public unicast address: not loopback, private, link-local, multicast,
+// unspecified or otherwise reserved
+func isPublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// publicAddressOnly is a net.Dialer Control refusing connections to non-public addresses. It runs once the host
+// is resolved, so a name resolving to an internal address, or rebinding to one, is refused too.
+func publicAddressOnly(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDiscoveryBlocked, err)
+	}
+	if !isPublicAddr(addrPort.Addr()) {
+		return fmt.Errorf("%w: %s is not a public address", ErrDiscoveryBlocked, addrPort.Addr())
+	}
+	return nil
+}
+
+// newDiscoveryClient returns a client for directories discovered through Signature-Agent, which any client can
+// point at any host. It only connects to public addresses, directly rather than through a proxy so that the address
+// checked is the one connected to, and follows at most maxDiscoveryRedirects redirects, all to https.
+// With config.proxy set, requests go through the proxy instead, which must then refuse internal destinations:
+// only hosts given as IP addresses can be checked here.
+func newDiscoveryClient(config directoryClientConfig) *http.Client {
+	client := newDirectoryClient(config)
+	if config.proxy == nil {
+		transport := client.Transport.(*http.Transport)
+		transport.Proxy = nil
+		transport.DialContext = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: publicAddressOnly}).DialContext
+	}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxDiscoveryRedirects {
+			return fmt.Errorf("%w: more than %d redirects", ErrDiscoveryBlocked, maxDiscoveryRedirects)
+		}
+		if req.URL.Scheme != "https" {
+			return fmt.Errorf("%w: redirect to %s is not https", ErrDiscoveryBlocked, req.URL.Redacted())
+		}
+		return checkAddressHost(req.URL.Hostname())
+	}
+	return client
+}
+
+// checkAddressHost rejects hosts given as a non-public IP address, which a proxy would otherwise connect to
+func checkAddressHost(host string) error {
+	if ip, err := netip.ParseAddr(host); err == nil && !isPublicAddr(ip) {
+		return fmt.Errorf("%w: %s is not a public address", ErrDiscoveryBlocked, ip)
+	}
+	return nil
+}
+
+// matchHost reports whether host matches one of patterns, host names that match exactly, or *.example.com
+// matching any subdomain of example.com
+func matchHost(patterns []string, host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(strings.ToLower(pattern), ".")
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDiscoveryHost rejects directories whose host is a non-public address, is denied, or is not allowed when an
+// allow list is set
+func (d *discovery) checkDiscoveryHost(host string) error {
+	if err := checkAddressHost(host); err != nil {
+		return err
+	}
+	if matchHost(d.denyHosts, host) {
+		return fmt.Errorf("%w: %s is denied", ErrDiscoveryBlocked, host)
+	}
+	if len(d.allowHosts) > 0 && !matchHost(d.allowHosts, host) {
+		return fmt.Errorf("%w: %s is not allowed", ErrDiscoveryBlocked, host)
+	}
+	return nil
+}
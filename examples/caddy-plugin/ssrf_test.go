@@ -0,0 +1,127 @@
+package httpsig
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestIsPublicAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.215.14":        true,
+		"2606:2800:21f:cb07::": true,
+		"127.0.0.1":            false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"100.64.0.1":           false,
+		"0.0.0.0":              false,
+		"224.0.0.1":            false,
+		"255.255.255.255":      false,
+		"::1":                  false,
+		"fe80::1":              false,
+		"fd00::1":              false,
+		"::ffff:127.0.0.1":     false,
+		"64:ff9b::a9fe:a9fe":   false,
+	} {
+		if got := isPublicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("isPublicAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestDiscoveryClient(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	client := newDiscoveryClient(directoryClientConfig{minVersion: tls.VersionTLS12})
+	if _, err := client.Get(srv.URL); !errors.Is(err, ErrDiscoveryBlocked) {
+		t.Errorf("loopback fetch: err = %v, want ErrDiscoveryBlocked", err)
+	}
+
+	redirect := func(target string, hops int) error {
+		req := &http.Request{URL: &url.URL{Scheme: "https", Host: "bot.example"}}
+		if target != "" {
+			req.URL, _ = url.Parse(target)
+		}
+		return client.CheckRedirect(req, make([]*http.Request, hops))
+	}
+	if err := redirect("", maxDiscoveryRedirects); err != nil {
+		t.Errorf("redirect: %v", err)
+	}
+	if err := redirect("", maxDiscoveryRedirects+1); !errors.Is(err, ErrDiscoveryBlocked) {
+		t.Errorf("too many redirects: err = %v", err)
+	}
+	if err := redirect("http://bot.example/", 1); !errors.Is(err, ErrDiscoveryBlocked) {
+		t.Errorf("redirect to http: err = %v", err)
+	}
+	if err := redirect("https://169.254.169.254/", 1); !errors.Is(err, ErrDiscoveryBlocked) {
+		t.Errorf("redirect to a link-local address: err = %v", err)
+	}
+}
+
+func TestMatchHost(t *testing.T) {
+	patterns := []string{"bot.example", "*.crawler.example"}
+	for host, want := range map[string]bool{
+		"bot.example":         true,
+		"BOT.example.":        true,
+		"www.bot.example":     false,
+		"a.crawler.example":   true,
+		"a.b.crawler.example": true,
+		"crawler.example":     false,
+		"evilcrawler.example": false,
+	} {
+		if got := matchHost(patterns, host); got != want {
+			t.Errorf("matchHost(%s) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestDiscoveryHosts(t *testing.T) {
+	_, vendorJWK, _ := generateKey(t)
+	allowed := &fakeFetcher{responses: []fakeResponse{{dir: directoryOf(vendorJWK)}}}
+	other := &fakeFetcher{responses: []fakeResponse{{dir: directoryOf(vendorJWK)}}}
+	denied := &fakeFetcher{responses: []fakeResponse{{dir: directoryOf(vendorJWK)}}}
+	m := &Middleware{
+		DiscoverDirectories: true,
+		DiscoveryAllowHosts: []string{"*.bots.example"},
+		DiscoveryDenyHosts:  []string{"bad.bots.example"},
+		Fetcher: fakeFetchers{
+			"https://good.bots.example": allowed,
+			"https://other.example":     other,
+			"https://bad.bots.example":  denied,
+		},
+	}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	for _, agent := range []string{`"https://other.example"`, `"https://bad.bots.example"`, `"https://10.0.0.1"`} {
+		if _, err := m.validate(agentRequest(t, testPrivateKey, testKeyID, agent)); !errors.Is(err, ErrDiscoveryBlocked) {
+			t.Errorf("%s: err = %v, want ErrDiscoveryBlocked", agent, err)
+		}
+	}
+	m.validate(agentRequest(t, testPrivateKey, testKeyID, `"https://good.bots.example"`))
+	if allowed.calls != 1 || other.calls != 0 || denied.calls != 0 {
+		t.Errorf("fetches = %d, %d and %d, want only the allowed host", allowed.calls, other.calls, denied.calls)
+	}
+
+	if err := (&Middleware{DiscoveryDenyHosts: []string{"x"}, DirectoryBase: "signer.example.com", Fetcher: allowed}).Provision(newTestContext(t)); err == nil {
+		t.Error("discovery_deny_hosts accepted without discover_directories")
+	}
+
+	var parsed Middleware
+	d := caddyfile.NewTestDispenser("httpsig {\ndiscover_directories\ndiscovery_allow_hosts a.example *.b.example\ndiscovery_deny_hosts c.b.example\n}")
+	if err := parsed.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.DiscoveryAllowHosts) != 2 || len(parsed.DiscoveryDenyHosts) != 1 {
+		t.Errorf("allow = %v, deny = %v", parsed.DiscoveryAllowHosts, parsed.DiscoveryDenyHosts)
+	}
+}
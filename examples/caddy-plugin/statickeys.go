@@ -0,0 +1,230 @@
+package httpsig
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
+// staticKeyExtensions are the file extensions read from static_keys_dir
+var staticKeyExtensions = []string{".json", ".jwk", ".pem"}
+
+// StaticKey is a public key configured inline, as a JWK or PEM, for bots that do not publish a directory
+type StaticKey struct {
+	// KeyID is the keyid the bot signs with. Defaults to the RFC 7638 thumbprint of the key.
+	KeyID string `json:"keyid,omitempty"`
+	// JWK is the public key as a JWK
+	JWK json.RawMessage `json:"jwk,omitempty"`
+	// PEM is the public key as a PEM block, such as a PKIX Ed25519 public key
+	PEM string `json:"pem,omitempty"`
+	// NotBefore and NotAfter bound when the key verifies signatures, overriding the nbf and exp members of its JWK.
+	// They let keys without such members, such as PEM keys, be rotated out at a set time.
+	NotBefore time.Time `json:"not_before,omitzero"`
+	NotAfter  time.Time `json:"not_after,omitzero"`
+}
+
+// keySpec parses the key, applying its explicit keyid
+func (sk StaticKey) keySpec() (keySpec, error) {
+	var data []byte
+	switch {
+	case len(sk.JWK) > 0 && sk.PEM != "":
+		return keySpec{}, fmt.Errorf("both jwk and pem are set")
+	case len(sk.JWK) > 0:
+		data = sk.JWK
+	case sk.PEM != "":
+		data = []byte(sk.PEM)
+	default:
+		return keySpec{}, fmt.Errorf("jwk or pem is required")
+	}
+	keys, err := decodeKeyMaterial(data)
+	if err != nil {
+		return keySpec{}, err
+	}
+	if len(keys) != 1 {
+		return keySpec{}, fmt.Errorf("holds %d keys, want 1", len(keys))
+	}
+	ks, err := parseKeySpec(keys[0])
+	if err != nil {
+		return keySpec{}, err
+	}
+	if sk.KeyID != "" {
+		ks.KeyID = sk.KeyID
+	}
+	if !sk.NotBefore.IsZero() {
+		ks.NotBefore = sk.NotBefore
+	}
+	if !sk.NotAfter.IsZero() {
+		ks.NotAfter = sk.NotAfter
+	}
+	if !ks.NotBefore.IsZero() && !ks.NotAfter.IsZero() && !ks.NotAfter.After(ks.NotBefore) {
+		return keySpec{}, fmt.Errorf("not_after %s is not after not_before %s", ks.NotAfter.Format(time.RFC3339), ks.NotBefore.Format(time.RFC3339))
+	}
+	return ks, nil
+}
+
+// setKeyMaterial sets the JWK or the PEM of key from material, told apart by the PEM header
+func setKeyMaterial(key *StaticKey, material string) error {
+	material = strings.TrimSpace(material)
+	if strings.HasPrefix(material, "-----BEGIN") {
+		key.PEM = material
+		return nil
+	}
+	if !json.Valid([]byte(material)) {
+		return fmt.Errorf("invalid JWK %s", material)
+	}
+	key.JWK = json.RawMessage(material)
+	return nil
+}
+
+// decodeKeyMaterial returns the JWKs held in data, which is a JWK, a JSON array of JWKs, a JWK Set,
+// or one or more PEM public keys. PEM keys are converted to JWKs so they are parsed like any other static key.
+func decodeKeyMaterial(data []byte) ([]json.RawMessage, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no key found")
+	}
+	if bytes.HasPrefix(data, []byte("-----BEGIN")) {
+		return pemToJWKs(data)
+	}
+
+	if data[0] == '[' {
+		var keys []json.RawMessage
+		if err := json.Unmarshal(data, &keys); err != nil {
+			return nil, fmt.Errorf("decoding key array: %w", err)
+		}
+		return keys, nil
+	}
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("decoding key: %w", err)
+	}
+	if set.Keys != nil {
+		return set.Keys, nil
+	}
+	return []json.RawMessage{data}, nil
+}
+
+// pemToJWKs converts each PEM block of data to a public JWK
+func pemToJWKs(data []byte) ([]json.RawMessage, error) {
+	var keys []json.RawMessage
+	for {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			break
+		}
+		key, err := jwk.ParseKey(pem.EncodeToMemory(block), jwk.WithPEM(true))
+		if err != nil {
+			return nil, fmt.Errorf("parsing PEM key: %w", err)
+		}
+		public, err := jwk.PublicKeyOf(key)
+		if err != nil {
+			return nil, fmt.Errorf("reading PEM public key: %w", err)
+		}
+		encoded, err := json.Marshal(public)
+		if err != nil {
+			return nil, fmt.Errorf("encoding PEM key: %w", err)
+		}
+		keys = append(keys, encoded)
+		data = rest
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+	return keys, nil
+}
+
+// staticKeysFromEnv returns the keys held in the environment variables names
+func staticKeysFromEnv(names []string) ([]json.RawMessage, error) {
+	var keys []json.RawMessage
+	for _, name := range names {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", name)
+		}
+		decoded, err := decodeKeyMaterial([]byte(value))
+		if err != nil {
+			return nil, fmt.Errorf("environment variable %s: %w", name, err)
+		}
+		keys = append(keys, decoded...)
+	}
+	return keys, nil
+}
+
+// staticKeysFromDir returns the keys held in the .json, .jwk and .pem files of dir, in file name order.
+// Other files, such as the dotfiles Kubernetes adds to mounted secrets, are ignored.
+func staticKeysFromDir(dir string) ([]json.RawMessage, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var keys []json.RawMessage
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || !slices.Contains(staticKeyExtensions, strings.ToLower(filepath.Ext(name))) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		// Mounted secrets are symlinks, so stat through them rather than trusting the entry type
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		decoded, err := decodeKeyMaterial(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, decoded...)
+	}
+	return keys, nil
+}
+
+// loadStaticKeys appends the keys from key blocks, static_keys_env and static_keys_dir to the static keys
+func (m *Middleware) loadStaticKeys() error {
+	for i, key := range m.Keys {
+		ks, err := key.keySpec()
+		if err != nil {
+			return fmt.Errorf("key %d: %w", i+1, err)
+		}
+		m.staticKeys = append(m.staticKeys, ks)
+	}
+
+	envKeys, err := staticKeysFromEnv(m.StaticKeysEnv)
+	if err != nil {
+		return fmt.Errorf("static_keys_env: %w", err)
+	}
+	specs, err := parseKeySpecs(envKeys)
+	if err != nil {
+		return fmt.Errorf("static_keys_env: %w", err)
+	}
+	m.staticKeys = append(m.staticKeys, specs...)
+
+	if m.StaticKeysDir == "" {
+		return nil
+	}
+	dirKeys, err := staticKeysFromDir(m.StaticKeysDir)
+	if err != nil {
+		return fmt.Errorf("static_keys_dir: %w", err)
+	}
+	if specs, err = parseKeySpecs(dirKeys); err != nil {
+		return fmt.Errorf("static_keys_dir: %w", err)
+	}
+	m.staticKeys = append(m.staticKeys, specs...)
+	return nil
+}
@@ -0,0 +1,208 @@
+package httpsig
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// generateKey returns a fresh Ed25519 key with its public JWK and keyid
+func generateKey(t *testing.T) (ed25519.PrivateKey, json.RawMessage, string) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, keyid := publicJWK(t, key)
+	return key, jwk, keyid
+}
+
+// publicPEM returns the PKIX PEM encoding of the public half of key
+func publicPEM(t *testing.T, key ed25519.PrivateKey) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStaticKeysDir(t *testing.T) {
+	jwkKey, jwkData, jwkKeyID := generateKey(t)
+	pemKey, _, pemKeyID := generateKey(t)
+	setKey, setData, setKeyID := generateKey(t)
+	ignoredKey, ignoredData, ignoredKeyID := generateKey(t)
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "bot.jwk"), jwkData)
+	writeFile(t, filepath.Join(dir, "bot.pem"), publicPEM(t, pemKey))
+	writeFile(t, filepath.Join(dir, "set.json"), fmt.Appendf(nil, `{"keys":[%s]}`, setData))
+	writeFile(t, filepath.Join(dir, ".hidden.json"), ignoredData)
+	writeFile(t, filepath.Join(dir, "notes.txt"), ignoredData)
+
+	m := &Middleware{StaticKeysDir: dir}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name  string
+		key   ed25519.PrivateKey
+		keyid string
+		want  bool
+	}{
+		{name: "jwk", key: jwkKey, keyid: jwkKeyID, want: true},
+		{name: "pem", key: pemKey, keyid: pemKeyID, want: true},
+		{name: "jwk set", key: setKey, keyid: setKeyID, want: true},
+		{name: "ignored", key: ignoredKey, keyid: ignoredKeyID, want: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+			signRequest(t, r, tt.key, tt.keyid)
+			_, err := m.validator.Load().Validate(r)
+			if got := err == nil; got != tt.want {
+				t.Errorf("accepted = %v, want %v (err: %v)", got, tt.want, err)
+			}
+		})
+	}
+}
+
+func TestStaticKeysEnv(t *testing.T) {
+	pemKey, _, pemKeyID := generateKey(t)
+	first, firstData, firstKeyID := generateKey(t)
+	second, secondData, secondKeyID := generateKey(t)
+	t.Setenv("HTTPSIG_TEST_PEM", string(publicPEM(t, pemKey)))
+	t.Setenv("HTTPSIG_TEST_JWKS", fmt.Sprintf("[%s, %s]", firstData, secondData))
+
+	m := &Middleware{StaticKeysEnv: []string{"HTTPSIG_TEST_PEM", "HTTPSIG_TEST_JWKS"}}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	for keyid, key := range map[string]ed25519.PrivateKey{pemKeyID: pemKey, firstKeyID: first, secondKeyID: second} {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		signRequest(t, r, key, keyid)
+		if _, err := m.validator.Load().Validate(r); err != nil {
+			t.Errorf("key %s: %v", keyid, err)
+		}
+	}
+}
+
+func TestStaticKeysSourceErrors(t *testing.T) {
+	t.Setenv("HTTPSIG_TEST_GARBAGE", "not a key")
+	for _, m := range []*Middleware{
+		{StaticKeysEnv: []string{"HTTPSIG_TEST_UNSET"}},
+		{StaticKeysEnv: []string{"HTTPSIG_TEST_GARBAGE"}},
+		{StaticKeysDir: filepath.Join(t.TempDir(), "missing")},
+	} {
+		if err := m.Provision(newTestContext(t)); err == nil {
+			t.Errorf("Provision(%+v) succeeded, want an error", m)
+		}
+	}
+}
+
+func TestKeyBlocks(t *testing.T) {
+	pemKey, _, _ := generateKey(t)
+	jwkKey, jwk, jwkKeyID := generateKey(t)
+
+	var m Middleware
+	d := caddyfile.NewTestDispenser(fmt.Sprintf("httpsig {\n"+
+		"key {\n keyid monitoring-bot\n pem `%s`\n}\n"+
+		"key `%s`\n"+
+		"}", publicPEM(t, pemKey), jwk))
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	// Inline keys do not need a directory, so nothing is fetched
+	m.Fetcher = &fakeFetcher{responses: []fakeResponse{{err: fmt.Errorf("no directory fetch expected")}}}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		key   ed25519.PrivateKey
+		keyid string
+	}{{pemKey, "monitoring-bot"}, {jwkKey, jwkKeyID}} {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		signRequest(t, r, tt.key, tt.keyid)
+		if result, err := m.validate(r); err != nil || result.KeyID != tt.keyid {
+			t.Errorf("keyid %s: result = %+v, err = %v", tt.keyid, result, err)
+		}
+	}
+}
+
+func TestKeyValidityPeriod(t *testing.T) {
+	now := time.Now()
+	expired, _, _ := generateKey(t)
+	pending, _, _ := generateKey(t)
+	current, _, _ := generateKey(t)
+	var m Middleware
+	d := caddyfile.NewTestDispenser(fmt.Sprintf("httpsig {\n"+
+		"key {\n keyid expired\n pem `%s`\n not_after %s\n}\n"+
+		"key {\n keyid pending\n pem `%s`\n not_before %s\n}\n"+
+		"key {\n keyid current\n pem `%s`\n not_before %s\n not_after %s\n}\n"+
+		"}",
+		publicPEM(t, expired), now.Add(-time.Hour).Format(time.RFC3339),
+		publicPEM(t, pending), now.Add(time.Hour).Format(time.RFC3339),
+		publicPEM(t, current), now.Add(-time.Hour).Format(time.RFC3339), now.Add(time.Hour).Format(time.RFC3339)))
+	if err := m.UnmarshalCaddyfile(d); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		key     ed25519.PrivateKey
+		keyid   string
+		wantErr bool
+	}{{expired, "expired", true}, {pending, "pending", true}, {current, "current", false}} {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		signRequest(t, r, tt.key, tt.keyid)
+		if _, err := m.validate(r); (err != nil) != tt.wantErr {
+			t.Errorf("keyid %s: err = %v, wantErr %v", tt.keyid, err, tt.wantErr)
+		}
+	}
+
+	_, jwk, _ := generateKey(t)
+	inverted := &Middleware{Keys: []StaticKey{{JWK: jwk, NotBefore: now, NotAfter: now.Add(-time.Hour)}}}
+	if err := inverted.Provision(newTestContext(t)); err == nil {
+		t.Error("key with not_after before not_before accepted")
+	}
+}
+
+func TestKeyBlockErrors(t *testing.T) {
+	for _, config := range []string{
+		"key",
+		"key {\n keyid only-an-id\n}",
+		"key not-a-key",
+		"key {\n size 32\n}",
+		"key {\n not_after tomorrow\n}",
+	} {
+		var m Middleware
+		if err := m.UnmarshalCaddyfile(caddyfile.NewTestDispenser("httpsig {\n" + config + "\n}")); err == nil {
+			t.Errorf("%q accepted", config)
+		}
+	}
+
+	_, jwk, _ := generateKey(t)
+	both := &Middleware{Keys: []StaticKey{{JWK: jwk, PEM: "-----BEGIN PUBLIC KEY-----"}}}
+	if err := both.Provision(newTestContext(t)); err == nil {
+		t.Error("key with both jwk and pem accepted")
+	}
+}
@@ -0,0 +1,46 @@
+package httpsig
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of httpsig spans
+const tracerName = "github.com/cloudflareresearch/web-bot-auth/examples/caddy-plugin"
+
+// tracer returns the tracer of the span in ctx, so that spans join the traces of Caddy's tracing handler,
+// and the tracer of the global provider otherwise
+func tracer(ctx context.Context) trace.Tracer {
+	if span := trace.SpanFromContext(ctx); span.SpanContext().IsValid() {
+		return span.TracerProvider().Tracer(tracerName)
+	}
+	return otel.GetTracerProvider().Tracer(tracerName)
+}
+
+// endValidation records the outcome of verifying r on span
+func endValidation(span trace.Span, r *http.Request, result ValidationResult, err error) {
+	span.SetAttributes(attribute.String("httpsig.result", outcomeOf(r, err)))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	span.SetAttributes(
+		attribute.String("httpsig.keyid", result.KeyID),
+		attribute.String("httpsig.algorithm", string(result.Algorithm)),
+		attribute.String("httpsig.label", result.Label),
+	)
+	if result.Directory != "" {
+		span.SetAttributes(attribute.String("httpsig.directory", result.Directory))
+	}
+}
+
+// setCacheHit records on the span in ctx whether a discovered directory was served from the cache
+func setCacheHit(ctx context.Context, hit bool) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("httpsig.directory.cache_hit", hit))
+}
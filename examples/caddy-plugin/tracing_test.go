@@ -0,0 +1,119 @@
+package httpsig
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// tracedRequest returns r within a span of a recording provider, as Caddy's tracing handler sets up
+func tracedRequest(t *testing.T, r *http.Request) (*http.Request, *tracetest.SpanRecorder) {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+	ctx, span := provider.Tracer("test").Start(r.Context(), "request")
+	t.Cleanup(func() { span.End() })
+	return r.WithContext(ctx), recorder
+}
+
+// spanAttributes returns the attributes of the ended span named name
+func spanAttributes(t *testing.T, recorder *tracetest.SpanRecorder, name string) (sdktrace.ReadOnlySpan, map[attribute.Key]attribute.Value) {
+	t.Helper()
+	for _, span := range recorder.Ended() {
+		if span.Name() != name {
+			continue
+		}
+		attrs := map[attribute.Key]attribute.Value{}
+		for _, kv := range span.Attributes() {
+			attrs[kv.Key] = kv.Value
+		}
+		return span, attrs
+	}
+	t.Fatalf("no %s span", name)
+	return nil, nil
+}
+
+func TestValidateSpan(t *testing.T) {
+	v := newTestValidator(t)
+
+	r, recorder := tracedRequest(t, newSignedRequest(t))
+	if _, err := v.Validate(r); err != nil {
+		t.Fatal(err)
+	}
+	span, attrs := spanAttributes(t, recorder, "httpsig.validate")
+	if span.Parent().SpanID() == [8]byte{} {
+		t.Error("span is not a child of the request span")
+	}
+	for key, want := range map[attribute.Key]string{"httpsig.result": "valid", "httpsig.keyid": testKeyID, "httpsig.algorithm": "ed25519"} {
+		if got := attrs[key].AsString(); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+
+	r, recorder = tracedRequest(t, httptest.NewRequest(http.MethodGet, "https://example.com/", nil))
+	if _, err := v.Validate(r); err == nil {
+		t.Fatal("unsigned request verified")
+	}
+	span, attrs = spanAttributes(t, recorder, "httpsig.validate")
+	if got := attrs["httpsig.result"].AsString(); got != "missing" {
+		t.Errorf("httpsig.result = %q, want missing", got)
+	}
+	if span.Status().Code != codes.Error {
+		t.Errorf("status = %v, want error", span.Status().Code)
+	}
+}
+
+func TestDirectoryFetchSpan(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"keys":[` + string(ed25519JWK(testPrivateKey)) + `]}`))
+	}))
+	defer srv.Close()
+
+	r, recorder := tracedRequest(t, httptest.NewRequest(http.MethodGet, "https://example.com/", nil))
+	f := &HTTPDirectoryFetcher{Client: srv.Client()}
+	if _, _, err := f.Fetch(r.Context(), srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	_, attrs := spanAttributes(t, recorder, "httpsig.directory.fetch")
+	if got := attrs["server.address"].AsString(); got != "127.0.0.1" {
+		t.Errorf("server.address = %q, want 127.0.0.1", got)
+	}
+	if got := attrs["httpsig.directory.keys"].AsInt64(); got != 1 {
+		t.Errorf("httpsig.directory.keys = %d, want 1", got)
+	}
+}
+
+func TestDiscoveryCacheHitAttribute(t *testing.T) {
+	m := &Middleware{
+		DiscoverDirectories: true,
+		Fetcher:             fakeFetchers{"https://vendor.example": &fakeFetcher{responses: []fakeResponse{{err: errors.New("unreachable")}}}},
+	}
+	if err := m.Provision(newTestContext(t)); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []bool{false, true} {
+		r, recorder := tracedRequest(t, agentRequest(t, testPrivateKey, testKeyID, `"https://vendor.example"`))
+		m.validate(r)
+		// The attribute is set on the request span, which ends with the test
+		var got *bool
+		for _, span := range recorder.Started() {
+			for _, kv := range span.Attributes() {
+				if kv.Key == "httpsig.directory.cache_hit" {
+					hit := kv.Value.AsBool()
+					got = &hit
+				}
+			}
+		}
+		if got == nil || *got != want {
+			t.Errorf("cache_hit = %v, want %v", got, want)
+		}
+	}
+}
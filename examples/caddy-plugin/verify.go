@@ -0,0 +1,260 @@
+package httpsig
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/cloudflareresearch/web-bot-auth/go/webbotauth"
+	"github.com/cloudflareresearch/web-bot-auth/go/webbotauth/httpmsgsig"
+	sfv "github.com/dunglas/httpsfv"
+	"github.com/remitly-oss/httpsig-go"
+)
+
+// requestVerifier verifies the signatures of requests over the RFC 9421 signature bases httpmsgsig builds, with the
+// keys of a key fetcher. It reports results and errors as the httpsig verifier does, which the checks of the
+// validator and the classification of rejections build on, but derives every component as RFC 9421 defines it.
+//
+// The httpsig types are kept as an adapter layer on purpose: KeyStores, keySpec and the key fetchers are exported
+// through ValidatorOptions as httpsig.KeyFetcher and httpsig.KeySpec, ErrorKind maps httpsig error codes, and
+// response signing still uses the httpsig signer. Signature bases and signature checks come from webbotauth alone;
+// moving the result and error types there too would break the Go API of the plugin, for no change in what verifies.
+type requestVerifier struct {
+	keys httpsig.KeyFetcher
+}
+
+// Verify verifies each signature of r. The result holds the signatures that verified and those that did not, and
+// the error is that of the last signature that did not verify, if any. Content-Digest, when present, is checked
+// first against the body of r, which is read.
+func (rv *requestVerifier) Verify(r *http.Request) (httpsig.VerifyResult, error) {
+	result := httpsig.VerifyResult{
+		Signatures:        map[string]httpsig.VerifiedSignature{},
+		InvalidSignatures: map[string]httpsig.InvalidSignature{},
+	}
+	if err := checkContentDigest(r); err != nil {
+		return result, err
+	}
+	if len(r.Header.Values("Signature")) == 0 || len(r.Header.Values("Signature-Input")) == 0 {
+		return result, &httpsig.SignatureError{Code: httpsig.ErrNoSigMissingSignature, Message: "missing Signature or Signature-Input"}
+	}
+	inputs, signatures, err := httpmsgsig.ParseSignatures(r.Header)
+	if err != nil {
+		return result, &httpsig.SignatureError{Code: httpsig.ErrNoSigInvalidSignature, Message: err.Error(), Cause: err}
+	}
+
+	var lastErr error
+	for _, label := range signatures.Names() {
+		sig, input, err := signatureOf(label, inputs, signatures)
+		if err != nil {
+			// Signatures that do not parse are reported but, as with the httpsig verifier, do not fail the others
+			result.InvalidSignatures[label] = httpsig.InvalidSignature{Label: label, Error: *err}
+			continue
+		}
+		metadata := inputMetadata{input.Params}
+		specer, base, err := rv.verifySignature(r, input, sig)
+		if trace, ok := r.Context().Value(baseTraceKey{}).(*baseTrace); ok {
+			trace.record(label, base, err)
+		}
+		if err != nil {
+			result.InvalidSignatures[label] = httpsig.InvalidSignature{Label: label, Error: *err, HasMetadata: true, MetadataProvider: metadata}
+			lastErr = err
+			continue
+		}
+		result.Signatures[label] = httpsig.VerifiedSignature{Label: label, KeySpec: specer, MetadataProvider: metadata}
+	}
+	return result, lastErr
+}
+
+// baseTraceKey is the context key of the baseTrace of a request
+type baseTraceKey struct{}
+
+// baseTrace records the signature bases requestVerifier computes for the requests whose context holds it, each
+// attempt at verifying a signature in order, for debug reports
+type baseTrace struct {
+	attempts []baseAttempt
+}
+
+// baseAttempt is an attempt at verifying the signature of a label, over base unless it could not be computed
+type baseAttempt struct {
+	label string
+	base  []byte
+	err   error
+}
+
+// withBaseTrace returns r with trace in its context
+func withBaseTrace(r *http.Request, trace *baseTrace) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), baseTraceKey{}, trace))
+}
+
+func (t *baseTrace) record(label string, base []byte, err *httpsig.SignatureError) {
+	attempt := baseAttempt{label: label, base: base}
+	if err != nil {
+		attempt.err = err
+	}
+	t.attempts = append(t.attempts, attempt)
+}
+
+// signatureOf returns the signature labeled label and its Signature-Input member
+func signatureOf(label string, inputs, signatures *sfv.Dictionary) ([]byte, sfv.InnerList, *httpsig.SignatureError) {
+	member, _ := signatures.Get(label)
+	item, _ := member.(sfv.Item)
+	sig, ok := item.Value.([]byte)
+	if !ok {
+		return nil, sfv.InnerList{}, &httpsig.SignatureError{Code: httpsig.ErrSigInvalidSignature, Message: fmt.Sprintf("the signature %s is not a byte sequence", label)}
+	}
+	member, ok = inputs.Get(label)
+	if !ok {
+		return nil, sfv.InnerList{}, &httpsig.SignatureError{Code: httpsig.ErrSigInvalidSignature, Message: fmt.Sprintf("the signature %s has no Signature-Input", label)}
+	}
+	input, ok := member.(sfv.InnerList)
+	if !ok {
+		return nil, sfv.InnerList{}, &httpsig.SignatureError{Code: httpsig.ErrSigInvalidSignature, Message: fmt.Sprintf("the Signature-Input of %s is not an inner list", label)}
+	}
+	return sig, input, nil
+}
+
+// verifySignature verifies sig over the signature base of r for input, which it returns once computed, with the
+// key of its keyid
+func (rv *requestVerifier) verifySignature(r *http.Request, input sfv.InnerList, sig []byte) (httpsig.KeySpecer, []byte, *httpsig.SignatureError) {
+	if err := checkMetadata(input.Params); err != nil {
+		return nil, nil, &httpsig.SignatureError{Code: httpsig.ErrInvalidMetadata, Message: err.Error(), Cause: err}
+	}
+	seen := map[string]bool{}
+	for _, item := range input.Items {
+		id, err := sfv.Marshal(item)
+		if err != nil || seen[id] {
+			return nil, nil, &httpsig.SignatureError{Code: httpsig.ErrInvalidComponent, Message: fmt.Sprintf("component %s is invalid or repeated", id)}
+		}
+		seen[id] = true
+	}
+	base, err := httpmsgsig.SignatureBase(r, input)
+	if err != nil {
+		return nil, nil, &httpsig.SignatureError{Code: httpsig.ErrInvalidComponent, Message: err.Error(), Cause: err}
+	}
+
+	keyid, err := inputMetadata{input.Params}.KeyID()
+	if err != nil {
+		return nil, base, &httpsig.SignatureError{Code: httpsig.ErrSigKeyFetch, Message: "signature has no keyid", Cause: err}
+	}
+	specer, err := rv.keys.FetchByKeyID(r.Context(), r.Header, keyid)
+	if err != nil {
+		return nil, base, &httpsig.SignatureError{Code: httpsig.ErrSigKeyFetch, Message: fmt.Sprintf("fetching key %s", keyid), Cause: err}
+	}
+	ks, err := specer.KeySpec()
+	if err != nil {
+		return nil, base, &httpsig.SignatureError{Code: httpsig.ErrSigKeyFetch, Message: fmt.Sprintf("reading key %s", keyid), Cause: err}
+	}
+	err = webbotauth.VerifyBase(webbotauth.Algorithm(ks.Algo), ks.PubKey, base, sig)
+	switch {
+	case errors.Is(err, webbotauth.ErrInvalidSignature):
+		return specer, base, &httpsig.SignatureError{Code: httpsig.ErrSigVerification, Message: fmt.Sprintf("signature did not verify for algorithm %s", ks.Algo), Cause: err}
+	case err != nil:
+		return specer, base, &httpsig.SignatureError{Code: httpsig.ErrSigUnsupportedAlgorithm, Message: err.Error(), Cause: err}
+	}
+	return specer, base, nil
+}
+
+// checkMetadata rejects signature parameters of RFC 9421 whose value is not of the type it defines
+func checkMetadata(params *sfv.Params) error {
+	for _, name := range params.Names() {
+		value, _ := params.Get(name)
+		var ok bool
+		switch name {
+		case "created", "expires":
+			_, ok = value.(int64)
+		case "nonce", "alg", "keyid", "tag":
+			_, ok = value.(string)
+		default:
+			ok = true
+		}
+		if !ok {
+			return fmt.Errorf("signature parameter %s has a value of type %T", name, value)
+		}
+	}
+	return nil
+}
+
+// checkContentDigest checks the body of r against its Content-Digest, if any. The body is read.
+func checkContentDigest(r *http.Request) error {
+	if len(r.Header.Values("Content-Digest")) == 0 {
+		return nil
+	}
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return &httpsig.SignatureError{Code: httpsig.ErrNoSigMessageBody, Message: "reading body", Cause: err}
+		}
+	}
+	return checkDigest(r.Header, body)
+}
+
+// checkDigest checks body against the Content-Digest of h with the first algorithm of it that is supported, sha-256
+// or sha-512
+func checkDigest(h http.Header, body []byte) error {
+	digests, err := sfv.UnmarshalDictionary(h.Values("Content-Digest"))
+	if err != nil {
+		return &httpsig.SignatureError{Code: httpsig.ErrNoSigInvalidHeader, Message: "parsing Content-Digest", Cause: err}
+	}
+	var algorithm string
+	var want []byte
+	for _, name := range digests.Names() {
+		member, _ := digests.Get(name)
+		item, _ := member.(sfv.Item)
+		if digest, ok := item.Value.([]byte); ok && (name == "sha-256" || name == "sha-512") {
+			algorithm, want = name, digest
+			break
+		}
+	}
+	if algorithm == "" {
+		return &httpsig.SignatureError{Code: httpsig.ErrNoSigUnsupportedDigest, Message: "Content-Digest has no sha-256 or sha-512 digest"}
+	}
+	var got []byte
+	if algorithm == "sha-256" {
+		sum := sha256.Sum256(body)
+		got = sum[:]
+	} else {
+		sum := sha512.Sum512(body)
+		got = sum[:]
+	}
+	if !bytes.Equal(got, want) {
+		return &httpsig.SignatureError{Code: httpsig.ErrNoSigWrongDigest, Message: "Content-Digest does not match the body"}
+	}
+	return nil
+}
+
+// inputMetadata is the httpsig.MetadataProvider of the parameters of a Signature-Input member, whose types
+// checkMetadata checked
+type inputMetadata struct {
+	params *sfv.Params
+}
+
+func (m inputMetadata) Created() (int, error)  { return m.integer("created") }
+func (m inputMetadata) Expires() (int, error)  { return m.integer("expires") }
+func (m inputMetadata) Nonce() (string, error) { return m.string("nonce") }
+func (m inputMetadata) Alg() (string, error)   { return m.string("alg") }
+func (m inputMetadata) KeyID() (string, error) { return m.string("keyid") }
+func (m inputMetadata) Tag() (string, error)   { return m.string("tag") }
+
+// integer returns the integer parameter name
+func (m inputMetadata) integer(name string) (int, error) {
+	value, _ := m.params.Get(name)
+	if i, ok := value.(int64); ok {
+		return int(i), nil
+	}
+	return 0, fmt.Errorf("no %s parameter", name)
+}
+
+// string returns the string parameter name
+func (m inputMetadata) string(name string) (string, error) {
+	value, _ := m.params.Get(name)
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return "", fmt.Errorf("no %s parameter", name)
+}
@@ -0,0 +1,37 @@
+package httpsig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"math/big"
+)
+
+// warmUpMessage is verified against a dummy signature by each key during warm-up
+var warmUpMessage = []byte("web-bot-auth warm-up")
+
+// WarmUp runs a throwaway verification with every key so that lazily built crypto state, such as the
+// precomputed curve tables, is initialized before the first signed request arrives.
+// The dummy signatures are expected to fail; only the work done to reject them matters.
+func (v *SignatureValidator) WarmUp() {
+	for _, ks := range v.keys {
+		switch pk := ks.PubKey.(type) {
+		case ed25519.PublicKey:
+			ed25519.Verify(pk, warmUpMessage, make([]byte, ed25519.SignatureSize))
+		case *ecdsa.PublicKey:
+			// r = s = 1 passes the range checks, so the full verification runs
+			sig, _ := asn1.Marshal(struct{ R, S *big.Int }{big.NewInt(1), big.NewInt(1)})
+			digest := sha256.Sum256(warmUpMessage)
+			ecdsa.VerifyASN1(pk, digest[:], sig)
+		case *rsa.PublicKey:
+			sig := make([]byte, pk.Size())
+			sig[len(sig)-1] = 1
+			digest := sha512.Sum512(warmUpMessage)
+			rsa.VerifyPSS(pk, crypto.SHA512, digest[:], sig, nil)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package httpsig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/remitly-oss/httpsig-go"
+)
+
+func TestWarmUp(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecJWK, ecKeyID := publicJWK(t, ecKey)
+	rsaJWK, rsaKeyID := publicJWK(t, rsaKey)
+
+	v, err := NewValidator([]json.RawMessage{ed25519JWK(testPrivateKey), ecJWK, rsaJWK}, ValidatorOptions{
+		AllowedAlgorithms: supportedAlgorithms,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.WarmUp()
+
+	for _, tt := range []struct {
+		algo  httpsig.Algorithm
+		key   crypto.PrivateKey
+		keyid string
+	}{
+		{httpsig.Algo_ED25519, testPrivateKey, testKeyID},
+		{httpsig.Algo_ECDSA_P256_SHA256, ecKey, ecKeyID},
+		{httpsig.Algo_RSA_PSS_SHA512, rsaKey, rsaKeyID},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		signRequestWith(t, r, tt.algo, tt.key, tt.keyid)
+		if _, err := v.Validate(r); err != nil {
+			t.Errorf("%s after warm-up: %v", tt.algo, err)
+		}
+	}
+}
+
+// BenchmarkFirstVerification measures the first verification by a freshly loaded validator.
+// Crypto state shared by the process is only cold in the very first iteration, so compare
+// cold start latency with -benchtime=1x -count=10.
+func BenchmarkFirstVerification(b *testing.B) {
+	key := ed25519JWK(testPrivateKey)
+	for _, warm := range []bool{false, true} {
+		name := "cold"
+		if warm {
+			name = "warm"
+		}
+		b.Run(name, func(b *testing.B) {
+			for b.Loop() {
+				b.StopTimer()
+				v, err := NewValidator([]json.RawMessage{key}, ValidatorOptions{})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if warm {
+					v.WarmUp()
+				}
+				r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+				err = httpsig.Sign(r, httpsig.SigningProfile{
+					Algorithm: httpsig.Algo_ED25519,
+					Fields:    httpsig.Fields("@authority"),
+					Metadata:  []httpsig.Metadata{httpsig.MetaCreated, httpsig.MetaExpires, httpsig.MetaKeyID, httpsig.MetaTag},
+				}, httpsig.SigningKey{Key: testPrivateKey, MetaKeyID: testKeyID, MetaTag: "web-bot-auth"})
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+
+				if _, err := v.Validate(r); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package webbotauthtest_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	httpsig "github.com/cloudflareresearch/web-bot-auth/examples/caddy-plugin"
+	"github.com/cloudflareresearch/web-bot-auth/examples/caddy-plugin/webbotauthtest"
+)
+
+// A handler behind signature verification is tested with valid, unsigned and expired requests.
+// The fake clock makes expiry deterministic.
+func Example() {
+	bot, err := webbotauthtest.NewKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	clock := webbotauthtest.NewFakeClock(time.Now())
+	validator, err := httpsig.NewValidator([]json.RawMessage{bot.JWK}, httpsig.ValidatorOptions{Now: clock.Now})
+	if err != nil {
+		log.Fatal(err)
+	}
+	app := validator.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello bot")
+	}))
+
+	status := func(r *http.Request) int {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	valid := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	if err := webbotauthtest.SignRequest(valid, bot); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("valid:", status(valid))
+
+	fmt.Println("unsigned:", status(httptest.NewRequest(http.MethodGet, "https://example.com/", nil)))
+
+	expired := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	if err := webbotauthtest.SignRequest(expired, bot); err != nil {
+		log.Fatal(err)
+	}
+	clock.Advance(10 * time.Minute)
+	fmt.Println("expired:", status(expired))
+	// Output:
+	// valid: 200
+	// unsigned: 401
+	// expired: 401
+}
+
+// The middleware fetches keys from the fake fetcher instead of the network
+func ExampleFakeDirectoryFetcher() {
+	bot, err := webbotauthtest.NewKey()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fetcher := webbotauthtest.NewFakeDirectoryFetcher(bot)
+	m := &httpsig.Middleware{DirectoryBase: "bot.example", Fetcher: fetcher}
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	if err := m.Provision(ctx); err != nil {
+		log.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	if err := webbotauthtest.SignRequest(r, bot); err != nil {
+		log.Fatal(err)
+	}
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		fmt.Println("verified")
+		return nil
+	})
+	if err := m.ServeHTTP(httptest.NewRecorder(), r, next); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("fetches:", fetcher.Calls())
+	// Output:
+	// verified
+	// fetches: 1
+}
@@ -0,0 +1,167 @@
+// Package webbotauthtest provides test doubles for code that verifies web-bot-auth signatures:
+// a controllable clock, an in-memory directory fetcher and bot keys that sign requests.
+//
+// Plug them into the validator or middleware to test handlers behind signature verification
+// deterministically, without a network directory or waiting for signatures to expire.
+//
+//	clock := webbotauthtest.NewFakeClock(time.Now())
+//	bot, _ := webbotauthtest.NewKey()
+//	v, _ := httpsig.NewValidator([]json.RawMessage{bot.JWK}, httpsig.ValidatorOptions{Now: clock.Now})
+package webbotauthtest
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	httpsig "github.com/cloudflareresearch/web-bot-auth/examples/caddy-plugin"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	sighttp "github.com/remitly-oss/httpsig-go"
+)
+
+// FakeClock is a clock that only moves when told to. It is safe for concurrent use.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock returns a clock stopped at now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the current time of the clock. Pass it as ValidatorOptions.Now or Middleware.Now.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set moves the clock to now
+func (c *FakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// FakeDirectoryFetcher serves a directory from memory, whatever directory_base is requested.
+// It is safe for concurrent use.
+type FakeDirectoryFetcher struct {
+	mu        sync.Mutex
+	directory httpsig.Directory
+	err       error
+	calls     int
+}
+
+// NewFakeDirectoryFetcher returns a fetcher serving the directory of keys
+func NewFakeDirectoryFetcher(keys ...Key) *FakeDirectoryFetcher {
+	return &FakeDirectoryFetcher{directory: Directory(keys...)}
+}
+
+// Fetch implements httpsig.DirectoryFetcher
+func (f *FakeDirectoryFetcher) Fetch(ctx context.Context, base string) (httpsig.Directory, httpsig.FetchMeta, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	meta := httpsig.FetchMeta{URL: base, FetchedAt: time.Now()}
+	if f.err != nil {
+		return httpsig.Directory{}, meta, f.err
+	}
+	return f.directory, meta, nil
+}
+
+// SetKeys replaces the served directory with one holding keys, as a bot rotating them would
+func (f *FakeDirectoryFetcher) SetKeys(keys ...Key) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.directory = Directory(keys...)
+	f.err = nil
+}
+
+// Fail makes the following fetches return err
+func (f *FakeDirectoryFetcher) Fail(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.err = err
+}
+
+// Calls returns the number of fetches so far
+func (f *FakeDirectoryFetcher) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// Key is an Ed25519 bot key
+type Key struct {
+	Private ed25519.PrivateKey
+	// JWK is the public key, as published in a directory or configured in static_keys
+	JWK json.RawMessage
+	// KeyID is the JWK thumbprint, which signatures carry as keyid
+	KeyID string
+}
+
+// NewKey generates a random bot key
+func NewKey() (Key, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return Key{}, err
+	}
+	return KeyFrom(private)
+}
+
+// KeyFrom returns the bot key for private, such as the RFC 9421 test key, so that signatures are reproducible
+func KeyFrom(private ed25519.PrivateKey) (Key, error) {
+	x := base64.RawURLEncoding.EncodeToString(private.Public().(ed25519.PublicKey))
+	data := fmt.Appendf(nil, `{"kty":"OKP","crv":"Ed25519","x":"%s"}`, x)
+	public, err := jwk.ParseKey(data)
+	if err != nil {
+		return Key{}, err
+	}
+	thumbprint, err := public.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return Key{}, err
+	}
+	return Key{Private: private, JWK: data, KeyID: base64.RawURLEncoding.EncodeToString(thumbprint)}, nil
+}
+
+// Directory returns a directory publishing keys
+func Directory(keys ...Key) httpsig.Directory {
+	dir := httpsig.Directory{Keys: make([]json.RawMessage, 0, len(keys))}
+	for _, key := range keys {
+		dir.Keys = append(dir.Keys, key.JWK)
+	}
+	return dir
+}
+
+// SignRequest signs r with key the way a web-bot-auth bot does: over fields, which default to @authority,
+// with created, expires, keyid and the web-bot-auth tag. Signatures expire five minutes after the wall clock time
+// they were made, so advance a FakeClock past that to test expiry.
+func SignRequest(r *http.Request, key Key, fields ...string) error {
+	if len(fields) == 0 {
+		fields = []string{"@authority"}
+	}
+	return sighttp.Sign(r, sighttp.SigningProfile{
+		Algorithm: sighttp.Algo_ED25519,
+		Fields:    sighttp.Fields(fields...),
+		Metadata:  []sighttp.Metadata{sighttp.MetaCreated, sighttp.MetaExpires, sighttp.MetaKeyID, sighttp.MetaTag},
+	}, sighttp.SigningKey{
+		Key:       key.Private,
+		MetaKeyID: key.KeyID,
+		MetaTag:   "web-bot-auth",
+	})
+}
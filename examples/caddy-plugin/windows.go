@@ -0,0 +1,98 @@
+package httpsig
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// AllowedWindows restricts verified bot traffic to daily time ranges, to protect peak capacity
+type AllowedWindows struct {
+	// Timezone is an IANA time zone name such as "Europe/London". Defaults to UTC.
+	Timezone string `json:"timezone,omitempty"`
+	// Ranges are daily "HH:MM-HH:MM" ranges in Timezone. A range ending before it starts wraps past midnight.
+	Ranges []string `json:"ranges"`
+}
+
+// dailyRange is a time range repeated every day, in minutes since midnight. The end is exclusive.
+type dailyRange struct {
+	start, end int
+}
+
+// contains reports whether the minute of the day falls in the range
+func (dr dailyRange) contains(minute int) bool {
+	if dr.start <= dr.end {
+		return minute >= dr.start && minute < dr.end
+	}
+	return minute >= dr.start || minute < dr.end
+}
+
+// timeWindows is the parsed form of AllowedWindows
+type timeWindows struct {
+	loc    *time.Location
+	ranges []dailyRange
+	now    func() time.Time
+}
+
+// parseClock parses "HH:MM" into minutes since midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, must be HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// newTimeWindows validates the allowed windows
+func newTimeWindows(aw AllowedWindows) (*timeWindows, error) {
+	tw := &timeWindows{loc: time.UTC, now: time.Now}
+	if aw.Timezone != "" {
+		loc, err := time.LoadLocation(aw.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("allowed_windows timezone: %w", err)
+		}
+		tw.loc = loc
+	}
+	if len(aw.Ranges) == 0 {
+		return nil, fmt.Errorf("allowed_windows has no ranges")
+	}
+	for _, r := range aw.Ranges {
+		start, end, ok := strings.Cut(r, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid allowed_windows range %q, must be HH:MM-HH:MM", r)
+		}
+		var dr dailyRange
+		var err error
+		if dr.start, err = parseClock(start); err != nil {
+			return nil, err
+		}
+		if dr.end, err = parseClock(end); err != nil {
+			return nil, err
+		}
+		if dr.start == dr.end {
+			return nil, fmt.Errorf("allowed_windows range %q is empty", r)
+		}
+		tw.ranges = append(tw.ranges, dr)
+	}
+	return tw, nil
+}
+
+// untilOpen returns zero when verified traffic is accepted now, or how long until the next window opens
+func (tw *timeWindows) untilOpen() time.Duration {
+	now := tw.now().In(tw.loc)
+	minute := now.Hour()*60 + now.Minute()
+	next := time.Duration(math.MaxInt64)
+	for _, dr := range tw.ranges {
+		if dr.contains(minute) {
+			return 0
+		}
+		// Wall clock arithmetic through time.Date keeps window starts right across DST changes
+		start := time.Date(now.Year(), now.Month(), now.Day(), dr.start/60, dr.start%60, 0, 0, tw.loc)
+		if !start.After(now) {
+			start = time.Date(now.Year(), now.Month(), now.Day()+1, dr.start/60, dr.start%60, 0, 0, tw.loc)
+		}
+		next = min(next, start.Sub(now))
+	}
+	return next
+}
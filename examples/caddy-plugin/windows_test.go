@@ -0,0 +1,77 @@
+package httpsig
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAllowedWindows(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("time zone database unavailable:", err)
+	}
+	windows := AllowedWindows{Timezone: "Europe/London", Ranges: []string{"01:00-06:00", "22:00-00:30"}}
+
+	tests := []struct {
+		name           string
+		now            time.Time
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{name: "in window", now: time.Date(2026, 3, 10, 3, 0, 0, 0, london), wantStatus: http.StatusOK},
+		{name: "in wrapping window before midnight", now: time.Date(2026, 3, 10, 23, 0, 0, 0, london), wantStatus: http.StatusOK},
+		{name: "in wrapping window after midnight", now: time.Date(2026, 3, 10, 0, 15, 0, 0, london), wantStatus: http.StatusOK},
+		{name: "window end is exclusive", now: time.Date(2026, 3, 10, 6, 0, 0, 0, london), wantStatus: http.StatusServiceUnavailable, wantRetryAfter: "57600"},
+		{name: "before window", now: time.Date(2026, 3, 10, 0, 45, 0, 0, london), wantStatus: http.StatusServiceUnavailable, wantRetryAfter: "900"},
+		{name: "peak hours", now: time.Date(2026, 3, 10, 12, 0, 0, 0, london), wantStatus: http.StatusServiceUnavailable, wantRetryAfter: "36000"},
+		// Clocks go forward at 01:00 on 29 March 2026, so the window opens at 02:00 BST, 15 minutes later
+		{name: "across DST change", now: time.Date(2026, 3, 29, 0, 45, 0, 0, london), wantStatus: http.StatusServiceUnavailable, wantRetryAfter: "900"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tw, err := newTimeWindows(windows)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tw.now = func() time.Time { return tt.now }
+			m := &Middleware{windows: tw}
+			m.validator.Store(newTestValidator(t))
+
+			w := httptest.NewRecorder()
+			if err := m.ServeHTTP(w, newSignedRequest(t), okHandler{}); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+
+			// Unsigned requests are rejected as before, regardless of the window
+			w = httptest.NewRecorder()
+			if err := m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.com/", nil), okHandler{}); err != nil {
+				t.Fatal(err)
+			}
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("unsigned status = %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+func TestNewTimeWindowsInvalid(t *testing.T) {
+	for _, aw := range []AllowedWindows{
+		{Ranges: nil},
+		{Ranges: []string{"01:00"}},
+		{Ranges: []string{"25:00-26:00"}},
+		{Ranges: []string{"01:00-01:00"}},
+		{Timezone: "Mars/Olympus", Ranges: []string{"01:00-02:00"}},
+	} {
+		if _, err := newTimeWindows(aw); err == nil {
+			t.Errorf("newTimeWindows(%+v) succeeded", aw)
+		}
+	}
+}
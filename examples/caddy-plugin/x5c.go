@@ -0,0 +1,93 @@
+package httpsig
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrKeyCertificate is returned for directory keys whose x5c chain does not verify against key_cas, or without
+// a chain when require_key_certificates is set
+var ErrKeyCertificate = errors.New("key certificate is not trusted")
+
+// parseX5C decodes the x5c member of a JWK, base64 DER certificates with the one of the key first
+func parseX5C(keyData []byte) ([]*x509.Certificate, error) {
+	var member struct {
+		X5C []string `json:"x5c"`
+	}
+	if err := json.Unmarshal(keyData, &member); err != nil {
+		return nil, fmt.Errorf("reading x5c: %w", err)
+	}
+	certs := make([]*x509.Certificate, 0, len(member.X5C))
+	for i, encoded := range member.X5C {
+		der, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("x5c %d: %w", i, err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("x5c %d: %w", i, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, nil
+	}
+	return certs, nil
+}
+
+// verifyKeyCertificate checks that the x5c chain of ks verifies against roots at now and that its leaf certifies
+// the key itself. Keys without a chain pass unless required.
+func verifyKeyCertificate(ks keySpec, roots *x509.CertPool, required bool, now time.Time) error {
+	if len(ks.Certificates) == 0 {
+		if required {
+			return fmt.Errorf("%w: key %s has no x5c chain", ErrKeyCertificate, ks.KeyID)
+		}
+		return nil
+	}
+	leaf := ks.Certificates[0]
+	if pub, ok := ks.PubKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(leaf.PublicKey) {
+		return fmt.Errorf("%w: key %s does not match its x5c certificate", ErrKeyCertificate, ks.KeyID)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range ks.Certificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return fmt.Errorf("%w: key %s: %v", ErrKeyCertificate, ks.KeyID, err)
+	}
+	return nil
+}
+
+// keyCertificates are the key_cas directory keys are certified by
+type keyCertificates struct {
+	roots *x509.CertPool
+	// required drops keys without an x5c chain
+	required bool
+}
+
+// certified returns the keys whose certificate verifies, and the errors of those dropped. A nil c keeps every key.
+func (c *keyCertificates) certified(keys []keySpec) ([]keySpec, error) {
+	if c == nil {
+		return keys, nil
+	}
+	certified := make([]keySpec, 0, len(keys))
+	var errs []error
+	for _, key := range keys {
+		if err := verifyKeyCertificate(key, c.roots, c.required, time.Now()); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		certified = append(certified, key)
+	}
+	return certified, errors.Join(errs...)
+}
@@ -0,0 +1,128 @@
+package httpsig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA is a CA issuing certificates for test keys
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "bot CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return testCA{cert: cert, key: key}
+}
+
+// file writes the CA certificate as PEM
+func (ca testCA) file(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// certifiedJWK returns the JWK of key with an x5c chain whose leaf, issued by ca, certifies pub
+func (ca testCA) certifiedJWK(t *testing.T, key ed25519.PrivateKey, pub crypto.PublicKey) json.RawMessage {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "bot"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, pub, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := base64.RawURLEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+	return fmt.Appendf(nil, `{"kty":"OKP","crv":"Ed25519","x":"%s","x5c":["%s"]}`, x, base64.StdEncoding.EncodeToString(der))
+}
+
+func TestKeyCertificates(t *testing.T) {
+	ca, otherCA := newTestCA(t), newTestCA(t)
+	bareKey, bareJWK, bareKeyID := generateKey(t)
+	mismatchedKey, _, mismatchedKeyID := generateKey(t)
+	untrustedKey, _, untrustedKeyID := generateKey(t)
+	directory := directoryOf(
+		ca.certifiedJWK(t, testPrivateKey, testPrivateKey.Public()),
+		bareJWK,
+		ca.certifiedJWK(t, mismatchedKey, bareKey.Public()),
+		otherCA.certifiedJWK(t, untrustedKey, untrustedKey.Public()),
+	)
+
+	for _, required := range []bool{false, true} {
+		m := &Middleware{
+			DirectoryBase:          "signer.example.com",
+			KeyCAs:                 []string{ca.file(t)},
+			RequireKeyCertificates: required,
+			Fetcher:                &fakeFetcher{responses: []fakeResponse{{dir: directory}}},
+		}
+		if err := m.Provision(newTestContext(t)); err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range []struct {
+			key   ed25519.PrivateKey
+			keyid string
+			want  bool
+		}{
+			{testPrivateKey, testKeyID, true},
+			{bareKey, bareKeyID, !required},
+			{mismatchedKey, mismatchedKeyID, false},
+			{untrustedKey, untrustedKeyID, false},
+		} {
+			if _, err := m.validate(agentRequest(t, tt.key, tt.keyid, "")); (err == nil) != tt.want {
+				t.Errorf("required %v, keyid %s: err = %v, want verified %v", required, tt.keyid, err, tt.want)
+			}
+		}
+	}
+
+	if err := (&Middleware{DirectoryBase: "signer.example.com", RequireKeyCertificates: true}).Provision(newTestContext(t)); err == nil {
+		t.Error("require_key_certificates accepted without key_cas")
+	}
+	certified, _ := parseKeySpec(ca.certifiedJWK(t, testPrivateKey, testPrivateKey.Public()))
+	if err := verifyKeyCertificate(certified, x509.NewCertPool(), false, time.Now()); !errors.Is(err, ErrKeyCertificate) {
+		t.Errorf("unknown CA: err = %v, want ErrKeyCertificate", err)
+	}
+	if _, err := parseKeySpec([]byte(`{"kty":"OKP","crv":"Ed25519","x":"JrQLj5P_89iXES9-vFgrIy29clF9CC_oPPsw3c5D0bs","x5c":["bm90IGEgY2VydA=="]}`)); err == nil {
+		t.Error("malformed x5c accepted")
+	}
+}
@@ -0,0 +1,120 @@
+package webbotauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Algorithm is an RFC 9421 signature algorithm
+type Algorithm string
+
+// Algorithms of the keys Signer and Verifier support
+const (
+	Ed25519         Algorithm = "ed25519"
+	ECDSAP256SHA256 Algorithm = "ecdsa-p256-sha256"
+	RSAPSSSHA512    Algorithm = "rsa-pss-sha512"
+)
+
+// Algorithms VerifyBase also supports, for keys published with one of them, as directories may
+const (
+	ECDSAP384SHA384 Algorithm = "ecdsa-p384-sha384"
+	RSAV15SHA256    Algorithm = "rsa-v1_5-sha256"
+)
+
+// algorithmOf returns the algorithm used with a public key
+func algorithmOf(pub crypto.PublicKey) (Algorithm, error) {
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		return Ed25519, nil
+	case *ecdsa.PublicKey:
+		if pub.Curve == elliptic.P256() {
+			return ECDSAP256SHA256, nil
+		}
+		return "", fmt.Errorf("unsupported elliptic curve %s", pub.Curve.Params().Name)
+	case *rsa.PublicKey:
+		return RSAPSSSHA512, nil
+	}
+	return "", fmt.Errorf("unsupported public key type %T", pub)
+}
+
+// signRaw signs base with key, with the encodings of RFC 9421 section 3.3. key is used through crypto.Signer only,
+// so that keys held in a KMS or an HSM sign like in-memory keys.
+func signRaw(key crypto.PrivateKey, base []byte) ([]byte, error) {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	switch signer.Public().(type) {
+	case ed25519.PublicKey:
+		return signer.Sign(rand.Reader, base, crypto.Hash(0))
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(base)
+		der, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		// crypto.Signer returns ASN.1 signatures, RFC 9421 the concatenation of r and s
+		var rs struct{ R, S *big.Int }
+		if rest, err := asn1.Unmarshal(der, &rs); err != nil || len(rest) > 0 || rs.R.BitLen() > 256 || rs.S.BitLen() > 256 {
+			return nil, errors.New("malformed ECDSA signature")
+		}
+		sig := make([]byte, 64)
+		rs.R.FillBytes(sig[:32])
+		rs.S.FillBytes(sig[32:])
+		return sig, nil
+	case *rsa.PublicKey:
+		digest := sha512.Sum512(base)
+		return signer.Sign(rand.Reader, digest[:], &rsa.PSSOptions{SaltLength: 64, Hash: crypto.SHA512})
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", signer.Public())
+}
+
+// VerifyBase verifies sig over the signature base base with the key pub of algorithm alg, with the encodings of
+// RFC 9421 section 3.3. It returns ErrInvalidSignature when sig does not match.
+func VerifyBase(alg Algorithm, pub crypto.PublicKey, base, sig []byte) error {
+	var ok bool
+	switch alg {
+	case Ed25519:
+		key, isKey := pub.(ed25519.PublicKey)
+		ok = isKey && ed25519.Verify(key, base, sig)
+	case ECDSAP256SHA256:
+		key, isKey := pub.(*ecdsa.PublicKey)
+		digest := sha256.Sum256(base)
+		ok = isKey && len(sig) == 64 && ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+	case ECDSAP384SHA384:
+		key, isKey := pub.(*ecdsa.PublicKey)
+		digest := sha512.Sum384(base)
+		ok = isKey && len(sig) == 96 && ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(sig[:48]), new(big.Int).SetBytes(sig[48:]))
+	case RSAPSSSHA512:
+		key, isKey := pub.(*rsa.PublicKey)
+		digest := sha512.Sum512(base)
+		// RFC 9421 section 3.3.1 fixes the salt length at 64 bytes
+		ok = isKey && rsa.VerifyPSS(key, crypto.SHA512, digest[:], sig, &rsa.PSSOptions{SaltLength: 64, Hash: crypto.SHA512}) == nil
+	case RSAV15SHA256:
+		key, isKey := pub.(*rsa.PublicKey)
+		digest := sha256.Sum256(base)
+		ok = isKey && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	default:
+		return fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	if !ok {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// verifyRaw verifies sig over base with pub, with the algorithm Signer uses for it
+func verifyRaw(pub crypto.PublicKey, base, sig []byte) bool {
+	alg, err := algorithmOf(pub)
+	return err == nil && VerifyBase(alg, pub, base, sig) == nil
+}
@@ -0,0 +1,25 @@
+// Package webbotauthchi adapts webbotauth.Middleware to the middleware signature of Chi.
+//
+//	router := chi.NewRouter()
+//	router.Use(webbotauthchi.Middleware(webbotauth.WithKeys(dir.KeySet())))
+//
+// Chi handlers share the request context with net/http, where the verified signature is recorded.
+package webbotauthchi
+
+import (
+	"net/http"
+
+	"github.com/cloudflareresearch/web-bot-auth/go/webbotauth"
+)
+
+// Middleware returns a Chi middleware verifying requests as webbotauth.Middleware does with opts
+func Middleware(opts ...webbotauth.Option) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return webbotauth.Middleware(next, opts...)
+	}
+}
+
+// Result returns the verified signature of r, as recorded by Middleware
+func Result(r *http.Request) (*webbotauth.Result, bool) {
+	return webbotauth.ResultFromContext(r.Context())
+}
@@ -0,0 +1,13 @@
+module github.com/cloudflareresearch/web-bot-auth/go/webbotauth/chi
+
+go 1.24
+
+require (
+	github.com/cloudflareresearch/web-bot-auth/go/webbotauth v0.0.0-00010101000000-000000000000
+	github.com/go-chi/chi/v5 v5.3.2
+)
+
+require github.com/dunglas/httpsfv v1.1.0 // indirect
+
+// Until webbotauth is tagged, it is built from this repository
+replace github.com/cloudflareresearch/web-bot-auth/go/webbotauth => ../
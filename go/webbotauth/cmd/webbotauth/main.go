@@ -0,0 +1,340 @@
+// Command webbotauth generates keys, signs and verifies requests and inspects key directories, to debug
+// interoperability between web-bot-auth implementations.
+//
+//	webbotauth keygen -out bot
+//	webbotauth thumbprint bot.jwk
+//	webbotauth sign -key bot.jwk -agent https://bot.example request.txt
+//	webbotauth verify -key bot.jwk request.txt
+//	webbotauth verify -directory bot.example -at 1735690000 request.txt
+//	webbotauth fetch-directory bot.example
+//
+// Requests are HTTP/1.1 messages, such as
+//
+//	GET /path HTTP/1.1
+//	Host: example.com
+//
+// read from a file, or from standard input when the file is - or omitted.
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cloudflareresearch/web-bot-auth/go/webbotauth"
+)
+
+// commands are the subcommands, by name
+var commands = map[string]func(args []string) error{
+	"keygen":          keygen,
+	"thumbprint":      thumbprint,
+	"sign":            sign,
+	"verify":          verify,
+	"fetch-directory": fetchDirectory,
+}
+
+func main() {
+	if len(os.Args) < 2 || commands[os.Args[1]] == nil {
+		fmt.Fprintf(os.Stderr, "usage: %s <keygen|thumbprint|sign|verify|fetch-directory> [flags]\n", os.Args[0])
+		os.Exit(2)
+	}
+	if err := commands[os.Args[1]](os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// newFlagSet returns the flag set of a subcommand, printing usage on errors
+func newFlagSet(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s %s %s\n", os.Args[0], name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// keygen writes a new Ed25519 private key as <out>.jwk and <out>.pem, and its public JWK as <out>.pub.jwk
+func keygen(args []string) error {
+	fs := newFlagSet("keygen", "[-out <prefix>]")
+	out := fs.String("out", "webbotauth", "prefix of the key files written")
+	fs.Parse(args)
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	public, err := webbotauth.PublicJWK(pub)
+	if err != nil {
+		return err
+	}
+	if public.Kid, err = public.Thumbprint(); err != nil {
+		return err
+	}
+	private := public
+	private.D = base64.RawURLEncoding.EncodeToString(key.Seed())
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	for name, v := range map[string]any{*out + ".jwk": private, *out + ".pub.jwk": public} {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(name, append(data, '\n'), 0o600); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(*out+".pem", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return err
+	}
+	fmt.Printf("keyid %s\n", public.Kid)
+	return nil
+}
+
+// thumbprint prints the RFC 7638 thumbprint, the keyid, of keys given as JWKs or PEM, public or private
+func thumbprint(args []string) error {
+	fs := newFlagSet("thumbprint", "<key>...")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	for _, name := range fs.Args() {
+		pub, err := readPublicKey(name)
+		if err != nil {
+			return err
+		}
+		keyid, err := webbotauth.KeyID(pub)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		fmt.Printf("%s %s\n", keyid, name)
+	}
+	return nil
+}
+
+// sign prints the request of a file, signed
+func sign(args []string) error {
+	fs := newFlagSet("sign", "-key <private key> [flags] [request]")
+	keyFile := fs.String("key", "", "private key to sign with, as a JWK or PEM")
+	agent := fs.String("agent", "", "directory URL advertised in Signature-Agent")
+	label := fs.String("label", "", `signature label (default "sig1")`)
+	components := fs.String("components", "", "components covered besides @authority and signature-agent, comma separated")
+	validity := fs.Duration("validity", webbotauth.DefaultValidity, "how long the signature is valid")
+	scheme := fs.String("scheme", "https", "scheme the request is sent over")
+	fs.Parse(args)
+	if *keyFile == "" || fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(*keyFile)
+	if err != nil {
+		return err
+	}
+	key, err := webbotauth.ParsePrivateKey(data)
+	if err != nil {
+		return err
+	}
+	signer, err := webbotauth.NewSigner(key)
+	if err != nil {
+		return err
+	}
+	signer.Label = *label
+	signer.Validity = *validity
+	if *components != "" {
+		signer.Components = strings.Split(*components, ",")
+	}
+	r, err := readRequest(fs.Arg(0), *scheme)
+	if err != nil {
+		return err
+	}
+	if *agent != "" {
+		r.Header.Set(webbotauth.SignatureAgentHeader, `"`+*agent+`"`)
+	}
+	if err := signer.Sign(r); err != nil {
+		return err
+	}
+	if _, ok := r.Header["User-Agent"]; !ok {
+		// Keep Write from adding the Go User-Agent
+		r.Header["User-Agent"] = []string{""}
+	}
+	return r.Write(os.Stdout)
+}
+
+// verify checks the signature of the request of a file against a key or a directory
+func verify(args []string) error {
+	fs := newFlagSet("verify", "(-key <public key> | -directory <base>) [flags] [request]")
+	keyFile := fs.String("key", "", "key to verify with, as a JWK or PEM, public or private")
+	directory := fs.String("directory", "", "host or URL of the directory to fetch keys from")
+	at := fs.Int64("at", 0, "Unix time to verify at, such as when the request was captured (default now)")
+	skew := fs.Duration("skew", 0, "how far in the future signatures may be created")
+	scheme := fs.String("scheme", "https", "scheme the request was sent over")
+	fs.Parse(args)
+	if (*keyFile == "") == (*directory == "") || fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	keys := webbotauth.KeySet{}
+	if *keyFile != "" {
+		pub, err := readPublicKey(*keyFile)
+		if err != nil {
+			return err
+		}
+		keyid, err := webbotauth.KeyID(pub)
+		if err != nil {
+			return err
+		}
+		keys[keyid] = pub
+	} else {
+		dir, err := webbotauth.FetchDirectory(context.Background(), nil, *directory)
+		if err != nil {
+			return err
+		}
+		keys = dir.KeySet()
+	}
+	r, err := readRequest(fs.Arg(0), *scheme)
+	if err != nil {
+		return err
+	}
+	v := webbotauth.NewVerifier(keys)
+	v.Skew = *skew
+	if *at != 0 {
+		v.Now = func() time.Time { return time.Unix(*at, 0) }
+	}
+	result, err := v.Verify(r)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("valid signature %s\n", result.Label)
+	fmt.Printf("  keyid      %s\n", result.KeyID)
+	fmt.Printf("  algorithm  %s\n", result.Algorithm)
+	fmt.Printf("  components %s\n", strings.Join(result.Components, " "))
+	fmt.Printf("  created    %s\n", result.Created.UTC().Format(time.RFC3339))
+	fmt.Printf("  expires    %s\n", result.Expires.UTC().Format(time.RFC3339))
+	if result.Nonce != "" {
+		fmt.Printf("  nonce      %s\n", result.Nonce)
+	}
+	return nil
+}
+
+// fetchDirectory prints the keys of a directory, with their keyids
+func fetchDirectory(args []string) error {
+	fs := newFlagSet("fetch-directory", "[-json] <base>")
+	raw := fs.Bool("json", false, "print the directory as JSON")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	target, err := webbotauth.DirectoryURL(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	dir, err := webbotauth.FetchDirectory(context.Background(), nil, target)
+	if err != nil {
+		return err
+	}
+	if *raw {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(dir)
+	}
+	fmt.Printf("directory %s\n", target)
+	if dir.Purpose != "" {
+		fmt.Printf("purpose %s\n", dir.Purpose)
+	}
+	for _, k := range dir.Keys {
+		keyid, err := k.Thumbprint()
+		if err != nil {
+			keyid = fmt.Sprintf("(%v)", err)
+		}
+		if _, err := k.PublicKey(); err != nil {
+			keyid += fmt.Sprintf(" unsupported: %v", err)
+		}
+		fmt.Printf("  %s %s", k.Kty, keyid)
+		if k.Crv != "" {
+			fmt.Printf(" crv %s", k.Crv)
+		}
+		if k.Kid != "" && k.Kid != keyid {
+			fmt.Printf(" kid %s", k.Kid)
+		}
+		fmt.Println()
+	}
+	if len(dir.Keys) == 0 {
+		fmt.Println("  no keys")
+	}
+	return nil
+}
+
+// readRequest reads an HTTP/1.1 request from the file name, or standard input when name is - or empty,
+// as sent over scheme
+func readRequest(name, scheme string) (*http.Request, error) {
+	var in io.Reader = os.Stdin
+	if name != "" && name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		in = f
+	}
+	r, err := http.ReadRequest(bufio.NewReader(in))
+	if err != nil {
+		return nil, fmt.Errorf("reading request: %w", err)
+	}
+	// Buffer the body, since the file is closed before the request is written
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading request body: %w", err)
+	}
+	r.Body = io.NopCloser(strings.NewReader(string(body)))
+	r.URL.Scheme = scheme
+	r.URL.Host = r.Host
+	return r, nil
+}
+
+// readPublicKey returns the public key of the file name, a JWK or PEM, of a public or private key
+func readPublicKey(name string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil && block.Type == "PUBLIC KEY" {
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return pub, nil
+	}
+	if k, err := webbotauth.ParseJWK(data); err == nil && k.D == "" {
+		return k.PublicKey()
+	}
+	key, err := webbotauth.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	private, ok := key.(interface{ Public() crypto.PublicKey })
+	if !ok {
+		return nil, errors.New(name + ": not a private key")
+	}
+	return private.Public(), nil
+}
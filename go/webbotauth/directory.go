@@ -0,0 +1,92 @@
+package webbotauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DirectoryPath is the well-known location of a key directory
+const DirectoryPath = "/.well-known/http-message-signatures-directory"
+
+// DirectoryMediaType is the media type of key directories
+const DirectoryMediaType = "application/http-message-signatures-directory+json"
+
+// maxDirectorySize bounds the body read from a directory
+const maxDirectorySize = 1 << 20
+
+// Directory is a key directory, a JWK Set published by a bot operator
+type Directory struct {
+	Keys    []JWK  `json:"keys"`
+	Purpose string `json:"purpose,omitempty"`
+}
+
+// KeySet returns the keys of d by their thumbprint. Keys of unsupported types are skipped.
+func (d *Directory) KeySet() KeySet {
+	ks := KeySet{}
+	for _, k := range d.Keys {
+		pub, err := k.PublicKey()
+		if err != nil {
+			continue
+		}
+		keyid, err := k.Thumbprint()
+		if err != nil {
+			continue
+		}
+		ks[keyid] = pub
+	}
+	return ks
+}
+
+// DirectoryURL returns the URL of the directory of base, a host or an https URL. A URL without a path
+// points at DirectoryPath.
+func DirectoryURL(base string) (string, error) {
+	if !strings.Contains(base, "://") {
+		base = "https://" + base
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("parsing directory %s: %w", base, err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("directory %s is not an https URL", base)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = DirectoryPath
+	}
+	return u.String(), nil
+}
+
+// FetchDirectory retrieves the directory of base, a host or URL as accepted by DirectoryURL, with client.
+// A nil client uses http.DefaultClient.
+func FetchDirectory(ctx context.Context, client *http.Client, base string) (*Directory, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	target, err := DirectoryURL(base)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", DirectoryMediaType+", application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching directory %s: %w", target, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching directory %s: status %d", target, resp.StatusCode)
+	}
+	var dir Directory
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDirectorySize)).Decode(&dir); err != nil {
+		return nil, fmt.Errorf("decoding directory %s: %w", target, err)
+	}
+	return &dir, nil
+}
@@ -0,0 +1,50 @@
+package webbotauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchDirectory(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != DirectoryPath {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", DirectoryMediaType)
+		w.Write([]byte(`{"keys":[{"kty":"OKP","crv":"Ed25519","x":"JrQLj5P_89iXES9-vFgrIy29clF9CC_oPPsw3c5D0bs","nbf":1743465600},{"kty":"oct","k":"c2VjcmV0"}],"purpose":"rag"}`))
+	}))
+	defer srv.Close()
+
+	dir, err := FetchDirectory(context.Background(), srv.Client(), srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir.Purpose != "rag" {
+		t.Errorf("purpose = %q, want rag", dir.Purpose)
+	}
+	ks := dir.KeySet()
+	if _, ok := ks[testKeyID]; !ok || len(ks) != 1 {
+		t.Errorf("key set = %v, want only %s", ks, testKeyID)
+	}
+}
+
+func TestDirectoryURL(t *testing.T) {
+	tests := map[string]string{
+		"signer.example.com":                   "https://signer.example.com" + DirectoryPath,
+		"https://signer.example.com/":          "https://signer.example.com" + DirectoryPath,
+		"https://signer.example.com/keys.json": "https://signer.example.com/keys.json",
+		"https://signer.example.com:8443?v=2":  "https://signer.example.com:8443" + DirectoryPath + "?v=2",
+	}
+	for base, want := range tests {
+		got, err := DirectoryURL(base)
+		if err != nil || got != want {
+			t.Errorf("DirectoryURL(%q) = %q, %v, want %q", base, got, err, want)
+		}
+	}
+	if _, err := DirectoryURL("http://signer.example.com"); err == nil {
+		t.Error("plain http directory accepted")
+	}
+}
@@ -0,0 +1,158 @@
+package webbotauth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cloudflareresearch/web-bot-auth/go/webbotauth/httpmsgsig"
+	sfv "github.com/dunglas/httpsfv"
+)
+
+// DirectoryTag is the tag parameter of the signatures over directory responses
+const DirectoryTag = "http-message-signatures-directory"
+
+// DirectoryHandler serves the key directory of Keys. Each response is signed by every key, binding the directory
+// to the authority it is served from and, with Content-Digest, to its content.
+//
+//	mux.Handle(webbotauth.DirectoryPath, handler)
+type DirectoryHandler struct {
+	// Keys are the private keys whose public JWKs the directory publishes, in memory or any crypto.Signer
+	Keys []crypto.PrivateKey
+	// Purpose is the purpose member of the directory, omitted when empty
+	Purpose string
+	// Validity is how long response signatures are valid. Defaults to DefaultValidity.
+	Validity time.Duration
+	// Now is the clock setting created. Defaults to time.Now.
+	Now func() time.Time
+
+	once   sync.Once
+	err    error
+	body   []byte
+	keyids []string
+	digest string
+}
+
+// NewDirectoryHandler returns a handler publishing the public keys of keys, for purpose
+func NewDirectoryHandler(purpose string, keys ...crypto.PrivateKey) (*DirectoryHandler, error) {
+	h := &DirectoryHandler{Keys: keys, Purpose: purpose}
+	if err := h.init(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// init encodes the directory of h and the keyids of its keys, once: Keys and Purpose are not read afterwards
+func (h *DirectoryHandler) init() error {
+	h.once.Do(func() { h.err = h.encode() })
+	return h.err
+}
+
+// encode encodes the directory of h and the keyids of its keys
+func (h *DirectoryHandler) encode() error {
+	dir := Directory{Keys: make([]JWK, 0, len(h.Keys)), Purpose: h.Purpose}
+	h.keyids = make([]string, 0, len(h.Keys))
+	for _, key := range h.Keys {
+		private, ok := key.(crypto.Signer)
+		if !ok {
+			return fmt.Errorf("unsupported private key type %T", key)
+		}
+		k, err := PublicJWK(private.Public())
+		if err != nil {
+			return err
+		}
+		if k.Kid, err = k.Thumbprint(); err != nil {
+			return err
+		}
+		dir.Keys = append(dir.Keys, k)
+		h.keyids = append(h.keyids, k.Kid)
+	}
+	body, err := json.Marshal(dir)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256(body)
+	h.body = body
+	h.digest = "sha-256=:" + base64.StdEncoding.EncodeToString(digest[:]) + ":"
+	return nil
+}
+
+// ServeHTTP answers GET and HEAD requests with the signed directory
+func (h *DirectoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := h.init(); err != nil {
+		http.Error(w, "Invalid directory keys", http.StatusInternalServerError)
+		return
+	}
+	header := w.Header()
+	header.Set("Content-Type", DirectoryMediaType)
+	header.Set("Content-Digest", h.digest)
+	if err := h.sign(r, header); err != nil {
+		http.Error(w, "Signing directory failed", http.StatusInternalServerError)
+		return
+	}
+	header.Set("Content-Length", strconv.Itoa(len(h.body)))
+	if r.Method == http.MethodGet {
+		w.Write(h.body)
+	}
+}
+
+// sign sets the Signature-Input and Signature fields of the directory response to r, labeled binding0 onwards
+// in the order of Keys
+func (h *DirectoryHandler) sign(r *http.Request, header http.Header) error {
+	now := time.Now
+	if h.Now != nil {
+		now = h.Now
+	}
+	validity := h.Validity
+	if validity == 0 {
+		validity = DefaultValidity
+	}
+	if len(h.Keys) == 0 {
+		return nil
+	}
+	created := now()
+	for i, key := range h.Keys {
+		alg, err := algorithmOf(key.(crypto.Signer).Public())
+		if err != nil {
+			return err
+		}
+		nonce := make([]byte, NonceLength)
+		if _, err := rand.Read(nonce); err != nil {
+			return err
+		}
+		authority := sfv.NewItem("@authority")
+		authority.Params.Add("req", true)
+		input := sfv.InnerList{Items: []sfv.Item{authority, sfv.NewItem("content-digest")}, Params: sfv.NewParams()}
+		input.Params.Add("alg", string(alg))
+		input.Params.Add("created", created.Unix())
+		input.Params.Add("expires", created.Add(validity).Unix())
+		input.Params.Add("keyid", h.keyids[i])
+		input.Params.Add("nonce", base64.StdEncoding.EncodeToString(nonce))
+		input.Params.Add("tag", DirectoryTag)
+
+		base, err := httpmsgsig.ResponseBase(r, http.StatusOK, header, input)
+		if err != nil {
+			return err
+		}
+		sig, err := signRaw(key, base)
+		if err != nil {
+			return err
+		}
+		if err := httpmsgsig.AddSignature(header, "binding"+strconv.Itoa(i), input, sig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
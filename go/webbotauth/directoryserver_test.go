@@ -0,0 +1,106 @@
+package webbotauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudflareresearch/web-bot-auth/go/webbotauth/httpmsgsig"
+	sfv "github.com/dunglas/httpsfv"
+)
+
+func TestDirectoryHandler(t *testing.T) {
+	edKey, err := ParsePrivateKey([]byte(testJWK))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	h, err := NewDirectoryHandler("rag", edKey, ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "https://signer.example.com"+DirectoryPath, nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != DirectoryMediaType {
+		t.Errorf("Content-Type = %s, want %s", ct, DirectoryMediaType)
+	}
+	var dir Directory
+	if err := json.Unmarshal(w.Body.Bytes(), &dir); err != nil {
+		t.Fatal(err)
+	}
+	ks := dir.KeySet()
+	if dir.Purpose != "rag" || len(ks) != 2 || ks[testKeyID] == nil {
+		t.Errorf("directory = %+v", dir)
+	}
+	digest := sha256.Sum256(w.Body.Bytes())
+	if got, want := w.Header().Get("Content-Digest"), "sha-256=:"+base64.StdEncoding.EncodeToString(digest[:])+":"; got != want {
+		t.Errorf("Content-Digest = %s, want %s", got, want)
+	}
+
+	// Every key signs the response, bound to the authority it was requested from
+	inputs, err := sfv.UnmarshalDictionary(w.Header().Values("Signature-Input"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signatures, err := sfv.UnmarshalDictionary(w.Header().Values("Signature"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, key := range []crypto.PrivateKey{edKey, ecKey} {
+		label := []string{"binding0", "binding1"}[i]
+		member, ok := inputs.Get(label)
+		if !ok {
+			t.Fatalf("no %s signature", label)
+		}
+		input := member.(sfv.InnerList)
+		if tag, _ := input.Params.Get("tag"); tag != DirectoryTag {
+			t.Errorf("%s: tag = %v, want %s", label, tag, DirectoryTag)
+		}
+		keyid, _ := input.Params.Get("keyid")
+		pub := key.(interface{ Public() crypto.PublicKey }).Public()
+		if ks[keyid.(string)] == nil {
+			t.Errorf("%s: keyid %v is not in the directory", label, keyid)
+		}
+		base, err := httpmsgsig.ResponseBase(r, http.StatusOK, w.Header(), input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, _ := signatures.Get(label)
+		if !verifyRaw(pub, base, sig.(sfv.Item).Value.([]byte)) {
+			t.Errorf("%s: signature does not verify", label)
+		}
+		other := httptest.NewRequest(http.MethodGet, "https://other.example"+DirectoryPath, nil)
+		if base, _ := httpmsgsig.ResponseBase(other, http.StatusOK, w.Header(), input); verifyRaw(pub, base, sig.(sfv.Item).Value.([]byte)) {
+			t.Errorf("%s: signature verifies for another authority", label)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodHead, "https://signer.example.com"+DirectoryPath, nil))
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Signature") == "" {
+		t.Errorf("HEAD: status = %d, body = %q, Signature = %q", w.Code, w.Body, w.Header().Get("Signature"))
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "https://signer.example.com"+DirectoryPath, nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestNewDirectoryHandlerUnsupportedKey(t *testing.T) {
+	if _, err := NewDirectoryHandler("", "not a key"); err == nil {
+		t.Error("NewDirectoryHandler accepted a string as key")
+	}
+}
@@ -0,0 +1,14 @@
+// Package webbotauth implements HTTP Message Signatures for bots, as defined in
+// draft-meunier-web-bot-auth-architecture, mirroring the Rust and TypeScript web-bot-auth packages.
+//
+// Bots sign requests with a Signer, keyed by the JWK thumbprint of their key:
+//
+//	signer, _ := webbotauth.NewSigner(privateKey)
+//	req.Header.Set("Signature-Agent", `"https://bot.example"`)
+//	signer.Sign(req)
+//
+// Origins verify them against the keys of the bot's directory:
+//
+//	dir, _ := webbotauth.FetchDirectory(ctx, nil, "bot.example")
+//	result, err := webbotauth.NewVerifier(dir.KeySet()).Verify(req)
+package webbotauth
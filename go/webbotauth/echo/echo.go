@@ -0,0 +1,53 @@
+// Package webbotauthecho adapts webbotauth.Middleware to Echo, recording the verified signature in the echo.Context.
+//
+//	e := echo.New()
+//	e.Use(webbotauthecho.Middleware(webbotauth.WithKeys(dir.KeySet())))
+package webbotauthecho
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cloudflareresearch/web-bot-auth/go/webbotauth"
+	"github.com/labstack/echo/v4"
+)
+
+// ResultKey is the echo.Context key of the Result of verified requests
+const ResultKey = "webbotauth.result"
+
+// contextKey is the request context key of the serving state of a request
+type contextKey struct{}
+
+// serving is a request being served by Middleware
+type serving struct {
+	c    echo.Context
+	next echo.HandlerFunc
+	err  error
+}
+
+// Middleware returns an Echo middleware verifying requests as webbotauth.Middleware does with opts. Rejected
+// requests are answered by the rejection of opts, without reaching the next handler.
+func Middleware(opts ...webbotauth.Option) echo.MiddlewareFunc {
+	verify := webbotauth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := r.Context().Value(contextKey{}).(*serving)
+		s.c.SetRequest(r)
+		if result, ok := webbotauth.ResultFromContext(r.Context()); ok {
+			s.c.Set(ResultKey, result)
+		}
+		s.err = s.next(s.c)
+	}), opts...)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			s := &serving{c: c, next: next}
+			r := c.Request()
+			verify.ServeHTTP(c.Response(), r.WithContext(context.WithValue(r.Context(), contextKey{}, s)))
+			return s.err
+		}
+	}
+}
+
+// Result returns the verified signature of the request c serves, as recorded by Middleware
+func Result(c echo.Context) (*webbotauth.Result, bool) {
+	result, ok := c.Get(ResultKey).(*webbotauth.Result)
+	return result, ok
+}
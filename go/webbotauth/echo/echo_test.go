@@ -0,0 +1,62 @@
+package webbotauthecho
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cloudflareresearch/web-bot-auth/go/webbotauth"
+	"github.com/labstack/echo/v4"
+)
+
+func TestMiddleware(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := webbotauth.NewSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := webbotauth.KeySet{signer.KeyID: pub}
+
+	tests := []struct {
+		name       string
+		opts       []webbotauth.Option
+		sign       bool
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "signed", opts: []webbotauth.Option{webbotauth.WithKeys(keys)}, sign: true, wantStatus: http.StatusOK, wantBody: signer.KeyID},
+		{name: "unsigned", opts: []webbotauth.Option{webbotauth.WithKeys(keys)}, wantStatus: http.StatusUnauthorized},
+		{name: "optional", opts: []webbotauth.Option{webbotauth.WithKeys(keys), webbotauth.WithOptional()}, wantStatus: http.StatusOK, wantBody: "anonymous"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.Use(Middleware(tt.opts...))
+			e.GET("/", func(c echo.Context) error {
+				if result, ok := Result(c); ok {
+					return c.String(http.StatusOK, result.KeyID)
+				}
+				return c.String(http.StatusOK, "anonymous")
+			})
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+			if tt.sign {
+				if err := signer.Sign(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+module github.com/cloudflareresearch/web-bot-auth/go/webbotauth/echo
+
+go 1.24.0
+
+require (
+	github.com/cloudflareresearch/web-bot-auth/go/webbotauth v0.0.0-00010101000000-000000000000
+	github.com/labstack/echo/v4 v4.13.4
+)
+
+require (
+	github.com/dunglas/httpsfv v1.1.0 // indirect
+	github.com/labstack/gommon v0.5.0 // indirect
+	github.com/mattn/go-colorable v0.1.15 // indirect
+	github.com/mattn/go-isatty v0.0.22 // indirect
+	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	github.com/valyala/fasttemplate v1.2.2 // indirect
+	golang.org/x/crypto v0.48.0 // indirect
+	golang.org/x/net v0.50.0 // indirect
+	golang.org/x/sys v0.41.0 // indirect
+	golang.org/x/text v0.34.0 // indirect
+)
+
+// Until webbotauth is tagged, it is built from this repository
+replace github.com/cloudflareresearch/web-bot-auth/go/webbotauth => ../
@@ -0,0 +1,57 @@
+// Package webbotauthgin adapts webbotauth.Middleware to Gin, recording the verified signature in the gin.Context.
+//
+//	router := gin.New()
+//	router.Use(webbotauthgin.Middleware(webbotauth.WithKeys(dir.KeySet())))
+package webbotauthgin
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/cloudflareresearch/web-bot-auth/go/webbotauth"
+	"github.com/gin-gonic/gin"
+)
+
+// ResultKey is the gin.Context key of the Result of verified requests
+const ResultKey = "webbotauth.result"
+
+// contextKey is the request context key of the serving state of a request
+type contextKey struct{}
+
+// serving is a request being served by Middleware
+type serving struct {
+	c       *gin.Context
+	reached bool
+}
+
+// Middleware returns a Gin middleware verifying requests as webbotauth.Middleware does with opts. Rejected
+// requests are aborted.
+func Middleware(opts ...webbotauth.Option) gin.HandlerFunc {
+	verify := webbotauth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := r.Context().Value(contextKey{}).(*serving)
+		s.reached = true
+		c := s.c
+		c.Request = r
+		if result, ok := webbotauth.ResultFromContext(r.Context()); ok {
+			c.Set(ResultKey, result)
+		}
+		c.Next()
+	}), opts...)
+	return func(c *gin.Context) {
+		s := &serving{c: c}
+		verify.ServeHTTP(c.Writer, c.Request.WithContext(context.WithValue(c.Request.Context(), contextKey{}, s)))
+		if !s.reached {
+			c.Abort()
+		}
+	}
+}
+
+// Result returns the verified signature of the request c serves, as recorded by Middleware
+func Result(c *gin.Context) (*webbotauth.Result, bool) {
+	value, ok := c.Get(ResultKey)
+	if !ok {
+		return nil, false
+	}
+	result, ok := value.(*webbotauth.Result)
+	return result, ok
+}
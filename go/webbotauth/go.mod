@@ -0,0 +1,5 @@
+module github.com/cloudflareresearch/web-bot-auth/go/webbotauth
+
+go 1.24
+
+require github.com/dunglas/httpsfv v1.1.0
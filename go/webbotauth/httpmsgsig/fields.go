@@ -0,0 +1,84 @@
+package httpmsgsig
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	sfv "github.com/dunglas/httpsfv"
+)
+
+// QueryParam returns the @query-param component covering the query parameter name, for NewInput
+func QueryParam(name string) string {
+	params := sfv.NewParams()
+	params.Add("name", encodeQueryComponent(name))
+	id, _ := sfv.Marshal(sfv.Item{Value: "@query-param", Params: params})
+	return id
+}
+
+// ParseComponent returns the identifier of a component given by name, or as serialized in Signature-Input when it
+// has parameters
+func ParseComponent(component string) (sfv.Item, error) {
+	if !strings.HasPrefix(component, `"`) {
+		return sfv.NewItem(component), nil
+	}
+	item, err := sfv.UnmarshalItem([]string{component})
+	if err != nil {
+		return sfv.Item{}, fmt.Errorf("invalid component %s: %w", component, err)
+	}
+	if _, ok := item.Value.(string); !ok {
+		return sfv.Item{}, fmt.Errorf("invalid component %s", component)
+	}
+	return item, nil
+}
+
+// NewInput returns the member of Signature-Input covering components, as accepted by ParseComponent, with params
+func NewInput(components []string, params *sfv.Params) (sfv.InnerList, error) {
+	if params == nil {
+		params = sfv.NewParams()
+	}
+	input := sfv.InnerList{Params: params}
+	for _, component := range components {
+		item, err := ParseComponent(component)
+		if err != nil {
+			return sfv.InnerList{}, err
+		}
+		input.Items = append(input.Items, item)
+	}
+	return input, nil
+}
+
+// ParseSignatures parses the Signature-Input and Signature fields of header, keyed by signature label
+func ParseSignatures(header http.Header) (inputs, signatures *sfv.Dictionary, err error) {
+	inputs, err = sfv.UnmarshalDictionary(header.Values("Signature-Input"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing Signature-Input: %w", err)
+	}
+	signatures, err = sfv.UnmarshalDictionary(header.Values("Signature"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing Signature: %w", err)
+	}
+	return inputs, signatures, nil
+}
+
+// AddSignature adds the signature sig over input, labeled label, to the Signature-Input and Signature fields of
+// header, replacing a signature with the same label
+func AddSignature(header http.Header, label string, input sfv.InnerList, sig []byte) error {
+	inputs, signatures, err := ParseSignatures(header)
+	if err != nil {
+		return err
+	}
+	inputs.Add(label, input)
+	signatures.Add(label, sfv.NewItem(sig))
+	signatureInput, err := sfv.Marshal(inputs)
+	if err != nil {
+		return err
+	}
+	signature, err := sfv.Marshal(signatures)
+	if err != nil {
+		return err
+	}
+	header.Set("Signature-Input", signatureInput)
+	header.Set("Signature", signature)
+	return nil
+}
@@ -0,0 +1,173 @@
+// Package httpmsgsig builds RFC 9421 signature bases and serializes the Signature-Input and Signature fields, as the
+// http-message-sig npm package does. It leaves keys, algorithms and what signatures must cover to its callers, such
+// as the webbotauth package.
+//
+//	input, err := httpmsgsig.NewInput([]string{"@authority", httpmsgsig.QueryParam("id")}, params)
+//	base, err := httpmsgsig.SignatureBase(req, input)
+//	// sign base with the key of your choice
+//	err = httpmsgsig.AddSignature(req.Header, "sig1", input, sig)
+package httpmsgsig
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	sfv "github.com/dunglas/httpsfv"
+)
+
+// SignatureBase returns the RFC 9421 signature base of r for the covered components and signature parameters of input
+func SignatureBase(r *http.Request, input sfv.InnerList) ([]byte, error) {
+	return buildBase(input, func(name string, params *sfv.Params) (string, error) {
+		if name == "@query-param" {
+			return queryParamValue(r, params)
+		}
+		if len(params.Names()) > 0 {
+			return "", fmt.Errorf("component parameters of %s are not supported", name)
+		}
+		return ComponentValue(r, name)
+	})
+}
+
+// ResponseBase returns the RFC 9421 signature base of a response to r, with status and header, for input.
+// Components with the req parameter, the only one supported, are taken from r.
+func ResponseBase(r *http.Request, status int, header http.Header, input sfv.InnerList) ([]byte, error) {
+	return buildBase(input, func(name string, params *sfv.Params) (string, error) {
+		switch names := params.Names(); {
+		case len(names) == 1 && names[0] == "req":
+			if req, _ := params.Get("req"); req != true {
+				return "", fmt.Errorf("invalid req parameter of %s", name)
+			}
+			return ComponentValue(r, name)
+		case len(names) > 0:
+			return "", fmt.Errorf("component parameters of %s are not supported", name)
+		case name == "@status":
+			return strconv.Itoa(status), nil
+		case strings.HasPrefix(name, "@"):
+			return "", fmt.Errorf("unsupported response component %s", name)
+		}
+		return FieldValue(header, name)
+	})
+}
+
+// buildBase returns the signature base for input, with the values of covered components returned by value
+func buildBase(input sfv.InnerList, value func(name string, params *sfv.Params) (string, error)) ([]byte, error) {
+	var base strings.Builder
+	for _, item := range input.Items {
+		name, ok := item.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("covered component is not a string")
+		}
+		v, err := value(name, item.Params)
+		if err != nil {
+			return nil, err
+		}
+		id, err := sfv.Marshal(item)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&base, "%s: %s\n", id, v)
+	}
+	params, err := sfv.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(&base, "\"@signature-params\": %s", params)
+	return []byte(base.String()), nil
+}
+
+// ComponentValue returns the value of the component name of r, a derived component without parameters or a header field
+func ComponentValue(r *http.Request, name string) (string, error) {
+	switch name {
+	case "@method":
+		return r.Method, nil
+	case "@authority":
+		return strings.ToLower(authority(r)), nil
+	case "@scheme":
+		return scheme(r), nil
+	case "@target-uri":
+		return scheme(r) + "://" + strings.ToLower(authority(r)) + r.URL.RequestURI(), nil
+	case "@request-target":
+		return r.URL.RequestURI(), nil
+	case "@path":
+		if path := r.URL.EscapedPath(); path != "" {
+			return path, nil
+		}
+		return "/", nil
+	case "@query":
+		return "?" + r.URL.RawQuery, nil
+	}
+	if strings.HasPrefix(name, "@") {
+		return "", fmt.Errorf("unsupported component %s", name)
+	}
+	return FieldValue(r.Header, name)
+}
+
+// queryParamValue returns the value of the @query-param component of r with params, the query parameter named by
+// the name parameter, re-encoded as RFC 9421 section 2.2.8 requires. Parameters absent from r or repeated in it
+// cannot be covered.
+func queryParamValue(r *http.Request, params *sfv.Params) (string, error) {
+	if names := params.Names(); len(names) != 1 || names[0] != "name" {
+		return "", errors.New("@query-param requires exactly a name parameter")
+	}
+	name, _ := params.Get("name")
+	encoded, ok := name.(string)
+	if !ok {
+		return "", errors.New("the name parameter of @query-param is not a string")
+	}
+	key, err := url.QueryUnescape(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid @query-param name %q", encoded)
+	}
+	query, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		return "", fmt.Errorf("parsing query: %w", err)
+	}
+	switch values := query[key]; len(values) {
+	case 0:
+		return "", fmt.Errorf("missing query parameter %s", encoded)
+	case 1:
+		return encodeQueryComponent(values[0]), nil
+	}
+	return "", fmt.Errorf("query parameter %s is repeated", encoded)
+}
+
+// encodeQueryComponent percent-encodes s as query parameter names and values are in @query-param, with spaces as %20
+func encodeQueryComponent(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// FieldValue returns the value of the field name of header, its values trimmed and joined
+func FieldValue(header http.Header, name string) (string, error) {
+	values := header.Values(name)
+	if len(values) == 0 {
+		return "", fmt.Errorf("missing covered field %s", name)
+	}
+	trimmed := make([]string, len(values))
+	for i, v := range values {
+		trimmed[i] = strings.TrimSpace(v)
+	}
+	return strings.Join(trimmed, ", "), nil
+}
+
+// authority returns the host r is addressed to
+func authority(r *http.Request) string {
+	if r.Host != "" {
+		return r.Host
+	}
+	return r.URL.Host
+}
+
+// scheme returns the scheme r is sent over
+func scheme(r *http.Request) string {
+	if r.URL.Scheme != "" {
+		return r.URL.Scheme
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,80 @@
+package httpmsgsig
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sfv "github.com/dunglas/httpsfv"
+)
+
+func TestDerivedComponents(t *testing.T) {
+	// The request of RFC 9421 section 2.2, as a server receives it
+	query := "param=value&foo=bar&baz=batman&qux=&var=this%20is%20a%20big%0Avalue&bar=with+plus+whitespace&fa%C3%A7ade%22%3A%20=something"
+	r := httptest.NewRequest(http.MethodPost, "/path?"+query, nil)
+	r.Host = "www.example.com"
+	r.URL.Scheme = "https"
+	inputs, err := sfv.UnmarshalDictionary([]string{`sig1=("@target-uri" "@path" "@query" "@query-param";name="baz" "@query-param";name="qux" "@query-param";name="var" "@query-param";name="bar" "@query-param";name="fa%C3%A7ade%22%3A%20")`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	input, _ := inputs.Get("sig1")
+	base, err := SignatureBase(r, input.(sfv.InnerList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `"@target-uri": https://www.example.com/path?` + query + `
+"@path": /path
+"@query": ?` + query + `
+"@query-param";name="baz": batman
+"@query-param";name="qux": 
+"@query-param";name="var": this%20is%20a%20big%0Avalue
+"@query-param";name="bar": with%20plus%20whitespace
+"@query-param";name="fa%C3%A7ade%22%3A%20": something
+`
+	if got, _, _ := strings.Cut(string(base), `"@signature-params"`); got != want {
+		t.Errorf("signature base =\n%s\nwant\n%s", got, want)
+	}
+
+	for _, component := range []string{`"@query-param";name="foo";sf`, `"@query-param";name="missing"`, `"@query-param"`} {
+		inputs, _ := sfv.UnmarshalDictionary([]string{"sig1=(" + component + ")"})
+		input, _ := inputs.Get("sig1")
+		if _, err := SignatureBase(r, input.(sfv.InnerList)); err == nil {
+			t.Errorf("%s accepted", component)
+		}
+	}
+}
+
+func TestAddSignature(t *testing.T) {
+	params := sfv.NewParams()
+	params.Add("created", int64(1618884473))
+	params.Add("keyid", "test-key-ed25519")
+	input, err := NewInput([]string{"date", "@method", QueryParam("Pet")}, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{}
+	header.Set("Signature-Input", `proxy=("@authority");created=1`)
+	header.Set("Signature", "proxy=:AAAA:")
+	if err := AddSignature(header, "sig1", input, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := header.Get("Signature-Input"), `proxy=("@authority");created=1, sig1=("date" "@method" "@query-param";name="Pet");created=1618884473;keyid="test-key-ed25519"`; got != want {
+		t.Errorf("Signature-Input = %s, want %s", got, want)
+	}
+	if got, want := header.Get("Signature"), "proxy=:AAAA:, sig1=:AQID:"; got != want {
+		t.Errorf("Signature = %s, want %s", got, want)
+	}
+
+	inputs, signatures, err := ParseSignatures(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := inputs.Names(); len(got) != 2 || len(signatures.Names()) != 2 {
+		t.Errorf("parsed %v", got)
+	}
+	if _, err := NewInput([]string{`"@query-param";name=`}, nil); err == nil {
+		t.Error("malformed component accepted")
+	}
+}
@@ -0,0 +1,172 @@
+package webbotauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"github.com/cloudflareresearch/web-bot-auth/go/webbotauth/jwkthumbprint"
+)
+
+// JWK is a JSON Web Key, as published in directories. Only the members needed for signing and verifying
+// are decoded; others, such as nbf or use, are ignored.
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	// D is the private key of OKP and EC keys. It is never set by PublicJWK.
+	D   string `json:"d,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// ParseJWK decodes a JWK from its JSON form
+func ParseJWK(data []byte) (JWK, error) {
+	var k JWK
+	if err := json.Unmarshal(data, &k); err != nil {
+		return JWK{}, fmt.Errorf("parsing JWK: %w", err)
+	}
+	if k.Kty == "" {
+		return JWK{}, fmt.Errorf("parsing JWK: no kty")
+	}
+	return k, nil
+}
+
+// PublicJWK returns the JWK of an Ed25519, ECDSA P-256 or RSA public key
+func PublicJWK(pub crypto.PublicKey) (JWK, error) {
+	enc := base64.RawURLEncoding
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Crv: "Ed25519", X: enc.EncodeToString(pub)}, nil
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return JWK{}, fmt.Errorf("unsupported elliptic curve %s", pub.Curve.Params().Name)
+		}
+		return JWK{Kty: "EC", Crv: "P-256", X: enc.EncodeToString(pub.X.FillBytes(make([]byte, 32))), Y: enc.EncodeToString(pub.Y.FillBytes(make([]byte, 32)))}, nil
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", N: enc.EncodeToString(pub.N.Bytes()), E: enc.EncodeToString(big.NewInt(int64(pub.E)).Bytes())}, nil
+	}
+	return JWK{}, fmt.Errorf("unsupported public key type %T", pub)
+}
+
+// KeyID returns the keyid of pub, its RFC 7638 JWK thumbprint, as jwkToKeyID does in the TypeScript package
+func KeyID(pub crypto.PublicKey) (string, error) {
+	k, err := PublicJWK(pub)
+	if err != nil {
+		return "", err
+	}
+	return k.Thumbprint()
+}
+
+// Thumbprint returns the base64url RFC 7638 SHA-256 thumbprint of k, which web-bot-auth uses as keyid
+func (k JWK) Thumbprint() (string, error) {
+	return jwkthumbprint.KeyID(jwkthumbprint.Key{Kty: k.Kty, Crv: k.Crv, X: k.X, Y: k.Y, N: k.N, E: k.E})
+}
+
+// PublicKey returns the public key of k
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch {
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x, err := decodeMember("x", k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("Ed25519 key of %d bytes", len(x))
+		}
+		return ed25519.PublicKey(x), nil
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, err := decodeMember("x", k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeMember("y", k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("EC point is not on P-256")
+		}
+		return pub, nil
+	case k.Kty == "RSA":
+		n, err := decodeMember("n", k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeMember("e", k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q with curve %q", k.Kty, k.Crv)
+}
+
+// PrivateKey returns the private key of an OKP or EC JWK
+func (k JWK) PrivateKey() (crypto.PrivateKey, error) {
+	if k.D == "" {
+		return nil, fmt.Errorf("JWK has no private key")
+	}
+	d, err := decodeMember("d", k.D)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := k.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		if len(d) != ed25519.SeedSize {
+			return nil, fmt.Errorf("Ed25519 seed of %d bytes", len(d))
+		}
+		return ed25519.NewKeyFromSeed(d), nil
+	case *ecdsa.PublicKey:
+		return &ecdsa.PrivateKey{PublicKey: *pub, D: new(big.Int).SetBytes(d)}, nil
+	}
+	return nil, fmt.Errorf("private %s JWKs are not supported, use PEM", k.Kty)
+}
+
+// ParsePrivateKey parses a private key given as a JWK or as a PKCS #8 PEM block
+func ParsePrivateKey(data []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		k, err := ParseJWK(data)
+		if err != nil {
+			return nil, err
+		}
+		return k.PrivateKey()
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing PEM private key: %w", err)
+	}
+	return key, nil
+}
+
+// decodeMember decodes the base64url member name of a JWK
+func decodeMember(name, value string) ([]byte, error) {
+	if value == "" {
+		return nil, fmt.Errorf("JWK has no %s", name)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("decoding JWK %s: %w", name, err)
+	}
+	return b, nil
+}
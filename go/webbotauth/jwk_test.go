@@ -0,0 +1,89 @@
+package webbotauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+// testJWK is the RFC 9421 Ed25519 test key, as in examples/rfc9421-keys/ed25519.json
+const testJWK = `{"kty":"OKP","crv":"Ed25519","kid":"test-key-ed25519","d":"n4Ni-HpISpVObnQMW0wOhCKROaIKqKtW_2ZYb2p9KcU","x":"JrQLj5P_89iXES9-vFgrIy29clF9CC_oPPsw3c5D0bs"}`
+
+// testKeyID is the thumbprint of testJWK, the keyid the Rust and TypeScript packages sign with
+const testKeyID = "poqkLGiymh_W0uP6PZFw-dvez3QJT5SolqXBCW38r0U"
+
+func TestThumbprint(t *testing.T) {
+	tests := []struct {
+		name string
+		jwk  string
+		want string
+	}{
+		{name: "RFC 9421 Ed25519 test key", jwk: testJWK, want: testKeyID},
+		{
+			name: "RFC 7638 example",
+			jwk:  `{"kty":"RSA","n":"0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw","e":"AQAB","alg":"RS256","kid":"2011-04-29"}`,
+			want: "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := ParseJWK([]byte(tt.jwk))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := k.Thumbprint()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("thumbprint = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPublicJWKRoundTrip(t *testing.T) {
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	for _, pub := range []any{edPub, &ecKey.PublicKey, &rsaKey.PublicKey} {
+		k, err := PublicJWK(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := k.PublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.(interface{ Equal(x crypto.PublicKey) bool }).Equal(pub) {
+			t.Errorf("%T does not round-trip through its JWK", pub)
+		}
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	fromJWK, err := ParsePrivateKey([]byte(testJWK))
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(fromJWK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromPEM, err := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fromPEM.(ed25519.PrivateKey).Equal(fromJWK) {
+		t.Error("PEM and JWK keys differ")
+	}
+	if _, err := ParsePrivateKey([]byte(`{"kty":"OKP","crv":"Ed25519","x":"JrQLj5P_89iXES9-vFgrIy29clF9CC_oPPsw3c5D0bs"}`)); err == nil {
+		t.Error("public JWK parsed as a private key")
+	}
+}
@@ -0,0 +1,138 @@
+// Package jwkthumbprint computes RFC 7638 JWK thumbprints of OKP (RFC 8037), EC and RSA keys, as the
+// jsonwebkey-thumbprint npm package does, so that keyids are derived identically by the Go, TypeScript and Rust code.
+//
+//	keyid, err := jwkthumbprint.KeyID(jwkthumbprint.Key{Kty: "OKP", Crv: "Ed25519", X: x})
+package jwkthumbprint
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // crypto.SHA256, used by KeyID
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// ErrUnsupportedKeyType is returned for keys other than OKP, EC and RSA
+var ErrUnsupportedKeyType = errors.New("unsupported key type")
+
+// Key holds the members of a JWK that its thumbprint depends on. Other members of a JWK are ignored.
+type Key struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// Parse decodes the thumbprint members of a JWK in its JSON form
+func Parse(data []byte) (Key, error) {
+	var k Key
+	if err := json.Unmarshal(data, &k); err != nil {
+		return Key{}, fmt.Errorf("parsing JWK: %w", err)
+	}
+	return k, nil
+}
+
+// FromPublicKey returns the members of the JWK of an Ed25519, ECDSA or RSA public key
+func FromPublicKey(pub crypto.PublicKey) (Key, error) {
+	enc := base64.RawURLEncoding
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		return Key{Kty: "OKP", Crv: "Ed25519", X: enc.EncodeToString(pub)}, nil
+	case *ecdsa.PublicKey:
+		crv, err := curveName(pub.Curve)
+		if err != nil {
+			return Key{}, err
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return Key{Kty: "EC", Crv: crv, X: enc.EncodeToString(pub.X.FillBytes(make([]byte, size))), Y: enc.EncodeToString(pub.Y.FillBytes(make([]byte, size)))}, nil
+	case *rsa.PublicKey:
+		return Key{Kty: "RSA", N: enc.EncodeToString(pub.N.Bytes()), E: enc.EncodeToString(big.NewInt(int64(pub.E)).Bytes())}, nil
+	}
+	return Key{}, fmt.Errorf("%w %T", ErrUnsupportedKeyType, pub)
+}
+
+// curveName returns the JWK crv of an elliptic curve
+func curveName(curve elliptic.Curve) (string, error) {
+	switch curve {
+	case elliptic.P256():
+		return "P-256", nil
+	case elliptic.P384():
+		return "P-384", nil
+	case elliptic.P521():
+		return "P-521", nil
+	}
+	return "", fmt.Errorf("%w: elliptic curve %s", ErrUnsupportedKeyType, curve.Params().Name)
+}
+
+// PreCompute returns the input of the thumbprint hash: the required members of k, in lexicographic order and
+// without whitespace, as RFC 7638 section 3.2 specifies
+func PreCompute(k Key) ([]byte, error) {
+	var members any
+	switch k.Kty {
+	// Defined in Appendix A.3 of RFC 8037
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{k.Crv, k.Kty, k.X}
+		if k.Crv == "" || k.X == "" {
+			return nil, errors.New("OKP JWK requires crv and x")
+		}
+	// Defined in Section 3.2 of RFC 7638
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{k.Crv, k.Kty, k.X, k.Y}
+		if k.Crv == "" || k.X == "" || k.Y == "" {
+			return nil, errors.New("EC JWK requires crv, x and y")
+		}
+	// Defined in Section 3.2 of RFC 7638
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{k.E, k.Kty, k.N}
+		if k.E == "" || k.N == "" {
+			return nil, errors.New("RSA JWK requires e and n")
+		}
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedKeyType, k.Kty)
+	}
+	return json.Marshal(members)
+}
+
+// Compute returns the thumbprint of k with hash, as defined in Section 3 of RFC 7638
+func Compute(k Key, hash crypto.Hash) ([]byte, error) {
+	data, err := PreCompute(k)
+	if err != nil {
+		return nil, err
+	}
+	if !hash.Available() {
+		return nil, fmt.Errorf("hash %s is not available", hash)
+	}
+	h := hash.New()
+	h.Write(data)
+	return h.Sum(nil), nil
+}
+
+// KeyID returns the base64url SHA-256 thumbprint of k, which web-bot-auth uses as keyid
+func KeyID(k Key) (string, error) {
+	sum, err := Compute(k, crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(sum), nil
+}
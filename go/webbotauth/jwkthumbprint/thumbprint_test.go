@@ -0,0 +1,101 @@
+package jwkthumbprint
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+)
+
+// vectorsFile holds the test vectors of the jsonwebkey-thumbprint npm package, which thumbprints must match
+const vectorsFile = "../../../packages/jsonwebkey-thumbprint/test/fixtures/vectors.json"
+
+func TestVectors(t *testing.T) {
+	data, err := os.ReadFile(vectorsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var vectors []struct {
+		Name       string `json:"name"`
+		JWK        string `json:"jwk"`
+		PreCompute string `json:"precompute"`
+		SHA256     string `json:"sha256"`
+		Thumbprint string `json:"thumbprint"`
+	}
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors) == 0 {
+		t.Fatal("no test vectors")
+	}
+	for _, v := range vectors {
+		t.Run(v.Name, func(t *testing.T) {
+			k, err := Parse([]byte(v.JWK))
+			if err != nil {
+				t.Fatal(err)
+			}
+			pre, err := PreCompute(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(pre); got != v.PreCompute {
+				t.Errorf("PreCompute = %s, want %s", pre, v.PreCompute)
+			}
+			sum, err := Compute(k, crypto.SHA256)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(sum); got != v.SHA256 {
+				t.Errorf("Compute = %s, want %s", got, v.SHA256)
+			}
+			if keyid, err := KeyID(k); err != nil || keyid != v.Thumbprint {
+				t.Errorf("KeyID = %s, %v, want %s", keyid, err, v.Thumbprint)
+			}
+		})
+	}
+}
+
+func TestFromPublicKey(t *testing.T) {
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	for _, pub := range []crypto.PublicKey{edPub, &p256.PublicKey, &p384.PublicKey, &rsaKey.PublicKey} {
+		k, err := FromPublicKey(pub)
+		if err != nil {
+			t.Fatalf("%T: %v", pub, err)
+		}
+		// The members round trip through JSON, as published in a directory
+		data, _ := json.Marshal(k)
+		parsed, err := Parse(data)
+		if err != nil || parsed != k {
+			t.Errorf("%T: parsed %+v, %v, want %+v", pub, parsed, err, k)
+		}
+		if _, err := KeyID(k); err != nil {
+			t.Errorf("%T: %v", pub, err)
+		}
+	}
+	if _, err := FromPublicKey("not a key"); !errors.Is(err, ErrUnsupportedKeyType) {
+		t.Errorf("err = %v, want ErrUnsupportedKeyType", err)
+	}
+}
+
+func TestPreComputeInvalid(t *testing.T) {
+	for name, k := range map[string]Key{
+		"unknown kty":   {Kty: "oct"},
+		"OKP without x": {Kty: "OKP", Crv: "Ed25519"},
+		"EC without y":  {Kty: "EC", Crv: "P-256", X: "x"},
+		"RSA without e": {Kty: "RSA", N: "n"},
+	} {
+		if _, err := PreCompute(k); err == nil {
+			t.Errorf("%s: PreCompute succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package webbotauth
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Option configures Middleware
+type Option func(*middleware)
+
+// middleware is the handler Middleware returns
+type middleware struct {
+	next     http.Handler
+	verifier Verifier
+	verify   func(r *http.Request) (*http.Request, *Result, error)
+	optional bool
+	reject   func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// resultKey is the context key of the Result of verified requests
+type resultKey struct{}
+
+// WithKeys sets the keys signatures are verified with. Without it, every request is rejected.
+func WithKeys(keys KeyResolver) Option {
+	return func(m *middleware) { m.verifier.Keys = keys }
+}
+
+// WithNonces rejects replayed signatures, recording their nonces in store. Without it, a signature captured from a
+// request can be replayed until it expires.
+func WithNonces(store NonceStore) Option {
+	return func(m *middleware) { m.verifier.Nonces = store }
+}
+
+// WithSkew accepts signatures created at most skew in the future
+func WithSkew(skew time.Duration) Option {
+	return func(m *middleware) { m.verifier.Skew = skew }
+}
+
+// WithClock sets the clock signatures are checked against
+func WithClock(now func() time.Time) Option {
+	return func(m *middleware) { m.verifier.Now = now }
+}
+
+// WithVerifier verifies requests with verify instead of Verifier, to apply another profile to them, such as that of the
+// SignatureValidator of the Caddy plugin. verify returns the request passed on to next, in whose context it may
+// record more than Result, and the verified signature. WithKeys, WithNonces, WithSkew and WithClock configure
+// Verifier, and have no effect along with it.
+func WithVerifier(verify func(r *http.Request) (*http.Request, *Result, error)) Option {
+	return func(m *middleware) { m.verify = verify }
+}
+
+// WithOptional passes requests without a valid signature on to next instead of rejecting them.
+// Handlers tell verified requests apart with ResultFromContext.
+func WithOptional() Option {
+	return func(m *middleware) { m.optional = true }
+}
+
+// WithRejection replaces the plain text 401 sent for requests without a valid signature
+func WithRejection(reject func(w http.ResponseWriter, r *http.Request, err error)) Option {
+	return func(m *middleware) { m.reject = reject }
+}
+
+// Middleware returns a handler passing requests with a valid web-bot-auth signature on to next, and rejecting
+// the others with 401. It works with any router built on net/http. It applies the checks of Verifier, unless
+// WithVerifier replaces it: replays are rejected with WithNonces alone, and keys are not bound to the directory the
+// request's Signature-Agent advertises.
+//
+//	dir, _ := webbotauth.FetchDirectory(ctx, nil, "signer.example.com")
+//	http.ListenAndServe(":8080", webbotauth.Middleware(mux, webbotauth.WithKeys(dir.KeySet())))
+func Middleware(next http.Handler, opts ...Option) http.Handler {
+	m := &middleware{next: next, verifier: Verifier{Keys: KeySet{}}}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.verify == nil {
+		m.verify = func(r *http.Request) (*http.Request, *Result, error) {
+			result, err := m.verifier.Verify(r)
+			return r, result, err
+		}
+	}
+	if m.reject == nil {
+		m.reject = func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, "Invalid HTTP signature", http.StatusUnauthorized)
+		}
+	}
+	return m
+}
+
+// ServeHTTP verifies r and records its Result in the context of the request passed to next
+func (m *middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	verified, result, err := m.verify(r)
+	if err != nil {
+		if m.optional {
+			m.next.ServeHTTP(w, r)
+			return
+		}
+		m.reject(w, r, err)
+		return
+	}
+	m.next.ServeHTTP(w, verified.WithContext(context.WithValue(verified.Context(), resultKey{}, result)))
+}
+
+// ResultFromContext returns the verified signature of the request ctx belongs to, as recorded by Middleware
+func ResultFromContext(ctx context.Context) (*Result, bool) {
+	result, ok := ctx.Value(resultKey{}).(*Result)
+	return result, ok
+}
@@ -0,0 +1,72 @@
+package webbotauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	key, err := ParsePrivateKey([]byte(testJWK))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The next handler reports the keyid of verified requests
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if result, ok := ResultFromContext(r.Context()); ok {
+			w.Write([]byte(result.KeyID))
+		}
+	})
+
+	tests := []struct {
+		name       string
+		opts       []Option
+		sign       bool
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "signed", opts: []Option{WithKeys(testKeys(t))}, sign: true, wantStatus: http.StatusOK, wantBody: testKeyID},
+		{name: "unsigned", opts: []Option{WithKeys(testKeys(t))}, wantStatus: http.StatusUnauthorized},
+		{name: "no keys", sign: true, wantStatus: http.StatusUnauthorized},
+		{name: "optional", opts: []Option{WithKeys(testKeys(t)), WithOptional()}, wantStatus: http.StatusOK},
+		{name: "nonces", opts: []Option{WithKeys(testKeys(t)), WithNonces(NewMemoryNonceStore())}, sign: true, wantStatus: http.StatusOK, wantBody: testKeyID},
+		{
+			name: "custom verifier",
+			opts: []Option{WithVerifier(func(r *http.Request) (*http.Request, *Result, error) {
+				return r, &Result{KeyID: "custom"}, nil
+			})},
+			wantStatus: http.StatusOK,
+			wantBody:   "custom",
+		},
+		{
+			name: "custom rejection",
+			opts: []Option{WithKeys(testKeys(t)), WithRejection(func(w http.ResponseWriter, r *http.Request, err error) {
+				http.Error(w, err.Error(), http.StatusForbidden)
+			})},
+			wantStatus: http.StatusForbidden,
+			wantBody:   ErrNoSignature.Error() + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+			if tt.sign {
+				if err := signer.Sign(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			w := httptest.NewRecorder()
+			Middleware(next, tt.opts...).ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
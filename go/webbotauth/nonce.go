@@ -0,0 +1,64 @@
+package webbotauth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrReplayedNonce is returned when a signature reuses the nonce of a signature that was already accepted
+var ErrReplayedNonce = errors.New("nonce was already used")
+
+// nonceSweepInterval bounds how often MemoryNonceStore purges expired nonces
+const nonceSweepInterval = time.Minute
+
+// NonceStore records the nonces of accepted signatures so that replays of them are rejected. A store shared by
+// several servers, backed by Redis for instance, rejects replays across all of them.
+type NonceStore interface {
+	// Use records key until expires, reporting false if it is already recorded and has not expired
+	Use(ctx context.Context, key string, expires time.Time) (bool, error)
+}
+
+// MemoryNonceStore is a NonceStore local to the process. Each nonce is held until its signature expires, so
+// memory grows with the number of signatures accepted within their validity.
+type MemoryNonceStore struct {
+	// Now is the clock nonces expire by. Defaults to time.Now.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewMemoryNonceStore returns an empty store
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{seen: map[string]time.Time{}}
+}
+
+// Use implements NonceStore
+func (s *MemoryNonceStore) Use(ctx context.Context, key string, expires time.Time) (bool, error) {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	t := now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.seen == nil {
+		s.seen = map[string]time.Time{}
+	}
+	if t.Sub(s.lastSweep) >= nonceSweepInterval {
+		for k, e := range s.seen {
+			if !t.Before(e) {
+				delete(s.seen, k)
+			}
+		}
+		s.lastSweep = t
+	}
+	if e, ok := s.seen[key]; ok && t.Before(e) {
+		return false, nil
+	}
+	s.seen[key] = expires
+	return true, nil
+}
@@ -0,0 +1,39 @@
+package webbotauth
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryNonceStore(t *testing.T) {
+	now := time.Now()
+	s := NewMemoryNonceStore()
+	s.Now = func() time.Time { return now }
+	use := func(key string, expires time.Time) bool {
+		t.Helper()
+		fresh, err := s.Use(context.Background(), key, expires)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fresh
+	}
+
+	if !use("a", now.Add(time.Minute)) {
+		t.Fatal("first use rejected")
+	}
+	if use("a", now.Add(time.Minute)) {
+		t.Error("reused nonce accepted")
+	}
+	// Once its signature expired, a nonce is forgotten
+	now = now.Add(2 * time.Minute)
+	if !use("b", now.Add(time.Minute)) {
+		t.Fatal("first use rejected")
+	}
+	if len(s.seen) != 1 {
+		t.Errorf("store holds %d nonces, want 1", len(s.seen))
+	}
+	if !use("a", now.Add(time.Minute)) {
+		t.Error("nonce of an expired signature still rejected")
+	}
+}
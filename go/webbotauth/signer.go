@@ -0,0 +1,118 @@
+package webbotauth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/cloudflareresearch/web-bot-auth/go/webbotauth/httpmsgsig"
+)
+
+// Tag is the tag parameter of web-bot-auth signatures
+const Tag = "web-bot-auth"
+
+// SignatureAgentHeader is the field advertising the directory of the signing key
+const SignatureAgentHeader = "Signature-Agent"
+
+// NonceLength is the length in bytes of the nonces Signer generates
+const NonceLength = 64
+
+// DefaultValidity is how long signatures are valid when Signer.Validity is zero
+const DefaultValidity = time.Hour
+
+// Signer adds web-bot-auth signatures to requests. It covers @authority, and Signature-Agent when the request
+// advertises a directory, as the Rust and TypeScript packages do.
+type Signer struct {
+	// Key is an ed25519.PrivateKey, an ECDSA P-256 *ecdsa.PrivateKey or an *rsa.PrivateKey, used with RSA-PSS,
+	// or any crypto.Signer with such a public key, such as one backed by a KMS or a PKCS#11 HSM
+	Key crypto.PrivateKey
+	// KeyID is the keyid of the signatures, the JWK thumbprint of Key as set by NewSigner
+	KeyID string
+	// Label is the label of the signatures. Defaults to "sig1".
+	Label string
+	// Components are covered in addition to @authority and Signature-Agent, such as "@method" or "content-digest".
+	// Components with parameters are given as serialized in Signature-Input, such as those returned by QueryParam.
+	Components []string
+	// Validity is how long signatures are valid, setting their expires. Defaults to DefaultValidity.
+	Validity time.Duration
+	// Now is the clock setting created. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// NewSigner returns a signer with key, keyed by its JWK thumbprint. key is a crypto.Signer: the private key
+// need not be in memory.
+func NewSigner(key crypto.PrivateKey) (*Signer, error) {
+	private, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	if _, err := algorithmOf(private.Public()); err != nil {
+		return nil, err
+	}
+	keyid, err := KeyID(private.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{Key: key, KeyID: keyid}, nil
+}
+
+// Sign sets the Signature-Input and Signature fields of r. Set Signature-Agent on r first for it to be covered.
+func (s *Signer) Sign(r *http.Request) error {
+	now := time.Now
+	if s.Now != nil {
+		now = s.Now
+	}
+	validity := s.Validity
+	if validity == 0 {
+		validity = DefaultValidity
+	}
+	label := s.Label
+	if label == "" {
+		label = "sig1"
+	}
+	nonce := make([]byte, NonceLength)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	components := []string{"@authority"}
+	if r.Header.Get(SignatureAgentHeader) != "" {
+		components = append(components, "signature-agent")
+	}
+	for _, name := range s.Components {
+		if !slices.Contains(components, name) {
+			components = append(components, name)
+		}
+	}
+	input, err := httpmsgsig.NewInput(components, nil)
+	if err != nil {
+		return err
+	}
+	created := now()
+	input.Params.Add("created", created.Unix())
+	input.Params.Add("expires", created.Add(validity).Unix())
+	input.Params.Add("keyid", s.KeyID)
+	input.Params.Add("nonce", base64.StdEncoding.EncodeToString(nonce))
+	input.Params.Add("tag", Tag)
+
+	base, err := httpmsgsig.SignatureBase(r, input)
+	if err != nil {
+		return err
+	}
+	sig, err := signRaw(s.Key, base)
+	if err != nil {
+		return err
+	}
+	r.Header.Del("Signature-Input")
+	r.Header.Del("Signature")
+	return httpmsgsig.AddSignature(r.Header, label, input, sig)
+}
+
+// QueryParam returns the @query-param component covering the query parameter name, for Signer.Components
+func QueryParam(name string) string {
+	return httpmsgsig.QueryParam(name)
+}
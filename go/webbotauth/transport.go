@@ -0,0 +1,56 @@
+package webbotauth
+
+import (
+	"net/http"
+
+	sfv "github.com/dunglas/httpsfv"
+)
+
+// SigningTransport is an http.RoundTripper signing every request with Signer before sending it with Base.
+// Each request gets a fresh created, expires and nonce.
+//
+//	client := &http.Client{Transport: webbotauth.NewSigningTransport(signer, "https://bot.example")}
+type SigningTransport struct {
+	// Signer signs requests
+	Signer *Signer
+	// SignatureAgent is the URL of the directory advertised in Signature-Agent, and covered by signatures.
+	// Requests advertise none when empty, unless they set Signature-Agent themselves.
+	SignatureAgent string
+	// Base sends signed requests. Defaults to http.DefaultTransport.
+	Base http.RoundTripper
+}
+
+// NewSigningTransport returns a transport signing requests with signer, advertising the directory signatureAgent,
+// over http.DefaultTransport
+func NewSigningTransport(signer *Signer, signatureAgent string) *SigningTransport {
+	return &SigningTransport{Signer: signer, SignatureAgent: signatureAgent}
+}
+
+// RoundTrip implements http.RoundTripper. r is not modified: a signed copy is sent.
+func (t *SigningTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	signed := r.Clone(r.Context())
+	if t.SignatureAgent != "" {
+		agent, err := sfv.Marshal(sfv.NewItem(t.SignatureAgent))
+		if err != nil {
+			closeBody(r)
+			return nil, err
+		}
+		signed.Header.Set(SignatureAgentHeader, agent)
+	}
+	if err := t.Signer.Sign(signed); err != nil {
+		closeBody(r)
+		return nil, err
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(signed)
+}
+
+// closeBody closes the body of r, as RoundTrip must even on errors
+func closeBody(r *http.Request) {
+	if r.Body != nil {
+		r.Body.Close()
+	}
+}
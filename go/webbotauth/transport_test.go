@@ -0,0 +1,65 @@
+package webbotauth
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestSigningTransport(t *testing.T) {
+	key, err := ParsePrivateKey([]byte(testJWK))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer.Components = []string{"@method", "@path"}
+	// The server reports the covered components and nonce of verified requests
+	results := make(chan *Result, 2)
+	server := httptest.NewServer(Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result, _ := ResultFromContext(r.Context())
+		results <- result
+		io.WriteString(w, r.Header.Get(SignatureAgentHeader))
+	}), WithKeys(testKeys(t))))
+	defer server.Close()
+
+	transport := NewSigningTransport(signer, "https://signer.example.com")
+	transport.Base = server.Client().Transport
+	client := &http.Client{Transport: transport}
+
+	var nonces []string
+	for range 2 {
+		r, err := http.NewRequest(http.MethodGet, server.URL+"/items", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+		}
+		if string(body) != `"https://signer.example.com"` {
+			t.Errorf("Signature-Agent = %s", body)
+		}
+		if r.Header.Get("Signature") != "" {
+			t.Error("the request passed to the client was modified")
+		}
+		result := <-results
+		want := []string{"@authority", "signature-agent", "@method", "@path"}
+		if !slices.Equal(result.Components, want) {
+			t.Errorf("components = %v, want %v", result.Components, want)
+		}
+		nonces = append(nonces, result.Nonce)
+	}
+	if nonces[0] == nonces[1] {
+		t.Error("requests were signed with the same nonce")
+	}
+}
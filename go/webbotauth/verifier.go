@@ -0,0 +1,180 @@
+package webbotauth
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/cloudflareresearch/web-bot-auth/go/webbotauth/httpmsgsig"
+	sfv "github.com/dunglas/httpsfv"
+)
+
+var (
+	// ErrNoSignature is returned when a request has no signature tagged web-bot-auth
+	ErrNoSignature = errors.New("no web-bot-auth signature")
+	// ErrUnknownKey is returned when no key is known for the keyid of a signature
+	ErrUnknownKey = errors.New("unknown keyid")
+	// ErrExpired is returned when a signature expired, or was created in the future
+	ErrExpired = errors.New("signature is not valid at this time")
+	// ErrInvalidSignature is returned when a signature does not verify, or does not cover the required components
+	ErrInvalidSignature = errors.New("invalid signature")
+)
+
+// KeyResolver returns the public key of a keyid, or an error wrapping ErrUnknownKey
+type KeyResolver interface {
+	ResolveKey(ctx context.Context, r *http.Request, keyid string) (crypto.PublicKey, error)
+}
+
+// KeySet is a KeyResolver of keys by keyid
+type KeySet map[string]crypto.PublicKey
+
+// ResolveKey implements KeyResolver
+func (ks KeySet) ResolveKey(ctx context.Context, r *http.Request, keyid string) (crypto.PublicKey, error) {
+	if pub, ok := ks[keyid]; ok {
+		return pub, nil
+	}
+	return nil, fmt.Errorf("%w %s", ErrUnknownKey, keyid)
+}
+
+// Result describes a verified signature
+type Result struct {
+	Label      string
+	KeyID      string
+	Algorithm  Algorithm
+	Created    time.Time
+	Expires    time.Time
+	Nonce      string
+	Components []string
+}
+
+// Verifier checks web-bot-auth signatures: tagged web-bot-auth, with a keyid, created and expires, covering
+// @authority and Signature-Agent when the request has one. Keys are trusted as Keys resolves them, whatever
+// directory the request's Signature-Agent advertises.
+//
+// This profile is fixed, and stricter than the defaults of the SignatureValidator of the Caddy plugin, which
+// configures the algorithms, times, nonces, authorities and components it accepts. The two are separate: a request
+// may verify with one and not the other. Middleware takes the validator, or any other profile, with WithVerifier.
+type Verifier struct {
+	// Keys resolves keyids to public keys
+	Keys KeyResolver
+	// Nonces rejects signatures reusing the nonce of one already accepted from the same keyid with ErrReplayedNonce.
+	// Without it, a captured signature can be replayed until it expires. Signatures without a nonce are accepted.
+	Nonces NonceStore
+	// Skew accepts signatures created at most this far in the future
+	Skew time.Duration
+	// Now is the clock signatures are checked against. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// NewVerifier returns a verifier trusting keys
+func NewVerifier(keys KeyResolver) *Verifier {
+	return &Verifier{Keys: keys}
+}
+
+// Verify checks the signatures of r tagged web-bot-auth, in label order, and returns the first that verifies.
+// Signatures with other tags are ignored.
+func (v *Verifier) Verify(r *http.Request) (*Result, error) {
+	inputs, signatures, err := httpmsgsig.ParseSignatures(r.Header)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, label := range slices.Sorted(slices.Values(inputs.Names())) {
+		member, _ := inputs.Get(label)
+		input, ok := member.(sfv.InnerList)
+		if !ok {
+			continue
+		}
+		if tag, _ := input.Params.Get("tag"); tag != Tag {
+			continue
+		}
+		result, err := v.verifySignature(r, label, input, signatures)
+		if err == nil {
+			return result, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", label, err))
+	}
+	if len(errs) == 0 {
+		return nil, ErrNoSignature
+	}
+	return nil, errors.Join(errs...)
+}
+
+// verifySignature verifies the signature labeled label of r, described by input
+func (v *Verifier) verifySignature(r *http.Request, label string, input sfv.InnerList, signatures *sfv.Dictionary) (*Result, error) {
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+	result := &Result{Label: label}
+	keyid, _ := input.Params.Get("keyid")
+	if result.KeyID, _ = keyid.(string); result.KeyID == "" {
+		return nil, fmt.Errorf("%w: no keyid", ErrInvalidSignature)
+	}
+	created, _ := input.Params.Get("created")
+	expires, _ := input.Params.Get("expires")
+	c, okCreated := created.(int64)
+	e, okExpires := expires.(int64)
+	if !okCreated || !okExpires {
+		return nil, fmt.Errorf("%w: created and expires are required", ErrInvalidSignature)
+	}
+	result.Created, result.Expires = time.Unix(c, 0), time.Unix(e, 0)
+	if t := now(); result.Created.After(t.Add(v.Skew)) || !t.Before(result.Expires) {
+		return nil, ErrExpired
+	}
+	if nonce, ok := input.Params.Get("nonce"); ok {
+		result.Nonce, _ = nonce.(string)
+	}
+	for _, item := range input.Items {
+		name, _ := item.Value.(string)
+		result.Components = append(result.Components, name)
+	}
+	if !slices.Contains(result.Components, "@authority") {
+		return nil, fmt.Errorf("%w: @authority is not covered", ErrInvalidSignature)
+	}
+	if r.Header.Get(SignatureAgentHeader) != "" && !slices.Contains(result.Components, "signature-agent") {
+		return nil, fmt.Errorf("%w: Signature-Agent is not covered", ErrInvalidSignature)
+	}
+
+	pub, err := v.Keys.ResolveKey(r.Context(), r, result.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if result.Algorithm, err = algorithmOf(pub); err != nil {
+		return nil, err
+	}
+	if alg, ok := input.Params.Get("alg"); ok && alg != string(result.Algorithm) {
+		return nil, fmt.Errorf("%w: alg %v does not match the %s key", ErrInvalidSignature, alg, result.Algorithm)
+	}
+	member, ok := signatures.Get(label)
+	if !ok {
+		return nil, fmt.Errorf("%w: no Signature", ErrInvalidSignature)
+	}
+	item, _ := member.(sfv.Item)
+	sig, ok := item.Value.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: Signature is not a byte sequence", ErrInvalidSignature)
+	}
+	base, err := httpmsgsig.SignatureBase(r, input)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	if err := VerifyBase(result.Algorithm, pub, base, sig); err != nil {
+		return nil, err
+	}
+	// Nonces are only recorded once the signature verified, so that forged requests cannot use them up
+	if v.Nonces != nil && result.Nonce != "" {
+		fresh, err := v.Nonces.Use(r.Context(), result.KeyID+" "+result.Nonce, result.Expires)
+		if err != nil {
+			return nil, fmt.Errorf("checking nonce: %w", err)
+		}
+		if !fresh {
+			return nil, ErrReplayedNonce
+		}
+	}
+	return result, nil
+}
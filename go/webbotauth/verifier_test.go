@@ -0,0 +1,305 @@
+package webbotauth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testKeys resolves testKeyID to the public RFC 9421 test key
+func testKeys(t *testing.T) KeySet {
+	t.Helper()
+	k, err := ParseJWK([]byte(testJWK))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := k.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return KeySet{testKeyID: pub}
+}
+
+func TestVerifyRustTestVector(t *testing.T) {
+	// The standard test vector of the Rust crate, signed for example.com
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	r.Header.Set("Signature", "sig1=:uz2SAv+VIemw+Oo890bhYh6Xf5qZdLUgv6/PbiQfCFXcX/vt1A8Pf7OcgL2yUDUYXFtffNpkEr5W6dldqFrkDg==:")
+	r.Header.Set("Signature-Input", `sig1=("@authority");created=1735689600;keyid="poqkLGiymh_W0uP6PZFw-dvez3QJT5SolqXBCW38r0U";alg="ed25519";expires=1735693200;nonce="gubxywVx7hzbYKatLgzuKDllDAIXAkz41PydU7aOY7vT+Mb3GJNxW0qD4zJ+IOQ1NVtg+BNbTCRUMt1Ojr5BgA==";tag="web-bot-auth"`)
+
+	v := NewVerifier(testKeys(t))
+	v.Now = func() time.Time { return time.Unix(1735690000, 0) }
+	result, err := v.Verify(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.KeyID != testKeyID || result.Algorithm != Ed25519 || result.Label != "sig1" {
+		t.Errorf("result = %+v", result)
+	}
+
+	v.Now = time.Now
+	if _, err := v.Verify(r); !errors.Is(err, ErrExpired) {
+		t.Errorf("expired signature: err = %v, want ErrExpired", err)
+	}
+}
+
+func TestSignThenVerify(t *testing.T) {
+	edKey, err := ParsePrivateKey([]byte(testJWK))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	for _, key := range []crypto.PrivateKey{edKey, ecKey, rsaKey} {
+		signer, err := NewSigner(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/path", nil)
+		r.Header.Set(SignatureAgentHeader, `"https://signer.example.com"`)
+		if err := signer.Sign(r); err != nil {
+			t.Fatal(err)
+		}
+		keys := KeySet{signer.KeyID: key.(interface{ Public() crypto.PublicKey }).Public()}
+		result, err := NewVerifier(keys).Verify(r)
+		if err != nil {
+			t.Fatalf("%T: %v", key, err)
+		}
+		if len(result.Components) != 2 || result.Components[1] != "signature-agent" {
+			t.Errorf("%T: components = %v", key, result.Components)
+		}
+		if len(result.Nonce) != 88 {
+			t.Errorf("%T: nonce %q is not 64 bytes", key, result.Nonce)
+		}
+
+		r.Host = "other.example.com"
+		if _, err := NewVerifier(keys).Verify(r); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%T: signature for another host: err = %v, want ErrInvalidSignature", key, err)
+		}
+	}
+}
+
+// remoteSigner hides the type of its key, as a crypto.Signer backed by a KMS or an HSM does
+type remoteSigner struct{ key crypto.Signer }
+
+func (s remoteSigner) Public() crypto.PublicKey { return s.key.Public() }
+
+func (s remoteSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.key.Sign(rand, digest, opts)
+}
+
+func TestSignWithCryptoSigner(t *testing.T) {
+	edKey, err := ParsePrivateKey([]byte(testJWK))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	for _, key := range []crypto.Signer{edKey.(crypto.Signer), ecKey, rsaKey} {
+		signer, err := NewSigner(remoteSigner{key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+		if err := signer.Sign(r); err != nil {
+			t.Fatalf("%T: %v", key, err)
+		}
+		if _, err := NewVerifier(KeySet{signer.KeyID: key.Public()}).Verify(r); err != nil {
+			t.Errorf("%T: %v", key, err)
+		}
+	}
+}
+
+func TestVerifyRequirements(t *testing.T) {
+	key, err := ParsePrivateKey([]byte(testJWK))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := NewVerifier(testKeys(t))
+
+	tests := []struct {
+		name    string
+		prepare func(r *http.Request)
+		wantErr error
+	}{
+		{name: "unsigned", prepare: func(*http.Request) {}, wantErr: ErrNoSignature},
+		{
+			name: "other tag",
+			prepare: func(r *http.Request) {
+				signer.Sign(r)
+				r.Header.Set("Signature-Input", `sig1=("@authority");created=1;expires=2;keyid="x";tag="other"`)
+			},
+			wantErr: ErrNoSignature,
+		},
+		{
+			name: "Signature-Agent added after signing",
+			prepare: func(r *http.Request) {
+				signer.Sign(r)
+				r.Header.Set(SignatureAgentHeader, `"https://signer.example.com"`)
+			},
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name: "unknown key",
+			prepare: func(r *http.Request) {
+				other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+				s, _ := NewSigner(other)
+				s.Sign(r)
+			},
+			wantErr: ErrUnknownKey,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+			tt.prepare(r)
+			if _, err := v.Verify(r); !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyReplayedNonce(t *testing.T) {
+	key, err := ParsePrivateKey([]byte(testJWK))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	if err := signer.Sign(r); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a nonce store, the signature can be replayed
+	v := NewVerifier(testKeys(t))
+	for range 2 {
+		if _, err := v.Verify(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v.Nonces = NewMemoryNonceStore()
+	if _, err := v.Verify(r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Verify(r); !errors.Is(err, ErrReplayedNonce) {
+		t.Errorf("replayed signature: err = %v, want ErrReplayedNonce", err)
+	}
+	// A forged signature does not use the nonce up
+	forged := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	if err := signer.Sign(forged); err != nil {
+		t.Fatal(err)
+	}
+	forged.Host = "other.example.com"
+	if _, err := v.Verify(forged); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("forged signature: err = %v, want ErrInvalidSignature", err)
+	}
+	forged.Host = "example.com"
+	if _, err := v.Verify(forged); err != nil {
+		t.Errorf("signature whose nonce a forgery carried: %v", err)
+	}
+}
+
+func TestSignQueryParam(t *testing.T) {
+	key, err := ParsePrivateKey([]byte(testJWK))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := NewSigner(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := QueryParam("a b"), `"@query-param";name="a%20b"`; got != want {
+		t.Errorf("QueryParam = %s, want %s", got, want)
+	}
+	signer.Components = []string{"@method", QueryParam("a b")}
+	r := httptest.NewRequest(http.MethodGet, "https://example.com/search?a+b=c%2Fd&e=f", nil)
+	if err := signer.Sign(r); err != nil {
+		t.Fatal(err)
+	}
+	v := NewVerifier(testKeys(t))
+	// Only the covered parameter is bound to the signature
+	r.URL.RawQuery = "e=g&a%20b=c/d"
+	if _, err := v.Verify(r); err != nil {
+		t.Fatal(err)
+	}
+	r.URL.RawQuery = "a+b=other&e=f"
+	if _, err := v.Verify(r); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("changed query parameter: err = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestVerifyBase(t *testing.T) {
+	base := []byte(`"@authority": example.com`)
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	digest384 := sha512.Sum384(base)
+	r, s, err := ecdsa.Sign(rand.Reader, p384Key, digest384[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384Sig := make([]byte, 96)
+	r.FillBytes(p384Sig[:48])
+	s.FillBytes(p384Sig[48:])
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	digest256 := sha256.Sum256(base)
+	pkcs1Sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest256[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pssSig, err := signRaw(rsaKey, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		alg Algorithm
+		pub crypto.PublicKey
+		sig []byte
+	}{
+		{ECDSAP384SHA384, &p384Key.PublicKey, p384Sig},
+		{RSAV15SHA256, &rsaKey.PublicKey, pkcs1Sig},
+		{RSAPSSSHA512, &rsaKey.PublicKey, pssSig},
+	} {
+		if err := VerifyBase(tc.alg, tc.pub, base, tc.sig); err != nil {
+			t.Errorf("%s: %v", tc.alg, err)
+		}
+		if err := VerifyBase(tc.alg, tc.pub, append(base, '/'), tc.sig); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s over another base: err = %v, want ErrInvalidSignature", tc.alg, err)
+		}
+	}
+	// Signatures only verify with the algorithm they were made with
+	if err := VerifyBase(RSAV15SHA256, &rsaKey.PublicKey, base, pssSig); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("RSA-PSS signature as rsa-v1_5-sha256: err = %v, want ErrInvalidSignature", err)
+	}
+	digest512 := sha512.Sum512(base)
+	shortSalt, err := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA512, digest512[:], &rsa.PSSOptions{SaltLength: 32})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyBase(RSAPSSSHA512, &rsaKey.PublicKey, base, shortSalt); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("RSA-PSS signature with a 32-byte salt: err = %v, want ErrInvalidSignature", err)
+	}
+	if err := VerifyBase(Ed25519, &rsaKey.PublicKey, base, pssSig); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("RSA key as ed25519: err = %v, want ErrInvalidSignature", err)
+	}
+	if err := VerifyBase("hmac-sha256", &rsaKey.PublicKey, base, pssSig); err == nil {
+		t.Error("unsupported algorithm accepted")
+	}
+}